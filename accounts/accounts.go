@@ -0,0 +1,279 @@
+// Package accounts tracks the balances of RHP4 ephemeral accounts.
+//
+// Renters pay for most RHP4 RPCs from ephemeral accounts, which hosts debit
+// without a round trip to sign a contract revision. A Manager tracks the
+// balance of each of a renter's accounts locally, so that it never sends an
+// RPC that the account cannot pay for, and tops accounts up before they run
+// dry. A Ledger is its host-side counterpart, crediting and debiting accounts
+// atomically and persisting every change.
+package accounts
+
+import (
+	"errors"
+	"sync"
+
+	rhp "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/core/types"
+)
+
+// ErrInsufficientBalance is returned when an account cannot pay for an RPC.
+var ErrInsufficientBalance = errors.New("insufficient account balance")
+
+// A RefillFunc tops up the balances of a host's accounts to target, typically
+// by calling rhp.Client.ReplenishAccounts and storing the revised contract. It
+// returns the amount deposited into each account.
+type RefillFunc func(hostKey types.PublicKey, accounts []rhp.Account, target types.Currency) ([]rhp.AccountDeposit, error)
+
+type accountKey struct {
+	host    types.PublicKey
+	account rhp.Account
+}
+
+type account struct {
+	balance  types.Currency // last known balance, less spending since
+	reserved types.Currency // cost of RPCs in flight
+}
+
+func (a *account) available() types.Currency {
+	if a.reserved.Cmp(a.balance) > 0 {
+		return types.ZeroCurrency
+	}
+	return a.balance.Sub(a.reserved)
+}
+
+func (a *account) deduct(amount types.Currency) {
+	if amount.Cmp(a.balance) > 0 {
+		amount = a.balance
+	}
+	a.balance = a.balance.Sub(amount)
+}
+
+// A Manager tracks the balances of a renter's ephemeral accounts.
+//
+// Balances are optimistic: the cost of each RPC is reserved before it is
+// sent and deducted when it completes, without consulting the host. Since an
+// RPC is only sent if its cost can be reserved, concurrent RPCs never overdraw
+// an account. Any drift from the host's view, e.g. after an RPC fails partway
+// through, is corrected by Sync.
+//
+// If the Manager has a RefillFunc, it tops up a host's accounts to the target
+// balance whenever one falls below the threshold, and before reserving a cost
+// that an account cannot cover.
+type Manager struct {
+	threshold types.Currency
+	target    types.Currency
+	refill    RefillFunc
+
+	mu        sync.Mutex
+	cond      sync.Cond // broadcast when a refill completes
+	accounts  map[accountKey]*account
+	refilling map[types.PublicKey]bool
+	refillErr map[types.PublicKey]error
+}
+
+func (m *Manager) account(hostKey types.PublicKey, a rhp.Account) *account {
+	k := accountKey{hostKey, a}
+	acc, ok := m.accounts[k]
+	if !ok {
+		acc = new(account)
+		m.accounts[k] = acc
+	}
+	return acc
+}
+
+// Balance returns the balance of an account that is not reserved by RPCs in
+// flight.
+func (m *Manager) Balance(hostKey types.PublicKey, a rhp.Account) types.Currency {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.account(hostKey, a).available()
+}
+
+// Deposit adds amount to the balance of an account, e.g. after funding it
+// with rhp.Client.FundAccounts.
+func (m *Manager) Deposit(hostKey types.PublicKey, a rhp.Account, amount types.Currency) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	acc := m.account(hostKey, a)
+	acc.balance = acc.balance.Add(amount)
+}
+
+// Reconcile replaces the balance of an account with the balance reported by
+// the host. Reservations for RPCs in flight are retained, so the balance may
+// briefly be lower than the host's.
+func (m *Manager) Reconcile(hostKey types.PublicKey, a rhp.Account, resp rhp.RPCAccountBalanceResponse) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.account(hostKey, a).balance = resp.Balance
+}
+
+// Sync fetches the balance of an account from the host and reconciles it.
+func (m *Manager) Sync(c *rhp.Client, a rhp.Account) error {
+	balance, err := c.AccountBalance(a)
+	if err != nil {
+		return err
+	}
+	m.Reconcile(c.HostKey(), a, rhp.RPCAccountBalanceResponse{Balance: balance})
+	return nil
+}
+
+// A Reservation is the reserved cost of an RPC.
+type Reservation struct {
+	m      *Manager
+	key    accountKey
+	amount types.Currency
+	done   bool
+}
+
+// Commit deducts the cost of usage from the account and releases the
+// reservation.
+func (r *Reservation) Commit(usage rhp.Usage) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if r.done {
+		return
+	}
+	r.done = true
+	acc := r.m.accounts[r.key]
+	acc.reserved = acc.reserved.Sub(r.amount)
+	acc.deduct(usage.RenterCost())
+	r.m.maybeRefill(r.key.host)
+	r.m.cond.Broadcast()
+}
+
+// Release releases the reservation without deducting anything, e.g. because
+// the RPC was never sent.
+func (r *Reservation) Release() {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	if r.done {
+		return
+	}
+	r.done = true
+	acc := r.m.accounts[r.key]
+	acc.reserved = acc.reserved.Sub(r.amount)
+	r.m.cond.Broadcast()
+}
+
+// maybeRefill starts a background refill of the host's accounts if any has
+// fallen below the threshold. It must be called with m.mu held.
+func (m *Manager) maybeRefill(hostKey types.PublicKey) {
+	if m.refill == nil || m.refilling[hostKey] || len(m.lowAccounts(hostKey)) == 0 {
+		return
+	}
+	m.refilling[hostKey] = true
+	go func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.doRefill(hostKey)
+	}()
+}
+
+func (m *Manager) lowAccounts(hostKey types.PublicKey) (low []rhp.Account) {
+	for k, acc := range m.accounts {
+		if k.host == hostKey && acc.available().Cmp(m.threshold) < 0 {
+			low = append(low, k.account)
+		}
+	}
+	return
+}
+
+// doRefill refills the host's accounts and reports whether anything was
+// deposited. It must be called with m.mu held and m.refilling[hostKey] set; it
+// releases the lock while the RefillFunc runs.
+func (m *Manager) doRefill(hostKey types.PublicKey) (deposited bool) {
+	accounts := m.lowAccounts(hostKey)
+	var deposits []rhp.AccountDeposit
+	var err error
+	if len(accounts) > 0 {
+		m.mu.Unlock()
+		deposits, err = m.refill(hostKey, accounts, m.target)
+		m.mu.Lock()
+	}
+	for _, d := range deposits {
+		acc := m.account(hostKey, d.Account)
+		acc.balance = acc.balance.Add(d.Amount)
+		deposited = deposited || !d.Amount.IsZero()
+	}
+	m.refillErr[hostKey] = err
+	m.refilling[hostKey] = false
+	m.cond.Broadcast()
+	return
+}
+
+// Reserve reserves amount from the balance of an account. If the account
+// cannot cover it and amount does not exceed the target, Reserve refills the
+// host's accounts, waiting for any refill or RPC already in flight to
+// complete. Otherwise, or if refilling fails, Reserve returns
+// ErrInsufficientBalance or the error returned by the RefillFunc.
+//
+// Since Reserve may wait for other reservations to be released, a caller
+// must not hold a reservation on the same host while calling it.
+func (m *Manager) Reserve(hostKey types.PublicKey, a rhp.Account, amount types.Currency) (*Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	acc := m.account(hostKey, a)
+	for stalled := false; acc.available().Cmp(amount) < 0; {
+		switch {
+		case m.refill == nil || amount.Cmp(m.target) > 0:
+			return nil, ErrInsufficientBalance
+		case m.refilling[hostKey]:
+			m.cond.Wait()
+			if err := m.refillErr[hostKey]; err != nil && !m.refilling[hostKey] {
+				return nil, err
+			}
+			stalled = false
+		case !stalled:
+			// if the refill deposits nothing, the host's balance already
+			// covers the RPCs in flight; wait for them before trying again
+			m.refilling[hostKey] = true
+			stalled = !m.doRefill(hostKey)
+			if err := m.refillErr[hostKey]; err != nil {
+				return nil, err
+			}
+		case acc.reserved.IsZero():
+			return nil, ErrInsufficientBalance
+		default:
+			m.cond.Wait()
+			stalled = false
+		}
+	}
+	acc.reserved = acc.reserved.Add(amount)
+	r := &Reservation{m: m, key: accountKey{hostKey, a}, amount: amount}
+	m.maybeRefill(hostKey)
+	return r, nil
+}
+
+// Spend reserves the cost of estimate from an account, calls fn to execute
+// the RPC, and deducts the cost of the usage returned by fn. If fn fails, the
+// host may have charged for the RPC anyway, so the full estimate is deducted
+// until the next Sync.
+func (m *Manager) Spend(hostKey types.PublicKey, a rhp.Account, estimate rhp.Usage, fn func() (rhp.Usage, error)) error {
+	r, err := m.Reserve(hostKey, a, estimate.RenterCost())
+	if err != nil {
+		return err
+	}
+	usage, err := fn()
+	if err != nil {
+		r.Commit(estimate)
+		return err
+	}
+	r.Commit(usage)
+	return nil
+}
+
+// NewManager returns a Manager that keeps account balances between threshold
+// and target using refill. If refill is nil, accounts are never refilled
+// automatically.
+func NewManager(threshold, target types.Currency, refill RefillFunc) *Manager {
+	m := &Manager{
+		threshold: threshold,
+		target:    target,
+		refill:    refill,
+		accounts:  make(map[accountKey]*account),
+		refilling: make(map[types.PublicKey]bool),
+		refillErr: make(map[types.PublicKey]error),
+	}
+	m.cond.L = &m.mu
+	return m
+}
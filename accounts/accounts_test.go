@@ -0,0 +1,306 @@
+package accounts
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"sync"
+	"testing"
+
+	rhp "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/core/rhp/v4/rhptest"
+	"go.sia.tech/core/types"
+	"lukechampine.com/frand"
+)
+
+// waitRefills waits for any background refills to complete.
+func waitRefills(m *Manager) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for {
+		var refilling bool
+		for _, r := range m.refilling {
+			refilling = refilling || r
+		}
+		if !refilling {
+			return
+		}
+		m.cond.Wait()
+	}
+}
+
+func TestManager(t *testing.T) {
+	h := rhptest.NewHost()
+	c := rhp.NewClient(h, h.PublicKey(), rhp.GougingSettings{})
+	renterKey := types.GeneratePrivateKey()
+	contractID := types.FileContractID(frand.Entropy256())
+	fc := types.V2FileContract{
+		RenterPublicKey: renterKey.PublicKey(),
+		HostPublicKey:   h.PublicKey(),
+		RenterOutput:    types.SiacoinOutput{Value: types.Siacoins(10)},
+	}
+	h.AddContract(contractID, fc)
+
+	hs, err := c.Settings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prices := hs.Prices
+	readCost := prices.RPCReadSectorCost(rhp.LeafSize)
+
+	var refillMu sync.Mutex
+	var refills int
+	refill := func(hostKey types.PublicKey, accounts []rhp.Account, target types.Currency) ([]rhp.AccountDeposit, error) {
+		refillMu.Lock()
+		defer refillMu.Unlock()
+		rev, deposits, err := c.ReplenishAccounts(h.State(), renterKey, contractID, fc, accounts, target)
+		if err != nil {
+			return nil, err
+		}
+		fc = rev
+		refills++
+		return deposits, nil
+	}
+	threshold, target := readCost.RenterCost().Mul64(3), readCost.RenterCost().Mul64(10)
+	m := NewManager(threshold, target, refill)
+
+	accountKey, account := rhp.GenerateAccount()
+	token := account.Token(accountKey, h.PublicKey())
+	checkBalance := func() {
+		t.Helper()
+		waitRefills(m)
+		if got, want := m.Balance(h.PublicKey(), account), h.Balance(account); got != want {
+			t.Fatalf("expected balance %v, got %v", want, got)
+		}
+	}
+
+	// the first RPC refills the empty account
+	var root types.Hash256
+	err = m.Spend(h.PublicKey(), account, prices.RPCWriteSectorCost(rhp.LeafSize), func() (usage rhp.Usage, err error) {
+		root, usage, err = c.WriteSector(prices, token, bytes.NewReader(frand.Bytes(rhp.LeafSize)), rhp.LeafSize)
+		return
+	})
+	if !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("expected write to exceed target, got %v", err)
+	} else if refills != 0 {
+		t.Fatal("expected no refill for a cost above the target")
+	}
+	m = NewManager(threshold, prices.RPCWriteSectorCost(rhp.LeafSize).RenterCost().Add(target), refill)
+	err = m.Spend(h.PublicKey(), account, prices.RPCWriteSectorCost(rhp.LeafSize), func() (usage rhp.Usage, err error) {
+		root, usage, err = c.WriteSector(prices, token, bytes.NewReader(frand.Bytes(rhp.LeafSize)), rhp.LeafSize)
+		return
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if refills != 1 {
+		t.Fatalf("expected 1 refill, got %v", refills)
+	}
+	checkBalance()
+	m = NewManager(threshold, target, refill)
+	if err := m.Sync(c, account); err != nil {
+		t.Fatal(err)
+	}
+	checkBalance()
+
+	// concurrent reads never overdraw the account, and refills keep it
+	// topped up
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for range cap(errs) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- m.Spend(h.PublicKey(), account, readCost, func() (rhp.Usage, error) {
+				return c.ReadSector(prices, token, new(bytes.Buffer), root, 0, rhp.LeafSize)
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkBalance()
+	if refills < 2 {
+		t.Fatalf("expected accounts to be refilled, got %v refills", refills)
+	} else if rev, _ := h.Contract(contractID); rev.RevisionNumber != fc.RevisionNumber || rev.HostSignature != fc.HostSignature {
+		t.Fatal("renter and host revisions differ")
+	}
+
+	// failed RPCs are assumed to have been charged until the next sync
+	m.refill = nil
+	before := m.Balance(h.PublicKey(), account)
+	err = m.Spend(h.PublicKey(), account, readCost, func() (rhp.Usage, error) {
+		return c.ReadSector(prices, token, new(bytes.Buffer), types.Hash256{1}, 0, rhp.LeafSize)
+	})
+	if err == nil {
+		t.Fatal("expected read of missing sector to fail")
+	} else if after := m.Balance(h.PublicKey(), account); after != before.Sub(readCost.RenterCost()) {
+		t.Fatalf("expected failed RPC to be deducted, got %v -> %v", before, after)
+	}
+	m.refill = refill
+	h.Credit(account, types.NewCurrency64(12345))
+	if err := m.Sync(c, account); err != nil {
+		t.Fatal(err)
+	}
+	checkBalance()
+
+	// funding accounts from the contract
+	other := rhp.Account(types.GeneratePrivateKey().PublicKey())
+	deposits := []rhp.AccountDeposit{{Account: other, Amount: types.Siacoins(1)}}
+	rev, balances, err := c.FundAccounts(h.State(), renterKey, contractID, fc, deposits)
+	if err != nil {
+		t.Fatal(err)
+	} else if balances[0] != types.Siacoins(1) {
+		t.Fatal("wrong balance", balances[0])
+	} else if rev.RenterOutput.Value != fc.RenterOutput.Value.Sub(types.Siacoins(1)) {
+		t.Fatal("wrong renter output")
+	}
+	fc = rev
+	m.Deposit(h.PublicKey(), other, balances[0])
+	if m.Balance(h.PublicKey(), other) != h.Balance(other) {
+		t.Fatal("balance mismatch after funding")
+	}
+}
+
+func TestManagerNoOverdraw(t *testing.T) {
+	hostKey := types.GeneratePrivateKey().PublicKey()
+	_, account := rhp.GenerateAccount()
+	cost := types.NewCurrency64(100)
+	m := NewManager(types.ZeroCurrency, types.ZeroCurrency, nil)
+	m.Deposit(hostKey, account, cost.Mul64(3))
+
+	// only three reservations fit, no matter how many are attempted at once
+	var mu sync.Mutex
+	var reserved []*Reservation
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if r, err := m.Reserve(hostKey, account, cost); err == nil {
+				mu.Lock()
+				reserved = append(reserved, r)
+				mu.Unlock()
+			} else if !errors.Is(err, ErrInsufficientBalance) {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(reserved) != 3 {
+		t.Fatalf("expected 3 reservations, got %v", len(reserved))
+	} else if !m.Balance(hostKey, account).IsZero() {
+		t.Fatal("expected no available balance")
+	}
+
+	// releasing a reservation frees its cost; committing deducts the actual
+	// usage
+	reserved[0].Release()
+	reserved[1].Commit(rhp.Usage{Egress: cost.Div64(2)})
+	reserved[1].Commit(rhp.Usage{Egress: cost}) // no-op
+	if got := m.Balance(hostKey, account); got != cost.Add(cost.Div64(2)) {
+		t.Fatalf("expected balance %v, got %v", cost.Add(cost.Div64(2)), got)
+	}
+	m.Reconcile(hostKey, account, rhp.RPCAccountBalanceResponse{Balance: cost.Mul64(5)})
+	if got := m.Balance(hostKey, account); got != cost.Mul64(4) {
+		t.Fatalf("expected reserved cost to be retained, got %v", got)
+	}
+}
+
+type memLedgerStore struct {
+	balances map[rhp.Account]types.Currency
+	fail     bool
+}
+
+func (s *memLedgerStore) AccountBalances() (map[rhp.Account]types.Currency, error) {
+	return maps.Clone(s.balances), nil
+}
+
+func (s *memLedgerStore) UpdateAccountBalances(updated map[rhp.Account]types.Currency) error {
+	if s.fail {
+		return errors.New("disk full")
+	}
+	for a, b := range updated {
+		if b.IsZero() {
+			delete(s.balances, a)
+		} else {
+			s.balances[a] = b
+		}
+	}
+	return nil
+}
+
+func TestLedger(t *testing.T) {
+	store := &memLedgerStore{balances: make(map[rhp.Account]types.Currency)}
+	l, err := NewLedger(store, types.Siacoins(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, a := rhp.GenerateAccount()
+	_, b := rhp.GenerateAccount()
+
+	if err := l.Debit(a, rhp.Usage{RPC: types.NewCurrency64(1)}); !errors.Is(err, rhp.ErrNotEnoughFunds) {
+		t.Fatalf("expected insufficient funds, got %v", err)
+	}
+	balances, err := l.Credit([]rhp.AccountDeposit{
+		{Account: a, Amount: types.Siacoins(2)},
+		{Account: b, Amount: types.Siacoins(3)},
+		{Account: a, Amount: types.Siacoins(1)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if balances[0] != types.Siacoins(3) || balances[1] != types.Siacoins(3) {
+		t.Fatal("wrong balances", balances)
+	}
+
+	// a deposit exceeding the maximum balance rejects the whole batch
+	if _, err := l.Credit([]rhp.AccountDeposit{
+		{Account: a, Amount: types.Siacoins(1)},
+		{Account: b, Amount: types.Siacoins(8)},
+	}); !errors.Is(err, ErrBalanceExceeded) {
+		t.Fatalf("expected balance exceeded, got %v", err)
+	} else if l.Balance(a) != types.Siacoins(3) {
+		t.Fatal("expected batch to be rejected atomically")
+	}
+
+	if err := l.Debit(a, rhp.Usage{Egress: types.Siacoins(1), Ingress: types.Siacoins(1)}); err != nil {
+		t.Fatal(err)
+	} else if l.Balance(a) != types.Siacoins(1) {
+		t.Fatal("wrong balance after debit", l.Balance(a))
+	}
+
+	// replenishing tops up accounts below the target
+	deposits := l.ReplenishDeposits([]rhp.Account{a, b, a}, types.Siacoins(2))
+	if len(deposits) != 1 || deposits[0] != (rhp.AccountDeposit{Account: a, Amount: types.Siacoins(1)}) {
+		t.Fatal("wrong deposits", deposits)
+	} else if l.Balance(a) != types.Siacoins(1) {
+		t.Fatal("expected deposits not to be applied")
+	} else if _, err := l.Credit(deposits); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed write leaves the balance unchanged
+	store.fail = true
+	if err := l.Debit(a, rhp.Usage{RPC: types.Siacoins(1)}); err == nil {
+		t.Fatal("expected store failure")
+	} else if l.Balance(a) != types.Siacoins(2) {
+		t.Fatal("expected balance to be unchanged")
+	}
+	store.fail = false
+
+	// balances persist across restarts
+	l2, err := NewLedger(store, types.Siacoins(10))
+	if err != nil {
+		t.Fatal(err)
+	} else if l2.Balance(a) != types.Siacoins(2) || l2.Balance(b) != types.Siacoins(3) {
+		t.Fatal("expected balances to persist")
+	}
+	if err := l2.Debit(a, rhp.Usage{RPC: types.Siacoins(2)}); err != nil {
+		t.Fatal(err)
+	} else if _, ok := store.balances[a]; ok {
+		t.Fatal("expected empty account to be deleted")
+	}
+}
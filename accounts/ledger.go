@@ -0,0 +1,134 @@
+package accounts
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	rhp "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/core/types"
+)
+
+// ErrBalanceExceeded is returned by Ledger.Credit when a deposit would exceed
+// the maximum account balance.
+var ErrBalanceExceeded = errors.New("deposit exceeds maximum account balance")
+
+// A LedgerStore persists account balances.
+type LedgerStore interface {
+	// AccountBalances returns the balance of every account.
+	AccountBalances() (map[rhp.Account]types.Currency, error)
+	// UpdateAccountBalances atomically sets the balances of the given
+	// accounts. Accounts with a zero balance may be deleted.
+	UpdateAccountBalances(map[rhp.Account]types.Currency) error
+}
+
+// A Ledger tracks ephemeral account balances on behalf of a host. Every
+// debit and credit is atomic and is persisted before it takes effect, so a
+// renter can never spend more than was deposited, even across restarts.
+type Ledger struct {
+	store      LedgerStore
+	maxBalance types.Currency
+
+	mu       sync.Mutex
+	balances map[rhp.Account]types.Currency
+}
+
+// commit persists the updated balances and applies them. It must be called
+// with l.mu held.
+func (l *Ledger) commit(updated map[rhp.Account]types.Currency) error {
+	if err := l.store.UpdateAccountBalances(updated); err != nil {
+		return fmt.Errorf("failed to update account balances: %w", err)
+	}
+	for a, balance := range updated {
+		if balance.IsZero() {
+			delete(l.balances, a)
+		} else {
+			l.balances[a] = balance
+		}
+	}
+	return nil
+}
+
+// Balance returns the balance of an account.
+func (l *Ledger) Balance(a rhp.Account) types.Currency {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.balances[a]
+}
+
+// Debit deducts the renter cost of usage from an account. It returns
+// rhp.ErrNotEnoughFunds if the account cannot pay.
+func (l *Ledger) Debit(a rhp.Account, usage rhp.Usage) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	balance, underflow := l.balances[a].SubWithUnderflow(usage.RenterCost())
+	if underflow {
+		return rhp.ErrNotEnoughFunds
+	}
+	return l.commit(map[rhp.Account]types.Currency{a: balance})
+}
+
+// Credit applies a set of deposits, as in RPCFundAccounts, and returns the
+// resulting balances. Either every deposit is applied or none are.
+func (l *Ledger) Credit(deposits []rhp.AccountDeposit) ([]types.Currency, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	updated := make(map[rhp.Account]types.Currency)
+	for _, d := range deposits {
+		balance, ok := updated[d.Account]
+		if !ok {
+			balance = l.balances[d.Account]
+		}
+		balance, overflow := balance.AddWithOverflow(d.Amount)
+		if overflow || (!l.maxBalance.IsZero() && balance.Cmp(l.maxBalance) > 0) {
+			return nil, fmt.Errorf("%w: account %v", ErrBalanceExceeded, d.Account)
+		}
+		updated[d.Account] = balance
+	}
+	if err := l.commit(updated); err != nil {
+		return nil, err
+	}
+	balances := make([]types.Currency, len(deposits))
+	for i, d := range deposits {
+		balances[i] = l.balances[d.Account]
+	}
+	return balances, nil
+}
+
+// ReplenishDeposits returns the deposits required to top up each account to
+// target, as in RPCReplenishAccounts. Accounts at or above target are
+// omitted. The deposits are not applied; the host should Credit them once the
+// renter has signed a revision paying for them.
+func (l *Ledger) ReplenishDeposits(accounts []rhp.Account, target types.Currency) []rhp.AccountDeposit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var deposits []rhp.AccountDeposit
+	seen := make(map[rhp.Account]bool)
+	for _, a := range accounts {
+		if seen[a] {
+			continue
+		}
+		seen[a] = true
+		if balance := l.balances[a]; balance.Cmp(target) < 0 {
+			deposits = append(deposits, rhp.AccountDeposit{Account: a, Amount: target.Sub(balance)})
+		}
+	}
+	return deposits
+}
+
+// NewLedger returns a Ledger backed by the given store. If maxBalance is
+// non-zero, deposits may not raise an account's balance above it.
+func NewLedger(store LedgerStore, maxBalance types.Currency) (*Ledger, error) {
+	balances, err := store.AccountBalances()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load account balances: %w", err)
+	}
+	if balances == nil {
+		balances = make(map[rhp.Account]types.Currency)
+	}
+	return &Ledger{
+		store:      store,
+		maxBalance: maxBalance,
+		balances:   balances,
+	}, nil
+}
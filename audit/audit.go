@@ -0,0 +1,353 @@
+// Package audit checks that hosts are storing a renter's data.
+//
+// An Auditor periodically samples random sectors from a renter's contracts
+// and asks each host to prove, via RPCVerifySector, that it is storing a
+// random leaf of the sector. Each proof is verified against the sector root
+// the renter recorded at upload time, so a host cannot pass an audit without
+// the data. The Auditor tracks the outcome of every audit per host, limits
+// its spending to a budget, and reports the hosts whose failure rate suggests
+// they have lost data, or whose error rate suggests they are refusing audits.
+package audit
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	rhp "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/core/types"
+	"lukechampine.com/frand"
+)
+
+// A Contract is a set of sectors a renter has stored with a host.
+type Contract struct {
+	ID      types.FileContractID
+	HostKey types.PublicKey
+	Roots   []types.Hash256
+}
+
+// A Host is an RHP4 host that can be audited, paying for each audit from an
+// ephemeral account.
+type Host struct {
+	Client *rhp.Client
+	Token  rhp.AccountToken
+}
+
+// An Outcome is the outcome of an audit.
+type Outcome uint8
+
+// Audit outcomes.
+const (
+	// OutcomePassed indicates that the host proved it is storing the leaf.
+	OutcomePassed Outcome = iota
+	// OutcomeFailed indicates that the host could not prove it is storing
+	// the leaf, either by returning an invalid proof or by reporting that
+	// the sector was not found.
+	OutcomeFailed
+	// OutcomeError indicates that the audit could not be performed, e.g.
+	// because the host was unreachable, its prices were rejected, or it
+	// reported an error unrelated to the sector, such as an internal error.
+	// Errors do not count towards a host's failure rate, but a host that
+	// errors too often is reported as bad regardless.
+	OutcomeError
+)
+
+// String implements fmt.Stringer.
+func (o Outcome) String() string {
+	switch o {
+	case OutcomePassed:
+		return "passed"
+	case OutcomeFailed:
+		return "failed"
+	case OutcomeError:
+		return "error"
+	default:
+		return fmt.Sprintf("Outcome(%d)", o)
+	}
+}
+
+// A Result is the result of a single audit.
+type Result struct {
+	HostKey    types.PublicKey
+	ContractID types.FileContractID
+	Root       types.Hash256
+	LeafIndex  uint64
+	Outcome    Outcome
+	Cost       types.Currency
+	Err        error
+}
+
+// isRPCError reports whether err is an RPCError with the same code and
+// description as target. Errors received from a host are decoded into new
+// values, so errors.Is cannot be used.
+func isRPCError(err, target error) bool {
+	re, te := new(rhp.RPCError), new(rhp.RPCError)
+	return errors.As(err, &re) && errors.As(target, &te) && *re == *te
+}
+
+// classify returns the outcome of an audit that returned err.
+func classify(err error) Outcome {
+	switch {
+	case err == nil:
+		return OutcomePassed
+	case errors.Is(err, rhp.ErrInvalidProof), isRPCError(err, rhp.ErrSectorNotFound):
+		return OutcomeFailed
+	default:
+		return OutcomeError
+	}
+}
+
+// HostStats summarizes the audits of a host.
+type HostStats struct {
+	Passed uint64
+	Failed uint64
+	Errors uint64
+	Spent  types.Currency
+}
+
+// Audits returns the number of audits the host passed or failed.
+func (hs HostStats) Audits() uint64 { return hs.Passed + hs.Failed }
+
+// FailureRate returns the fraction of audits the host failed.
+func (hs HostStats) FailureRate() float64 {
+	if hs.Audits() == 0 {
+		return 0
+	}
+	return float64(hs.Failed) / float64(hs.Audits())
+}
+
+// Attempts returns the number of audits attempted, including errors.
+func (hs HostStats) Attempts() uint64 { return hs.Audits() + hs.Errors }
+
+// ErrorRate returns the fraction of attempted audits that resulted in an
+// error.
+func (hs HostStats) ErrorRate() float64 {
+	if hs.Attempts() == 0 {
+		return 0
+	}
+	return float64(hs.Errors) / float64(hs.Attempts())
+}
+
+// Config contains the parameters of an Auditor.
+type Config struct {
+	// SamplesPerHost is the number of audits performed per host in each
+	// round.
+	SamplesPerHost int
+	// Budget is the maximum amount spent on audits in each round. If zero,
+	// spending is unlimited.
+	Budget types.Currency
+	// MinAudits is the number of audits a host must have passed or failed
+	// before it can be reported for its failure rate, or attempted before it
+	// can be reported for its error rate.
+	MinAudits uint64
+	// MaxFailureRate is the failure rate above which a host is reported as
+	// bad.
+	MaxFailureRate float64
+	// MaxErrorRate is the error rate above which a host is reported as bad,
+	// so that a host cannot avoid failing audits by refusing them. If zero,
+	// errors are ignored.
+	MaxErrorRate float64
+}
+
+// DefaultConfig returns a reasonable default configuration.
+func DefaultConfig() Config {
+	return Config{
+		SamplesPerHost: 5,
+		Budget:         types.Siacoins(1),
+		MinAudits:      10,
+		MaxFailureRate: 0.05,
+		MaxErrorRate:   0.5,
+	}
+}
+
+// A Report summarizes the audits performed by an Auditor.
+type Report struct {
+	Hosts map[types.PublicKey]HostStats
+	Spent types.Currency
+	// Bad lists the hosts whose failure rate or error rate exceeds the
+	// maximum, in order of decreasing failure rate, then error rate.
+	Bad []types.PublicKey
+}
+
+// An Auditor audits the sectors stored with a set of hosts.
+type Auditor struct {
+	cfg   Config
+	hosts map[types.PublicKey]Host
+
+	mu    sync.Mutex
+	stats map[types.PublicKey]HostStats
+	spent types.Currency
+}
+
+type audit struct {
+	contract  *Contract
+	root      types.Hash256
+	leafIndex uint64
+}
+
+// sample returns a random sector of the given contracts, each sector being
+// equally likely.
+func sample(contracts []*Contract, total int) (*Contract, types.Hash256) {
+	i := frand.Intn(total)
+	for _, c := range contracts {
+		if i < len(c.Roots) {
+			return c, c.Roots[i]
+		}
+		i -= len(c.Roots)
+	}
+	panic("unreachable")
+}
+
+// Audit performs a round of audits across the given contracts, sampling
+// SamplesPerHost random sectors and leaves from each host's contracts. Hosts
+// are audited in round-robin order until the budget is exhausted, so that a
+// small budget is spread evenly. Contracts with hosts unknown to the Auditor
+// are ignored.
+func (a *Auditor) Audit(contracts []Contract) []Result {
+	byHost := make(map[types.PublicKey][]*Contract)
+	sectors := make(map[types.PublicKey]int)
+	for i := range contracts {
+		c := &contracts[i]
+		if _, ok := a.hosts[c.HostKey]; ok && len(c.Roots) > 0 {
+			byHost[c.HostKey] = append(byHost[c.HostKey], c)
+			sectors[c.HostKey] += len(c.Roots)
+		}
+	}
+
+	// fetch each host's prices; a host whose prices cannot be fetched is
+	// recorded as an error and skipped
+	var results []Result
+	prices := make(map[types.PublicKey]rhp.HostPrices)
+	hostKeys := make([]types.PublicKey, 0, len(byHost))
+	for hostKey := range byHost {
+		hs, err := a.hosts[hostKey].Client.Settings()
+		if err != nil {
+			results = append(results, Result{
+				HostKey: hostKey,
+				Outcome: OutcomeError,
+				Err:     fmt.Errorf("failed to fetch prices: %w", err),
+			})
+			continue
+		}
+		prices[hostKey] = hs.Prices
+		hostKeys = append(hostKeys, hostKey)
+	}
+	frand.Shuffle(len(hostKeys), func(i, j int) { hostKeys[i], hostKeys[j] = hostKeys[j], hostKeys[i] })
+
+	// plan the round within the budget
+	planned := make(map[types.PublicKey][]audit)
+	var budgeted types.Currency
+plan:
+	for range a.cfg.SamplesPerHost {
+		for _, hostKey := range hostKeys {
+			cost := prices[hostKey].RPCVerifySectorCost().RenterCost()
+			if !a.cfg.Budget.IsZero() && budgeted.Add(cost).Cmp(a.cfg.Budget) > 0 {
+				break plan
+			}
+			budgeted = budgeted.Add(cost)
+			c, root := sample(byHost[hostKey], sectors[hostKey])
+			planned[hostKey] = append(planned[hostKey], audit{c, root, frand.Uint64n(rhp.LeavesPerSector)})
+		}
+	}
+
+	// audit hosts in parallel, and each host's sectors sequentially
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for hostKey, audits := range planned {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h := a.hosts[hostKey]
+			for _, au := range audits {
+				_, usage, err := h.Client.VerifySector(prices[hostKey], h.Token, au.root, au.leafIndex)
+				res := Result{
+					HostKey:    hostKey,
+					ContractID: au.contract.ID,
+					Root:       au.root,
+					LeafIndex:  au.leafIndex,
+					Outcome:    classify(err),
+					Err:        err,
+				}
+				res.Cost = usage.RenterCost()
+				if err != nil && res.Cost.IsZero() && rhp.ErrorCode(err) != rhp.ErrorCodeTransport {
+					// the host may have charged before rejecting the
+					// request, so assume the worst
+					res.Cost = prices[hostKey].RPCVerifySectorCost().RenterCost()
+				}
+				mu.Lock()
+				results = append(results, res)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, res := range results {
+		hs := a.stats[res.HostKey]
+		switch res.Outcome {
+		case OutcomePassed:
+			hs.Passed++
+		case OutcomeFailed:
+			hs.Failed++
+		case OutcomeError:
+			hs.Errors++
+		}
+		hs.Spent = hs.Spent.Add(res.Cost)
+		a.stats[res.HostKey] = hs
+		a.spent = a.spent.Add(res.Cost)
+	}
+	return results
+}
+
+// Report returns a summary of every audit performed so far.
+func (a *Auditor) Report() Report {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r := Report{
+		Hosts: make(map[types.PublicKey]HostStats, len(a.stats)),
+		Spent: a.spent,
+	}
+	for hostKey, hs := range a.stats {
+		r.Hosts[hostKey] = hs
+		failing := hs.Audits() >= a.cfg.MinAudits && hs.FailureRate() > a.cfg.MaxFailureRate
+		erroring := a.cfg.MaxErrorRate > 0 && hs.Attempts() >= a.cfg.MinAudits && hs.ErrorRate() > a.cfg.MaxErrorRate
+		if failing || erroring {
+			r.Bad = append(r.Bad, hostKey)
+		}
+	}
+	slices.SortFunc(r.Bad, func(x, y types.PublicKey) int {
+		hx, hy := r.Hosts[x], r.Hosts[y]
+		switch {
+		case hx.FailureRate() != hy.FailureRate():
+			return -cmp.Compare(hx.FailureRate(), hy.FailureRate())
+		default:
+			return -cmp.Compare(hx.ErrorRate(), hy.ErrorRate())
+		}
+	})
+	return r
+}
+
+// ResetHost clears the statistics of a host, e.g. after its data has been
+// migrated elsewhere.
+func (a *Auditor) ResetHost(hostKey types.PublicKey) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.stats, hostKey)
+}
+
+// NewAuditor returns an Auditor for the given hosts.
+func NewAuditor(hosts []Host, cfg Config) *Auditor {
+	a := &Auditor{
+		cfg:   cfg,
+		hosts: make(map[types.PublicKey]Host, len(hosts)),
+		stats: make(map[types.PublicKey]HostStats),
+	}
+	for _, h := range hosts {
+		a.hosts[h.Client.HostKey()] = h
+	}
+	return a
+}
@@ -0,0 +1,187 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	rhp "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/core/rhp/v4/rhptest"
+	"go.sia.tech/core/types"
+	"lukechampine.com/frand"
+)
+
+func TestAuditor(t *testing.T) {
+	// set up three hosts storing four sectors each: one honest, one that
+	// has lost half of its sectors, and one that has corrupted them all
+	var hosts []Host
+	var rhpHosts []*rhptest.Host
+	var contracts []Contract
+	for range 3 {
+		h := rhptest.NewHost()
+		c := rhp.NewClient(h, h.PublicKey(), rhp.GougingSettings{})
+		renterKey, account := rhp.GenerateAccount()
+		token := account.Token(renterKey, h.PublicKey())
+		h.Credit(account, types.Siacoins(100))
+		prices := h.Settings().Prices
+
+		contract := Contract{ID: types.FileContractID(frand.Entropy256()), HostKey: h.PublicKey()}
+		for range 4 {
+			root, _, err := c.WriteSector(prices, token, bytes.NewReader(frand.Bytes(rhp.LeafSize)), rhp.LeafSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			contract.Roots = append(contract.Roots, root)
+		}
+		hosts = append(hosts, Host{Client: c, Token: token})
+		rhpHosts = append(rhpHosts, h)
+		contracts = append(contracts, contract)
+	}
+	good, lossy, corrupt := rhpHosts[0], rhpHosts[1], rhpHosts[2]
+	for _, root := range contracts[1].Roots[2:] {
+		lossy.DeleteSector(root)
+	}
+	for _, root := range contracts[2].Roots {
+		sector, _ := corrupt.Sector(root)
+		frand.Read(sector[:])
+	}
+	// contracts with unknown hosts are ignored
+	contracts = append(contracts, Contract{
+		HostKey: types.GeneratePrivateKey().PublicKey(),
+		Roots:   []types.Hash256{frand.Entropy256()},
+	})
+
+	cost := good.Settings().Prices.RPCVerifySectorCost().RenterCost()
+	a := NewAuditor(hosts, Config{
+		SamplesPerHost: 10,
+		MinAudits:      20,
+		MaxFailureRate: 0.05,
+	})
+	results := a.Audit(contracts)
+	if len(results) != 30 {
+		t.Fatalf("expected 30 results, got %v", len(results))
+	}
+	for _, res := range results {
+		switch {
+		case res.HostKey == good.PublicKey() && res.Outcome != OutcomePassed:
+			t.Fatalf("expected honest host to pass, got %v", res.Err)
+		case res.HostKey == corrupt.PublicKey() && res.Outcome != OutcomeFailed:
+			t.Fatal("expected corrupt host to fail")
+		case res.HostKey == lossy.PublicKey() && res.Outcome == OutcomeError:
+			t.Fatal(res.Err)
+		case res.Cost != cost:
+			t.Fatalf("expected audit to cost %v, got %v", cost, res.Cost)
+		}
+	}
+
+	// hosts are not reported until they have been audited enough
+	if r := a.Report(); len(r.Bad) != 0 {
+		t.Fatal("expected no bad hosts yet", r.Bad)
+	}
+	a.Audit(contracts)
+	r := a.Report()
+	if r.Spent != cost.Mul64(60) {
+		t.Fatalf("expected %v spent, got %v", cost.Mul64(60), r.Spent)
+	} else if hs := r.Hosts[good.PublicKey()]; hs.Passed != 20 || hs.FailureRate() != 0 {
+		t.Fatal("wrong stats for honest host", hs)
+	} else if hs := r.Hosts[lossy.PublicKey()]; hs.Failed == 0 || hs.Failed == 20 {
+		t.Fatal("wrong stats for lossy host", hs)
+	} else if len(r.Bad) != 2 || r.Bad[0] != corrupt.PublicKey() || r.Bad[1] != lossy.PublicKey() {
+		t.Fatal("wrong bad hosts", r.Bad)
+	}
+
+	// the budget is spread across hosts
+	a.ResetHost(corrupt.PublicKey())
+	a.cfg.Budget = cost.Mul64(7)
+	results = a.Audit(contracts)
+	perHost := make(map[types.PublicKey]int)
+	for _, res := range results {
+		perHost[res.HostKey]++
+	}
+	if len(results) != 7 {
+		t.Fatalf("expected 7 audits within budget, got %v", len(results))
+	}
+	for _, h := range rhpHosts {
+		if n := perHost[h.PublicKey()]; n < 2 || n > 3 {
+			t.Fatalf("expected 2-3 audits per host, got %v", n)
+		}
+	}
+	if hs := a.Report().Hosts[corrupt.PublicKey()]; hs.Audits() != uint64(perHost[corrupt.PublicKey()]) {
+		t.Fatal("expected reset host stats to start over")
+	}
+
+	// audits rejected by the renter are neither charged nor counted as
+	// failures
+	hosts[0].Client.Gouging().SetSettings(rhp.GougingSettings{MaxEgressPrice: types.NewCurrency64(1)})
+	a.cfg.Budget = types.ZeroCurrency
+	before := a.Report().Hosts[good.PublicKey()]
+	for _, res := range a.Audit(contracts[:1]) {
+		if res.Outcome != OutcomeError || !res.Cost.IsZero() {
+			t.Fatalf("expected free error, got %v costing %v", res.Outcome, res.Cost)
+		}
+	}
+	if after := a.Report().Hosts[good.PublicKey()]; after.Audits() != before.Audits() || after.Errors != before.Errors+10 {
+		t.Fatal("wrong stats after rejected audits", after)
+	}
+}
+
+func TestAuditorErrors(t *testing.T) {
+	// a host that refuses every audit with an error never fails one
+	h := rhptest.NewHost()
+	c := rhp.NewClient(h, h.PublicKey(), rhp.GougingSettings{})
+	renterKey, account := rhp.GenerateAccount()
+	h.Credit(account, types.Siacoins(1))
+	root, _, err := c.WriteSector(h.Settings().Prices, account.Token(renterKey, h.PublicKey()), bytes.NewReader(frand.Bytes(rhp.LeafSize)), rhp.LeafSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contracts := []Contract{{HostKey: h.PublicKey(), Roots: []types.Hash256{root}}}
+	// audit from an empty account, so that the host rejects every audit
+	auditKey, auditAccount := rhp.GenerateAccount()
+	token := auditAccount.Token(auditKey, h.PublicKey())
+
+	cfg := Config{
+		SamplesPerHost: 10,
+		MinAudits:      10,
+		MaxFailureRate: 0.05,
+	}
+	a := NewAuditor([]Host{{Client: c, Token: token}}, cfg)
+	for _, res := range a.Audit(contracts) {
+		if res.Outcome != OutcomeError {
+			t.Fatalf("expected error, got %v", res.Outcome)
+		}
+	}
+	if r := a.Report(); len(r.Bad) != 0 {
+		t.Fatal("expected errors to be ignored without a maximum error rate", r.Bad)
+	} else if hs := r.Hosts[h.PublicKey()]; hs.Attempts() != 10 || hs.ErrorRate() != 1 {
+		t.Fatal("wrong stats for erroring host", hs)
+	}
+
+	a.cfg.MaxErrorRate = 0.5
+	if r := a.Report(); len(r.Bad) != 1 || r.Bad[0] != h.PublicKey() {
+		t.Fatal("expected erroring host to be reported", r.Bad)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err  error
+		want Outcome
+	}{
+		{nil, OutcomePassed},
+		{fmt.Errorf("failed to verify: %w", rhp.ErrInvalidProof), OutcomeFailed},
+		// errors received from the host are decoded into new values
+		{rhp.NewRPCError(rhp.ErrorCodeHostError, "sector not found"), OutcomeFailed},
+		{rhp.NewRPCError(rhp.ErrorCodeHostError, "internal error"), OutcomeError},
+		{rhp.ErrHostInternalError, OutcomeError},
+		{rhp.ErrNotEnoughStorage, OutcomeError},
+		{rhp.ErrNotEnoughFunds, OutcomeError},
+		{errors.New("connection reset"), OutcomeError},
+	}
+	for _, test := range tests {
+		if got := classify(test.err); got != test.want {
+			t.Errorf("classify(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
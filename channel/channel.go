@@ -0,0 +1,183 @@
+// Package channel implements unidirectional siacoin payment channels.
+//
+// A channel is represented on-chain as an empty V2FileContract. The contract's
+// RenterOutput holds the payer's remaining balance, and its HostOutput holds
+// the payee's balance. Payments are off-chain revisions that move value from
+// the former to the latter. Since the contract stores no data, the payee can
+// always resolve it "validly" by submitting an empty storage proof once the
+// contract's ProofHeight has been reached.
+package channel
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+// DisputeWindow is the minimum number of blocks between a channel's ProofHeight
+// and its ExpirationHeight. The payee must resolve the channel within this
+// window; otherwise, anyone may expire it, and the payee's balance is
+// forfeited.
+const DisputeWindow = 144 // 24 hours
+
+// ErrChannelClosed is returned when attempting to pay with a channel that has
+// been finalized.
+var ErrChannelClosed = errors.New("channel is closed")
+
+// Params specify the terms of a new channel.
+type Params struct {
+	PayerPublicKey types.PublicKey `json:"payerPublicKey"`
+	PayerAddress   types.Address   `json:"payerAddress"`
+	PayeePublicKey types.PublicKey `json:"payeePublicKey"`
+	PayeeAddress   types.Address   `json:"payeeAddress"`
+	// Deposit is the amount locked into the channel by the payer. It is the
+	// maximum amount that can be paid through the channel.
+	Deposit types.Currency `json:"deposit"`
+	// ProofHeight is the height at which the payee may resolve the channel. No
+	// further payments may be made on-chain after this height.
+	ProofHeight uint64 `json:"proofHeight"`
+}
+
+// NewChannel returns the initial, unsigned contract for a channel with the
+// given parameters.
+func NewChannel(p Params) types.V2FileContract {
+	return types.V2FileContract{
+		ProofHeight:      p.ProofHeight,
+		ExpirationHeight: p.ProofHeight + DisputeWindow,
+		RenterOutput: types.SiacoinOutput{
+			Value:   p.Deposit,
+			Address: p.PayerAddress,
+		},
+		HostOutput: types.SiacoinOutput{
+			Value:   types.ZeroCurrency,
+			Address: p.PayeeAddress,
+		},
+		MissedHostValue: types.ZeroCurrency,
+		TotalCollateral: types.ZeroCurrency,
+		RenterPublicKey: p.PayerPublicKey,
+		HostPublicKey:   p.PayeePublicKey,
+	}
+}
+
+// OpenCost returns the total amount the payer must fund in order to open a
+// channel with the given contract, including the siafund tax.
+func OpenCost(cs consensus.State, fc types.V2FileContract) types.Currency {
+	return fc.RenterOutput.Value.Add(fc.HostOutput.Value).Add(cs.V2FileContractTax(fc))
+}
+
+// Balances returns the payer's remaining balance and the amount paid to the
+// payee so far.
+func Balances(fc types.V2FileContract) (payer, payee types.Currency) {
+	return fc.RenterOutput.Value, fc.HostOutput.Value
+}
+
+// SignPayer signs fc with the payer's key.
+func SignPayer(cs consensus.State, fc *types.V2FileContract, sk types.PrivateKey) {
+	fc.RenterSignature = sk.SignHash(cs.ContractSigHash(*fc))
+}
+
+// SignPayee signs fc with the payee's key.
+func SignPayee(cs consensus.State, fc *types.V2FileContract, sk types.PrivateKey) {
+	fc.HostSignature = sk.SignHash(cs.ContractSigHash(*fc))
+}
+
+// Pay returns a revision of fc that transfers amount from the payer to the
+// payee. The revision is unsigned.
+func Pay(fc types.V2FileContract, amount types.Currency) (types.V2FileContract, error) {
+	if fc.RevisionNumber == math.MaxUint64 {
+		return fc, ErrChannelClosed
+	} else if amount.IsZero() {
+		return fc, errors.New("payment must be greater than zero")
+	} else if fc.RenterOutput.Value.Cmp(amount) < 0 {
+		return fc, fmt.Errorf("insufficient channel balance: %v < %v", fc.RenterOutput.Value, amount)
+	}
+	fc.RevisionNumber++
+	fc.RenterOutput.Value = fc.RenterOutput.Value.Sub(amount)
+	fc.HostOutput.Value = fc.HostOutput.Value.Add(amount)
+	fc.RenterSignature = types.Signature{}
+	fc.HostSignature = types.Signature{}
+	return fc, nil
+}
+
+// ValidatePayment checks that rev is a revision of cur that transfers exactly
+// amount to the payee, and that it carries a valid payer signature. The payee
+// should call ValidatePayment before countersigning a payment.
+func ValidatePayment(cs consensus.State, cur, rev types.V2FileContract, amount types.Currency) error {
+	expected, err := Pay(cur, amount)
+	if err != nil {
+		return err
+	}
+	expected.RenterSignature, expected.HostSignature = rev.RenterSignature, rev.HostSignature
+	if rev != expected {
+		return errors.New("revision does not match expected payment")
+	} else if !cur.RenterPublicKey.VerifyHash(cs.ContractSigHash(rev), rev.RenterSignature) {
+		return errors.New("revision has invalid payer signature")
+	}
+	return nil
+}
+
+// Close returns the final, unsigned revision of fc. The revision number is set
+// to its maximum value, preventing any further revisions, and the proof height
+// is moved to proofHeight, allowing the channel to be resolved without waiting
+// for its original proof height. proofHeight must be greater than the current
+// chain height.
+func Close(fc types.V2FileContract, proofHeight uint64) types.V2FileContract {
+	fc.RevisionNumber = math.MaxUint64
+	fc.ProofHeight = proofHeight
+	fc.ExpirationHeight = proofHeight + DisputeWindow
+	fc.RenterSignature = types.Signature{}
+	fc.HostSignature = types.Signature{}
+	return fc
+}
+
+// RevisionTransaction returns a transaction that broadcasts rev, which must be
+// signed by both parties, as a revision of the on-chain channel fce. The
+// transaction does not pay a miner fee.
+func RevisionTransaction(fce types.V2FileContractElement, rev types.V2FileContract) types.V2Transaction {
+	return types.V2Transaction{
+		FileContractRevisions: []types.V2FileContractRevision{{
+			Parent:   fce.Copy(),
+			Revision: rev,
+		}},
+	}
+}
+
+// Dispute returns a transaction that replaces a stale on-chain revision with
+// latest. It returns false if the on-chain revision is not stale, in which
+// case no action is required.
+//
+// Disputes must be confirmed before the channel's ProofHeight; after that,
+// the on-chain revision is final.
+func Dispute(fce types.V2FileContractElement, latest types.V2FileContract) (types.V2Transaction, bool) {
+	if latest.RevisionNumber <= fce.V2FileContract.RevisionNumber {
+		return types.V2Transaction{}, false
+	}
+	return RevisionTransaction(fce, latest), true
+}
+
+// ResolveTransaction returns a transaction that resolves the on-chain channel
+// fce, creating outputs for the payer's and payee's balances. proofIndex must
+// be the ChainIndexElement at the channel's ProofHeight. The transaction is
+// valid once the channel's ProofHeight has been reached, but after the
+// channel's ExpirationHeight, anyone may expire the channel instead, forfeiting
+// the payee's balance. The transaction does not pay a miner fee.
+func ResolveTransaction(fce types.V2FileContractElement, proofIndex types.ChainIndexElement) (types.V2Transaction, error) {
+	if fce.V2FileContract.Filesize != 0 {
+		return types.V2Transaction{}, errors.New("contract is not a payment channel")
+	} else if proofIndex.ChainIndex.Height != fce.V2FileContract.ProofHeight {
+		return types.V2Transaction{}, fmt.Errorf("proof index height (%v) does not match channel proof height (%v)", proofIndex.ChainIndex.Height, fce.V2FileContract.ProofHeight)
+	}
+	// an empty contract has an empty Merkle root, which is produced by an empty
+	// storage proof
+	return types.V2Transaction{
+		FileContractResolutions: []types.V2FileContractResolution{{
+			Parent: fce.Copy(),
+			Resolution: &types.V2StorageProof{
+				ProofIndex: proofIndex.Copy(),
+			},
+		}},
+	}, nil
+}
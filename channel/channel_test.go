@@ -0,0 +1,346 @@
+package channel
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+// testChain is a minimal offline chain that mines blocks with
+// consensus.ApplyBlock and tracks the elements relevant to a channel.
+type testChain struct {
+	t     *testing.T
+	cs    consensus.State
+	sces  map[types.SiacoinOutputID]types.SiacoinElement
+	fces  map[types.FileContractID]types.V2FileContractElement
+	cies  []types.ChainIndexElement
+	miner types.Address
+}
+
+func (c *testChain) applyUpdate(au consensus.ApplyUpdate) {
+	for id, sce := range c.sces {
+		au.UpdateElementProof(&sce.StateElement)
+		c.sces[id] = sce.Move()
+	}
+	for id, fce := range c.fces {
+		au.UpdateElementProof(&fce.StateElement)
+		c.fces[id] = fce.Move()
+	}
+	for i := range c.cies {
+		au.UpdateElementProof(&c.cies[i].StateElement)
+	}
+	for _, diff := range au.SiacoinElementDiffs() {
+		if diff.Spent {
+			delete(c.sces, diff.SiacoinElement.ID)
+		} else {
+			c.sces[diff.SiacoinElement.ID] = diff.SiacoinElement.Copy()
+		}
+	}
+	for _, diff := range au.V2FileContractElementDiffs() {
+		switch {
+		case diff.Resolution != nil:
+			delete(c.fces, diff.V2FileContractElement.ID)
+		case diff.Revision != nil:
+			diff.V2FileContractElement.V2FileContract = *diff.Revision
+			c.fces[diff.V2FileContractElement.ID] = diff.V2FileContractElement.Copy()
+		default:
+			c.fces[diff.V2FileContractElement.ID] = diff.V2FileContractElement.Copy()
+		}
+	}
+	c.cies = append(c.cies, au.ChainIndexElement())
+}
+
+func (c *testChain) mineBlock(txns ...types.V2Transaction) error {
+	b := types.Block{
+		ParentID:  c.cs.Index.ID,
+		Timestamp: c.cs.PrevTimestamps[0].Add(time.Second),
+		MinerPayouts: []types.SiacoinOutput{{
+			Address: c.miner,
+			Value:   c.cs.BlockReward(),
+		}},
+		V2: &types.V2BlockData{
+			Height:       c.cs.Index.Height + 1,
+			Transactions: txns,
+		},
+	}
+	for _, txn := range txns {
+		b.MinerPayouts[0].Value = b.MinerPayouts[0].Value.Add(txn.MinerFee)
+	}
+	b.V2.Commitment = c.cs.Commitment(c.cs.TransactionsCommitment(b.Transactions, b.V2Transactions()), b.MinerPayouts[0].Address)
+	for b.Nonce%c.cs.NonceFactor() != 0 {
+		b.Nonce++
+	}
+	for b.ID().CmpWork(c.cs.ChildTarget) < 0 {
+		b.Nonce += c.cs.NonceFactor()
+	}
+	if err := consensus.ValidateBlock(c.cs, b, consensus.V1BlockSupplement{}); err != nil {
+		return err
+	}
+	var au consensus.ApplyUpdate
+	c.cs, au = consensus.ApplyBlock(c.cs, b, consensus.V1BlockSupplement{}, time.Time{})
+	c.applyUpdate(au)
+	return nil
+}
+
+func (c *testChain) mustMine(txns ...types.V2Transaction) {
+	c.t.Helper()
+	if err := c.mineBlock(txns...); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testChain) mineTo(height uint64) {
+	c.t.Helper()
+	for c.cs.Index.Height < height {
+		c.mustMine()
+	}
+}
+
+func testNetwork() *consensus.Network {
+	n := &consensus.Network{
+		Name:            "channel-test",
+		InitialCoinbase: types.Siacoins(300000),
+		MinimumCoinbase: types.Siacoins(300000),
+		InitialTarget:   types.BlockID{0xFF},
+		BlockInterval:   10 * time.Millisecond,
+		MaturityDelay:   5,
+	}
+	n.HardforkOak.GenesisTimestamp = time.Unix(1618033988, 0)
+	n.HardforkASIC.Height = 1e6
+	n.HardforkFoundation.Height = 1e6
+	n.HardforkFoundation.PrimaryAddress = types.VoidAddress
+	n.HardforkFoundation.FailsafeAddress = types.VoidAddress
+	return n
+}
+
+// newTestChain returns a chain whose genesis block funds addr with the given
+// value.
+func newTestChain(t *testing.T, addr types.Address, value types.Currency) *testChain {
+	n := testNetwork()
+	genesis := types.Block{
+		Timestamp: n.HardforkOak.GenesisTimestamp,
+		V2: &types.V2BlockData{
+			Transactions: []types.V2Transaction{{
+				SiacoinOutputs: []types.SiacoinOutput{{Address: addr, Value: value}},
+			}},
+		},
+	}
+	c := &testChain{
+		t:     t,
+		sces:  make(map[types.SiacoinOutputID]types.SiacoinElement),
+		fces:  make(map[types.FileContractID]types.V2FileContractElement),
+		miner: types.VoidAddress,
+	}
+	var au consensus.ApplyUpdate
+	c.cs, au = consensus.ApplyBlock(n.GenesisState(), genesis, consensus.V1BlockSupplement{}, time.Time{})
+	c.applyUpdate(au)
+	return c
+}
+
+func (c *testChain) balance(addr types.Address) (sum types.Currency) {
+	for _, sce := range c.sces {
+		if sce.SiacoinOutput.Address == addr {
+			sum = sum.Add(sce.SiacoinOutput.Value)
+		}
+	}
+	return
+}
+
+type testParty struct {
+	sk     types.PrivateKey
+	policy types.SpendPolicy
+	addr   types.Address
+}
+
+func newTestParty() testParty {
+	sk := types.GeneratePrivateKey()
+	policy := types.PolicyPublicKey(sk.PublicKey())
+	return testParty{sk: sk, policy: policy, addr: policy.Address()}
+}
+
+// openChannel funds and broadcasts a new channel, returning its ID.
+func openChannel(t *testing.T, c *testChain, payer, payee testParty, deposit types.Currency, proofHeight uint64) (types.FileContractID, types.V2FileContract) {
+	t.Helper()
+	fc := NewChannel(Params{
+		PayerPublicKey: payer.sk.PublicKey(),
+		PayerAddress:   payer.addr,
+		PayeePublicKey: payee.sk.PublicKey(),
+		PayeeAddress:   payee.addr,
+		Deposit:        deposit,
+		ProofHeight:    proofHeight,
+	})
+	SignPayer(c.cs, &fc, payer.sk)
+	SignPayee(c.cs, &fc, payee.sk)
+
+	var txn types.V2Transaction
+	var inputSum types.Currency
+	for _, sce := range c.sces {
+		if sce.SiacoinOutput.Address == payer.addr {
+			txn.SiacoinInputs = append(txn.SiacoinInputs, types.V2SiacoinInput{
+				Parent:          sce.Copy(),
+				SatisfiedPolicy: types.SatisfiedPolicy{Policy: payer.policy},
+			})
+			inputSum = inputSum.Add(sce.SiacoinOutput.Value)
+		}
+	}
+	cost := OpenCost(c.cs, fc)
+	txn.FileContracts = []types.V2FileContract{fc}
+	txn.SiacoinOutputs = []types.SiacoinOutput{{Address: payer.addr, Value: inputSum.Sub(cost)}}
+	sig := payer.sk.SignHash(c.cs.InputSigHash(txn))
+	for i := range txn.SiacoinInputs {
+		txn.SiacoinInputs[i].SatisfiedPolicy.Signatures = []types.Signature{sig}
+	}
+	c.mustMine(txn)
+	return txn.V2FileContractID(txn.ID(), 0), fc
+}
+
+// pay performs an off-chain payment, with the payer signing the revision and
+// the payee validating and countersigning it.
+func pay(t *testing.T, cs consensus.State, payer, payee testParty, fc types.V2FileContract, amount types.Currency) types.V2FileContract {
+	t.Helper()
+	rev, err := Pay(fc, amount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SignPayer(cs, &rev, payer.sk)
+	if err := ValidatePayment(cs, fc, rev, amount); err != nil {
+		t.Fatal(err)
+	}
+	SignPayee(cs, &rev, payee.sk)
+	return rev
+}
+
+func TestChannelDispute(t *testing.T) {
+	payer, payee := newTestParty(), newTestParty()
+	c := newTestChain(t, payer.addr, types.Siacoins(100))
+
+	deposit := types.Siacoins(50)
+	fcid, fc := openChannel(t, c, payer, payee, deposit, 20)
+	if _, ok := c.fces[fcid]; !ok {
+		t.Fatal("channel was not created")
+	}
+
+	// make a series of payments
+	stale := pay(t, c.cs, payer, payee, fc, types.Siacoins(1))
+	latest := stale
+	for i := 0; i < 5; i++ {
+		latest = pay(t, c.cs, payer, payee, latest, types.Siacoins(2))
+	}
+	if payerBal, payeeBal := Balances(latest); !payerBal.Equals(types.Siacoins(39)) || !payeeBal.Equals(types.Siacoins(11)) {
+		t.Fatalf("expected balances 39 SC / 11 SC, got %v / %v", payerBal, payeeBal)
+	}
+
+	// payer broadcasts a stale revision
+	c.mustMine(RevisionTransaction(c.fces[fcid], stale))
+	if c.fces[fcid].V2FileContract.RevisionNumber != stale.RevisionNumber {
+		t.Fatal("stale revision was not applied")
+	}
+
+	// payee disputes with the latest revision
+	txn, ok := Dispute(c.fces[fcid], latest)
+	if !ok {
+		t.Fatal("expected dispute")
+	}
+	c.mustMine(txn)
+	if c.fces[fcid].V2FileContract.RevisionNumber != latest.RevisionNumber {
+		t.Fatal("latest revision was not applied")
+	} else if _, ok := Dispute(c.fces[fcid], latest); ok {
+		t.Fatal("should not dispute a current revision")
+	}
+
+	// the stale revision can no longer be applied
+	if err := c.mineBlock(RevisionTransaction(c.fces[fcid], stale)); err == nil {
+		t.Fatal("expected stale revision to be rejected")
+	}
+
+	// resolution is not valid before the proof height
+	if _, err := ResolveTransaction(c.fces[fcid], c.cies[len(c.cies)-1]); err == nil {
+		t.Fatal("expected error when resolving with wrong proof index")
+	}
+	c.mineTo(fc.ProofHeight)
+	txn, err := ResolveTransaction(c.fces[fcid], c.cies[fc.ProofHeight])
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.mustMine(txn)
+	if _, ok := c.fces[fcid]; ok {
+		t.Fatal("channel was not resolved")
+	} else if bal := c.balance(payee.addr); !bal.Equals(types.Siacoins(11)) {
+		t.Fatalf("expected payee balance of 11 SC, got %v", bal)
+	} else if bal := c.balance(payer.addr); !bal.Equals(types.Siacoins(89).Sub(c.cs.V2FileContractTax(fc))) {
+		t.Fatalf("expected payer balance of 89 SC minus tax, got %v", bal)
+	}
+}
+
+func TestChannelClose(t *testing.T) {
+	payer, payee := newTestParty(), newTestParty()
+	c := newTestChain(t, payer.addr, types.Siacoins(100))
+	fcid, fc := openChannel(t, c, payer, payee, types.Siacoins(10), 1000)
+
+	rev := pay(t, c.cs, payer, payee, fc, types.Siacoins(3))
+	final := Close(rev, c.cs.Index.Height+1)
+	SignPayer(c.cs, &final, payer.sk)
+	SignPayee(c.cs, &final, payee.sk)
+	if _, err := Pay(final, types.Siacoins(1)); !errors.Is(err, ErrChannelClosed) {
+		t.Fatalf("expected %v, got %v", ErrChannelClosed, err)
+	}
+	c.mustMine(RevisionTransaction(c.fces[fcid], final))
+
+	// the closed channel cannot be revised further
+	if _, ok := Dispute(c.fces[fcid], rev); ok {
+		t.Fatal("should not dispute a closed channel")
+	}
+
+	txn, err := ResolveTransaction(c.fces[fcid], c.cies[final.ProofHeight])
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.mustMine(txn)
+	if _, ok := c.fces[fcid]; ok {
+		t.Fatal("channel was not resolved")
+	} else if bal := c.balance(payee.addr); !bal.Equals(types.Siacoins(3)) {
+		t.Fatalf("expected payee balance of 3 SC, got %v", bal)
+	}
+}
+
+func TestChannelPayment(t *testing.T) {
+	payer, payee := newTestParty(), newTestParty()
+	cs := testNetwork().GenesisState()
+	fc := NewChannel(Params{
+		PayerPublicKey: payer.sk.PublicKey(),
+		PayerAddress:   payer.addr,
+		PayeePublicKey: payee.sk.PublicKey(),
+		PayeeAddress:   payee.addr,
+		Deposit:        types.Siacoins(5),
+		ProofHeight:    100,
+	})
+
+	if _, err := Pay(fc, types.Siacoins(6)); err == nil {
+		t.Fatal("expected insufficient balance error")
+	} else if _, err := Pay(fc, types.ZeroCurrency); err == nil {
+		t.Fatal("expected zero payment error")
+	}
+
+	rev, err := Pay(fc, types.Siacoins(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidatePayment(cs, fc, rev, types.Siacoins(2)); err == nil {
+		t.Fatal("expected missing signature error")
+	}
+	SignPayer(cs, &rev, payer.sk)
+	if err := ValidatePayment(cs, fc, rev, types.Siacoins(2)); err != nil {
+		t.Fatal(err)
+	} else if err := ValidatePayment(cs, fc, rev, types.Siacoins(1)); err == nil {
+		t.Fatal("expected amount mismatch error")
+	}
+
+	// a revision signed by the wrong key is rejected
+	SignPayer(cs, &rev, payee.sk)
+	if err := ValidatePayment(cs, fc, rev, types.Siacoins(2)); err == nil {
+		t.Fatal("expected invalid signature error")
+	}
+}
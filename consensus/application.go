@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"iter"
 	"math/big"
 	"math/bits"
 	"slices"
@@ -679,6 +680,31 @@ func forEachRevertedElement(sces []SiacoinElementDiff, sfes []SiafundElementDiff
 	}
 }
 
+// A ProofUpdater updates the Merkle proofs of elements to incorporate the
+// changes made to the accumulator by an ApplyUpdate or RevertUpdate. The
+// intermediate hashes needed to update each proof are computed once, when the
+// ProofUpdater is created, making it much faster than UpdateElementProof when
+// updating many elements.
+//
+// A ProofUpdater is safe for concurrent use, provided that each element is
+// updated by only one goroutine.
+type ProofUpdater struct {
+	update func(*types.StateElement)
+}
+
+// UpdateElementProof updates the Merkle proof of the supplied element. As with
+// ApplyUpdate.UpdateElementProof, the element's proof must be up-to-date.
+func (pu *ProofUpdater) UpdateElementProof(e *types.StateElement) {
+	pu.update(e)
+}
+
+// UpdateElementProofs updates the Merkle proofs of each supplied element.
+func (pu *ProofUpdater) UpdateElementProofs(seq iter.Seq[*types.StateElement]) {
+	for e := range seq {
+		pu.update(e)
+	}
+}
+
 // An ApplyUpdate represents the effects of applying a block to a state.
 type ApplyUpdate struct {
 	sces   []SiacoinElementDiff
@@ -714,6 +740,20 @@ func (au ApplyUpdate) UpdateElementProof(e *types.StateElement) {
 	au.eau.updateElementProof(e)
 }
 
+// ProofUpdater returns a ProofUpdater for au.
+func (au ApplyUpdate) ProofUpdater() *ProofUpdater {
+	eau := au.eau
+	pu := newProofUpdater(&eau.updated)
+	return &ProofUpdater{update: func(e *types.StateElement) { eau.updateElementProofWith(e, pu) }}
+}
+
+// UpdateElementProofs updates the Merkle proofs of each supplied element. It
+// is equivalent to, but faster than, calling UpdateElementProof on each
+// element.
+func (au ApplyUpdate) UpdateElementProofs(seq iter.Seq[*types.StateElement]) {
+	au.ProofUpdater().UpdateElementProofs(seq)
+}
+
 // ForEachTreeNode calls fn on each node in the accumulator affected by au.
 func (au ApplyUpdate) ForEachTreeNode(fn func(row, col uint64, h types.Hash256)) {
 	seen := make(map[[2]uint64]bool)
@@ -814,6 +854,20 @@ func (ru RevertUpdate) UpdateElementProof(e *types.StateElement) {
 	ru.eru.updateElementProof(e)
 }
 
+// ProofUpdater returns a ProofUpdater for ru.
+func (ru RevertUpdate) ProofUpdater() *ProofUpdater {
+	eru := ru.eru
+	pu := newProofUpdater(&eru.updated)
+	return &ProofUpdater{update: func(e *types.StateElement) { eru.updateElementProofWith(e, pu) }}
+}
+
+// UpdateElementProofs updates the Merkle proofs of each supplied element. It
+// is equivalent to, but faster than, calling UpdateElementProof on each
+// element.
+func (ru RevertUpdate) UpdateElementProofs(seq iter.Seq[*types.StateElement]) {
+	ru.ProofUpdater().UpdateElementProofs(seq)
+}
+
 // ForEachTreeNode calls fn on each node in the accumulator affected by ru.
 func (ru RevertUpdate) ForEachTreeNode(fn func(row, col uint64, h types.Hash256)) {
 	seen := make(map[[2]uint64]bool)
@@ -0,0 +1,509 @@
+// Package chaintest provides a deterministic chain simulator for testing code
+// that interacts with consensus.
+//
+// A Chain drives ApplyBlock and RevertBlock directly, without a node or a
+// database. It constructs V1BlockSupplements automatically, tracks every
+// element in the accumulator, and keeps their Merkle proofs up to date, so that
+// multi-block scenarios (reorgs, contract expirations, storage proofs, siafund
+// claims, and the v2 hardfork transition) can be expressed in a few lines:
+//
+//	c := chaintest.New(t, nil)
+//	alice, bob := c.Wallet("alice"), c.Wallet("bob")
+//	c.MineTo(alice.Address(), 1).Mine(int(c.Network().MaturityDelay))
+//	c.SendSiacoins(alice, bob.Address(), types.Siacoins(100)).Mine(1)
+//
+// Wallet transactions are funded only with confirmed outputs; an output created
+// by a pending transaction cannot be spent until the next block is mined.
+//
+// Keys are derived from wallet names and block timestamps are taken from a
+// simulated clock, so identical scenarios always produce identical chains.
+package chaintest
+
+import (
+	"cmp"
+	"slices"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+// GenesisTimestamp is the timestamp of the genesis block of the Network
+// returned by Network.
+var GenesisTimestamp = time.Unix(1618033988, 0).UTC()
+
+// Network returns a network suitable for testing. Each v1 hardfork activates
+// within the first 10 blocks, v2 transactions are allowed at height 20, and v2
+// is required at height 30. The Foundation subsidy is paid to the "foundation"
+// wallet. The returned network may be modified before passing it to New.
+func Network() *consensus.Network {
+	n := &consensus.Network{
+		Name:            "chaintest",
+		InitialCoinbase: types.Siacoins(300000),
+		MinimumCoinbase: types.Siacoins(30000),
+		InitialTarget:   types.BlockID{0xFF},
+		BlockInterval:   10 * time.Minute,
+		MaturityDelay:   5,
+	}
+	n.HardforkDevAddr.Height = 1
+	n.HardforkTax.Height = 2
+	n.HardforkStorageProof.Height = 3
+	n.HardforkOak.Height = 4
+	n.HardforkOak.FixHeight = 5
+	n.HardforkOak.GenesisTimestamp = GenesisTimestamp
+	n.HardforkASIC.Height = 6
+	n.HardforkASIC.OakTime = 6 * n.BlockInterval
+	n.HardforkASIC.OakTarget = n.InitialTarget
+	n.HardforkFoundation.Height = 7
+	n.HardforkFoundation.PrimaryAddress = types.StandardUnlockHash(walletKey("foundation").PublicKey())
+	n.HardforkFoundation.FailsafeAddress = types.VoidAddress
+	n.HardforkV2.AllowHeight = 20
+	n.HardforkV2.RequireHeight = 30
+	return n
+}
+
+// A Chain is a simulated blockchain. Methods that modify the chain report
+// errors by calling Fatal on the Chain's testing.TB, and return the Chain so
+// that calls can be chained.
+type Chain struct {
+	tb      testing.TB
+	n       *consensus.Network
+	clock   time.Time
+	wallets map[string]*Wallet
+
+	states []consensus.State // states[i] is the state after applying blocks[i]
+	blocks []types.Block
+	supps  []consensus.V1BlockSupplement
+	cies   []types.ChainIndexElement
+
+	sces   map[types.SiacoinOutputID]types.SiacoinElement
+	sfes   map[types.SiafundOutputID]types.SiafundElement
+	fces   map[types.FileContractID]types.FileContractElement
+	v2fces map[types.FileContractID]types.V2FileContractElement
+
+	txns   []types.Transaction
+	v2txns []types.V2Transaction
+	used   map[types.ElementID]bool // elements spent by pending transactions
+
+	onApply  []func(consensus.State, types.Block, consensus.ApplyUpdate)
+	onRevert []func(consensus.State, types.Block, consensus.RevertUpdate)
+}
+
+// Network returns the chain's network.
+func (c *Chain) Network() *consensus.Network { return c.n }
+
+// Tip returns the current consensus state.
+func (c *Chain) Tip() consensus.State { return c.states[len(c.states)-1] }
+
+// State returns the consensus state as of the block at the specified height.
+func (c *Chain) State(height uint64) consensus.State {
+	c.tb.Helper()
+	if height >= uint64(len(c.states)) {
+		c.tb.Fatalf("no block at height %v", height)
+	}
+	return c.states[height]
+}
+
+// Block returns the block at the specified height.
+func (c *Chain) Block(height uint64) types.Block {
+	c.tb.Helper()
+	if height >= uint64(len(c.blocks)) {
+		c.tb.Fatalf("no block at height %v", height)
+	}
+	return c.blocks[height]
+}
+
+// Supplement returns the supplement used to apply the block at the specified
+// height.
+func (c *Chain) Supplement(height uint64) consensus.V1BlockSupplement {
+	c.tb.Helper()
+	if height >= uint64(len(c.supps)) {
+		c.tb.Fatalf("no block at height %v", height)
+	}
+	return c.supps[height]
+}
+
+// Time returns the timestamp that will be used for the next block.
+func (c *Chain) Time() time.Time { return c.clock }
+
+// SetTime sets the timestamp that will be used for the next block. Subsequent
+// blocks are spaced BlockInterval apart.
+func (c *Chain) SetTime(t time.Time) *Chain {
+	c.clock = t
+	return c
+}
+
+// AdvanceTime adds d to the timestamp that will be used for the next block.
+func (c *Chain) AdvanceTime(d time.Duration) *Chain {
+	c.clock = c.clock.Add(d)
+	return c
+}
+
+// OnApply registers a function to be called after each block is applied. It is
+// not called for the genesis block, which is applied by New.
+func (c *Chain) OnApply(fn func(cs consensus.State, b types.Block, au consensus.ApplyUpdate)) *Chain {
+	c.onApply = append(c.onApply, fn)
+	return c
+}
+
+// OnRevert registers a function to be called after each block is reverted. cs
+// is the state after reverting the block, i.e. the state of its parent.
+func (c *Chain) OnRevert(fn func(cs consensus.State, b types.Block, ru consensus.RevertUpdate)) *Chain {
+	c.onRevert = append(c.onRevert, fn)
+	return c
+}
+
+// SiacoinElement returns the unspent siacoin element with the given ID.
+func (c *Chain) SiacoinElement(id types.SiacoinOutputID) (types.SiacoinElement, bool) {
+	sce, ok := c.sces[id]
+	return sce.Copy(), ok
+}
+
+// SiafundElement returns the unspent siafund element with the given ID.
+func (c *Chain) SiafundElement(id types.SiafundOutputID) (types.SiafundElement, bool) {
+	sfe, ok := c.sfes[id]
+	return sfe.Copy(), ok
+}
+
+// FileContractElement returns the unresolved v1 file contract with the given
+// ID.
+func (c *Chain) FileContractElement(id types.FileContractID) (types.FileContractElement, bool) {
+	fce, ok := c.fces[id]
+	return fce.Copy(), ok
+}
+
+// V2FileContractElement returns the unresolved v2 file contract with the given
+// ID.
+func (c *Chain) V2FileContractElement(id types.FileContractID) (types.V2FileContractElement, bool) {
+	fce, ok := c.v2fces[id]
+	return fce.Copy(), ok
+}
+
+// ChainIndexElement returns the chain index element of the block at the
+// specified height.
+func (c *Chain) ChainIndexElement(height uint64) types.ChainIndexElement {
+	c.tb.Helper()
+	if height >= uint64(len(c.cies)) {
+		c.tb.Fatalf("no block at height %v", height)
+	}
+	return c.cies[height].Copy()
+}
+
+// AddTransaction adds a v1 transaction to the next block.
+func (c *Chain) AddTransaction(txn types.Transaction) *Chain {
+	c.txns = append(c.txns, txn)
+	return c
+}
+
+// AddV2Transaction adds a v2 transaction to the next block.
+func (c *Chain) AddV2Transaction(txn types.V2Transaction) *Chain {
+	c.v2txns = append(c.v2txns, txn.DeepCopy())
+	return c
+}
+
+// supplement returns the supplement for b, which must be a child of the tip.
+func (c *Chain) supplement(b types.Block) consensus.V1BlockSupplement {
+	bs := consensus.V1BlockSupplement{
+		Transactions: make([]consensus.V1TransactionSupplement, len(b.Transactions)),
+	}
+	for i, txn := range b.Transactions {
+		ts := &bs.Transactions[i]
+		for _, sci := range txn.SiacoinInputs {
+			if sce, ok := c.sces[sci.ParentID]; ok {
+				ts.SiacoinInputs = append(ts.SiacoinInputs, sce.Copy())
+			}
+		}
+		for _, sfi := range txn.SiafundInputs {
+			if sfe, ok := c.sfes[sfi.ParentID]; ok {
+				ts.SiafundInputs = append(ts.SiafundInputs, sfe.Copy())
+			}
+		}
+		for _, fcr := range txn.FileContractRevisions {
+			if fce, ok := c.fces[fcr.ParentID]; ok {
+				ts.RevisedFileContracts = append(ts.RevisedFileContracts, fce.Copy())
+			}
+		}
+		for _, sp := range txn.StorageProofs {
+			if fce, ok := c.fces[sp.ParentID]; ok && fce.FileContract.WindowStart > 0 && fce.FileContract.WindowStart <= uint64(len(c.states)) {
+				ts.StorageProofs = append(ts.StorageProofs, consensus.V1StorageProofSupplement{
+					FileContract: fce.Copy(),
+					WindowID:     c.states[fce.FileContract.WindowStart-1].Index.ID,
+				})
+			}
+		}
+	}
+	childHeight := c.Tip().Index.Height + 1
+	for _, fce := range c.fces {
+		if fce.FileContract.WindowEnd == childHeight {
+			bs.ExpiringFileContracts = append(bs.ExpiringFileContracts, fce.Copy())
+		}
+	}
+	slices.SortFunc(bs.ExpiringFileContracts, func(a, b types.FileContractElement) int {
+		return cmp.Compare(a.StateElement.LeafIndex, b.StateElement.LeafIndex)
+	})
+	return bs
+}
+
+// ancestorTimestamp returns the timestamp used to adjust the difficulty of the
+// child block.
+func (c *Chain) ancestorTimestamp() time.Time {
+	cs := c.Tip()
+	childHeight := cs.Index.Height + 1
+	return c.blocks[childHeight-min(childHeight, cs.AncestorDepth())].Timestamp
+}
+
+// NewBlock returns a block containing the pending transactions, paying the
+// block reward to the void address. The block is not applied.
+func (c *Chain) NewBlock() types.Block {
+	return c.newBlock(types.VoidAddress)
+}
+
+func (c *Chain) newBlock(minerAddr types.Address) types.Block {
+	cs := c.Tip()
+	reward := cs.BlockReward()
+	for _, txn := range c.txns {
+		for _, fee := range txn.MinerFees {
+			reward = reward.Add(fee)
+		}
+	}
+	for _, txn := range c.v2txns {
+		reward = reward.Add(txn.MinerFee)
+	}
+	b := types.Block{
+		ParentID:     cs.Index.ID,
+		Timestamp:    c.clock,
+		Transactions: slices.Clone(c.txns),
+		MinerPayouts: []types.SiacoinOutput{{Address: minerAddr, Value: reward}},
+	}
+	if childHeight := cs.Index.Height + 1; childHeight >= c.n.HardforkV2.AllowHeight {
+		b.V2 = &types.V2BlockData{
+			Height:       childHeight,
+			Transactions: slices.Clone(c.v2txns),
+		}
+		b.V2.Commitment = cs.Commitment(cs.TransactionsCommitment(b.Transactions, b.V2Transactions()), minerAddr)
+	}
+	for b.Nonce%cs.NonceFactor() != 0 {
+		b.Nonce++
+	}
+	for b.ID().CmpWork(cs.ChildTarget) < 0 {
+		b.Nonce += cs.NonceFactor()
+	}
+	return b
+}
+
+// ApplyBlock validates b and applies it to the tip. Pending transactions are
+// not affected.
+func (c *Chain) ApplyBlock(b types.Block) error {
+	cs := c.Tip()
+	bs := c.supplement(b)
+	if err := consensus.ValidateBlock(cs, b, bs); err != nil {
+		return err
+	}
+	cs, au := consensus.ApplyBlock(cs, b, bs, c.ancestorTimestamp())
+	c.applyBlock(cs, b, bs, au)
+	return nil
+}
+
+func (c *Chain) applyBlock(cs consensus.State, b types.Block, bs consensus.V1BlockSupplement, au consensus.ApplyUpdate) {
+	for id, sce := range c.sces {
+		au.UpdateElementProof(&sce.StateElement)
+		c.sces[id] = sce.Move()
+	}
+	for id, sfe := range c.sfes {
+		au.UpdateElementProof(&sfe.StateElement)
+		c.sfes[id] = sfe.Move()
+	}
+	for id, fce := range c.fces {
+		au.UpdateElementProof(&fce.StateElement)
+		c.fces[id] = fce.Move()
+	}
+	for id, fce := range c.v2fces {
+		au.UpdateElementProof(&fce.StateElement)
+		c.v2fces[id] = fce.Move()
+	}
+	for i := range c.cies {
+		au.UpdateElementProof(&c.cies[i].StateElement)
+	}
+
+	for _, sced := range au.SiacoinElementDiffs() {
+		if sced.Spent {
+			delete(c.sces, sced.SiacoinElement.ID)
+		} else {
+			c.sces[sced.SiacoinElement.ID] = sced.SiacoinElement.Copy()
+		}
+	}
+	for _, sfed := range au.SiafundElementDiffs() {
+		if sfed.Spent {
+			delete(c.sfes, sfed.SiafundElement.ID)
+		} else {
+			c.sfes[sfed.SiafundElement.ID] = sfed.SiafundElement.Copy()
+		}
+	}
+	for _, fced := range au.FileContractElementDiffs() {
+		fce := fced.FileContractElement.Copy()
+		if fced.Revision != nil {
+			fce.FileContract = *fced.Revision
+		}
+		if fced.Resolved {
+			delete(c.fces, fce.ID)
+		} else {
+			c.fces[fce.ID] = fce
+		}
+	}
+	for _, fced := range au.V2FileContractElementDiffs() {
+		fce := fced.V2FileContractElement.Copy()
+		if fced.Revision != nil {
+			fce.V2FileContract = *fced.Revision
+		}
+		if fced.Resolution != nil {
+			delete(c.v2fces, fce.ID)
+		} else {
+			c.v2fces[fce.ID] = fce
+		}
+	}
+	c.cies = append(c.cies, au.ChainIndexElement())
+	c.states = append(c.states, cs)
+	c.blocks = append(c.blocks, b)
+	c.supps = append(c.supps, bs)
+	for _, fn := range c.onApply {
+		fn(cs, b, au)
+	}
+}
+
+// MineTo mines n blocks, paying the block rewards to addr. The pending
+// transactions are included in the first block.
+func (c *Chain) MineTo(addr types.Address, n int) *Chain {
+	c.tb.Helper()
+	for range n {
+		b := c.newBlock(addr)
+		if err := c.ApplyBlock(b); err != nil {
+			c.tb.Fatalf("failed to mine block at height %v: %v", c.Tip().Index.Height+1, err)
+		}
+		c.txns, c.v2txns = nil, nil
+		clear(c.used)
+		c.clock = c.clock.Add(c.n.BlockInterval)
+	}
+	return c
+}
+
+// Mine mines n blocks, paying the block rewards to the void address. The
+// pending transactions are included in the first block.
+func (c *Chain) Mine(n int) *Chain {
+	c.tb.Helper()
+	return c.MineTo(types.VoidAddress, n)
+}
+
+// Revert reverts the n most recent blocks. Any pending transactions are
+// discarded. The simulated clock is not rewound, so mining after a revert
+// produces a different chain.
+func (c *Chain) Revert(n int) *Chain {
+	c.tb.Helper()
+	if n >= len(c.blocks) {
+		c.tb.Fatalf("cannot revert %v blocks from chain of height %v", n, c.Tip().Index.Height)
+	}
+	c.txns, c.v2txns = nil, nil
+	clear(c.used)
+	for range n {
+		b := c.blocks[len(c.blocks)-1]
+		bs := c.supps[len(c.supps)-1]
+		parent := c.states[len(c.states)-2]
+		ru := consensus.RevertBlock(parent, b, bs)
+		c.revertBlock(parent, b, ru)
+	}
+	return c
+}
+
+func (c *Chain) revertBlock(cs consensus.State, b types.Block, ru consensus.RevertUpdate) {
+	for _, sced := range ru.SiacoinElementDiffs() {
+		if sced.Created {
+			delete(c.sces, sced.SiacoinElement.ID)
+		} else if sced.Spent {
+			c.sces[sced.SiacoinElement.ID] = sced.SiacoinElement.Copy()
+		}
+	}
+	for _, sfed := range ru.SiafundElementDiffs() {
+		if sfed.Created {
+			delete(c.sfes, sfed.SiafundElement.ID)
+		} else if sfed.Spent {
+			c.sfes[sfed.SiafundElement.ID] = sfed.SiafundElement.Copy()
+		}
+	}
+	for _, fced := range ru.FileContractElementDiffs() {
+		if fced.Created {
+			delete(c.fces, fced.FileContractElement.ID)
+		} else {
+			c.fces[fced.FileContractElement.ID] = fced.FileContractElement.Copy()
+		}
+	}
+	for _, fced := range ru.V2FileContractElementDiffs() {
+		if fced.Created {
+			delete(c.v2fces, fced.V2FileContractElement.ID)
+		} else {
+			c.v2fces[fced.V2FileContractElement.ID] = fced.V2FileContractElement.Copy()
+		}
+	}
+	c.cies = c.cies[:len(c.cies)-1]
+	c.states = c.states[:len(c.states)-1]
+	c.blocks = c.blocks[:len(c.blocks)-1]
+	c.supps = c.supps[:len(c.supps)-1]
+
+	for id, sce := range c.sces {
+		ru.UpdateElementProof(&sce.StateElement)
+		c.sces[id] = sce.Move()
+	}
+	for id, sfe := range c.sfes {
+		ru.UpdateElementProof(&sfe.StateElement)
+		c.sfes[id] = sfe.Move()
+	}
+	for id, fce := range c.fces {
+		ru.UpdateElementProof(&fce.StateElement)
+		c.fces[id] = fce.Move()
+	}
+	for id, fce := range c.v2fces {
+		ru.UpdateElementProof(&fce.StateElement)
+		c.v2fces[id] = fce.Move()
+	}
+	for i := range c.cies {
+		ru.UpdateElementProof(&c.cies[i].StateElement)
+	}
+	for _, fn := range c.onRevert {
+		fn(cs, b, ru)
+	}
+}
+
+// New returns a Chain for the given network, which defaults to Network() if
+// nil. The genesis block pays 1 million siacoins and all 10,000 siafunds to the
+// "genesis" wallet.
+func New(tb testing.TB, n *consensus.Network) *Chain {
+	if n == nil {
+		n = Network()
+	}
+	c := &Chain{
+		tb:      tb,
+		n:       n,
+		clock:   n.HardforkOak.GenesisTimestamp,
+		wallets: make(map[string]*Wallet),
+		sces:    make(map[types.SiacoinOutputID]types.SiacoinElement),
+		sfes:    make(map[types.SiafundOutputID]types.SiafundElement),
+		fces:    make(map[types.FileContractID]types.FileContractElement),
+		v2fces:  make(map[types.FileContractID]types.V2FileContractElement),
+		used:    make(map[types.ElementID]bool),
+	}
+	addr := c.Wallet("genesis").Address()
+	genesisTxn := types.Transaction{
+		SiafundOutputs: []types.SiafundOutput{{Address: addr, Value: 10000}},
+	}
+	for range 10 {
+		genesisTxn.SiacoinOutputs = append(genesisTxn.SiacoinOutputs, types.SiacoinOutput{Address: addr, Value: types.Siacoins(100000)})
+	}
+	b := types.Block{
+		Timestamp:    c.clock,
+		Transactions: []types.Transaction{genesisTxn},
+	}
+	bs := consensus.V1BlockSupplement{Transactions: make([]consensus.V1TransactionSupplement, 1)}
+	cs, au := consensus.ApplyBlock(n.GenesisState(), b, bs, time.Time{})
+	c.applyBlock(cs, b, bs, au)
+	c.clock = c.clock.Add(n.BlockInterval)
+	return c
+}
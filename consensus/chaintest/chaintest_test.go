@@ -0,0 +1,171 @@
+package chaintest
+
+import (
+	"testing"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+// checkProofs checks that every wallet element has a valid proof.
+func checkProofs(t *testing.T, c *Chain, wallets ...*Wallet) {
+	t.Helper()
+	cs := c.Tip()
+	for _, w := range wallets {
+		for _, sce := range w.SiacoinElements() {
+			if err := cs.Elements.VerifySiacoinElement(sce, false); err != nil {
+				t.Fatalf("wallet %q: %v", w.Name, err)
+			}
+		}
+		for _, sfe := range w.SiafundElements() {
+			if err := cs.Elements.VerifySiafundElement(sfe, false); err != nil {
+				t.Fatalf("wallet %q: %v", w.Name, err)
+			}
+		}
+	}
+}
+
+func TestScenario(t *testing.T) {
+	c := New(t, nil)
+	n := c.Network()
+	genesis, alice, bob, host := c.Wallet("genesis"), c.Wallet("alice"), c.Wallet("bob"), c.Wallet("host")
+	if sc, _ := genesis.Balance(); sc != types.Siacoins(1000000) {
+		t.Fatalf("expected genesis wallet to have 1M SC, got %v", sc)
+	} else if genesis.SiafundBalance() != 10000 {
+		t.Fatalf("expected genesis wallet to have 10000 SF, got %v", genesis.SiafundBalance())
+	}
+
+	// fund wallets with v1 transactions; each wallet receives two outputs so
+	// that it can fund two transactions in the same block
+	c.SendSiacoins(genesis, alice.Address(), types.Siacoins(1000)).
+		SendSiacoins(genesis, alice.Address(), types.Siacoins(1000)).
+		SendSiacoins(genesis, host.Address(), types.Siacoins(1000)).
+		SendSiacoins(genesis, host.Address(), types.Siacoins(1000)).
+		SendSiafunds(genesis, bob.Address(), 2500).
+		Mine(1)
+	if sc, _ := alice.Balance(); sc != types.Siacoins(2000) {
+		t.Fatalf("expected alice to have 2000 SC, got %v", sc)
+	} else if bob.SiafundBalance() != 2500 {
+		t.Fatalf("expected bob to have 2500 SF, got %v", bob.SiafundBalance())
+	}
+	if len(c.Block(1).Transactions) != 5 || c.Block(1).V2 != nil {
+		t.Fatal("expected v1 block with 5 transactions")
+	}
+
+	// miner payouts mature after MaturityDelay
+	c.MineTo(bob.Address(), 1)
+	if sc, immature := bob.Balance(); !sc.IsZero() || immature.IsZero() {
+		t.Fatalf("expected immature miner payout, got %v spendable, %v immature", sc, immature)
+	}
+	c.Mine(int(n.MaturityDelay))
+	if sc, immature := bob.Balance(); sc.IsZero() || !immature.IsZero() {
+		t.Fatalf("expected mature miner payout, got %v spendable, %v immature", sc, immature)
+	}
+
+	// form two v1 contracts: one will be proven, the other will expire
+	height := c.Tip().Index.Height
+	proven := c.FormContract(alice, host, types.Siacoins(100), types.Siacoins(200), height+3, height+5)
+	missed := c.FormContract(alice, host, types.Siacoins(100), types.Siacoins(200), height+3, height+5)
+	c.Mine(1)
+	if _, ok := c.FileContractElement(proven); !ok {
+		t.Fatal("missing contract")
+	} else if c.Tip().SiafundTaxRevenue.IsZero() {
+		t.Fatal("expected contract tax to be added to the siafund pool")
+	}
+	c.Mine(2).SubmitStorageProof(proven).Mine(1)
+	if _, ok := c.FileContractElement(proven); ok {
+		t.Fatal("expected proven contract to be resolved")
+	} else if _, ok := c.FileContractElement(missed); !ok {
+		t.Fatal("expected missed contract to remain unresolved")
+	}
+	c.Mine(1)
+	if _, ok := c.FileContractElement(missed); ok {
+		t.Fatal("expected missed contract to expire")
+	}
+	if _, ok := c.SiacoinElement(proven.ValidOutputID(1)); !ok {
+		t.Fatal("missing valid proof output")
+	} else if _, ok := c.SiacoinElement(missed.MissedOutputID(1)); !ok {
+		t.Fatal("missing missed proof output")
+	}
+
+	// spending siafunds claims a share of the tax
+	c.SendSiafunds(bob, bob.Address(), 2500).Mine(1)
+	if _, immature := bob.Balance(); immature.IsZero() {
+		t.Fatal("expected siafund claim")
+	}
+	checkProofs(t, c, genesis, alice, bob, host)
+
+	// cross the v2 allow height; transactions are now v2
+	c.Mine(int(n.HardforkV2.AllowHeight - c.Tip().Index.Height - 1))
+	c.SendSiacoins(alice, bob.Address(), types.Siacoins(10)).Mine(1)
+	if b := c.Block(c.Tip().Index.Height); b.V2 == nil || len(b.V2.Transactions) != 1 || len(b.Transactions) != 0 {
+		t.Fatal("expected v2 block with 1 v2 transaction")
+	}
+	height = c.Tip().Index.Height
+	v2proven := c.FormContract(alice, host, types.Siacoins(100), types.Siacoins(200), height+2, height+4)
+	v2expired := c.FormContract(alice, host, types.Siacoins(100), types.Siacoins(200), height+2, height+4)
+	c.Mine(3).SubmitStorageProof(v2proven).Mine(2).ExpireContract(v2expired).Mine(1)
+	if _, ok := c.V2FileContractElement(v2proven); ok {
+		t.Fatal("expected proven contract to be resolved")
+	} else if _, ok := c.V2FileContractElement(v2expired); ok {
+		t.Fatal("expected expired contract to be resolved")
+	}
+
+	// v1 transactions are rejected after the require height
+	c.Mine(int(n.HardforkV2.RequireHeight - c.Tip().Index.Height))
+	b := c.NewBlock()
+	b.Transactions = []types.Transaction{{}}
+	if err := c.ApplyBlock(b); err == nil {
+		t.Fatal("expected v1 transaction to be rejected after require height")
+	}
+	checkProofs(t, c, genesis, alice, bob, host)
+
+	// reorg
+	var applied, reverted int
+	c.OnApply(func(consensus.State, types.Block, consensus.ApplyUpdate) { applied++ })
+	c.OnRevert(func(consensus.State, types.Block, consensus.RevertUpdate) { reverted++ })
+	tip := c.Tip().Index
+	c.SendSiacoins(alice, bob.Address(), types.Siacoins(1)).Mine(1)
+	aliceBalance, _ := alice.Balance()
+	c.Revert(2)
+	if sc, _ := alice.Balance(); sc == aliceBalance {
+		t.Fatal("expected reverted transaction to be undone")
+	}
+	checkProofs(t, c, genesis, alice, bob, host)
+	c.Mine(3)
+	if applied != 4 || reverted != 2 {
+		t.Fatalf("expected 4 applied and 2 reverted blocks, got %v and %v", applied, reverted)
+	} else if c.Tip().Index.Height != tip.Height+2 || c.Tip().Index.ID == tip.ID {
+		t.Fatal("expected chain to be reorged")
+	}
+	checkProofs(t, c, genesis, alice, bob, host)
+}
+
+func TestDeterminism(t *testing.T) {
+	run := func() *Chain {
+		c := New(t, nil)
+		alice := c.Wallet("alice")
+		c.SendSiacoins(c.Wallet("genesis"), alice.Address(), types.Siacoins(100)).Mine(5)
+		c.AdvanceTime(time.Hour).Mine(20)
+		c.SendSiacoins(alice, types.VoidAddress, types.Siacoins(50)).Mine(1)
+		return c
+	}
+	c1, c2 := run(), run()
+	if c1.Tip().Index != c2.Tip().Index {
+		t.Fatal("identical scenarios produced different chains")
+	}
+
+	// timestamps follow the simulated clock
+	n := c1.Network()
+	if ts := c1.Block(5).Timestamp; !ts.Equal(GenesisTimestamp.Add(5 * n.BlockInterval)) {
+		t.Fatalf("unexpected timestamp %v", ts)
+	} else if ts := c1.Block(6).Timestamp; !ts.Equal(GenesisTimestamp.Add(6*n.BlockInterval + time.Hour)) {
+		t.Fatalf("unexpected timestamp %v", ts)
+	}
+	// a timestamp before the median is rejected
+	c1.SetTime(GenesisTimestamp)
+	if err := c1.ApplyBlock(c1.NewBlock()); err == nil {
+		t.Fatal("expected block with old timestamp to be rejected")
+	}
+}
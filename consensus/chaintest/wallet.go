@@ -0,0 +1,445 @@
+package chaintest
+
+import (
+	"cmp"
+	"slices"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+func walletKey(name string) types.PrivateKey {
+	seed := types.HashBytes([]byte("chaintest/" + name))
+	return types.NewPrivateKeyFromSeed(seed[:])
+}
+
+// A Wallet owns the elements sent to its address. Its keys are derived from its
+// name, and the proofs of its elements are kept up to date by its Chain.
+type Wallet struct {
+	c          *Chain
+	Name       string
+	PrivateKey types.PrivateKey
+}
+
+// PublicKey returns the wallet's public key.
+func (w *Wallet) PublicKey() types.PublicKey { return w.PrivateKey.PublicKey() }
+
+// UnlockConditions returns the wallet's unlock conditions.
+func (w *Wallet) UnlockConditions() types.UnlockConditions {
+	return types.StandardUnlockConditions(w.PublicKey())
+}
+
+// SpendPolicy returns the v2 spend policy equivalent to the wallet's unlock
+// conditions.
+func (w *Wallet) SpendPolicy() types.SpendPolicy {
+	return types.SpendPolicy{Type: types.PolicyTypeUnlockConditions(w.UnlockConditions())}
+}
+
+// Address returns the wallet's address, which is the same for v1 and v2
+// transactions.
+func (w *Wallet) Address() types.Address { return types.StandardUnlockHash(w.PublicKey()) }
+
+// SiacoinElements returns the wallet's unspent siacoin elements, including
+// immature elements, in the order they were created. Their proofs are valid
+// for the current tip.
+func (w *Wallet) SiacoinElements() []types.SiacoinElement {
+	var sces []types.SiacoinElement
+	for _, sce := range w.c.sces {
+		if sce.SiacoinOutput.Address == w.Address() {
+			sces = append(sces, sce.Copy())
+		}
+	}
+	slices.SortFunc(sces, func(a, b types.SiacoinElement) int {
+		return cmp.Compare(a.StateElement.LeafIndex, b.StateElement.LeafIndex)
+	})
+	return sces
+}
+
+// SiafundElements returns the wallet's unspent siafund elements, in the order
+// they were created. Their proofs are valid for the current tip.
+func (w *Wallet) SiafundElements() []types.SiafundElement {
+	var sfes []types.SiafundElement
+	for _, sfe := range w.c.sfes {
+		if sfe.SiafundOutput.Address == w.Address() {
+			sfes = append(sfes, sfe.Copy())
+		}
+	}
+	slices.SortFunc(sfes, func(a, b types.SiafundElement) int {
+		return cmp.Compare(a.StateElement.LeafIndex, b.StateElement.LeafIndex)
+	})
+	return sfes
+}
+
+// Balance returns the wallet's spendable and immature siacoin balances.
+func (w *Wallet) Balance() (spendable, immature types.Currency) {
+	childHeight := w.c.Tip().Index.Height + 1
+	for _, sce := range w.SiacoinElements() {
+		if sce.MaturityHeight > childHeight {
+			immature = immature.Add(sce.SiacoinOutput.Value)
+		} else {
+			spendable = spendable.Add(sce.SiacoinOutput.Value)
+		}
+	}
+	return
+}
+
+// SiafundBalance returns the wallet's siafund balance.
+func (w *Wallet) SiafundBalance() (n uint64) {
+	for _, sfe := range w.SiafundElements() {
+		n += sfe.SiafundOutput.Value
+	}
+	return
+}
+
+// Wallet returns the wallet with the given name, creating it if necessary.
+func (c *Chain) Wallet(name string) *Wallet {
+	w, ok := c.wallets[name]
+	if !ok {
+		w = &Wallet{c: c, Name: name, PrivateKey: walletKey(name)}
+		c.wallets[name] = w
+	}
+	return w
+}
+
+// A txnBuilder constructs a transaction that is encoded as v1 or v2 depending
+// on the height of the next block.
+type txnBuilder struct {
+	c  *Chain
+	v2 bool
+
+	sces      []types.SiacoinElement
+	scOwners  []*Wallet
+	sfes      []types.SiafundElement
+	sfOwners  []*Wallet
+	scOutputs []types.SiacoinOutput
+	sfOutputs []types.SiafundOutput
+
+	fc     types.FileContract
+	v2fc   types.V2FileContract
+	signFC [2]*Wallet // renter and host of the new contract, if any
+
+	storageProofs []types.StorageProof
+	resolutions   []types.V2FileContractResolution
+}
+
+func (c *Chain) newTxnBuilder() *txnBuilder {
+	return &txnBuilder{c: c, v2: c.Tip().Index.Height+1 >= c.n.HardforkV2.AllowHeight}
+}
+
+// fundSiacoins adds spendable inputs from w worth at least amount, along with a
+// change output if necessary.
+func (tb *txnBuilder) fundSiacoins(w *Wallet, amount types.Currency) {
+	tb.c.tb.Helper()
+	if amount.IsZero() {
+		return
+	}
+	childHeight := tb.c.Tip().Index.Height + 1
+	var sum types.Currency
+	for _, sce := range w.SiacoinElements() {
+		if sum.Cmp(amount) >= 0 {
+			break
+		} else if sce.MaturityHeight > childHeight || tb.c.used[types.ElementID(sce.ID)] {
+			continue
+		}
+		tb.c.used[types.ElementID(sce.ID)] = true
+		tb.sces = append(tb.sces, sce)
+		tb.scOwners = append(tb.scOwners, w)
+		sum = sum.Add(sce.SiacoinOutput.Value)
+	}
+	if sum.Cmp(amount) < 0 {
+		tb.c.tb.Fatalf("wallet %q has insufficient siacoins: have %v, need %v", w.Name, sum, amount)
+	} else if change := sum.Sub(amount); !change.IsZero() {
+		tb.scOutputs = append(tb.scOutputs, types.SiacoinOutput{Address: w.Address(), Value: change})
+	}
+}
+
+// fundSiafunds adds inputs from w worth at least amount, along with a change
+// output if necessary. Siafund claims are paid to w.
+func (tb *txnBuilder) fundSiafunds(w *Wallet, amount uint64) {
+	tb.c.tb.Helper()
+	var sum uint64
+	for _, sfe := range w.SiafundElements() {
+		if sum >= amount {
+			break
+		} else if tb.c.used[types.ElementID(sfe.ID)] {
+			continue
+		}
+		tb.c.used[types.ElementID(sfe.ID)] = true
+		tb.sfes = append(tb.sfes, sfe)
+		tb.sfOwners = append(tb.sfOwners, w)
+		sum += sfe.SiafundOutput.Value
+	}
+	if sum < amount {
+		tb.c.tb.Fatalf("wallet %q has insufficient siafunds: have %v, need %v", w.Name, sum, amount)
+	} else if sum > amount {
+		tb.sfOutputs = append(tb.sfOutputs, types.SiafundOutput{Address: w.Address(), Value: sum - amount})
+	}
+}
+
+// submit signs the transaction and adds it to the chain's pending
+// transactions.
+func (tb *txnBuilder) submit() (types.Transaction, types.V2Transaction) {
+	cs := tb.c.Tip()
+	if !tb.v2 {
+		txn := types.Transaction{
+			SiacoinOutputs: tb.scOutputs,
+			SiafundOutputs: tb.sfOutputs,
+			StorageProofs:  tb.storageProofs,
+		}
+		for i, sce := range tb.sces {
+			txn.SiacoinInputs = append(txn.SiacoinInputs, types.SiacoinInput{
+				ParentID:         sce.ID,
+				UnlockConditions: tb.scOwners[i].UnlockConditions(),
+			})
+		}
+		for i, sfe := range tb.sfes {
+			owner := tb.sfOwners[i]
+			txn.SiafundInputs = append(txn.SiafundInputs, types.SiafundInput{
+				ParentID:         sfe.ID,
+				UnlockConditions: owner.UnlockConditions(),
+				ClaimAddress:     owner.Address(),
+			})
+		}
+		if tb.signFC[0] != nil {
+			txn.FileContracts = []types.FileContract{tb.fc}
+		}
+		sign := func(w *Wallet, parentID types.Hash256) {
+			sig := w.PrivateKey.SignHash(cs.WholeSigHash(txn, parentID, 0, 0, nil))
+			txn.Signatures = append(txn.Signatures, types.TransactionSignature{
+				ParentID:      parentID,
+				CoveredFields: types.CoveredFields{WholeTransaction: true},
+				Signature:     sig[:],
+			})
+		}
+		for i, sci := range txn.SiacoinInputs {
+			sign(tb.scOwners[i], types.Hash256(sci.ParentID))
+		}
+		for i, sfi := range txn.SiafundInputs {
+			sign(tb.sfOwners[i], types.Hash256(sfi.ParentID))
+		}
+		tb.c.txns = append(tb.c.txns, txn)
+		return txn, types.V2Transaction{}
+	}
+
+	txn := types.V2Transaction{
+		SiacoinOutputs:          tb.scOutputs,
+		SiafundOutputs:          tb.sfOutputs,
+		FileContractResolutions: tb.resolutions,
+	}
+	for i, sce := range tb.sces {
+		txn.SiacoinInputs = append(txn.SiacoinInputs, types.V2SiacoinInput{
+			Parent:          sce.Copy(),
+			SatisfiedPolicy: types.SatisfiedPolicy{Policy: tb.scOwners[i].SpendPolicy()},
+		})
+	}
+	for i, sfe := range tb.sfes {
+		owner := tb.sfOwners[i]
+		txn.SiafundInputs = append(txn.SiafundInputs, types.V2SiafundInput{
+			Parent:          sfe.Copy(),
+			ClaimAddress:    owner.Address(),
+			SatisfiedPolicy: types.SatisfiedPolicy{Policy: owner.SpendPolicy()},
+		})
+	}
+	if renter, host := tb.signFC[0], tb.signFC[1]; renter != nil {
+		fc := tb.v2fc
+		fc.RenterSignature = renter.PrivateKey.SignHash(cs.ContractSigHash(fc))
+		fc.HostSignature = host.PrivateKey.SignHash(cs.ContractSigHash(fc))
+		txn.FileContracts = []types.V2FileContract{fc}
+	}
+	sigHash := cs.InputSigHash(txn)
+	for i := range txn.SiacoinInputs {
+		txn.SiacoinInputs[i].SatisfiedPolicy.Signatures = []types.Signature{tb.scOwners[i].PrivateKey.SignHash(sigHash)}
+	}
+	for i := range txn.SiafundInputs {
+		txn.SiafundInputs[i].SatisfiedPolicy.Signatures = []types.Signature{tb.sfOwners[i].PrivateKey.SignHash(sigHash)}
+	}
+	tb.c.v2txns = append(tb.c.v2txns, txn)
+	return types.Transaction{}, txn
+}
+
+// SendSiacoins adds a transaction to the next block that sends amount siacoins
+// from w to addr. The transaction is a v2 transaction if the next block is at
+// or above the v2 allow height.
+func (c *Chain) SendSiacoins(from *Wallet, to types.Address, amount types.Currency) *Chain {
+	c.tb.Helper()
+	tb := c.newTxnBuilder()
+	tb.scOutputs = append(tb.scOutputs, types.SiacoinOutput{Address: to, Value: amount})
+	tb.fundSiacoins(from, amount)
+	tb.submit()
+	return c
+}
+
+// SendSiafunds adds a transaction to the next block that sends amount siafunds
+// from w to addr. Any siafund claims are paid to w.
+func (c *Chain) SendSiafunds(from *Wallet, to types.Address, amount uint64) *Chain {
+	c.tb.Helper()
+	tb := c.newTxnBuilder()
+	tb.sfOutputs = append(tb.sfOutputs, types.SiafundOutput{Address: to, Value: amount})
+	tb.fundSiafunds(from, amount)
+	tb.submit()
+	return c
+}
+
+// ContractRoot is the Merkle root of the data stored by contracts formed with
+// FormContract: a single segment of zeros.
+var ContractRoot = (consensus.State{}).StorageProofLeafHash(make([]byte, 64))
+
+// FormContract adds a transaction to the next block that forms a file contract
+// between renter and host, returning the contract's ID. The renter pays
+// renterFunds plus the siafund tax, and the host pays hostCollateral. The
+// contract stores a single segment of zeros, so its storage proofs are
+// trivial.
+//
+// If the next block is below the v2 allow height, a v1 contract is formed with
+// a proof window from proofHeight to expirationHeight, and the host's payout
+// absorbs any rounding error in the tax. Otherwise, a v2 contract is formed
+// with the given proof and expiration heights.
+func (c *Chain) FormContract(renter, host *Wallet, renterFunds, hostCollateral types.Currency, proofHeight, expirationHeight uint64) types.FileContractID {
+	c.tb.Helper()
+	cs := c.Tip()
+	tb := c.newTxnBuilder()
+	tb.signFC = [2]*Wallet{renter, host}
+	if !tb.v2 {
+		uc := types.UnlockConditions{
+			PublicKeys:         []types.UnlockKey{renter.PublicKey().UnlockKey(), host.PublicKey().UnlockKey()},
+			SignaturesRequired: 2,
+		}
+		fc := types.FileContract{
+			Filesize:       64,
+			FileMerkleRoot: ContractRoot,
+			WindowStart:    proofHeight,
+			WindowEnd:      expirationHeight,
+			UnlockHash:     uc.UnlockHash(),
+		}
+		// invert the tax, then give any remainder to the host
+		target := renterFunds.Add(hostCollateral)
+		fc.Payout = target.Mul64(1000).Div64(961).Add(types.NewCurrency64(cs.SiafundCount()))
+		hostPayout := fc.Payout.Sub(cs.FileContractTax(fc)).Sub(renterFunds)
+		fc.ValidProofOutputs = []types.SiacoinOutput{
+			{Address: renter.Address(), Value: renterFunds},
+			{Address: host.Address(), Value: hostPayout},
+		}
+		fc.MissedProofOutputs = slices.Clone(fc.ValidProofOutputs)
+		tb.fc = fc
+		tb.fundSiacoins(renter, fc.Payout.Sub(hostCollateral))
+		tb.fundSiacoins(host, hostCollateral)
+		txn, _ := tb.submit()
+		return txn.FileContractID(0)
+	}
+
+	return c.FormV2Contract(renter, host, types.V2FileContract{
+		Capacity:         64,
+		Filesize:         64,
+		FileMerkleRoot:   ContractRoot,
+		ProofHeight:      proofHeight,
+		ExpirationHeight: expirationHeight,
+		RenterOutput:     types.SiacoinOutput{Address: renter.Address(), Value: renterFunds},
+		HostOutput:       types.SiacoinOutput{Address: host.Address(), Value: hostCollateral},
+		MissedHostValue:  hostCollateral,
+		TotalCollateral:  hostCollateral,
+	})
+}
+
+// FormV2Contract adds a transaction to the next block that forms fc, returning
+// the contract's ID. The public keys of fc are set to those of renter and host;
+// the renter pays the renter output plus the tax, and the host pays the host
+// output. The next block must be at or above the v2 allow height.
+func (c *Chain) FormV2Contract(renter, host *Wallet, fc types.V2FileContract) types.FileContractID {
+	c.tb.Helper()
+	cs := c.Tip()
+	tb := c.newTxnBuilder()
+	if !tb.v2 {
+		c.tb.Fatal("cannot form v2 contract before the v2 allow height")
+	}
+	fc.RenterPublicKey = renter.PublicKey()
+	fc.HostPublicKey = host.PublicKey()
+	tb.signFC = [2]*Wallet{renter, host}
+	tb.v2fc = fc
+	tb.fundSiacoins(renter, fc.RenterOutput.Value.Add(cs.V2FileContractTax(fc)))
+	tb.fundSiacoins(host, fc.HostOutput.Value)
+	_, txn := tb.submit()
+	return txn.V2FileContractID(txn.ID(), 0)
+}
+
+// SubmitStorageProof adds a transaction to the next block that submits a
+// storage proof for a contract formed with FormContract.
+func (c *Chain) SubmitStorageProof(id types.FileContractID) *Chain {
+	c.tb.Helper()
+	tb := c.newTxnBuilder()
+	if _, ok := c.fces[id]; ok {
+		tb.v2 = false
+		tb.storageProofs = []types.StorageProof{{ParentID: id}}
+	} else if fce, ok := c.v2fces[id]; ok {
+		if fce.V2FileContract.ProofHeight >= uint64(len(c.cies)) {
+			c.tb.Fatalf("cannot submit storage proof for contract %v before its proof height (%v)", id, fce.V2FileContract.ProofHeight)
+		}
+		tb.v2 = true
+		tb.resolutions = []types.V2FileContractResolution{{
+			Parent: fce.Copy(),
+			Resolution: &types.V2StorageProof{
+				ProofIndex: c.cies[fce.V2FileContract.ProofHeight].Copy(),
+			},
+		}}
+	} else {
+		c.tb.Fatalf("no unresolved contract with ID %v", id)
+	}
+	tb.submit()
+	return c
+}
+
+// RenewContract adds a transaction to the next block that renews a v2 contract
+// between renter and host, returning the ID of the new contract. The new
+// contract has the given proof and expiration heights; the outputs of the
+// existing contract roll over into it, and the renter pays the tax.
+func (c *Chain) RenewContract(renter, host *Wallet, id types.FileContractID, proofHeight, expirationHeight uint64) types.FileContractID {
+	c.tb.Helper()
+	cs := c.Tip()
+	fce, ok := c.v2fces[id]
+	if !ok {
+		c.tb.Fatalf("no unresolved v2 contract with ID %v", id)
+	}
+	fc := fce.V2FileContract
+	newContract := fc
+	newContract.ProofHeight = proofHeight
+	newContract.ExpirationHeight = expirationHeight
+	newContract.RevisionNumber = 0
+	newContract.RenterSignature = renter.PrivateKey.SignHash(cs.ContractSigHash(newContract))
+	newContract.HostSignature = host.PrivateKey.SignHash(cs.ContractSigHash(newContract))
+	renewal := types.V2FileContractRenewal{
+		FinalRenterOutput: types.SiacoinOutput{Address: fc.RenterOutput.Address},
+		FinalHostOutput:   types.SiacoinOutput{Address: fc.HostOutput.Address},
+		RenterRollover:    fc.RenterOutput.Value,
+		HostRollover:      fc.HostOutput.Value,
+		NewContract:       newContract,
+	}
+	renewal.RenterSignature = renter.PrivateKey.SignHash(cs.RenewalSigHash(renewal))
+	renewal.HostSignature = host.PrivateKey.SignHash(cs.RenewalSigHash(renewal))
+
+	tb := c.newTxnBuilder()
+	tb.v2 = true
+	tb.resolutions = []types.V2FileContractResolution{{
+		Parent:     fce.Copy(),
+		Resolution: &renewal,
+	}}
+	tb.fundSiacoins(renter, cs.V2FileContractTax(newContract))
+	tb.submit()
+	return id.V2RenewalID()
+}
+
+// ExpireContract adds a transaction to the next block that resolves an expired
+// v2 contract. v1 contracts expire automatically.
+func (c *Chain) ExpireContract(id types.FileContractID) *Chain {
+	c.tb.Helper()
+	fce, ok := c.v2fces[id]
+	if !ok {
+		c.tb.Fatalf("no unresolved v2 contract with ID %v", id)
+	}
+	tb := c.newTxnBuilder()
+	tb.v2 = true
+	tb.resolutions = []types.V2FileContractResolution{{
+		Parent:     fce.Copy(),
+		Resolution: &types.V2FileContractExpiration{},
+	}}
+	tb.submit()
+	return c
+}
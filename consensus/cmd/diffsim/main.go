@@ -0,0 +1,157 @@
+// Command diffsim simulates the difficulty adjustment algorithm and writes the
+// results to stdout as CSV.
+//
+// By default, diffsim simulates mining with the hashrate given by -hashrate,
+// which is either a constant or a comma-separated list of height:hashrate
+// steps, e.g. "0:1e12,5000:2e12". Alternatively, -timestamps replays a file
+// containing one Unix timestamp per line, beginning with the genesis block.
+//
+// Each row contains the block height, its Unix timestamp, the seconds elapsed
+// since its parent, and the target and difficulty of its child.
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"math/rand/v2"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+// parseHashrate parses a constant hashrate or a list of height:hashrate steps.
+func parseHashrate(s string) (func(uint64) float64, error) {
+	type step struct {
+		height   uint64
+		hashrate float64
+	}
+	var steps []step
+	for _, part := range strings.Split(s, ",") {
+		h, r, ok := strings.Cut(part, ":")
+		if !ok {
+			h, r = "0", part
+		}
+		height, err := strconv.ParseUint(h, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid height %q: %w", h, err)
+		}
+		hashrate, err := strconv.ParseFloat(r, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hashrate %q: %w", r, err)
+		} else if hashrate <= 0 {
+			return nil, fmt.Errorf("hashrate must be positive")
+		}
+		steps = append(steps, step{height, hashrate})
+	}
+	sort.Slice(steps, func(i, j int) bool { return steps[i].height < steps[j].height })
+	return func(height uint64) float64 {
+		r := steps[0].hashrate
+		for _, s := range steps {
+			if s.height <= height {
+				r = s.hashrate
+			}
+		}
+		return r
+	}, nil
+}
+
+func readTimestamps(path string) ([]time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var timestamps []time.Time
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		secs, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q: %w", line, err)
+		}
+		timestamps = append(timestamps, time.Unix(secs, 0).UTC())
+	}
+	if len(timestamps) == 0 {
+		return nil, fmt.Errorf("%v contains no timestamps", path)
+	}
+	return timestamps, s.Err()
+}
+
+func main() {
+	interval := flag.Duration("interval", 10*time.Minute, "target block interval")
+	numBlocks := flag.Int("blocks", 1000, "number of blocks to simulate")
+	hashrateStr := flag.String("hashrate", "1e9", "hashrate in H/s, or a list of height:hashrate steps")
+	difficulty := flag.Float64("difficulty", 0, "initial difficulty in hashes (defaults to the initial hashrate times the block interval)")
+	v2Height := flag.Uint64("v2", 0, "height at which the v2 difficulty algorithm activates")
+	seed := flag.Uint64("seed", 0, "if nonzero, draw block times randomly using this seed")
+	timestampsPath := flag.String("timestamps", "", "replay the timestamps in this file instead of simulating mining")
+	flag.Parse()
+
+	hashrate, err := parseHashrate(*hashrateStr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *difficulty == 0 {
+		*difficulty = hashrate(0) * interval.Seconds()
+	}
+	d, _ := new(big.Float).SetFloat64(*difficulty).Int(nil)
+	if d.Sign() <= 0 {
+		log.Fatal("difficulty must be positive")
+	}
+	maxTarget := new(big.Int).Lsh(big.NewInt(1), 256)
+	var initialTarget types.BlockID
+	new(big.Int).Div(maxTarget, d).FillBytes(initialTarget[:])
+
+	n := &consensus.Network{
+		Name:          "diffsim",
+		InitialTarget: initialTarget,
+		BlockInterval: *interval,
+	}
+	n.HardforkOak.GenesisTimestamp = time.Unix(1618033988, 0).UTC()
+	n.HardforkV2.AllowHeight = *v2Height
+	n.HardforkV2.RequireHeight = *v2Height
+
+	var samples []consensus.DifficultySample
+	if *timestampsPath != "" {
+		timestamps, err := readTimestamps(*timestampsPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		n.HardforkOak.GenesisTimestamp = timestamps[0]
+		samples = consensus.SimulateDifficulty(n, timestamps)
+	} else {
+		var rng *rand.Rand
+		if *seed != 0 {
+			rng = rand.New(rand.NewPCG(*seed, 0))
+		}
+		samples = consensus.SimulateHashrate(n, *numBlocks, hashrate, rng)
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"height", "timestamp", "interval", "target", "difficulty"})
+	for _, s := range samples {
+		w.Write([]string{
+			strconv.FormatUint(s.Height, 10),
+			strconv.FormatInt(s.Timestamp.Unix(), 10),
+			strconv.FormatInt(int64(s.Interval/time.Second), 10),
+			s.ChildTarget.String(),
+			s.Difficulty.String(),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		log.Fatal(err)
+	}
+}
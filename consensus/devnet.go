@@ -0,0 +1,52 @@
+package consensus
+
+import (
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+// TrivialWork is a BlockAuthorizer that accepts every block, regardless of its
+// work. It is intended for local development clusters, where mining is a
+// needless expense.
+type TrivialWork struct{}
+
+// AuthorizeHeader implements BlockAuthorizer.
+func (TrivialWork) AuthorizeHeader(State, types.BlockHeader) error { return nil }
+
+// AuthorizeBlock implements BlockAuthorizer.
+func (TrivialWork) AuthorizeBlock(State, types.Block) error { return nil }
+
+// Devnet returns the network parameters and genesis block of a private network
+// for local development clusters. Blocks require no work, the block interval is
+// 10 seconds, every v1 hardfork activates within the first 10 blocks, and v2 is
+// required from height 20. The genesis block contains no transactions; callers
+// will typically add outputs funding their wallets before applying it.
+func Devnet() (*Network, types.Block) {
+	n := &Network{
+		Name:            "devnet",
+		InitialCoinbase: types.Siacoins(300000),
+		MinimumCoinbase: types.Siacoins(300000),
+		InitialTarget:   types.BlockID{0xFF},
+		BlockInterval:   10 * time.Second,
+		MaturityDelay:   5,
+		BlockAuthorizer: TrivialWork{},
+	}
+	n.HardforkDevAddr.Height = 1
+	n.HardforkTax.Height = 2
+	n.HardforkStorageProof.Height = 3
+	n.HardforkOak.Height = 4
+	n.HardforkOak.FixHeight = 5
+	n.HardforkOak.GenesisTimestamp = time.Unix(1735689600, 0).UTC() // 2025-01-01
+	n.HardforkASIC.Height = 6
+	n.HardforkASIC.OakTime = 6 * n.BlockInterval
+	n.HardforkASIC.OakTarget = n.InitialTarget
+	n.HardforkFoundation.Height = 7
+	n.HardforkFoundation.PrimaryAddress = types.VoidAddress
+	n.HardforkFoundation.FailsafeAddress = types.VoidAddress
+	n.HardforkV2.AllowHeight = 10
+	n.HardforkV2.RequireHeight = 20
+
+	b := types.Block{Timestamp: n.HardforkOak.GenesisTimestamp}
+	return n, b
+}
@@ -0,0 +1,74 @@
+package consensus
+
+import (
+	"errors"
+	"testing"
+
+	"go.sia.tech/core/types"
+)
+
+// proofOfAuthority is a BlockAuthorizer that requires each block to contain a
+// transaction whose arbitrary data is the authority's signature of the
+// block's parent ID.
+type proofOfAuthority struct {
+	authority types.PublicKey
+}
+
+func (proofOfAuthority) AuthorizeHeader(State, types.BlockHeader) error { return nil }
+
+func (poa proofOfAuthority) AuthorizeBlock(s State, b types.Block) error {
+	for _, txn := range b.Transactions {
+		var sig types.Signature
+		if len(txn.ArbitraryData) == 1 && copy(sig[:], txn.ArbitraryData[0]) == len(sig) &&
+			poa.authority.VerifyHash(types.Hash256(b.ParentID), sig) {
+			return nil
+		}
+	}
+	return errors.New("missing authority signature")
+}
+
+func TestBlockAuthorizer(t *testing.T) {
+	n, genesisBlock := Devnet()
+	// make the target unreachable, so that any block passing validation was
+	// authorized rather than mined
+	n.InitialTarget = types.BlockID{8: 1}
+	cs, _ := ApplyBlock(n.GenesisState(), genesisBlock, V1BlockSupplement{}, genesisBlock.Timestamp)
+
+	b := types.Block{
+		ParentID:     cs.Index.ID,
+		Timestamp:    cs.PrevTimestamps[0].Add(n.BlockInterval),
+		MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+	}
+	if err := ValidateHeader(cs, b.Header()); err != nil {
+		t.Fatal(err)
+	} else if err := ValidateBlock(cs, b, V1BlockSupplement{Transactions: make([]V1TransactionSupplement, len(b.Transactions))}); err != nil {
+		t.Fatal(err)
+	}
+
+	// without an authorizer, the block has insufficient work
+	n.BlockAuthorizer = nil
+	if err := ValidateHeader(cs, b.Header()); !errors.Is(err, ErrInsufficientWork) {
+		t.Fatalf("expected insufficient work, got %v", err)
+	} else if err := ValidateOrphan(cs, b); !errors.Is(err, ErrInsufficientWork) {
+		t.Fatalf("expected insufficient work, got %v", err)
+	}
+
+	// proof-of-authority
+	sk := types.GeneratePrivateKey()
+	n.BlockAuthorizer = proofOfAuthority{sk.PublicKey()}
+	if err := ValidateOrphan(cs, b); !errors.Is(err, ErrUnauthorizedBlock) {
+		t.Fatalf("expected unauthorized block, got %v", err)
+	} else if ErrUnauthorizedBlock.Severity() != SeverityConsensusFatal {
+		t.Fatal("expected unauthorized block to be consensus-fatal")
+	}
+	sig := sk.SignHash(types.Hash256(b.ParentID))
+	b.Transactions = []types.Transaction{{ArbitraryData: [][]byte{sig[:]}}}
+	if err := ValidateOrphan(cs, b); err != nil {
+		t.Fatal(err)
+	}
+	other := types.GeneratePrivateKey().SignHash(types.Hash256(b.ParentID))
+	b.Transactions[0].ArbitraryData[0] = other[:]
+	if err := ValidateOrphan(cs, b); !errors.Is(err, ErrUnauthorizedBlock) {
+		t.Fatalf("expected unauthorized block, got %v", err)
+	}
+}
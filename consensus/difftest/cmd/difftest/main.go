@@ -0,0 +1,70 @@
+// Command difftest replays consensus fixtures against the current consensus
+// code.
+//
+// Usage:
+//
+//	difftest [file or directory ...]
+//
+// Directories are searched for fixtures with a .json extension. If no
+// arguments are given, the current directory is searched.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.sia.tech/core/consensus/difftest"
+)
+
+func replay(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fix, err := difftest.Read(f)
+	if err != nil {
+		return err
+	}
+	return fix.Replay()
+}
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	var paths []string
+	for _, arg := range args {
+		if fi, err := os.Stat(arg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		} else if !fi.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(arg, "*.json"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		paths = append(paths, matches...)
+	}
+
+	var failed int
+	for _, path := range paths {
+		if err := replay(path); err != nil {
+			fmt.Printf("FAIL\t%v: %v\n", path, err)
+			failed++
+		} else {
+			fmt.Printf("ok\t%v\n", path)
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("%v of %v fixtures failed\n", failed, len(paths))
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,193 @@
+// Package difftest checks the consensus package against recorded fixtures.
+//
+// A Fixture records the inputs to ApplyBlock (a parent State, a block, its
+// supplement, and the target timestamp), along with the ApplyUpdate and child
+// State that ApplyBlock produced when the fixture was generated. Replaying a
+// fixture applies the block with the current code and reports any difference
+// from the recorded outputs, so that unintended changes to the consensus rules
+// are caught before they can cause a chain split.
+//
+// Fixtures are generated from any chain store with Generate, and are stored as
+// JSON so that they can be reviewed and replayed without network access.
+//
+// The fixtures bundled with this package's tests are blocks of a simulated
+// chaintest chain. Recorded mainnet blocks are out of scope: they require a
+// synced mainnet store, and the historical hardforks (the Oak fix, ASIC, and
+// Foundation heights) are therefore not covered by the bundled fixtures.
+package difftest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+// A Fixture records the application of a single block.
+type Fixture struct {
+	Description string             `json:"description"`
+	Network     *consensus.Network `json:"network"`
+
+	// inputs
+	Parent            consensus.State             `json:"parent"`
+	Block             types.Block                 `json:"block"`
+	Supplement        consensus.V1BlockSupplement `json:"supplement"`
+	AncestorTimestamp time.Time                   `json:"ancestorTimestamp"`
+
+	// expected outputs
+	Update consensus.ApplyUpdate `json:"update"`
+	State  consensus.State       `json:"state"`
+}
+
+// New returns a Fixture recording the application of b to parent. The block
+// must be valid.
+func New(desc string, parent consensus.State, b types.Block, bs consensus.V1BlockSupplement, ancestorTimestamp time.Time) (Fixture, error) {
+	if parent.Network == nil {
+		return Fixture{}, errors.New("parent state has no network")
+	} else if err := consensus.ValidateBlock(parent, b, bs); err != nil {
+		return Fixture{}, fmt.Errorf("invalid block: %w", err)
+	}
+	cs, au := consensus.ApplyBlock(parent, b, bs, ancestorTimestamp)
+	return Fixture{
+		Description:       desc,
+		Network:           parent.Network,
+		Parent:            parent,
+		Block:             b,
+		Supplement:        bs,
+		AncestorTimestamp: ancestorTimestamp,
+		Update:            au,
+		State:             cs,
+	}, nil
+}
+
+// A Store is a source of blocks and states. It is satisfied by the chain
+// stores used by full nodes.
+type Store interface {
+	BestIndex(height uint64) (types.ChainIndex, bool)
+	Block(id types.BlockID) (types.Block, *consensus.V1BlockSupplement, bool)
+	State(id types.BlockID) (consensus.State, bool)
+	// AncestorTimestamp returns the target timestamp used when applying a
+	// child of the block with the given ID.
+	AncestorTimestamp(id types.BlockID) (time.Time, bool)
+}
+
+// Generate returns a Fixture recording the application of the block at the
+// specified height of the store's best chain.
+func Generate(s Store, height uint64, desc string) (Fixture, error) {
+	if height == 0 {
+		return Fixture{}, errors.New("cannot generate fixture for genesis block")
+	}
+	index, ok := s.BestIndex(height)
+	if !ok {
+		return Fixture{}, fmt.Errorf("no block at height %v", height)
+	}
+	b, bs, ok := s.Block(index.ID)
+	if !ok {
+		return Fixture{}, fmt.Errorf("missing block %v", index)
+	} else if bs == nil {
+		return Fixture{}, fmt.Errorf("missing supplement for block %v", index)
+	}
+	parent, ok := s.State(b.ParentID)
+	if !ok {
+		return Fixture{}, fmt.Errorf("missing parent state for block %v", index)
+	}
+	ancestorTimestamp, ok := s.AncestorTimestamp(b.ParentID)
+	if !ok {
+		return Fixture{}, fmt.Errorf("missing ancestor timestamp for block %v", index)
+	}
+	return New(desc, parent, b, *bs, ancestorTimestamp)
+}
+
+// Replay validates and applies the fixture's block using the current consensus
+// code, returning an error if the block is no longer valid or if the resulting
+// ApplyUpdate or State differ from the recorded ones. Mismatches are reported
+// as JSON paths, e.g. "state.childTarget".
+func (f Fixture) Replay() error {
+	parent := f.Parent
+	parent.Network = f.Network
+	if parent.Index.Height > 0 && parent.Index.ID != f.Block.ParentID {
+		return errors.New("block is not a child of the parent state")
+	} else if err := consensus.ValidateBlock(parent, f.Block, f.Supplement); err != nil {
+		return fmt.Errorf("block is no longer valid: %w", err)
+	}
+	cs, au := consensus.ApplyBlock(parent, f.Block, f.Supplement, f.AncestorTimestamp)
+	var mismatches []string
+	for _, c := range []struct {
+		name          string
+		expected, got any
+	}{
+		{"update", f.Update, au},
+		{"state", f.State, cs},
+	} {
+		expected, err := json.Marshal(c.expected)
+		if err != nil {
+			return fmt.Errorf("failed to encode expected %v: %w", c.name, err)
+		}
+		got, err := json.Marshal(c.got)
+		if err != nil {
+			return fmt.Errorf("failed to encode %v: %w", c.name, err)
+		}
+		mismatches = append(mismatches, diffJSON(c.name, expected, got)...)
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("block %v diverged from fixture: %v", f.Block.ID(), strings.Join(mismatches, "; "))
+	}
+	return nil
+}
+
+// diffJSON returns the paths at which two JSON values differ, descending into
+// objects. Values that are small enough to be readable are included.
+func diffJSON(path string, expected, got json.RawMessage) []string {
+	if bytes.Equal(expected, got) {
+		return nil
+	}
+	var eobj, gobj map[string]json.RawMessage
+	if json.Unmarshal(expected, &eobj) != nil || json.Unmarshal(got, &gobj) != nil {
+		if len(expected)+len(got) > 200 {
+			return []string{path}
+		}
+		return []string{fmt.Sprintf("%v: expected %s, got %s", path, expected, got)}
+	}
+	keys := make([]string, 0, len(eobj)+len(gobj))
+	for k := range eobj {
+		keys = append(keys, k)
+	}
+	for k := range gobj {
+		if _, ok := eobj[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	var diffs []string
+	for _, k := range keys {
+		diffs = append(diffs, diffJSON(path+"."+k, eobj[k], gobj[k])...)
+	}
+	return diffs
+}
+
+// Write writes f to w as JSON.
+func (f Fixture) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(f)
+}
+
+// Read reads a Fixture written by Write from r.
+func Read(r io.Reader) (Fixture, error) {
+	var f Fixture
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return Fixture{}, fmt.Errorf("failed to decode fixture: %w", err)
+	} else if f.Network == nil {
+		return Fixture{}, errors.New("fixture has no network")
+	}
+	f.Parent.Network = f.Network
+	f.State.Network = f.Network
+	return f, nil
+}
@@ -0,0 +1,176 @@
+package difftest
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/consensus/chaintest"
+	"go.sia.tech/core/types"
+)
+
+var update = flag.Bool("update", false, "regenerate the fixtures in testdata")
+
+// chainStore implements Store for a chaintest.Chain.
+type chainStore struct {
+	c *chaintest.Chain
+}
+
+func (s chainStore) height(id types.BlockID) (uint64, bool) {
+	for h := range s.c.Tip().Index.Height + 1 {
+		if s.c.State(h).Index.ID == id {
+			return h, true
+		}
+	}
+	return 0, false
+}
+
+func (s chainStore) BestIndex(height uint64) (types.ChainIndex, bool) {
+	if height > s.c.Tip().Index.Height {
+		return types.ChainIndex{}, false
+	}
+	return s.c.State(height).Index, true
+}
+
+func (s chainStore) Block(id types.BlockID) (types.Block, *consensus.V1BlockSupplement, bool) {
+	h, ok := s.height(id)
+	if !ok {
+		return types.Block{}, nil, false
+	}
+	bs := s.c.Supplement(h)
+	return s.c.Block(h), &bs, true
+}
+
+func (s chainStore) State(id types.BlockID) (consensus.State, bool) {
+	h, ok := s.height(id)
+	if !ok {
+		return consensus.State{}, false
+	}
+	return s.c.State(h), true
+}
+
+func (s chainStore) AncestorTimestamp(id types.BlockID) (time.Time, bool) {
+	h, ok := s.height(id)
+	if !ok {
+		return time.Time{}, false
+	}
+	childHeight := h + 1
+	return s.c.Block(childHeight - min(childHeight, s.c.State(h).AncestorDepth())).Timestamp, true
+}
+
+// fixtureHeights are the heights of the blocks recorded in testdata, along
+// with their descriptions. The blocks are mined on the simulated chaintest
+// network by newFixtureChain; they are not mainnet blocks, and only exercise
+// the code paths of each hardfork at the chaintest network's heights.
+var fixtureHeights = []struct {
+	name   string
+	height uint64
+	desc   string
+}{
+	{"chaintest-h5-transfers", 5, "simulated chaintest block at height 5 (the test network's Oak fix height), with siacoin transfers and irregular block times"},
+	{"chaintest-h6-contract", 6, "simulated chaintest block at height 6 (the test network's ASIC hardfork height), with a file contract formation"},
+	{"chaintest-h7-subsidy", 7, "simulated chaintest block at height 7 (the test network's Foundation hardfork height), paying the initial Foundation subsidy"},
+	{"chaintest-h20-v2-transaction", 20, "simulated chaintest block at height 20 (the test network's v2 allow height), containing a v2 transaction"},
+	{"chaintest-h30-v2-contract", 30, "simulated chaintest block at height 30 (the test network's v2 require height), forming a v2 file contract"},
+}
+
+// newFixtureChain returns a simulated chain whose blocks exercise each hardfork
+// of the chaintest network.
+func newFixtureChain(t *testing.T) *chaintest.Chain {
+	c := chaintest.New(t, nil)
+	genesis, alice, host := c.Wallet("genesis"), c.Wallet("alice"), c.Wallet("host")
+	c.Mine(3)
+	c.AdvanceTime(3 * time.Minute).Mine(1)
+	c.AdvanceTime(2*time.Hour).
+		SendSiacoins(genesis, alice.Address(), types.Siacoins(1000)).
+		SendSiacoins(genesis, alice.Address(), types.Siacoins(1000)).
+		SendSiacoins(genesis, host.Address(), types.Siacoins(1000)).
+		Mine(1)
+	c.FormContract(alice, host, types.Siacoins(100), types.Siacoins(200), 8, 10)
+	c.SendSiafunds(genesis, alice.Address(), 100).Mine(1)
+	c.SendSiacoins(alice, host.Address(), types.Siacoins(10)).Mine(1)
+	c.Mine(int(c.Network().HardforkV2.AllowHeight - c.Tip().Index.Height - 1))
+	c.SendSiacoins(host, alice.Address(), types.Siacoins(10)).Mine(1)
+	c.Mine(int(c.Network().HardforkV2.RequireHeight - c.Tip().Index.Height - 1))
+	c.FormContract(alice, host, types.Siacoins(100), types.Siacoins(200), 35, 40)
+	c.Mine(1)
+	return c
+}
+
+func TestFixtures(t *testing.T) {
+	if *update {
+		s := chainStore{newFixtureChain(t)}
+		for _, fh := range fixtureHeights {
+			f, err := Generate(s, fh.height, fh.desc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := f.Write(&buf); err != nil {
+				t.Fatal(err)
+			} else if err := os.WriteFile(filepath.Join("testdata", fh.name+".json"), buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(paths) != len(fixtureHeights) {
+		t.Fatalf("expected %v fixtures, found %v", len(fixtureHeights), len(paths))
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			f, err := Read(file)
+			if err != nil {
+				t.Fatal(err)
+			} else if err := f.Replay(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestReplayDivergence(t *testing.T) {
+	s := chainStore{newFixtureChain(t)}
+	f, err := Generate(s, 7, "")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := f.Replay(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Generate(s, 0, ""); err == nil {
+		t.Fatal("expected error for genesis block")
+	} else if _, err := Generate(s, 100, ""); err == nil {
+		t.Fatal("expected error for missing block")
+	}
+
+	// simulate a rule change by altering the network
+	n := *f.Network
+	n.HardforkFoundation.Height++
+	changed := f
+	changed.Network = &n
+	if err := changed.Replay(); err == nil {
+		t.Fatal("expected divergence")
+	} else if !strings.Contains(err.Error(), "update.siacoinElements") || !strings.Contains(err.Error(), "state.elements") {
+		t.Fatalf("expected mismatch in siacoin elements, got %v", err)
+	}
+
+	// a block that is no longer valid is reported as such
+	invalid := f
+	invalid.Block.MinerPayouts = nil
+	if err := invalid.Replay(); err == nil || !strings.Contains(err.Error(), "no longer valid") {
+		t.Fatalf("expected invalid block, got %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package consensus
+
+import (
+	"fmt"
+
+	"go.sia.tech/core/types"
+)
+
+// A Rule identifies a group of consensus rules.
+type Rule string
+
+// Consensus rules.
+const (
+	RuleBlockWeight    Rule = "block weight"
+	RuleMinerPayouts   Rule = "miner payouts"
+	RuleHeader         Rule = "header"
+	RuleBlockHeight    Rule = "block height"
+	RuleSupplement     Rule = "block supplement"
+	RuleCommitment     Rule = "commitment"
+	RuleVersion        Rule = "transaction version"
+	RuleOverflow       Rule = "currency overflow"
+	RuleWeight         Rule = "transaction weight"
+	RuleMinimumValues  Rule = "minimum values"
+	RuleSiacoins       Rule = "siacoins"
+	RuleSiafunds       Rule = "siafunds"
+	RuleFileContracts  Rule = "file contracts"
+	RuleArbitraryData  Rule = "arbitrary data"
+	RuleSignatures     Rule = "signatures"
+	RuleAttestations   Rule = "attestations"
+	RuleFoundationAddr Rule = "foundation address update"
+)
+
+// A Severity classifies validation errors by how they should be handled.
+type Severity int
+
+// Severities.
+const (
+	// SeverityPermanent indicates that the transaction can never become
+	// valid. Peers relaying it are misbehaving.
+	SeverityPermanent Severity = iota
+	// SeverityTemporary indicates that the transaction is invalid in the
+	// current context, but could be (or could have been) valid in another,
+	// e.g. because it conflicts with another transaction, spends an output
+	// that does not exist yet, or is premature. Such transactions should be
+	// dropped, but peers relaying them are not necessarily misbehaving.
+	SeverityTemporary
+	// SeverityConsensusFatal indicates that the block itself is invalid,
+	// independent of any transaction.
+	SeverityConsensusFatal
+)
+
+// String implements fmt.Stringer.
+func (s Severity) String() string {
+	switch s {
+	case SeverityPermanent:
+		return "permanent"
+	case SeverityTemporary:
+		return "temporary"
+	case SeverityConsensusFatal:
+		return "consensus-fatal"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// An ErrorKind identifies a specific kind of consensus violation. ErrorKinds
+// are sentinel errors: errors.Is(err, ErrConflict) reports whether err is
+// a *ValidationError of that kind.
+type ErrorKind string
+
+// Error implements error.
+func (k ErrorKind) Error() string { return string(k) }
+
+// Severity returns the severity of the error kind.
+func (k ErrorKind) Severity() Severity {
+	switch k {
+	case ErrWrongParent, ErrTimestampTooOld, ErrInvalidNonce, ErrInsufficientWork,
+		ErrUnauthorizedBlock, ErrBlockWeight, ErrMinerPayouts, ErrBlockHeight, ErrSupplement, ErrCommitment:
+		return SeverityConsensusFatal
+	case ErrV2NotAllowed, ErrTimelocked, ErrConflict, ErrMissingElement,
+		ErrImmature, ErrPrematureResolution:
+		return SeverityTemporary
+	default:
+		return SeverityPermanent
+	}
+}
+
+// Block-level error kinds.
+const (
+	ErrWrongParent      ErrorKind = "wrong parent ID"
+	ErrTimestampTooOld  ErrorKind = "timestamp too far in the past"
+	ErrInvalidNonce     ErrorKind = "invalid nonce"
+	ErrInsufficientWork ErrorKind = "insufficient work"
+	// ErrUnauthorizedBlock is returned when a Network's BlockAuthorizer
+	// rejects a block.
+	ErrUnauthorizedBlock ErrorKind = "unauthorized block"
+	ErrBlockWeight       ErrorKind = "block exceeds maximum weight"
+	ErrMinerPayouts      ErrorKind = "invalid miner payouts"
+	ErrBlockHeight       ErrorKind = "incorrect block height"
+	ErrSupplement        ErrorKind = "invalid block supplement"
+	ErrCommitment        ErrorKind = "commitment hash mismatch"
+)
+
+// Transaction-level error kinds.
+const (
+	ErrV1NotAllowed    ErrorKind = "v1 transactions not allowed"
+	ErrV2NotAllowed    ErrorKind = "v2 transactions not allowed yet"
+	ErrOverflow        ErrorKind = "currency overflow"
+	ErrWeight          ErrorKind = "invalid transaction weight"
+	ErrZeroValue       ErrorKind = "zero value"
+	ErrTimelocked      ErrorKind = "timelocked"
+	ErrConflict        ErrorKind = "conflicts with prior spend or resolution"
+	ErrDuplicateInput  ErrorKind = "duplicate input"
+	ErrMissingElement  ErrorKind = "missing element"
+	ErrImmature        ErrorKind = "immature parent"
+	ErrIncorrectUnlock ErrorKind = "incorrect unlock conditions or policy"
+	// ErrUnsatisfiedPolicy is returned when a spend policy can never be
+	// satisfied, e.g. because of an invalid signature. A policy that is only
+	// unsatisfied because of a timelock is reported as ErrTimelocked.
+	ErrUnsatisfiedPolicy   ErrorKind = "unsatisfied spend policy"
+	ErrUnbalanced          ErrorKind = "inputs do not equal outputs"
+	ErrInvalidSignature    ErrorKind = "invalid signature"
+	ErrMalformed           ErrorKind = "malformed transaction"
+	ErrInvalidContract     ErrorKind = "invalid file contract"
+	ErrInvalidRevision     ErrorKind = "invalid file contract revision"
+	ErrInvalidRenewal      ErrorKind = "invalid file contract renewal"
+	ErrPrematureResolution ErrorKind = "premature file contract resolution"
+	ErrInvalidStorageProof ErrorKind = "invalid storage proof"
+	ErrUnauthorized        ErrorKind = "unauthorized Foundation address update"
+)
+
+// A ValidationError is returned when a block or transaction violates a
+// consensus rule.
+type ValidationError struct {
+	Kind ErrorKind
+	Rule Rule
+	// Transaction is the index of the offending transaction within its block,
+	// or -1 if the error does not pertain to a transaction in a block. If V2 is
+	// true, the index refers to the block's v2 transactions.
+	Transaction int
+	V2          bool
+	// Input is the index of the offending input (siacoin input, siafund input,
+	// file contract revision, resolution, or storage proof) within its
+	// respective field of the transaction, or -1.
+	Input int
+	// Output is the index of the offending output (siacoin output, siafund
+	// output, file contract, or attestation) within its respective field of
+	// the transaction, or -1.
+	Output int
+	// ElementID is the ID of the offending element, if any. For inputs, this
+	// is the ID of the parent element; for outputs, it is the ID of the
+	// element that would have been created.
+	ElementID types.ElementID
+	Err       error
+}
+
+// Error implements error.
+func (e *ValidationError) Error() string {
+	switch {
+	case e.Transaction < 0:
+		return e.Err.Error()
+	case e.V2:
+		return fmt.Sprintf("v2 transaction %v is invalid: %v", e.Transaction, e.Err)
+	default:
+		return fmt.Sprintf("transaction %v is invalid: %v", e.Transaction, e.Err)
+	}
+}
+
+// Unwrap returns the underlying error.
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// Is reports whether e is of the specified ErrorKind.
+func (e *ValidationError) Is(target error) bool {
+	k, ok := target.(ErrorKind)
+	return ok && k == e.Kind
+}
+
+// Severity returns the severity of the error.
+func (e *ValidationError) Severity() Severity { return e.Kind.Severity() }
+
+// violation returns a ValidationError that does not pertain to a specific
+// input or output. The Rule is filled in by the caller of the validation
+// function.
+func violation(kind ErrorKind, format string, args ...any) *ValidationError {
+	return &ValidationError{
+		Kind:        kind,
+		Transaction: -1,
+		Input:       -1,
+		Output:      -1,
+		Err:         fmt.Errorf(format, args...),
+	}
+}
+
+// inputViolation returns a ValidationError for the i'th input of a
+// transaction.
+func inputViolation(kind ErrorKind, i int, id types.ElementID, format string, args ...any) *ValidationError {
+	ve := violation(kind, format, args...)
+	ve.Input, ve.ElementID = i, id
+	return ve
+}
+
+// outputViolation returns a ValidationError for the i'th output of a
+// transaction.
+func outputViolation(kind ErrorKind, i int, id types.ElementID, format string, args ...any) *ValidationError {
+	ve := violation(kind, format, args...)
+	ve.Output, ve.ElementID = i, id
+	return ve
+}
+
+// ruleError tags err with the rule that it violates.
+func ruleError(r Rule, err error) error {
+	if ve, ok := err.(*ValidationError); ok {
+		ve.Rule = r
+		return ve
+	}
+	return &ValidationError{Rule: r, Transaction: -1, Input: -1, Output: -1, Err: err}
+}
+
+// transactionError tags err with the index of the transaction that caused it.
+func transactionError(i int, v2 bool, err error) error {
+	ve, ok := err.(*ValidationError)
+	if !ok {
+		ve = &ValidationError{Transaction: -1, Input: -1, Output: -1, Err: err}
+	}
+	tagged := *ve
+	tagged.Transaction, tagged.V2 = i, v2
+	return &tagged
+}
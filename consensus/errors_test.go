@@ -0,0 +1,171 @@
+package consensus
+
+import (
+	"errors"
+	"testing"
+
+	"go.sia.tech/core/types"
+)
+
+func TestValidationErrorKinds(t *testing.T) {
+	n, genesisBlock := testnet()
+	n.HardforkV2.AllowHeight = 0
+	n.HardforkV2.RequireHeight = 0
+
+	sk := types.GeneratePrivateKey()
+	policy := types.PolicyPublicKey(sk.PublicKey())
+	addr := policy.Address()
+	lockedPolicy := types.PolicyThreshold(2, []types.SpendPolicy{types.PolicyAbove(1000), policy})
+	lockedAddr := lockedPolicy.Address()
+	genesisBlock.V2 = &types.V2BlockData{
+		Transactions: []types.V2Transaction{{
+			SiacoinOutputs: []types.SiacoinOutput{
+				{Address: addr, Value: types.Siacoins(100)},
+				{Address: lockedAddr, Value: types.Siacoins(100)},
+			},
+		}},
+	}
+	db, cs := newConsensusDB(n, genesisBlock)
+	var sce, lockedSCE types.SiacoinElement
+	for _, e := range db.sces {
+		switch e.SiacoinOutput.Address {
+		case addr:
+			sce = e.Copy()
+		case lockedAddr:
+			lockedSCE = e.Copy()
+		}
+	}
+
+	validTxn := func() types.V2Transaction {
+		txn := types.V2Transaction{
+			SiacoinInputs: []types.V2SiacoinInput{{
+				Parent:          sce.Copy(),
+				SatisfiedPolicy: types.SatisfiedPolicy{Policy: policy},
+			}},
+			SiacoinOutputs: []types.SiacoinOutput{{Address: addr, Value: types.Siacoins(100)}},
+		}
+		txn.SiacoinInputs[0].SatisfiedPolicy.Signatures = []types.Signature{sk.SignHash(cs.InputSigHash(txn))}
+		return txn
+	}
+	if err := ValidateV2Transaction(NewMidState(cs), validTxn()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc     string
+		corrupt  func(*types.V2Transaction)
+		kind     ErrorKind
+		severity Severity
+		input    int
+		output   int
+	}{
+		{
+			desc: "bad signature",
+			corrupt: func(txn *types.V2Transaction) {
+				txn.SiacoinInputs[0].SatisfiedPolicy.Signatures[0][0] ^= 1
+			},
+			kind:     ErrUnsatisfiedPolicy,
+			severity: SeverityPermanent,
+			input:    0,
+			output:   -1,
+		},
+		{
+			desc: "duplicate input",
+			corrupt: func(txn *types.V2Transaction) {
+				txn.SiacoinInputs = append(txn.SiacoinInputs, txn.SiacoinInputs[0])
+				txn.SiacoinInputs[1].Parent = sce.Copy()
+			},
+			kind:     ErrDuplicateInput,
+			severity: SeverityPermanent,
+			input:    1,
+			output:   -1,
+		},
+		{
+			desc: "missing parent",
+			corrupt: func(txn *types.V2Transaction) {
+				txn.SiacoinInputs[0].Parent.ID[0] ^= 1
+			},
+			kind:     ErrMissingElement,
+			severity: SeverityTemporary,
+			input:    0,
+			output:   -1,
+		},
+		{
+			desc: "zero-valued output",
+			corrupt: func(txn *types.V2Transaction) {
+				txn.SiacoinOutputs = append(txn.SiacoinOutputs, types.SiacoinOutput{})
+			},
+			kind:     ErrZeroValue,
+			severity: SeverityPermanent,
+			input:    -1,
+			output:   1,
+		},
+		{
+			desc: "unbalanced",
+			corrupt: func(txn *types.V2Transaction) {
+				txn.SiacoinOutputs[0].Value = types.Siacoins(99)
+			},
+			kind:     ErrUnbalanced,
+			severity: SeverityPermanent,
+			input:    -1,
+			output:   -1,
+		},
+	}
+	for _, test := range tests {
+		txn := validTxn()
+		test.corrupt(&txn)
+		if test.kind != ErrUnsatisfiedPolicy {
+			for i := range txn.SiacoinInputs {
+				txn.SiacoinInputs[i].SatisfiedPolicy.Signatures = []types.Signature{sk.SignHash(cs.InputSigHash(txn))}
+			}
+		}
+		err := ValidateV2Transaction(NewMidState(cs), txn)
+		var ve *ValidationError
+		if !errors.As(err, &ve) {
+			t.Fatalf("%s: expected ValidationError, got %v", test.desc, err)
+		} else if !errors.Is(err, test.kind) {
+			t.Fatalf("%s: expected kind %q, got %q (%v)", test.desc, test.kind, ve.Kind, err)
+		} else if ve.Severity() != test.severity {
+			t.Fatalf("%s: expected severity %v, got %v", test.desc, test.severity, ve.Severity())
+		} else if ve.Input != test.input || ve.Output != test.output {
+			t.Fatalf("%s: expected input/output %v/%v, got %v/%v", test.desc, test.input, test.output, ve.Input, ve.Output)
+		} else if ve.Transaction != -1 || ve.Rule != RuleSiacoins {
+			t.Fatalf("%s: unexpected transaction index %v or rule %q", test.desc, ve.Transaction, ve.Rule)
+		}
+		if test.input >= 0 && ve.ElementID != txn.SiacoinInputs[test.input].Parent.ID {
+			t.Fatalf("%s: unexpected element ID %v", test.desc, ve.ElementID)
+		} else if test.output >= 0 && ve.ElementID != txn.SiacoinOutputID(txn.ID(), test.output) {
+			t.Fatalf("%s: unexpected element ID %v", test.desc, ve.ElementID)
+		}
+	}
+
+	// a policy that is only unsatisfied because of a timelock is temporary,
+	// but a bad signature is not excused by the timelock
+	lockedTxn := types.V2Transaction{
+		SiacoinInputs: []types.V2SiacoinInput{{
+			Parent:          lockedSCE.Copy(),
+			SatisfiedPolicy: types.SatisfiedPolicy{Policy: lockedPolicy},
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{Address: addr, Value: types.Siacoins(100)}},
+	}
+	lockedTxn.SiacoinInputs[0].SatisfiedPolicy.Signatures = []types.Signature{sk.SignHash(cs.InputSigHash(lockedTxn))}
+	if err := ValidateV2Transaction(NewMidState(cs), lockedTxn); !errors.Is(err, ErrTimelocked) {
+		t.Fatalf("expected timelocked error, got %v", err)
+	} else if ve := new(ValidationError); !errors.As(err, &ve) || ve.Severity() != SeverityTemporary {
+		t.Fatalf("expected temporary error, got %v", err)
+	}
+	lockedTxn.SiacoinInputs[0].SatisfiedPolicy.Signatures[0][0] ^= 1
+	if err := ValidateV2Transaction(NewMidState(cs), lockedTxn); !errors.Is(err, ErrUnsatisfiedPolicy) {
+		t.Fatalf("expected unsatisfied policy, got %v", err)
+	} else if ve := new(ValidationError); !errors.As(err, &ve) || ve.Severity() != SeverityPermanent {
+		t.Fatalf("expected permanent error, got %v", err)
+	}
+
+	// block-level errors are consensus-fatal
+	b := types.Block{ParentID: types.BlockID{1}}
+	if err := ValidateOrphan(cs, b); !errors.Is(err, ErrWrongParent) && !errors.Is(err, ErrMinerPayouts) {
+		t.Fatalf("expected block-level error, got %v", err)
+	} else if ve := new(ValidationError); !errors.As(err, &ve) || ve.Severity() != SeverityConsensusFatal {
+		t.Fatalf("expected consensus-fatal error, got %v", err)
+	}
+}
@@ -0,0 +1,311 @@
+package consensus
+
+import (
+	"fmt"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+// An ElementSource identifies the cause of a change to an element.
+type ElementSource struct {
+	// Transaction is the index of the responsible transaction within the
+	// block, or -1 if the change was caused by the block itself, e.g. a miner
+	// payout, the Foundation subsidy, or a v1 contract expiration. If V2 is
+	// true, the index refers to the block's v2 transactions.
+	Transaction int                 `json:"transaction"`
+	V2          bool                `json:"v2"`
+	ID          types.TransactionID `json:"id"`
+}
+
+// An ExplainedSiacoinElement is a SiacoinElementDiff annotated with the
+// sources of its changes.
+type ExplainedSiacoinElement struct {
+	SiacoinElementDiff
+	CreatedBy *ElementSource `json:"createdBy,omitempty"`
+	SpentBy   *ElementSource `json:"spentBy,omitempty"`
+}
+
+// An ExplainedSiafundElement is a SiafundElementDiff annotated with the
+// sources of its changes.
+type ExplainedSiafundElement struct {
+	SiafundElementDiff
+	CreatedBy *ElementSource `json:"createdBy,omitempty"`
+	SpentBy   *ElementSource `json:"spentBy,omitempty"`
+}
+
+// An ExplainedFileContractElement is a FileContractElementDiff annotated with
+// the sources of its changes.
+type ExplainedFileContractElement struct {
+	FileContractElementDiff
+	CreatedBy  *ElementSource `json:"createdBy,omitempty"`
+	RevisedBy  *ElementSource `json:"revisedBy,omitempty"`
+	ResolvedBy *ElementSource `json:"resolvedBy,omitempty"`
+}
+
+// An ExplainedV2FileContractElement is a V2FileContractElementDiff annotated
+// with the sources of its changes.
+type ExplainedV2FileContractElement struct {
+	V2FileContractElementDiff
+	CreatedBy  *ElementSource `json:"createdBy,omitempty"`
+	RevisedBy  *ElementSource `json:"revisedBy,omitempty"`
+	ResolvedBy *ElementSource `json:"resolvedBy,omitempty"`
+}
+
+// An ExplainedAttestation is an AttestationElement annotated with the
+// transaction that created it.
+type ExplainedAttestation struct {
+	AttestationElement types.AttestationElement `json:"attestationElement"`
+	CreatedBy          ElementSource            `json:"createdBy"`
+}
+
+// An ExplainedTransaction summarizes the value flows of a transaction.
+type ExplainedTransaction struct {
+	ElementSource
+	MinerFee types.Currency `json:"minerFee"`
+	// SiafundTax is the tax levied on the file contracts created by the
+	// transaction.
+	SiafundTax types.Currency `json:"siafundTax"`
+}
+
+// A StateFieldChange describes a change to a single field of a State.
+type StateFieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// A StateChange describes the transition from a parent State to a child State.
+type StateChange struct {
+	Parent State `json:"parent"`
+	Child  State `json:"child"`
+}
+
+// Fields returns the fields of the State that changed.
+func (sc StateChange) Fields() []StateFieldChange {
+	var fields []StateFieldChange
+	add := func(name string, o, n any) {
+		if os, ns := fmt.Sprint(o), fmt.Sprint(n); os != ns {
+			fields = append(fields, StateFieldChange{Field: name, Old: os, New: ns})
+		}
+	}
+	p, c := sc.Parent, sc.Child
+	add("index", p.Index, c.Index)
+	add("prevTimestamp", p.PrevTimestamps[0], c.PrevTimestamps[0])
+	add("depth", p.Depth, c.Depth)
+	add("childTarget", p.ChildTarget, c.ChildTarget)
+	add("siafundTaxRevenue", p.SiafundTaxRevenue, c.SiafundTaxRevenue)
+	add("oakTime", p.OakTime, c.OakTime)
+	add("oakTarget", p.OakTarget, c.OakTarget)
+	add("foundationSubsidyAddress", p.FoundationSubsidyAddress, c.FoundationSubsidyAddress)
+	add("foundationManagementAddress", p.FoundationManagementAddress, c.FoundationManagementAddress)
+	add("totalWork", p.TotalWork, c.TotalWork)
+	add("difficulty", p.Difficulty, c.Difficulty)
+	add("oakWork", p.OakWork, c.OakWork)
+	add("numLeaves", p.Elements.NumLeaves, c.Elements.NumLeaves)
+	add("attestations", p.Attestations, c.Attestations)
+	return fields
+}
+
+// A BlockExplanation is a structured report of the effects of a block.
+type BlockExplanation struct {
+	Index        types.ChainIndex       `json:"index"`
+	Transactions []ExplainedTransaction `json:"transactions"`
+
+	SiacoinElements        []ExplainedSiacoinElement        `json:"siacoinElements"`
+	SiafundElements        []ExplainedSiafundElement        `json:"siafundElements"`
+	FileContractElements   []ExplainedFileContractElement   `json:"fileContractElements"`
+	V2FileContractElements []ExplainedV2FileContractElement `json:"v2FileContractElements"`
+	Attestations           []ExplainedAttestation           `json:"attestations"`
+	ChainIndexElement      types.ChainIndexElement          `json:"chainIndexElement"`
+
+	BlockReward types.Currency `json:"blockReward"`
+	MinerFees   types.Currency `json:"minerFees"`
+	SiafundTax  types.Currency `json:"siafundTax"`
+	// FoundationSubsidy is nil if the block does not pay a subsidy.
+	FoundationSubsidy *types.SiacoinOutput `json:"foundationSubsidy,omitempty"`
+
+	State StateChange `json:"state"`
+}
+
+// ExplainBlock validates and applies b to s, returning a report of everything
+// the block did. If the block is invalid, the returned error is a
+// *ValidationError.
+func ExplainBlock(s State, b types.Block, bs V1BlockSupplement, targetTimestamp time.Time) (BlockExplanation, error) {
+	if err := ValidateBlock(s, b, bs); err != nil {
+		return BlockExplanation{}, err
+	}
+	child, au := ApplyBlock(s, b, bs, targetTimestamp)
+
+	// replay the block's transactions, attributing each change to its source
+	ms := NewMidState(s)
+	sources := make(map[types.TransactionID]ElementSource)
+	created := make(map[types.ElementID]ElementSource)
+	revised := make(map[types.ElementID]ElementSource)
+	var txns []ExplainedTransaction
+	apply := func(src ElementSource, fee types.Currency, fn func()) {
+		sources[src.ID] = src
+		nsces, nsfes, nfces, nv2fces, naes := len(ms.sces), len(ms.sfes), len(ms.fces), len(ms.v2fces), len(ms.aes)
+		revenue := ms.siafundTaxRevenue
+		fn()
+		for _, d := range ms.sces[nsces:] {
+			if d.Created {
+				created[d.SiacoinElement.ID] = src
+			}
+		}
+		for _, d := range ms.sfes[nsfes:] {
+			if d.Created {
+				created[d.SiafundElement.ID] = src
+			}
+		}
+		for _, d := range ms.fces[nfces:] {
+			if d.Created {
+				created[d.FileContractElement.ID] = src
+			}
+		}
+		for _, d := range ms.v2fces[nv2fces:] {
+			if d.Created {
+				created[d.V2FileContractElement.ID] = src
+			}
+		}
+		for _, ae := range ms.aes[naes:] {
+			created[ae.ID] = src
+		}
+		txns = append(txns, ExplainedTransaction{
+			ElementSource: src,
+			MinerFee:      fee,
+			SiafundTax:    ms.siafundTaxRevenue.Sub(revenue),
+		})
+	}
+	for i, txn := range b.Transactions {
+		src := ElementSource{Transaction: i, ID: txn.ID()}
+		var fee types.Currency
+		for _, f := range txn.MinerFees {
+			fee = fee.Add(f)
+		}
+		apply(src, fee, func() { ms.ApplyTransaction(txn, bs.Transactions[i]) })
+		for _, fcr := range txn.FileContractRevisions {
+			revised[fcr.ParentID] = src
+		}
+	}
+	for i, txn := range b.V2Transactions() {
+		src := ElementSource{Transaction: i, V2: true, ID: txn.ID()}
+		apply(src, txn.MinerFee, func() { ms.ApplyV2Transaction(txn) })
+		for _, fcr := range txn.FileContractRevisions {
+			revised[fcr.Parent.ID] = src
+		}
+	}
+
+	blockSource := ElementSource{Transaction: -1}
+	lookup := func(m map[types.ElementID]ElementSource, id types.ElementID) *ElementSource {
+		if src, ok := m[id]; ok {
+			return &src
+		}
+		src := blockSource
+		return &src
+	}
+	spentBy := func(id types.ElementID) *ElementSource {
+		if txid, ok := ms.spends[id]; ok {
+			if src, ok := sources[txid]; ok {
+				return &src
+			}
+		}
+		src := blockSource
+		return &src
+	}
+
+	exp := BlockExplanation{
+		Index:             child.Index,
+		Transactions:      txns,
+		ChainIndexElement: au.cie.Copy(),
+		BlockReward:       s.BlockReward(),
+		SiafundTax:        child.SiafundTaxRevenue.Sub(s.SiafundTaxRevenue),
+		State:             StateChange{Parent: s, Child: child},
+	}
+	for _, txn := range txns {
+		exp.MinerFees = exp.MinerFees.Add(txn.MinerFee)
+	}
+	if subsidy, ok := s.FoundationSubsidy(); ok {
+		exp.FoundationSubsidy = &subsidy
+	}
+	for _, d := range au.sces {
+		e := ExplainedSiacoinElement{
+			SiacoinElementDiff: SiacoinElementDiff{
+				SiacoinElement: d.SiacoinElement.Copy(),
+				Created:        d.Created,
+				Spent:          d.Spent,
+			},
+		}
+		if d.Created {
+			e.CreatedBy = lookup(created, d.SiacoinElement.ID)
+		}
+		if d.Spent {
+			e.SpentBy = spentBy(d.SiacoinElement.ID)
+		}
+		exp.SiacoinElements = append(exp.SiacoinElements, e)
+	}
+	for _, d := range au.sfes {
+		e := ExplainedSiafundElement{
+			SiafundElementDiff: SiafundElementDiff{
+				SiafundElement: d.SiafundElement.Copy(),
+				Created:        d.Created,
+				Spent:          d.Spent,
+			},
+		}
+		if d.Created {
+			e.CreatedBy = lookup(created, d.SiafundElement.ID)
+		}
+		if d.Spent {
+			e.SpentBy = spentBy(d.SiafundElement.ID)
+		}
+		exp.SiafundElements = append(exp.SiafundElements, e)
+	}
+	for _, d := range au.fces {
+		e := ExplainedFileContractElement{
+			FileContractElementDiff: FileContractElementDiff{
+				FileContractElement: d.FileContractElement.Copy(),
+				Created:             d.Created,
+				Revision:            d.Revision,
+				Resolved:            d.Resolved,
+				Valid:               d.Valid,
+			},
+		}
+		if d.Created {
+			e.CreatedBy = lookup(created, d.FileContractElement.ID)
+		}
+		if d.Revision != nil {
+			e.RevisedBy = lookup(revised, d.FileContractElement.ID)
+		}
+		if d.Resolved {
+			e.ResolvedBy = spentBy(d.FileContractElement.ID)
+		}
+		exp.FileContractElements = append(exp.FileContractElements, e)
+	}
+	for _, d := range au.v2fces {
+		e := ExplainedV2FileContractElement{
+			V2FileContractElementDiff: V2FileContractElementDiff{
+				V2FileContractElement: d.V2FileContractElement.Copy(),
+				Created:               d.Created,
+				Revision:              d.Revision,
+				Resolution:            d.Resolution,
+			},
+		}
+		if d.Created {
+			e.CreatedBy = lookup(created, d.V2FileContractElement.ID)
+		}
+		if d.Revision != nil {
+			e.RevisedBy = lookup(revised, d.V2FileContractElement.ID)
+		}
+		if d.Resolution != nil {
+			e.ResolvedBy = spentBy(d.V2FileContractElement.ID)
+		}
+		exp.V2FileContractElements = append(exp.V2FileContractElements, e)
+	}
+	for _, ae := range au.aes {
+		exp.Attestations = append(exp.Attestations, ExplainedAttestation{
+			AttestationElement: ae.Copy(),
+			CreatedBy:          *lookup(created, ae.ID),
+		})
+	}
+	return exp, nil
+}
@@ -0,0 +1,150 @@
+package consensus
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+func TestExplainBlock(t *testing.T) {
+	n, genesisBlock := testnet()
+	n.HardforkOak.Height = 0
+	n.HardforkTax.Height = 0
+	n.HardforkFoundation.Height = 0
+	n.HardforkV2.AllowHeight = 0
+	n.HardforkV2.RequireHeight = 0
+
+	sk := types.GeneratePrivateKey()
+	policy := types.PolicyPublicKey(sk.PublicKey())
+	addr := policy.Address()
+
+	fc := types.V2FileContract{
+		ProofHeight:      20,
+		ExpirationHeight: 30,
+		RenterOutput:     types.SiacoinOutput{Address: addr, Value: types.Siacoins(10)},
+		HostOutput:       types.SiacoinOutput{Address: addr, Value: types.Siacoins(5)},
+		RenterPublicKey:  sk.PublicKey(),
+		HostPublicKey:    sk.PublicKey(),
+	}
+	genesisBlock.V2 = &types.V2BlockData{
+		Transactions: []types.V2Transaction{{
+			SiacoinOutputs: []types.SiacoinOutput{{Address: addr, Value: types.Siacoins(100)}},
+		}},
+	}
+	db, cs := newConsensusDB(n, genesisBlock)
+	var gift types.SiacoinElement
+	for _, sce := range db.sces {
+		if sce.SiacoinOutput.Address == addr {
+			gift = sce.Copy()
+		}
+	}
+
+	fc.RenterSignature = sk.SignHash(cs.ContractSigHash(fc))
+	fc.HostSignature = sk.SignHash(cs.ContractSigHash(fc))
+	minerFee := types.Siacoins(1)
+	tax := cs.V2FileContractTax(fc)
+	txn := types.V2Transaction{
+		SiacoinInputs: []types.V2SiacoinInput{{
+			Parent:          gift.Copy(),
+			SatisfiedPolicy: types.SatisfiedPolicy{Policy: policy},
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{
+			Address: addr,
+			Value:   types.Siacoins(100).Sub(minerFee).Sub(types.Siacoins(15)).Sub(tax),
+		}},
+		FileContracts: []types.V2FileContract{fc},
+		MinerFee:      minerFee,
+	}
+	txn.SiacoinInputs[0].SatisfiedPolicy.Signatures = []types.Signature{sk.SignHash(cs.InputSigHash(txn))}
+
+	b := types.Block{
+		ParentID:  cs.Index.ID,
+		Timestamp: types.CurrentTimestamp(),
+		V2: &types.V2BlockData{
+			Height:       cs.Index.Height + 1,
+			Transactions: []types.V2Transaction{txn},
+		},
+		MinerPayouts: []types.SiacoinOutput{{
+			Address: types.VoidAddress,
+			Value:   cs.BlockReward().Add(minerFee),
+		}},
+	}
+	b.V2.Commitment = cs.Commitment(cs.TransactionsCommitment(b.Transactions, b.V2Transactions()), b.MinerPayouts[0].Address)
+	findBlockNonce(cs, &b)
+
+	exp, err := ExplainBlock(cs, b, V1BlockSupplement{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	txid := txn.ID()
+	if len(exp.Transactions) != 1 {
+		t.Fatalf("expected 1 transaction, got %v", len(exp.Transactions))
+	} else if et := exp.Transactions[0]; et.ID != txid || !et.V2 || et.Transaction != 0 {
+		t.Fatalf("unexpected transaction source %+v", et.ElementSource)
+	} else if !et.MinerFee.Equals(minerFee) || !et.SiafundTax.Equals(tax) {
+		t.Fatalf("expected fee %v and tax %v, got %v and %v", minerFee, tax, et.MinerFee, et.SiafundTax)
+	} else if !exp.MinerFees.Equals(minerFee) || !exp.SiafundTax.Equals(tax) {
+		t.Fatalf("expected block fees %v and tax %v, got %v and %v", minerFee, tax, exp.MinerFees, exp.SiafundTax)
+	} else if exp.Index != (types.ChainIndex{Height: 1, ID: b.ID()}) {
+		t.Fatalf("unexpected index %v", exp.Index)
+	}
+
+	var sawSpent, sawOutput, sawPayout bool
+	for _, e := range exp.SiacoinElements {
+		id := e.SiacoinElement.ID
+		switch {
+		case id == gift.ID:
+			sawSpent = e.Spent && !e.Created && e.SpentBy != nil && e.SpentBy.ID == txid
+		case id == txn.SiacoinOutputID(txid, 0):
+			sawOutput = e.Created && e.CreatedBy != nil && e.CreatedBy.ID == txid
+		case id == b.ID().MinerOutputID(0):
+			sawPayout = e.Created && e.CreatedBy != nil && e.CreatedBy.Transaction == -1
+		default:
+			if id != b.ID().FoundationOutputID() || exp.FoundationSubsidy == nil {
+				t.Fatalf("unexpected siacoin element %v", id)
+			}
+		}
+	}
+	if !sawSpent || !sawOutput || !sawPayout {
+		t.Fatalf("missing siacoin element changes (spent: %v, output: %v, payout: %v)", sawSpent, sawOutput, sawPayout)
+	}
+	if len(exp.V2FileContractElements) != 1 {
+		t.Fatalf("expected 1 contract, got %v", len(exp.V2FileContractElements))
+	} else if e := exp.V2FileContractElements[0]; !e.Created || e.CreatedBy == nil || e.CreatedBy.ID != txid {
+		t.Fatal("contract not attributed to transaction")
+	}
+
+	changed := make(map[string]bool)
+	for _, f := range exp.State.Fields() {
+		changed[f.Field] = true
+	}
+	if !changed["index"] || !changed["siafundTaxRevenue"] || !changed["numLeaves"] {
+		t.Fatalf("missing state changes: %v", exp.State.Fields())
+	}
+
+	// invalidate the input signature
+	bad := deepCopyBlock(b)
+	bad.V2.Transactions[0].SiacoinInputs[0].SatisfiedPolicy.Signatures[0][0] ^= 1
+	bad.V2.Commitment = cs.Commitment(cs.TransactionsCommitment(bad.Transactions, bad.V2Transactions()), bad.MinerPayouts[0].Address)
+	findBlockNonce(cs, &bad)
+	_, err = ExplainBlock(cs, bad, V1BlockSupplement{}, time.Time{})
+	var ve *ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("expected ValidationError, got %v", err)
+	} else if ve.Transaction != 0 || !ve.V2 || ve.Input != 0 || ve.Rule != RuleSiacoins {
+		t.Fatalf("unexpected error fields %+v", ve)
+	} else if !strings.HasPrefix(err.Error(), "v2 transaction 0 is invalid: siacoin input 0 failed to satisfy spend policy") {
+		t.Fatalf("unexpected error message %q", err)
+	}
+
+	// break the commitment
+	bad = deepCopyBlock(b)
+	bad.V2.Commitment = types.Hash256{}
+	findBlockNonce(cs, &bad)
+	if _, err := ExplainBlock(cs, bad, V1BlockSupplement{}, time.Time{}); !errors.As(err, &ve) || ve.Rule != RuleCommitment || ve.Transaction != -1 {
+		t.Fatalf("expected commitment error, got %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"math/bits"
 	"sort"
 
@@ -219,6 +220,146 @@ func (acc *ElementAccumulator) containsResolvedV2FileContractElement(fce types.V
 	return acc.containsLeaf(v2FileContractLeaf(&fce, nil, true))
 }
 
+// Errors returned by the ElementAccumulator's Verify methods.
+var (
+	ErrLeafIndexOutOfRange = errors.New("leaf index out of range")
+	ErrInvalidProofLength  = errors.New("invalid proof length")
+	ErrProofMismatch       = errors.New("proof does not match accumulator")
+	ErrElementSpent        = errors.New("element has been spent")
+	ErrElementUnspent      = errors.New("element has not been spent")
+	ErrContractResolved    = errors.New("file contract has been resolved")
+	ErrContractUnresolved  = errors.New("file contract has not been resolved")
+)
+
+// verifyLeaf checks that l is present in the accumulator.
+func (acc *ElementAccumulator) verifyLeaf(l elementLeaf) error {
+	if l.LeafIndex >= acc.NumLeaves {
+		return fmt.Errorf("%w: leaf index is %v, but accumulator has %v leaves", ErrLeafIndexOutOfRange, l.LeafIndex, acc.NumLeaves)
+	}
+	// the height of the tree containing the leaf is determined by the highest
+	// bit at which the leaf index and the number of leaves differ
+	height := mergeHeight(l.LeafIndex, acc.NumLeaves) - 1
+	if len(l.MerkleProof) != height {
+		return fmt.Errorf("%w: leaf %v is in a tree of height %v, but proof has length %v (proof may be outdated)", ErrInvalidProofLength, l.LeafIndex, height, len(l.MerkleProof))
+	} else if l.proofRoot() != acc.Trees[height] {
+		return ErrProofMismatch
+	}
+	return nil
+}
+
+// verifyElement checks that the leaf returned by leaf(spent) is present in the
+// accumulator. If it is not, but leaf(!spent) is, the returned error reports
+// the element's actual status: errSpent if it has been spent, or errUnspent if
+// it has not.
+func (acc *ElementAccumulator) verifyElement(desc string, id types.ElementID, leaf func(spent bool) elementLeaf, spent bool, errSpent, errUnspent error) error {
+	err := acc.verifyLeaf(leaf(spent))
+	if errors.Is(err, ErrProofMismatch) && acc.containsLeaf(leaf(!spent)) {
+		if spent {
+			err = errUnspent
+		} else {
+			err = errSpent
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%v %v: %w", desc, id, err)
+	}
+	return nil
+}
+
+// VerifySiacoinElement checks that sce, along with its Merkle proof, is present
+// in the accumulator, and that it has (or has not) been spent.
+func (acc *ElementAccumulator) VerifySiacoinElement(sce types.SiacoinElement, spent bool) error {
+	return acc.verifyElement("siacoin element", types.ElementID(sce.ID), func(spent bool) elementLeaf {
+		return siacoinLeaf(&sce, spent)
+	}, spent, ErrElementSpent, ErrElementUnspent)
+}
+
+// VerifySiafundElement checks that sfe, along with its Merkle proof, is present
+// in the accumulator, and that it has (or has not) been spent.
+func (acc *ElementAccumulator) VerifySiafundElement(sfe types.SiafundElement, spent bool) error {
+	return acc.verifyElement("siafund element", types.ElementID(sfe.ID), func(spent bool) elementLeaf {
+		return siafundLeaf(&sfe, spent)
+	}, spent, ErrElementSpent, ErrElementUnspent)
+}
+
+// VerifyFileContractElement checks that fce, along with its Merkle proof, is
+// present in the accumulator, and that it has (or has not) been resolved.
+func (acc *ElementAccumulator) VerifyFileContractElement(fce types.FileContractElement, resolved bool) error {
+	return acc.verifyElement("file contract element", types.ElementID(fce.ID), func(resolved bool) elementLeaf {
+		return fileContractLeaf(&fce, nil, resolved)
+	}, resolved, ErrContractResolved, ErrContractUnresolved)
+}
+
+// VerifyV2FileContractElement checks that fce, along with its Merkle proof, is
+// present in the accumulator, and that it has (or has not) been resolved.
+func (acc *ElementAccumulator) VerifyV2FileContractElement(fce types.V2FileContractElement, resolved bool) error {
+	return acc.verifyElement("v2 file contract element", types.ElementID(fce.ID), func(resolved bool) elementLeaf {
+		return v2FileContractLeaf(&fce, nil, resolved)
+	}, resolved, ErrContractResolved, ErrContractUnresolved)
+}
+
+// VerifyAttestationElement checks that ae, along with its Merkle proof, is
+// present in the accumulator.
+func (acc *ElementAccumulator) VerifyAttestationElement(ae types.AttestationElement) error {
+	if err := acc.verifyLeaf(attestationLeaf(&ae)); err != nil {
+		return fmt.Errorf("attestation element %v: %w", ae.ID, err)
+	}
+	return nil
+}
+
+// VerifyChainIndexElement checks that cie, along with its Merkle proof, is
+// present in the accumulator.
+func (acc *ElementAccumulator) VerifyChainIndexElement(cie types.ChainIndexElement) error {
+	if err := acc.verifyLeaf(chainIndexLeaf(&cie)); err != nil {
+		return fmt.Errorf("chain index element %v: %w", cie.ID, err)
+	}
+	return nil
+}
+
+// VerifyBlockInclusion checks that the block identified by index is part of the
+// chain summarized by the accumulator, using cie, the ChainIndexElement created
+// by that block, as proof.
+func (acc *ElementAccumulator) VerifyBlockInclusion(index types.ChainIndex, cie types.ChainIndexElement) error {
+	if cie.ChainIndex != index {
+		return fmt.Errorf("chain index element is for block %v, not %v", cie.ChainIndex, index)
+	} else if cie.ID != index.ID {
+		return fmt.Errorf("chain index element has ID %v, but block has ID %v", cie.ID, index.ID)
+	}
+	return acc.VerifyChainIndexElement(cie)
+}
+
+// VerifyElementMultiproof checks that every element in mp, along with its
+// Merkle proof, is present in the accumulator. Siacoin and siafund elements
+// must be unspent, and file contract elements must be unresolved.
+func (acc *ElementAccumulator) VerifyElementMultiproof(mp types.ElementMultiproof) error {
+	for _, sce := range mp.SiacoinElements {
+		if err := acc.VerifySiacoinElement(sce.Share(), false); err != nil {
+			return err
+		}
+	}
+	for _, sfe := range mp.SiafundElements {
+		if err := acc.VerifySiafundElement(sfe.Share(), false); err != nil {
+			return err
+		}
+	}
+	for _, fce := range mp.FileContractElements {
+		if err := acc.VerifyFileContractElement(fce.Share(), false); err != nil {
+			return err
+		}
+	}
+	for _, fce := range mp.V2FileContractElements {
+		if err := acc.VerifyV2FileContractElement(fce.Share(), false); err != nil {
+			return err
+		}
+	}
+	for _, cie := range mp.ChainIndexElements {
+		if err := acc.VerifyChainIndexElement(cie.Share()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // addLeaves adds the supplied leaves to the accumulator, filling in their
 // Merkle proofs and returning the new node hashes that extend each existing
 // tree.
@@ -420,6 +561,67 @@ func updateProof(e *types.StateElement, updated *[64][]elementLeaf) {
 	}
 }
 
+// A proofUpdater updates Merkle proofs in the same manner as updateProof, but
+// precomputes the paths of the updated leaves so that each proof can be updated
+// without rehashing. Paths that share nodes share their hashes.
+type proofUpdater struct {
+	updated *[64][]elementLeaf
+	// paths[height][j][row] is the node at row above updated[height][j]
+	paths [64][][]types.Hash256
+}
+
+func newProofUpdater(updated *[64][]elementLeaf) *proofUpdater {
+	pu := &proofUpdater{updated: updated}
+	for height, leaves := range updated {
+		if height == 0 || len(leaves) == 0 {
+			continue
+		}
+		// leaves are sorted by index, so adjacent leaves share the greatest
+		// number of nodes
+		paths := make([][]types.Hash256, len(leaves))
+		for j, l := range leaves {
+			paths[j] = make([]types.Hash256, height)
+			n := height
+			if j > 0 {
+				n = min(height, mergeHeight(leaves[j-1].LeafIndex, l.LeafIndex))
+				copy(paths[j][n:], paths[j-1][n:])
+			}
+			h := l.hash()
+			for row := range n {
+				paths[j][row] = h
+				if l.LeafIndex&(1<<row) == 0 {
+					h = blake2b.SumPair(h, l.MerkleProof[row])
+				} else {
+					h = blake2b.SumPair(l.MerkleProof[row], h)
+				}
+			}
+		}
+		pu.paths[height] = paths
+	}
+	return pu
+}
+
+// updateProof is equivalent to updateProof(e, pu.updated).
+func (pu *proofUpdater) updateProof(e *types.StateElement) {
+	height := len(e.MerkleProof)
+	leaves := pu.updated[height]
+	if len(leaves) == 0 {
+		return
+	}
+	// the "closest" updated leaf is adjacent to e in sorted order
+	best := sort.Search(len(leaves), func(i int) bool { return leaves[i].LeafIndex >= e.LeafIndex })
+	if best == len(leaves) || (best > 0 && mergeHeight(e.LeafIndex, leaves[best-1].LeafIndex) < mergeHeight(e.LeafIndex, leaves[best].LeafIndex)) {
+		best--
+	}
+	if l := leaves[best]; l.LeafIndex == e.LeafIndex {
+		copy(e.MerkleProof, l.MerkleProof)
+	} else {
+		mh := mergeHeight(e.LeafIndex, l.LeafIndex)
+		copy(e.MerkleProof[mh:], l.MerkleProof[mh:])
+		e.MerkleProof[mh-1] = pu.paths[height][best][mh-1]
+	}
+}
+
 type elementApplyUpdate struct {
 	updated      [64][]elementLeaf
 	treeGrowth   [64][]types.Hash256
@@ -428,13 +630,22 @@ type elementApplyUpdate struct {
 }
 
 func (eau *elementApplyUpdate) updateElementProof(e *types.StateElement) {
+	eau.updateElementProofWith(e, nil)
+}
+
+// updateElementProofWith updates e using pu, if non-nil.
+func (eau *elementApplyUpdate) updateElementProofWith(e *types.StateElement, pu *proofUpdater) {
 	_ = e.Move() // panics if element is shared
 	if e.LeafIndex == types.UnassignedLeafIndex {
 		panic("cannot update an ephemeral element")
 	} else if e.LeafIndex >= eau.oldNumLeaves {
 		return // newly-added element
 	}
-	updateProof(e, &eau.updated)
+	if pu != nil {
+		pu.updateProof(e)
+	} else {
+		updateProof(e, &eau.updated)
+	}
 	if mh := mergeHeight(eau.numLeaves, e.LeafIndex); mh != len(e.MerkleProof) {
 		e.MerkleProof = append(e.MerkleProof, eau.treeGrowth[len(e.MerkleProof)]...)
 	}
@@ -446,6 +657,11 @@ type elementRevertUpdate struct {
 }
 
 func (eru *elementRevertUpdate) updateElementProof(e *types.StateElement) {
+	eru.updateElementProofWith(e, nil)
+}
+
+// updateElementProofWith updates e using pu, if non-nil.
+func (eru *elementRevertUpdate) updateElementProofWith(e *types.StateElement, pu *proofUpdater) {
 	_ = e.Move() // panics if element is shared
 	if e.LeafIndex == types.UnassignedLeafIndex {
 		panic("cannot update an ephemeral element")
@@ -455,5 +671,9 @@ func (eru *elementRevertUpdate) updateElementProof(e *types.StateElement) {
 	if mh := mergeHeight(eru.numLeaves, e.LeafIndex); mh <= len(e.MerkleProof) {
 		e.MerkleProof = e.MerkleProof[:mh-1]
 	}
-	updateProof(e, &eru.updated)
+	if pu != nil {
+		pu.updateProof(e)
+	} else {
+		updateProof(e, &eru.updated)
+	}
 }
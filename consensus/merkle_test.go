@@ -2,9 +2,16 @@ package consensus
 
 import (
 	"bytes"
+	"errors"
+	"iter"
+	"runtime"
+	"slices"
+	"sync"
 	"testing"
+	"time"
 
 	"go.sia.tech/core/types"
+	"lukechampine.com/frand"
 )
 
 func TestElementAccumulatorEncoding(t *testing.T) {
@@ -139,3 +146,270 @@ func TestUpdateElementProof(t *testing.T) {
 		})
 	}
 }
+
+func TestVerifyElements(t *testing.T) {
+	n, genesisBlock := testnet()
+	n.HardforkOak.Height = 0
+	n.HardforkTax.Height = 0
+	n.HardforkFoundation.Height = 0
+	n.HardforkV2.AllowHeight = 0
+	n.HardforkV2.RequireHeight = 0
+
+	addr := types.AnyoneCanSpend().Address()
+	genesisBlock.V2 = &types.V2BlockData{
+		Transactions: []types.V2Transaction{{
+			SiacoinOutputs: []types.SiacoinOutput{{Address: addr, Value: types.Siacoins(100)}},
+			SiafundOutputs: []types.SiafundOutput{{Address: addr, Value: 100}},
+			FileContracts: []types.V2FileContract{{
+				ProofHeight:      20,
+				ExpirationHeight: 30,
+				RenterOutput:     types.SiacoinOutput{Address: addr, Value: types.Siacoins(10)},
+			}},
+		}},
+	}
+	cs, au := ApplyBlock(n.GenesisState(), genesisBlock, V1BlockSupplement{}, time.Time{})
+	sce := au.SiacoinElementDiffs()[0].SiacoinElement.Copy()
+	sfe := au.SiafundElementDiffs()[0].SiafundElement.Copy()
+	fce := au.V2FileContractElementDiffs()[0].V2FileContractElement.Copy()
+	cie := au.ChainIndexElement()
+	genesisIndex := cs.Index
+
+	// mine a few empty blocks
+	for range 3 {
+		b := types.Block{
+			ParentID:     cs.Index.ID,
+			Timestamp:    types.CurrentTimestamp(),
+			V2:           &types.V2BlockData{Height: cs.Index.Height + 1},
+			MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+		}
+		b.V2.Commitment = cs.Commitment(cs.TransactionsCommitment(b.Transactions, b.V2Transactions()), b.MinerPayouts[0].Address)
+		findBlockNonce(cs, &b)
+		if err := ValidateBlock(cs, b, V1BlockSupplement{}); err != nil {
+			t.Fatal(err)
+		}
+		cs, au = ApplyBlock(cs, b, V1BlockSupplement{}, time.Time{})
+		au.UpdateElementProof(&sce.StateElement)
+		au.UpdateElementProof(&sfe.StateElement)
+		au.UpdateElementProof(&fce.StateElement)
+		au.UpdateElementProof(&cie.StateElement)
+	}
+	acc := &cs.Elements
+
+	if err := acc.VerifySiacoinElement(sce.Share(), false); err != nil {
+		t.Fatal(err)
+	} else if err := acc.VerifySiacoinElement(sce.Share(), true); !errors.Is(err, ErrElementUnspent) {
+		t.Fatalf("expected %v, got %v", ErrElementUnspent, err)
+	} else if err := acc.VerifySiafundElement(sfe.Share(), false); err != nil {
+		t.Fatal(err)
+	} else if err := acc.VerifyV2FileContractElement(fce.Share(), false); err != nil {
+		t.Fatal(err)
+	} else if err := acc.VerifyV2FileContractElement(fce.Share(), true); !errors.Is(err, ErrContractUnresolved) {
+		t.Fatalf("expected %v, got %v", ErrContractUnresolved, err)
+	} else if err := acc.VerifyChainIndexElement(cie.Share()); err != nil {
+		t.Fatal(err)
+	} else if err := acc.VerifyBlockInclusion(genesisIndex, cie.Share()); err != nil {
+		t.Fatal(err)
+	} else if err := acc.VerifyBlockInclusion(cs.Index, cie.Share()); err == nil {
+		t.Fatal("expected block inclusion proof for wrong index to be rejected")
+	}
+
+	tests := []struct {
+		desc    string
+		corrupt func(*types.SiacoinElement)
+		err     error
+	}{
+		{"modified value", func(sce *types.SiacoinElement) { sce.SiacoinOutput.Value = types.Siacoins(1000) }, ErrProofMismatch},
+		{"modified proof", func(sce *types.SiacoinElement) { sce.StateElement.MerkleProof[0][0] ^= 1 }, ErrProofMismatch},
+		{"truncated proof", func(sce *types.SiacoinElement) {
+			sce.StateElement.MerkleProof = sce.StateElement.MerkleProof[:len(sce.StateElement.MerkleProof)-1]
+		}, ErrInvalidProofLength},
+		{"leaf index out of range", func(sce *types.SiacoinElement) { sce.StateElement.LeafIndex = acc.NumLeaves }, ErrLeafIndexOutOfRange},
+		{"unassigned leaf index", func(sce *types.SiacoinElement) { sce.StateElement.LeafIndex = types.UnassignedLeafIndex }, ErrLeafIndexOutOfRange},
+	}
+	for _, test := range tests {
+		bad := sce.Copy()
+		test.corrupt(&bad)
+		if err := acc.VerifySiacoinElement(bad, false); !errors.Is(err, test.err) {
+			t.Fatalf("%v: expected %v, got %v", test.desc, test.err, err)
+		}
+	}
+}
+
+// anyoneCanSpendChain returns the genesis state of a v2 chain whose genesis
+// block creates n anyone-can-spend outputs, along with those outputs.
+func anyoneCanSpendChain(n int) (State, []types.SiacoinElement) {
+	network, genesisBlock := testnet()
+	network.HardforkOak.Height = 0
+	network.HardforkTax.Height = 0
+	network.HardforkFoundation.Height = 0
+	network.HardforkV2.AllowHeight = 0
+	network.HardforkV2.RequireHeight = 0
+	txn := types.V2Transaction{SiacoinOutputs: make([]types.SiacoinOutput, n)}
+	for i := range txn.SiacoinOutputs {
+		txn.SiacoinOutputs[i] = types.SiacoinOutput{Address: types.AnyoneCanSpend().Address(), Value: types.Siacoins(1)}
+	}
+	genesisBlock.V2 = &types.V2BlockData{Transactions: []types.V2Transaction{txn}}
+	cs, au := ApplyBlock(network.GenesisState(), genesisBlock, V1BlockSupplement{}, time.Time{})
+	var sces []types.SiacoinElement
+	for _, sced := range au.SiacoinElementDiffs() {
+		// skip the immature genesis miner payout
+		if sced.SiacoinElement.SiacoinOutput.Address == types.AnyoneCanSpend().Address() && sced.SiacoinElement.MaturityHeight == 0 {
+			sces = append(sces, sced.SiacoinElement.Copy())
+		}
+	}
+	return cs, sces
+}
+
+// spendingBlock returns a block that spends the specified elements.
+func spendingBlock(cs State, sces []types.SiacoinElement) types.Block {
+	var txn types.V2Transaction
+	for _, sce := range sces {
+		txn.SiacoinInputs = append(txn.SiacoinInputs, types.V2SiacoinInput{
+			Parent:          sce.Copy(),
+			SatisfiedPolicy: types.SatisfiedPolicy{Policy: types.AnyoneCanSpend()},
+		})
+		txn.SiacoinOutputs = append(txn.SiacoinOutputs, sce.SiacoinOutput)
+	}
+	b := types.Block{
+		ParentID:     cs.Index.ID,
+		Timestamp:    types.CurrentTimestamp(),
+		V2:           &types.V2BlockData{Height: cs.Index.Height + 1, Transactions: []types.V2Transaction{txn}},
+		MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+	}
+	b.V2.Commitment = cs.Commitment(cs.TransactionsCommitment(b.Transactions, b.V2Transactions()), b.MinerPayouts[0].Address)
+	findBlockNonce(cs, &b)
+	return b
+}
+
+func copyElements(sces []types.SiacoinElement) []types.SiacoinElement {
+	c := make([]types.SiacoinElement, len(sces))
+	for i := range sces {
+		c[i] = sces[i].Copy()
+	}
+	return c
+}
+
+func stateElements(sces []types.SiacoinElement) iter.Seq[*types.StateElement] {
+	return func(yield func(*types.StateElement) bool) {
+		for i := range sces {
+			if !yield(&sces[i].StateElement) {
+				return
+			}
+		}
+	}
+}
+
+func TestProofUpdater(t *testing.T) {
+	cs, sces := anyoneCanSpendChain(1000)
+	for range 10 {
+		var spent []types.SiacoinElement
+		for _, i := range frand.Perm(len(sces))[:frand.Intn(50)+1] {
+			spent = append(spent, sces[i].Copy())
+		}
+		b := spendingBlock(cs, spent)
+		if err := ValidateBlock(cs, b, V1BlockSupplement{}); err != nil {
+			t.Fatal(err)
+		}
+		parent := cs
+		var au ApplyUpdate
+		cs, au = ApplyBlock(cs, b, V1BlockSupplement{}, time.Time{})
+
+		// update proofs sequentially and in parallel
+		want := copyElements(sces)
+		for i := range want {
+			au.UpdateElementProof(&want[i].StateElement)
+		}
+		got := copyElements(sces)
+		pu := au.ProofUpdater()
+		var wg sync.WaitGroup
+		for chunk := range slices.Chunk(got, 100) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				pu.UpdateElementProofs(stateElements(chunk))
+			}()
+		}
+		wg.Wait()
+		for i := range want {
+			if !slices.Equal(want[i].StateElement.MerkleProof, got[i].StateElement.MerkleProof) {
+				t.Fatalf("batch proof for element %v does not match", i)
+			}
+		}
+
+		// revert the block and check that the proofs match again
+		ru := RevertBlock(parent, b, V1BlockSupplement{})
+		reverted := copyElements(want)
+		for i := range reverted {
+			ru.UpdateElementProof(&reverted[i].StateElement)
+		}
+		batch := copyElements(want)
+		ru.UpdateElementProofs(stateElements(batch))
+		for i := range reverted {
+			if !slices.Equal(reverted[i].StateElement.MerkleProof, batch[i].StateElement.MerkleProof) {
+				t.Fatalf("batch revert proof for element %v does not match", i)
+			} else if !slices.Equal(reverted[i].StateElement.MerkleProof, sces[i].StateElement.MerkleProof) {
+				t.Fatalf("reverted proof for element %v does not match original", i)
+			}
+		}
+
+		// track the new set of unspent elements
+		sces = want
+		for _, sced := range au.SiacoinElementDiffs() {
+			if sced.Spent {
+				sces = slices.DeleteFunc(sces, func(sce types.SiacoinElement) bool { return sce.ID == sced.SiacoinElement.ID })
+			} else if sced.SiacoinElement.SiacoinOutput.Address == types.AnyoneCanSpend().Address() {
+				sces = append(sces, sced.SiacoinElement.Copy())
+			}
+		}
+		for _, sce := range sces {
+			if err := cs.Elements.VerifySiacoinElement(sce.Share(), false); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkUpdateElementProofs(b *testing.B) {
+	cs, sces := anyoneCanSpendChain(100000)
+	spent := make([]types.SiacoinElement, 100)
+	for i, j := range frand.Perm(len(sces))[:len(spent)] {
+		spent[i] = sces[j].Copy()
+	}
+	_, au := ApplyBlock(cs, spendingBlock(cs, spent), V1BlockSupplement{}, time.Time{})
+
+	run := func(b *testing.B, update func([]types.SiacoinElement)) {
+		b.ReportAllocs()
+		for range b.N {
+			b.StopTimer()
+			es := copyElements(sces)
+			b.StartTimer()
+			update(es)
+		}
+	}
+	b.Run("single", func(b *testing.B) {
+		run(b, func(es []types.SiacoinElement) {
+			for i := range es {
+				au.UpdateElementProof(&es[i].StateElement)
+			}
+		})
+	})
+	b.Run("batch", func(b *testing.B) {
+		run(b, func(es []types.SiacoinElement) {
+			au.UpdateElementProofs(stateElements(es))
+		})
+	})
+	b.Run("parallel", func(b *testing.B) {
+		run(b, func(es []types.SiacoinElement) {
+			pu := au.ProofUpdater()
+			var wg sync.WaitGroup
+			for chunk := range slices.Chunk(es, len(es)/runtime.NumCPU()+1) {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					pu.UpdateElementProofs(stateElements(chunk))
+				}()
+			}
+			wg.Wait()
+		})
+	})
+}
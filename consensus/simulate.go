@@ -0,0 +1,91 @@
+package consensus
+
+import (
+	"math"
+	"math/big"
+	"math/rand/v2"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+// A DifficultySample records the outcome of the difficulty adjustment after a
+// simulated block.
+type DifficultySample struct {
+	Height    uint64
+	Timestamp time.Time
+	// Interval is the time elapsed since the parent block. It is zero for the
+	// genesis block.
+	Interval time.Duration
+	// ChildTarget and Difficulty are the target and difficulty of the block's
+	// child, i.e. the result of the adjustment.
+	ChildTarget types.BlockID
+	Difficulty  Work
+}
+
+// A difficultySim applies blocks to a State using the same difficulty
+// adjustment code as ApplyBlock.
+type difficultySim struct {
+	s          State
+	timestamps []time.Time
+}
+
+func (sim *difficultySim) apply(ts time.Time) DifficultySample {
+	childHeight := uint64(len(sim.timestamps))
+	sim.timestamps = append(sim.timestamps, ts)
+	targetTimestamp := sim.timestamps[childHeight-min(childHeight, sim.s.AncestorDepth())]
+	var interval time.Duration
+	if childHeight > 0 {
+		interval = ts.Sub(sim.s.PrevTimestamps[0])
+	}
+	b := types.Block{ParentID: sim.s.Index.ID, Timestamp: ts}
+	sim.s = ApplyOrphan(sim.s, b, targetTimestamp)
+	return DifficultySample{
+		Height:      sim.s.Index.Height,
+		Timestamp:   ts,
+		Interval:    interval,
+		ChildTarget: sim.s.ChildTarget,
+		Difficulty:  sim.s.Difficulty,
+	}
+}
+
+// SimulateDifficulty applies a chain of blocks with the given timestamps,
+// beginning with the genesis block, to the genesis state of n and returns the
+// resulting difficulty adjustments. Only the proof-of-work fields of the State
+// are updated; the timestamps are not validated.
+func SimulateDifficulty(n *Network, timestamps []time.Time) []DifficultySample {
+	sim := &difficultySim{s: n.GenesisState()}
+	samples := make([]DifficultySample, len(timestamps))
+	for i, ts := range timestamps {
+		samples[i] = sim.apply(ts)
+	}
+	return samples
+}
+
+// SimulateHashrate simulates mining numBlocks blocks on n, beginning with a
+// genesis block at n.HardforkOak.GenesisTimestamp. hashrate returns the total
+// hashrate, in hashes per second, of the miners working on the block at the
+// given height. If rng is nil, each block is found after exactly the expected
+// time, i.e. its difficulty divided by the hashrate; otherwise, block times are
+// drawn from an exponential distribution with that mean.
+func SimulateHashrate(n *Network, numBlocks int, hashrate func(height uint64) float64, rng *rand.Rand) []DifficultySample {
+	if numBlocks <= 0 {
+		return nil
+	}
+	sim := &difficultySim{s: n.GenesisState()}
+	samples := make([]DifficultySample, numBlocks)
+	ts := n.HardforkOak.GenesisTimestamp
+	samples[0] = sim.apply(ts)
+	for i := 1; i < numBlocks; i++ {
+		difficulty, _ := new(big.Float).SetInt(new(big.Int).SetBytes(sim.s.Difficulty.n[:])).Float64()
+		secs := difficulty / hashrate(uint64(i))
+		if rng != nil {
+			secs *= rng.ExpFloat64()
+		}
+		// block timestamps have a resolution of one second
+		secs = math.Min(math.Round(secs), float64(math.MaxInt64/time.Second))
+		ts = ts.Add(time.Duration(secs) * time.Second)
+		samples[i] = sim.apply(ts)
+	}
+	return samples
+}
@@ -0,0 +1,93 @@
+package consensus
+
+import (
+	"math/big"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+func TestSimulateDifficulty(t *testing.T) {
+	n, genesisBlock := testnet()
+	n.BlockInterval = 10 * time.Minute
+	n.HardforkV2.AllowHeight = 20
+
+	// block times vary between 1 and 20 minutes
+	timestamps := []time.Time{genesisBlock.Timestamp}
+	for i := 1; i < 40; i++ {
+		timestamps = append(timestamps, timestamps[i-1].Add(time.Duration(1+(i*7)%20)*time.Minute))
+	}
+	samples := SimulateDifficulty(n, timestamps)
+	if len(samples) != len(timestamps) {
+		t.Fatalf("expected %v samples, got %v", len(timestamps), len(samples))
+	}
+
+	// the simulation should match ApplyBlock exactly
+	genesisBlock.Timestamp = timestamps[0]
+	_, cs := newConsensusDB(n, genesisBlock)
+	for i := 1; i < len(timestamps); i++ {
+		b := types.Block{
+			ParentID:     cs.Index.ID,
+			Timestamp:    timestamps[i],
+			MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+		}
+		if cs.childHeight() >= n.HardforkV2.AllowHeight {
+			b.V2 = &types.V2BlockData{Height: cs.childHeight()}
+			b.V2.Commitment = cs.Commitment(cs.TransactionsCommitment(nil, nil), types.VoidAddress)
+		}
+		findBlockNonce(cs, &b)
+		childHeight := uint64(i)
+		cs, _ = ApplyBlock(cs, b, V1BlockSupplement{}, timestamps[childHeight-min(childHeight, cs.AncestorDepth())])
+		if s := samples[i]; s.Height != cs.Index.Height || s.ChildTarget != cs.ChildTarget || s.Difficulty != cs.Difficulty {
+			t.Fatalf("simulation diverged from ApplyBlock at height %v", cs.Index.Height)
+		} else if s.Interval != timestamps[i].Sub(timestamps[i-1]) {
+			t.Fatalf("wrong interval at height %v: %v", s.Height, s.Interval)
+		}
+	}
+}
+
+func TestSimulateHashrate(t *testing.T) {
+	n, _ := testnet()
+	n.BlockInterval = 10 * time.Minute
+	n.HardforkV2.AllowHeight = 1
+
+	// start with a difficulty that matches the hashrate
+	const hashrate = 1e9
+	difficulty := big.NewInt(hashrate * 600)
+	n.InitialTarget = intToTarget(new(big.Int).Div(maxTarget, difficulty))
+	avgInterval := func(samples []DifficultySample) time.Duration {
+		return samples[len(samples)-1].Timestamp.Sub(samples[0].Timestamp) / time.Duration(len(samples)-1)
+	}
+
+	constant := func(uint64) float64 { return hashrate }
+	samples := SimulateHashrate(n, 3000, constant, nil)
+	if len(samples) != 3000 {
+		t.Fatalf("expected 3000 samples, got %v", len(samples))
+	} else if avg := avgInterval(samples); avg < 9*time.Minute || avg > 11*time.Minute {
+		t.Fatalf("expected block interval to remain stable, got %v", avg)
+	}
+
+	// doubling the hashrate should increase the difficulty
+	doubled := SimulateHashrate(n, 3000, func(height uint64) float64 {
+		if height >= 2000 {
+			return 2 * hashrate
+		}
+		return hashrate
+	}, nil)
+	if doubled[2999].Difficulty.Cmp(samples[2999].Difficulty) <= 0 {
+		t.Fatal("expected difficulty to increase with hashrate")
+	} else if avg := avgInterval(doubled[2000:2100]); avg >= 9*time.Minute {
+		t.Fatalf("expected faster blocks after hashrate increase, got %v", avg)
+	}
+
+	// random simulations are deterministic for a given seed
+	r1 := SimulateHashrate(n, 500, constant, rand.New(rand.NewPCG(1, 2)))
+	r2 := SimulateHashrate(n, 500, constant, rand.New(rand.NewPCG(1, 2)))
+	if r1[499] != r2[499] {
+		t.Fatal("expected identical simulations")
+	} else if r1[499] == samples[499] {
+		t.Fatal("expected random simulation to differ from expected-time simulation")
+	}
+}
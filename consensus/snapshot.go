@@ -0,0 +1,179 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"go.sia.tech/core/types"
+)
+
+// snapshotVersion is the version of the snapshot file format.
+const snapshotVersion = 1
+
+var snapshotSpecifier = types.NewSpecifier("snapshot")
+
+// A Snapshot pairs a State with a set of elements and their Merkle proofs,
+// allowing a node to begin tracking those elements from the State's height
+// rather than from genesis. After importing a snapshot, the elements' proofs
+// must be updated with each subsequent ApplyUpdate, as usual.
+//
+// The integrity of a snapshot's elements can be verified against its State,
+// but the State itself cannot be verified without the blocks that preceded it.
+// A snapshot should therefore only be used if its Hash matches a trusted
+// checkpoint, or if it carries a valid signature from a trusted party.
+type Snapshot struct {
+	State                  State                         `json:"state"`
+	SiacoinElements        []types.SiacoinElement        `json:"siacoinElements"`
+	SiafundElements        []types.SiafundElement        `json:"siafundElements"`
+	V2FileContractElements []types.V2FileContractElement `json:"v2FileContractElements"`
+
+	// Signer and Signature are optional. They are not covered by Hash.
+	Signer    types.PublicKey `json:"signer"`
+	Signature types.Signature `json:"signature"`
+}
+
+// Hash returns the hash of the snapshot's State and elements.
+func (snap Snapshot) Hash() types.Hash256 {
+	h := hasherPool.Get().(*types.Hasher)
+	defer hasherPool.Put(h)
+	h.Reset()
+	h.WriteDistinguisher("snapshot")
+	snap.State.EncodeTo(h.E)
+	types.EncodeSlice(h.E, snap.SiacoinElements)
+	types.EncodeSlice(h.E, snap.SiafundElements)
+	types.EncodeSlice(h.E, snap.V2FileContractElements)
+	return h.Sum()
+}
+
+// Sign signs the snapshot with sk.
+func (snap *Snapshot) Sign(sk types.PrivateKey) {
+	snap.Signer = sk.PublicKey()
+	snap.Signature = sk.SignHash(snap.Hash())
+}
+
+// VerifySignature checks that the snapshot was signed by pk.
+func (snap Snapshot) VerifySignature(pk types.PublicKey) error {
+	if snap.Signer != pk {
+		return fmt.Errorf("snapshot was signed by %v, not %v", snap.Signer, pk)
+	} else if !pk.VerifyHash(snap.Hash(), snap.Signature) {
+		return errors.New("snapshot has invalid signature")
+	}
+	return nil
+}
+
+// Verify checks that every element in the snapshot is present and unspent in
+// the snapshot's State, and that no element appears more than once.
+func (snap Snapshot) Verify() error {
+	acc := &snap.State.Elements
+	seen := make(map[types.ElementID]bool)
+	for i, sce := range snap.SiacoinElements {
+		if seen[sce.ID] {
+			return fmt.Errorf("siacoin element %v (%v) appears more than once", i, sce.ID)
+		} else if err := acc.VerifySiacoinElement(sce.Share(), false); err != nil {
+			return fmt.Errorf("siacoin element %v is invalid: %w", i, err)
+		}
+		seen[sce.ID] = true
+	}
+	for i, sfe := range snap.SiafundElements {
+		if seen[sfe.ID] {
+			return fmt.Errorf("siafund element %v (%v) appears more than once", i, sfe.ID)
+		} else if err := acc.VerifySiafundElement(sfe.Share(), false); err != nil {
+			return fmt.Errorf("siafund element %v is invalid: %w", i, err)
+		}
+		seen[sfe.ID] = true
+	}
+	for i, fce := range snap.V2FileContractElements {
+		if seen[fce.ID] {
+			return fmt.Errorf("v2 file contract element %v (%v) appears more than once", i, fce.ID)
+		} else if err := acc.VerifyV2FileContractElement(fce.Share(), false); err != nil {
+			return fmt.Errorf("v2 file contract element %v is invalid: %w", i, err)
+		}
+		seen[fce.ID] = true
+	}
+	return nil
+}
+
+// NewSnapshot returns a verified Snapshot of the given elements as of s. The
+// elements are copied.
+func NewSnapshot(s State, sces []types.SiacoinElement, sfes []types.SiafundElement, fces []types.V2FileContractElement) (Snapshot, error) {
+	snap := Snapshot{
+		State:                  s,
+		SiacoinElements:        make([]types.SiacoinElement, len(sces)),
+		SiafundElements:        make([]types.SiafundElement, len(sfes)),
+		V2FileContractElements: make([]types.V2FileContractElement, len(fces)),
+	}
+	for i := range sces {
+		snap.SiacoinElements[i] = sces[i].Copy()
+	}
+	for i := range sfes {
+		snap.SiafundElements[i] = sfes[i].Copy()
+	}
+	for i := range fces {
+		snap.V2FileContractElements[i] = fces[i].Copy()
+	}
+	if err := snap.Verify(); err != nil {
+		return Snapshot{}, err
+	}
+	return snap, nil
+}
+
+// EncodeTo implements types.EncoderTo.
+func (snap Snapshot) EncodeTo(e *types.Encoder) {
+	snap.State.EncodeTo(e)
+	types.EncodeSlice(e, snap.SiacoinElements)
+	types.EncodeSlice(e, snap.SiafundElements)
+	types.EncodeSlice(e, snap.V2FileContractElements)
+	snap.Signer.EncodeTo(e)
+	snap.Signature.EncodeTo(e)
+}
+
+// DecodeFrom implements types.DecoderFrom. The State's Network is not set.
+func (snap *Snapshot) DecodeFrom(d *types.Decoder) {
+	snap.State.DecodeFrom(d)
+	types.DecodeSlice(d, &snap.SiacoinElements)
+	types.DecodeSlice(d, &snap.SiafundElements)
+	types.DecodeSlice(d, &snap.V2FileContractElements)
+	snap.Signer.DecodeFrom(d)
+	snap.Signature.DecodeFrom(d)
+}
+
+// ExportSnapshot writes snap to w, followed by its hash, which is checked by
+// ImportSnapshot.
+func ExportSnapshot(w io.Writer, snap Snapshot) error {
+	e := types.NewEncoder(w)
+	snapshotSpecifier.EncodeTo(e)
+	e.WriteUint8(snapshotVersion)
+	snap.EncodeTo(e)
+	snap.Hash().EncodeTo(e)
+	return e.Flush()
+}
+
+// ImportSnapshot reads a snapshot written by ExportSnapshot from r, reading at
+// most maxLen bytes. It checks the snapshot's hash and verifies its elements
+// against its State. The caller is responsible for establishing that the
+// snapshot is trustworthy, e.g. by comparing its Hash to a trusted checkpoint
+// or calling VerifySignature.
+func ImportSnapshot(n *Network, r io.Reader, maxLen int64) (Snapshot, error) {
+	d := types.NewDecoder(io.LimitedReader{R: r, N: maxLen})
+	var spec types.Specifier
+	spec.DecodeFrom(d)
+	version := d.ReadUint8()
+	var snap Snapshot
+	snap.DecodeFrom(d)
+	var checksum types.Hash256
+	checksum.DecodeFrom(d)
+	if err := d.Err(); err != nil {
+		return Snapshot{}, fmt.Errorf("failed to decode snapshot: %w", err)
+	} else if spec != snapshotSpecifier {
+		return Snapshot{}, errors.New("not a snapshot")
+	} else if version != snapshotVersion {
+		return Snapshot{}, fmt.Errorf("unsupported snapshot version %v", version)
+	} else if checksum != snap.Hash() {
+		return Snapshot{}, errors.New("snapshot checksum mismatch")
+	} else if err := snap.Verify(); err != nil {
+		return Snapshot{}, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	snap.State.Network = n
+	return snap, nil
+}
@@ -0,0 +1,155 @@
+package consensus
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+func TestSnapshot(t *testing.T) {
+	n, genesisBlock := testnet()
+	n.HardforkOak.Height = 0
+	n.HardforkTax.Height = 0
+	n.HardforkFoundation.Height = 0
+	n.HardforkV2.AllowHeight = 0
+	n.HardforkV2.RequireHeight = 0
+
+	sk := types.GeneratePrivateKey()
+	policy := types.PolicyPublicKey(sk.PublicKey())
+	addr := policy.Address()
+	genesisBlock.V2 = &types.V2BlockData{
+		Transactions: []types.V2Transaction{{
+			SiacoinOutputs: []types.SiacoinOutput{
+				{Address: addr, Value: types.Siacoins(100)},
+				{Address: addr, Value: types.Siacoins(200)},
+			},
+			SiafundOutputs: []types.SiafundOutput{{Address: addr, Value: 100}},
+			FileContracts: []types.V2FileContract{{
+				ProofHeight:      20,
+				ExpirationHeight: 30,
+				RenterOutput:     types.SiacoinOutput{Address: addr, Value: types.Siacoins(10)},
+				HostOutput:       types.SiacoinOutput{Address: addr, Value: types.Siacoins(5)},
+			}},
+		}},
+	}
+	db, cs := newConsensusDB(n, genesisBlock)
+	var sces []types.SiacoinElement
+	for _, sce := range db.sces {
+		if sce.SiacoinOutput.Address == addr {
+			sces = append(sces, sce.Copy())
+		}
+	}
+	var sfes []types.SiafundElement
+	for _, sfe := range db.sfes {
+		sfes = append(sfes, sfe.Copy())
+	}
+	var fces []types.V2FileContractElement
+	for _, fce := range db.v2fces {
+		fces = append(fces, fce.Copy())
+	}
+	if len(sces) != 2 || len(sfes) == 0 || len(fces) != 1 {
+		t.Fatalf("unexpected genesis elements: %v siacoin, %v siafund, %v contracts", len(sces), len(sfes), len(fces))
+	}
+
+	snap, err := NewSnapshot(cs, sces, sfes, fces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap.Sign(sk)
+	if err := snap.VerifySignature(sk.PublicKey()); err != nil {
+		t.Fatal(err)
+	} else if err := snap.VerifySignature(types.GeneratePrivateKey().PublicKey()); err == nil {
+		t.Fatal("expected signature from wrong key to be rejected")
+	}
+
+	// round-trip
+	var buf bytes.Buffer
+	if err := ExportSnapshot(&buf, snap); err != nil {
+		t.Fatal(err)
+	}
+	exported := append([]byte(nil), buf.Bytes()...)
+	imported, err := ImportSnapshot(n, &buf, int64(len(exported)))
+	if err != nil {
+		t.Fatal(err)
+	} else if imported.Hash() != snap.Hash() {
+		t.Fatal("imported snapshot has different hash")
+	} else if imported.State.Network != n {
+		t.Fatal("imported snapshot has wrong network")
+	} else if err := imported.VerifySignature(sk.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+
+	// corrupt a byte in the middle of the snapshot
+	corrupt := append([]byte(nil), exported...)
+	corrupt[len(corrupt)/2] ^= 1
+	if _, err := ImportSnapshot(n, bytes.NewReader(corrupt), int64(len(corrupt))); err == nil {
+		t.Fatal("expected corrupted snapshot to be rejected")
+	}
+	// truncate the snapshot
+	if _, err := ImportSnapshot(n, bytes.NewReader(exported[:len(exported)-1]), int64(len(exported))); err == nil {
+		t.Fatal("expected truncated snapshot to be rejected")
+	}
+
+	// duplicate element
+	if _, err := NewSnapshot(cs, append(sces, sces[0]), nil, nil); err == nil || !strings.Contains(err.Error(), "more than once") {
+		t.Fatalf("expected duplicate element error, got %v", err)
+	}
+	// invalid proof
+	bad := sces[0].Copy()
+	bad.SiacoinOutput.Value = types.Siacoins(1000)
+	if _, err := NewSnapshot(cs, []types.SiacoinElement{bad}, nil, nil); err == nil || !errors.Is(err, ErrProofMismatch) {
+		t.Fatalf("expected proof mismatch, got %v", err)
+	}
+
+	// spend one of the outputs
+	spent := sces[0].Copy()
+	txn := types.V2Transaction{
+		SiacoinInputs: []types.V2SiacoinInput{{
+			Parent:          spent.Copy(),
+			SatisfiedPolicy: types.SatisfiedPolicy{Policy: policy},
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{Address: addr, Value: spent.SiacoinOutput.Value}},
+	}
+	txn.SiacoinInputs[0].SatisfiedPolicy.Signatures = []types.Signature{sk.SignHash(cs.InputSigHash(txn))}
+	b := types.Block{
+		ParentID:  cs.Index.ID,
+		Timestamp: types.CurrentTimestamp(),
+		V2: &types.V2BlockData{
+			Height:       cs.Index.Height + 1,
+			Transactions: []types.V2Transaction{txn},
+		},
+		MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+	}
+	b.V2.Commitment = cs.Commitment(cs.TransactionsCommitment(b.Transactions, b.V2Transactions()), b.MinerPayouts[0].Address)
+	findBlockNonce(cs, &b)
+	if err := ValidateBlock(cs, b, V1BlockSupplement{}); err != nil {
+		t.Fatal(err)
+	}
+	cs, au := ApplyBlock(cs, b, V1BlockSupplement{}, time.Time{})
+	for i := range sces {
+		au.UpdateElementProof(&sces[i].StateElement)
+	}
+	for i := range sfes {
+		au.UpdateElementProof(&sfes[i].StateElement)
+	}
+	for i := range fces {
+		au.UpdateElementProof(&fces[i].StateElement)
+	}
+
+	// the old snapshot's proofs are no longer valid for the new state
+	stale := snap
+	stale.State = cs
+	if err := stale.Verify(); err == nil {
+		t.Fatal("expected stale proofs to be rejected")
+	}
+	// the spent output cannot be included in a snapshot
+	if _, err := NewSnapshot(cs, sces, sfes, fces); err == nil || !errors.Is(err, ErrElementSpent) {
+		t.Fatalf("expected spent element error, got %v", err)
+	} else if _, err := NewSnapshot(cs, sces[1:], sfes, fces); err != nil {
+		t.Fatal(err)
+	}
+}
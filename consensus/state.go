@@ -84,6 +84,25 @@ type Network struct {
 		AllowHeight   uint64 `json:"allowHeight"`
 		RequireHeight uint64 `json:"requireHeight"`
 	} `json:"hardforkV2"`
+
+	// BlockAuthorizer, if non-nil, replaces the proof-of-work check for
+	// blocks on the network. It is intended for private chains; public
+	// networks such as mainnet leave it nil.
+	BlockAuthorizer BlockAuthorizer `json:"-"`
+}
+
+// A BlockAuthorizer decides whether a block may extend the chain, replacing
+// the proof-of-work check. This allows private chains to use alternative
+// schemes, such as proof-of-authority. Implementations must be deterministic.
+type BlockAuthorizer interface {
+	// AuthorizeHeader is called by ValidateHeader and ValidateOrphan in place
+	// of the proof-of-work check. s is the parent state of the block.
+	AuthorizeHeader(s State, bh types.BlockHeader) error
+	// AuthorizeBlock is called by ValidateOrphan after AuthorizeHeader
+	// succeeds. Schemes that carry authorization data in the body of the
+	// block, such as a signature in a transaction's arbitrary data, should
+	// check it here.
+	AuthorizeBlock(s State, b types.Block) error
 }
 
 // GenesisState returns the state to which the genesis block should be applied.
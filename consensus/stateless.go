@@ -0,0 +1,306 @@
+package consensus
+
+import (
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+// A V1BlockWitness contains everything needed to validate and apply a v1 block
+// using only its parent State: the block's supplement, including Merkle proofs
+// for each of its elements, and proofs of the window IDs used by the storage
+// proofs in the block. When encoded, the proofs are compressed into a single
+// multiproof.
+//
+// A witness can be verified against a State with ValidateV1BlockWitness.
+// However, the accumulator cannot prove that an element is absent, so
+// ValidateV1BlockWitness cannot detect a witness that omits an expiring file
+// contract; such a witness causes ApplyBlock to produce an incorrect State. If
+// the block's child is a v2 block, its commitment covers the State produced by
+// the block, and ValidateV1BlockWitnessCompleteness can be used to detect
+// omissions. No such check is possible for a v1 block whose child is also a v1
+// block, so stateless nodes must only accept those witnesses from trusted
+// peers.
+type V1BlockWitness struct {
+	Supplement V1BlockSupplement
+	// WindowProofs contains a ChainIndexElement for each storage proof
+	// supplement, in order, proving that the supplement's WindowID is the ID of
+	// the block at height WindowStart-1.
+	WindowProofs []types.ChainIndexElement
+}
+
+// forEachElement calls the supplied functions on each element of the witness,
+// in a fixed order.
+func (w *V1BlockWitness) forEachElement(sc func(*types.SiacoinElement), sf func(*types.SiafundElement), fc func(*types.FileContractElement), ci func(*types.ChainIndexElement)) {
+	for i := range w.Supplement.Transactions {
+		ts := &w.Supplement.Transactions[i]
+		for j := range ts.SiacoinInputs {
+			sc(&ts.SiacoinInputs[j])
+		}
+		for j := range ts.SiafundInputs {
+			sf(&ts.SiafundInputs[j])
+		}
+		for j := range ts.RevisedFileContracts {
+			fc(&ts.RevisedFileContracts[j])
+		}
+		for j := range ts.StorageProofs {
+			fc(&ts.StorageProofs[j].FileContract)
+		}
+	}
+	for i := range w.Supplement.ExpiringFileContracts {
+		fc(&w.Supplement.ExpiringFileContracts[i])
+	}
+	for i := range w.WindowProofs {
+		ci(&w.WindowProofs[i])
+	}
+}
+
+// elements returns every element in the witness as an ElementMultiproof.
+func (w *V1BlockWitness) elements() (mp types.ElementMultiproof) {
+	w.forEachElement(
+		func(sce *types.SiacoinElement) { mp.SiacoinElements = append(mp.SiacoinElements, sce.Share()) },
+		func(sfe *types.SiafundElement) { mp.SiafundElements = append(mp.SiafundElements, sfe.Share()) },
+		func(fce *types.FileContractElement) {
+			mp.FileContractElements = append(mp.FileContractElements, fce.Share())
+		},
+		func(cie *types.ChainIndexElement) { mp.ChainIndexElements = append(mp.ChainIndexElements, cie.Share()) },
+	)
+	return
+}
+
+// setProofs sets the Merkle proofs of the witness's elements to those of mp,
+// which must have been returned by w.elements.
+func (w *V1BlockWitness) setProofs(mp types.ElementMultiproof) {
+	var sci, sfi, fci, cii int
+	w.forEachElement(
+		func(sce *types.SiacoinElement) {
+			sce.StateElement.MerkleProof = mp.SiacoinElements[sci].StateElement.MerkleProof
+			sci++
+		},
+		func(sfe *types.SiafundElement) {
+			sfe.StateElement.MerkleProof = mp.SiafundElements[sfi].StateElement.MerkleProof
+			sfi++
+		},
+		func(fce *types.FileContractElement) {
+			fce.StateElement.MerkleProof = mp.FileContractElements[fci].StateElement.MerkleProof
+			fci++
+		},
+		func(cie *types.ChainIndexElement) {
+			cie.StateElement.MerkleProof = mp.ChainIndexElements[cii].StateElement.MerkleProof
+			cii++
+		},
+	)
+}
+
+// NewV1BlockWitness returns a witness for a block with the given supplement,
+// looking up the ChainIndexElement for each storage proof window with
+// windowProof. The elements of the supplement and the returned
+// ChainIndexElements must have proofs that are valid for the block's parent
+// state.
+func NewV1BlockWitness(bs V1BlockSupplement, windowProof func(types.ChainIndex) (types.ChainIndexElement, bool)) (V1BlockWitness, bool) {
+	w := V1BlockWitness{Supplement: bs}
+	for _, ts := range bs.Transactions {
+		for _, sps := range ts.StorageProofs {
+			index := types.ChainIndex{Height: sps.FileContract.FileContract.WindowStart - 1, ID: sps.WindowID}
+			cie, ok := windowProof(index)
+			if !ok {
+				return V1BlockWitness{}, false
+			}
+			w.WindowProofs = append(w.WindowProofs, cie)
+		}
+	}
+	return w, true
+}
+
+// EncodeTo implements types.EncoderTo.
+func (w V1BlockWitness) EncodeTo(e *types.Encoder) {
+	// encode a copy of the witness without its proofs, followed by the
+	// multiproof
+	stripped := V1BlockWitness{
+		Supplement: V1BlockSupplement{
+			Transactions:          make([]V1TransactionSupplement, len(w.Supplement.Transactions)),
+			ExpiringFileContracts: make([]types.FileContractElement, len(w.Supplement.ExpiringFileContracts)),
+		},
+		WindowProofs: make([]types.ChainIndexElement, len(w.WindowProofs)),
+	}
+	for i, ts := range w.Supplement.Transactions {
+		sts := &stripped.Supplement.Transactions[i]
+		sts.SiacoinInputs = make([]types.SiacoinElement, len(ts.SiacoinInputs))
+		sts.SiafundInputs = make([]types.SiafundElement, len(ts.SiafundInputs))
+		sts.RevisedFileContracts = make([]types.FileContractElement, len(ts.RevisedFileContracts))
+		sts.StorageProofs = make([]V1StorageProofSupplement, len(ts.StorageProofs))
+		for j := range ts.SiacoinInputs {
+			sts.SiacoinInputs[j] = ts.SiacoinInputs[j].Share()
+		}
+		for j := range ts.SiafundInputs {
+			sts.SiafundInputs[j] = ts.SiafundInputs[j].Share()
+		}
+		for j := range ts.RevisedFileContracts {
+			sts.RevisedFileContracts[j] = ts.RevisedFileContracts[j].Share()
+		}
+		for j := range ts.StorageProofs {
+			sts.StorageProofs[j] = V1StorageProofSupplement{
+				FileContract: ts.StorageProofs[j].FileContract.Share(),
+				WindowID:     ts.StorageProofs[j].WindowID,
+			}
+		}
+	}
+	for i := range w.Supplement.ExpiringFileContracts {
+		stripped.Supplement.ExpiringFileContracts[i] = w.Supplement.ExpiringFileContracts[i].Share()
+	}
+	for i := range w.WindowProofs {
+		stripped.WindowProofs[i] = w.WindowProofs[i].Share()
+	}
+	stripped.forEachElement(
+		func(sce *types.SiacoinElement) { sce.StateElement.MerkleProof = nil },
+		func(sfe *types.SiafundElement) { sfe.StateElement.MerkleProof = nil },
+		func(fce *types.FileContractElement) { fce.StateElement.MerkleProof = nil },
+		func(cie *types.ChainIndexElement) { cie.StateElement.MerkleProof = nil },
+	)
+	stripped.Supplement.EncodeTo(e)
+	types.EncodeSlice(e, stripped.WindowProofs)
+	w.elements().EncodeProofs(e)
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (w *V1BlockWitness) DecodeFrom(d *types.Decoder) {
+	w.Supplement.DecodeFrom(d)
+	types.DecodeSlice(d, &w.WindowProofs)
+	if d.Err() == nil {
+		mp := w.elements()
+		mp.DecodeProofs(d)
+		w.setProofs(mp)
+	}
+}
+
+// ValidateV1BlockWitness checks that w is a valid witness for b, which must be
+// a child of s. Unlike ValidateBlock, an error indicates that the witness, not
+// the block, is invalid. The error is a *ValidationError of kind
+// ErrSupplement.
+func ValidateV1BlockWitness(s State, b types.Block, w V1BlockWitness) error {
+	if err := validateSupplement(s, b, w.Supplement); err != nil {
+		return ruleError(RuleSupplement, err)
+	}
+
+	// check that the supplement contains every element referenced by the
+	// block that is not created within the block
+	created := make(map[types.ElementID]bool)
+	var numStorageProofs int
+	for i, txn := range b.Transactions {
+		ts := w.Supplement.Transactions[i]
+		has := func(id types.ElementID) bool {
+			if created[id] {
+				return true
+			}
+			for _, sce := range ts.SiacoinInputs {
+				if sce.ID == id {
+					return true
+				}
+			}
+			for _, sfe := range ts.SiafundInputs {
+				if sfe.ID == id {
+					return true
+				}
+			}
+			for _, fce := range ts.RevisedFileContracts {
+				if fce.ID == id {
+					return true
+				}
+			}
+			for _, sps := range ts.StorageProofs {
+				if sps.FileContract.ID == id {
+					return true
+				}
+			}
+			return false
+		}
+		for _, sci := range txn.SiacoinInputs {
+			if !has(sci.ParentID) {
+				return ruleError(RuleSupplement, violation(ErrSupplement, "supplement for transaction %v is missing siacoin element %v", i, sci.ParentID))
+			}
+		}
+		for _, sfi := range txn.SiafundInputs {
+			if !has(sfi.ParentID) {
+				return ruleError(RuleSupplement, violation(ErrSupplement, "supplement for transaction %v is missing siafund element %v", i, sfi.ParentID))
+			}
+		}
+		for _, fcr := range txn.FileContractRevisions {
+			if !has(fcr.ParentID) {
+				return ruleError(RuleSupplement, violation(ErrSupplement, "supplement for transaction %v is missing file contract %v", i, fcr.ParentID))
+			}
+		}
+		for _, sp := range txn.StorageProofs {
+			if !has(sp.ParentID) {
+				return ruleError(RuleSupplement, violation(ErrSupplement, "supplement for transaction %v is missing file contract %v", i, sp.ParentID))
+			}
+		}
+		for j := range txn.SiacoinOutputs {
+			created[txn.SiacoinOutputID(j)] = true
+		}
+		for j := range txn.SiafundOutputs {
+			created[txn.SiafundOutputID(j)] = true
+		}
+		for j := range txn.FileContracts {
+			created[txn.FileContractID(j)] = true
+		}
+		numStorageProofs += len(ts.StorageProofs)
+	}
+
+	// check the storage proof windows
+	if len(w.WindowProofs) != numStorageProofs {
+		return ruleError(RuleSupplement, violation(ErrSupplement, "witness has %v window proofs, but supplement has %v storage proofs", len(w.WindowProofs), numStorageProofs))
+	}
+	var j int
+	for _, ts := range w.Supplement.Transactions {
+		for _, sps := range ts.StorageProofs {
+			cie := w.WindowProofs[j]
+			j++
+			index := types.ChainIndex{Height: sps.FileContract.FileContract.WindowStart - 1, ID: sps.WindowID}
+			if err := s.Elements.VerifyBlockInclusion(index, cie.Share()); err != nil {
+				return ruleError(RuleSupplement, violation(ErrSupplement, "invalid window for file contract %v: %w", sps.FileContract.ID, err))
+			}
+		}
+	}
+
+	// check that the expiring contracts actually expire in this block
+	seen := make(map[types.FileContractID]bool)
+	for _, fce := range w.Supplement.ExpiringFileContracts {
+		if fce.FileContract.WindowEnd != s.childHeight() {
+			return ruleError(RuleSupplement, violation(ErrSupplement, "file contract %v does not expire at height %v", fce.ID, s.childHeight()))
+		} else if seen[fce.ID] {
+			return ruleError(RuleSupplement, violation(ErrSupplement, "file contract %v expires more than once", fce.ID))
+		}
+		seen[fce.ID] = true
+	}
+	return nil
+}
+
+// ValidateV1BlockWitnessCompleteness checks that w contains every file contract
+// expiring in b, which must be a child of s, using child, the v2 block that
+// follows b. The commitment of child covers the State produced by applying b,
+// so a witness that omits an expiring contract, and therefore produces a
+// different State, is detected. The header of child is also validated, so that
+// forging a matching child requires as much work as mining a block. The error
+// is a *ValidationError of kind ErrSupplement.
+//
+// The witness should be validated with ValidateV1BlockWitness first.
+func ValidateV1BlockWitnessCompleteness(s State, b types.Block, w V1BlockWitness, child types.Block) error {
+	if b.ParentID != s.Index.ID {
+		return ruleError(RuleSupplement, violation(ErrSupplement, "block %v is not a child of %v", b.ID(), s.Index))
+	} else if child.V2 == nil {
+		return ruleError(RuleSupplement, violation(ErrSupplement, "completeness of witness for block %v cannot be verified without a v2 child", b.ID()))
+	} else if s.childHeight() <= s.Network.HardforkOak.Height {
+		// ApplyBlock only uses the target timestamp prior to the Oak hardfork,
+		// which always precedes v2 blocks
+		return ruleError(RuleSupplement, violation(ErrSupplement, "cannot verify completeness of witness for pre-Oak block %v", b.ID()))
+	} else if len(child.MinerPayouts) == 0 {
+		return ruleError(RuleSupplement, violation(ErrSupplement, "child block %v has no miner payouts", child.ID()))
+	}
+	ns, _ := ApplyBlock(s, b, w.Supplement, time.Time{})
+	if err := ValidateHeader(ns, child.Header()); err != nil {
+		return ruleError(RuleSupplement, violation(ErrSupplement, "invalid child block: %w", err))
+	} else if child.V2.Commitment != ns.Commitment(ns.TransactionsCommitment(child.Transactions, child.V2Transactions()), child.MinerPayouts[0].Address) {
+		return ruleError(RuleSupplement, violation(ErrSupplement, "witness for block %v is incomplete: state does not match commitment of child block %v", b.ID(), child.ID()))
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package consensus
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+func TestStatelessValidation(t *testing.T) {
+	n, genesisBlock := testnet()
+	n.HardforkV2.AllowHeight = 5
+	uc := types.UnlockConditions{}
+	addr := uc.UnlockHash()
+	genesisBlock.Transactions = []types.Transaction{{
+		SiacoinOutputs: make([]types.SiacoinOutput, 32),
+		FileContracts: []types.FileContract{
+			{WindowStart: 2, WindowEnd: 5, UnlockHash: addr},
+			{WindowStart: 1, WindowEnd: 2, UnlockHash: addr},
+			{WindowStart: 4, WindowEnd: 5, UnlockHash: addr},
+		},
+	}}
+	for i := range genesisBlock.Transactions[0].SiacoinOutputs {
+		genesisBlock.Transactions[0].SiacoinOutputs[i] = types.SiacoinOutput{Address: addr, Value: types.Siacoins(100)}
+	}
+	db, cs := newConsensusDB(n, genesisBlock)
+	stateless := cs
+	var cies []types.ChainIndexElement
+
+	mine := func(txns []types.Transaction) (types.Block, V1BlockWitness) {
+		t.Helper()
+		b := types.Block{
+			ParentID:     cs.Index.ID,
+			Timestamp:    types.CurrentTimestamp(),
+			Transactions: txns,
+			MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+		}
+		findBlockNonce(cs, &b)
+		bs := db.supplementTipBlock(b)
+		for i := range bs.Transactions {
+			for j := range bs.Transactions[i].StorageProofs {
+				sps := &bs.Transactions[i].StorageProofs[j]
+				sps.WindowID = db.blockIDs[sps.FileContract.FileContract.WindowStart-1]
+			}
+		}
+		for _, fce := range db.fces {
+			if fce.FileContract.WindowEnd == cs.childHeight() {
+				bs.ExpiringFileContracts = append(bs.ExpiringFileContracts, fce.Copy())
+			}
+		}
+		w, ok := NewV1BlockWitness(bs, func(index types.ChainIndex) (types.ChainIndexElement, bool) {
+			for _, cie := range cies {
+				if cie.ChainIndex == index {
+					return cie.Copy(), true
+				}
+			}
+			return types.ChainIndexElement{}, false
+		})
+		if !ok {
+			t.Fatal("missing window proof")
+		}
+		return b, w
+	}
+
+	roundTrip := func(w V1BlockWitness) (w2 V1BlockWitness) {
+		t.Helper()
+		var buf bytes.Buffer
+		e := types.NewEncoder(&buf)
+		w.EncodeTo(e)
+		e.Flush()
+		d := types.NewBufDecoder(buf.Bytes())
+		if w2.DecodeFrom(d); d.Err() != nil {
+			t.Fatal(d.Err())
+		}
+		return
+	}
+
+	apply := func(b types.Block, w V1BlockWitness) {
+		t.Helper()
+		// the stateful node uses the original supplement
+		if err := ValidateBlock(cs, b, w.Supplement); err != nil {
+			t.Fatal(err)
+		}
+		var au ApplyUpdate
+		cs, au = ApplyBlock(cs, b, w.Supplement, db.ancestorTimestamp(b.ParentID))
+		db.applyBlock(au)
+		for i := range cies {
+			au.UpdateElementProof(&cies[i].StateElement)
+		}
+		cies = append(cies, au.ChainIndexElement())
+
+		// the stateless node uses only its State and the decoded witness
+		w = roundTrip(w)
+		if err := ValidateV1BlockWitness(stateless, b, w); err != nil {
+			t.Fatal(err)
+		} else if err := ValidateBlock(stateless, b, w.Supplement); err != nil {
+			t.Fatal(err)
+		}
+		stateless, _ = ApplyBlock(stateless, b, w.Supplement, time.Time{})
+		if stateless.Index != cs.Index || stateless.Elements != cs.Elements {
+			t.Fatalf("stateless node diverged at height %v", cs.Index.Height)
+		}
+	}
+
+	// height 1: split the genesis output, then spend one of the new outputs
+	// within the same block
+	txn1 := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{ParentID: genesisBlock.Transactions[0].SiacoinOutputID(0), UnlockConditions: uc}},
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: addr, Value: types.Siacoins(60)},
+			{Address: addr, Value: types.Siacoins(40)},
+		},
+	}
+	txn2 := types.Transaction{
+		SiacoinInputs:  []types.SiacoinInput{{ParentID: txn1.SiacoinOutputID(1), UnlockConditions: uc}},
+		SiacoinOutputs: []types.SiacoinOutput{{Address: addr, Value: types.Siacoins(40)}},
+	}
+	apply(mine([]types.Transaction{txn1, txn2}))
+
+	// height 2: spend an output from the previous block; the second contract
+	// expires
+	txn3 := types.Transaction{
+		SiacoinInputs:  []types.SiacoinInput{{ParentID: txn1.SiacoinOutputID(0), UnlockConditions: uc}},
+		SiacoinOutputs: []types.SiacoinOutput{{Address: addr, Value: types.Siacoins(60)}},
+	}
+	b, w := mine([]types.Transaction{txn3})
+	if len(w.Supplement.ExpiringFileContracts) != 1 {
+		t.Fatal("expected an expiring contract")
+	}
+	apply(b, w)
+
+	// height 3: submit a storage proof for the first contract
+	txn4 := types.Transaction{
+		StorageProofs: []types.StorageProof{{ParentID: genesisBlock.Transactions[0].FileContractID(0)}},
+	}
+	b, w = mine([]types.Transaction{txn4})
+	if len(w.WindowProofs) != 1 {
+		t.Fatal("expected a window proof")
+	}
+
+	// invalid witnesses should be rejected
+	tests := []struct {
+		desc    string
+		corrupt func(*V1BlockWitness)
+	}{
+		{
+			"wrong window ID",
+			func(w *V1BlockWitness) {
+				w.Supplement.Transactions[0].StorageProofs[0].WindowID = cs.Index.ID
+			},
+		},
+		{
+			"missing window proof",
+			func(w *V1BlockWitness) {
+				w.WindowProofs = nil
+			},
+		},
+		{
+			"missing storage proof supplement",
+			func(w *V1BlockWitness) {
+				w.Supplement.Transactions[0].StorageProofs = nil
+				w.WindowProofs = nil
+			},
+		},
+		{
+			"tampered contract",
+			func(w *V1BlockWitness) {
+				w.Supplement.Transactions[0].StorageProofs[0].FileContract.FileContract.Payout = types.Siacoins(1)
+			},
+		},
+		{
+			"contract that does not expire",
+			func(w *V1BlockWitness) {
+				w.Supplement.ExpiringFileContracts = append(w.Supplement.ExpiringFileContracts, w.Supplement.Transactions[0].StorageProofs[0].FileContract.Copy())
+			},
+		},
+	}
+	for _, test := range tests {
+		bad := roundTrip(w)
+		test.corrupt(&bad)
+		bad = roundTrip(bad)
+		if err := ValidateV1BlockWitness(stateless, b, bad); err == nil {
+			t.Errorf("%v: expected witness to be rejected", test.desc)
+		} else if !errors.Is(err, ErrSupplement) {
+			t.Errorf("%v: expected ErrSupplement, got %v", test.desc, err)
+		}
+	}
+	apply(b, w)
+
+	// height 4: spend the remaining genesis outputs; the multiproof should be
+	// much smaller than the individual proofs
+	var txn5 types.Transaction
+	for i := 1; i < 32; i++ {
+		txn5.SiacoinInputs = append(txn5.SiacoinInputs, types.SiacoinInput{ParentID: genesisBlock.Transactions[0].SiacoinOutputID(i), UnlockConditions: uc})
+	}
+	txn5.SiacoinOutputs = []types.SiacoinOutput{{Address: addr, Value: types.Siacoins(100 * 31)}}
+	b, w = mine([]types.Transaction{txn5})
+	var wbuf, sbuf bytes.Buffer
+	we, se := types.NewEncoder(&wbuf), types.NewEncoder(&sbuf)
+	w.EncodeTo(we)
+	w.Supplement.EncodeTo(se)
+	types.EncodeSlice(se, w.WindowProofs)
+	we.Flush()
+	se.Flush()
+	if wbuf.Len() >= sbuf.Len()/2 {
+		t.Fatalf("witness (%v bytes) is not much smaller than uncompressed witness (%v bytes)", wbuf.Len(), sbuf.Len())
+	}
+	apply(b, w)
+
+	// height 5: the third contract expires; since the next block is a v2
+	// block, the completeness of the witness can be verified
+	b, w = mine(nil)
+	if len(w.Supplement.ExpiringFileContracts) != 1 {
+		t.Fatal("expected an expiring contract")
+	}
+	parent := stateless
+	apply(b, w)
+	child := types.Block{
+		ParentID:     cs.Index.ID,
+		Timestamp:    types.CurrentTimestamp(),
+		MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+		V2:           &types.V2BlockData{Height: cs.childHeight()},
+	}
+	child.V2.Commitment = cs.Commitment(cs.TransactionsCommitment(nil, nil), child.MinerPayouts[0].Address)
+	findBlockNonce(cs, &child)
+	if err := ValidateBlock(cs, child, V1BlockSupplement{}); err != nil {
+		t.Fatal(err)
+	} else if err := ValidateV1BlockWitnessCompleteness(parent, b, roundTrip(w), child); err != nil {
+		t.Fatal(err)
+	}
+
+	// a witness that omits the expiring contract passes ValidateV1BlockWitness,
+	// but not the completeness check
+	incomplete := roundTrip(w)
+	incomplete.Supplement.ExpiringFileContracts = nil
+	if err := ValidateV1BlockWitness(parent, b, incomplete); err != nil {
+		t.Fatal(err)
+	} else if err := ValidateV1BlockWitnessCompleteness(parent, b, incomplete, child); !errors.Is(err, ErrSupplement) {
+		t.Fatalf("expected ErrSupplement, got %v", err)
+	}
+
+	// completeness cannot be verified without a v2 child
+	v1Child := child
+	v1Child.V2 = nil
+	if err := ValidateV1BlockWitnessCompleteness(parent, b, roundTrip(w), v1Child); err == nil {
+		t.Fatal("expected completeness check without a v2 child to fail")
+	}
+}
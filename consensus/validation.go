@@ -2,24 +2,43 @@ package consensus
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
+	"math"
 	"math/bits"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.sia.tech/core/internal/blake2b"
 	"go.sia.tech/core/types"
 )
 
-func validateHeader(s State, parentID types.BlockID, timestamp time.Time, nonce uint64, id types.BlockID) error {
-	if parentID != s.Index.ID {
-		return errors.New("wrong parent ID")
-	} else if timestamp.Before(s.medianTimestamp()) {
-		return errors.New("timestamp too far in the past")
-	} else if nonce%s.NonceFactor() != 0 {
-		return errors.New("nonce not divisible by required factor")
-	} else if id.CmpWork(s.ChildTarget) < 0 {
-		return errors.New("insufficient work")
+func validateHeader(s State, bh types.BlockHeader) *ValidationError {
+	if bh.ParentID != s.Index.ID {
+		return violation(ErrWrongParent, "wrong parent ID")
+	} else if bh.Timestamp.Before(s.medianTimestamp()) {
+		return violation(ErrTimestampTooOld, "timestamp too far in the past")
+	} else if bh.Nonce%s.NonceFactor() != 0 {
+		return violation(ErrInvalidNonce, "nonce not divisible by required factor")
+	}
+	if auth := s.Network.BlockAuthorizer; auth != nil {
+		if err := auth.AuthorizeHeader(s, bh); err != nil {
+			return violation(ErrUnauthorizedBlock, "unauthorized header: %w", err)
+		}
+	} else if bh.ID().CmpWork(s.ChildTarget) < 0 {
+		return violation(ErrInsufficientWork, "insufficient work")
+	}
+	return nil
+}
+
+// ValidateHeader validates bh in the context of s, which must be the state of
+// its parent. Unless the Network specifies a BlockAuthorizer, this includes
+// checking that the header's ID meets the target.
+func ValidateHeader(s State, bh types.BlockHeader) error {
+	if err := validateHeader(s, bh); err != nil {
+		err.Err = fmt.Errorf("block header has %w", err.Err)
+		return ruleError(RuleHeader, err)
 	}
 	return nil
 }
@@ -40,11 +59,11 @@ func validateMinerPayouts(s State, b types.Block) error {
 			// will never be zero-valued anyway. In any case, this check is moot
 			// in v2, where transactions have a single MinerFee, not a slice.
 			if fee.IsZero() {
-				return errors.New("transaction fee has zero value")
+				return violation(ErrMinerPayouts, "transaction fee has zero value")
 			}
 			expectedSum, overflow = expectedSum.AddWithOverflow(fee)
 			if overflow {
-				return errors.New("transaction fees overflow")
+				return violation(ErrMinerPayouts, "transaction fees overflow")
 			}
 		}
 	}
@@ -52,26 +71,26 @@ func validateMinerPayouts(s State, b types.Block) error {
 		for _, txn := range b.V2.Transactions {
 			expectedSum, overflow = expectedSum.AddWithOverflow(txn.MinerFee)
 			if overflow {
-				return errors.New("v2 transaction fees overflow")
+				return violation(ErrMinerPayouts, "v2 transaction fees overflow")
 			}
 		}
 		if len(b.MinerPayouts) != 1 {
-			return errors.New("block must have exactly one miner payout")
+			return violation(ErrMinerPayouts, "block must have exactly one miner payout")
 		}
 	}
 
 	var sum types.Currency
 	for _, mp := range b.MinerPayouts {
 		if mp.Value.IsZero() {
-			return errors.New("miner payout has zero value")
+			return violation(ErrMinerPayouts, "miner payout has zero value")
 		}
 		sum, overflow = sum.AddWithOverflow(mp.Value)
 		if overflow {
-			return errors.New("miner payouts overflow")
+			return violation(ErrMinerPayouts, "miner payouts overflow")
 		}
 	}
 	if sum != expectedSum {
-		return fmt.Errorf("miner payout sum (%v) does not match block reward + fees (%v)", sum, expectedSum)
+		return violation(ErrMinerPayouts, "miner payout sum (%v) does not match block reward + fees (%v)", sum, expectedSum)
 	}
 	return nil
 }
@@ -86,15 +105,20 @@ func ValidateOrphan(s State, b types.Block) error {
 		weight += s.V2TransactionWeight(txn)
 	}
 	if weight > s.MaxBlockWeight() {
-		return fmt.Errorf("block exceeds maximum weight (%v > %v)", weight, s.MaxBlockWeight())
+		return ruleError(RuleBlockWeight, violation(ErrBlockWeight, "block exceeds maximum weight (%v > %v)", weight, s.MaxBlockWeight()))
 	} else if err := validateMinerPayouts(s, b); err != nil {
-		return err
-	} else if err := validateHeader(s, b.ParentID, b.Timestamp, b.Nonce, b.ID()); err != nil {
-		return fmt.Errorf("block has %w", err)
+		return ruleError(RuleMinerPayouts, err)
+	} else if err := validateHeader(s, b.Header()); err != nil {
+		err.Err = fmt.Errorf("block has %w", err.Err)
+		return ruleError(RuleHeader, err)
+	} else if auth := s.Network.BlockAuthorizer; auth != nil {
+		if err := auth.AuthorizeBlock(s, b); err != nil {
+			return ruleError(RuleHeader, violation(ErrUnauthorizedBlock, "block is not authorized: %w", err))
+		}
 	}
 	if b.V2 != nil {
 		if b.V2.Height != s.Index.Height+1 {
-			return errors.New("block height does not increment parent height")
+			return ruleError(RuleBlockHeight, violation(ErrBlockHeight, "block height does not increment parent height"))
 		}
 	}
 	return nil
@@ -148,27 +172,32 @@ func validateCurrencyOverflow(ms *MidState, txn types.Transaction) error {
 	}
 
 	if overflow {
-		return errors.New("transaction outputs exceed inputs") // technically true
+		return violation(ErrOverflow, "transaction outputs exceed inputs") // technically true
 	}
 	return nil
 }
 
 func validateMinimumValues(_ *MidState, txn types.Transaction) error {
-	zero := false
-	for _, sco := range txn.SiacoinOutputs {
-		zero = zero || sco.Value.IsZero()
+	const msg = "transaction creates a zero-valued output"
+	for i, sco := range txn.SiacoinOutputs {
+		if sco.Value.IsZero() {
+			return outputViolation(ErrZeroValue, i, txn.SiacoinOutputID(i), msg)
+		}
 	}
-	for _, fc := range txn.FileContracts {
-		zero = zero || fc.Payout.IsZero()
+	for i, fc := range txn.FileContracts {
+		if fc.Payout.IsZero() {
+			return outputViolation(ErrZeroValue, i, txn.FileContractID(i), msg)
+		}
 	}
-	for _, sfo := range txn.SiafundOutputs {
-		zero = zero || sfo.Value == 0
+	for i, sfo := range txn.SiafundOutputs {
+		if sfo.Value == 0 {
+			return outputViolation(ErrZeroValue, i, txn.SiafundOutputID(i), msg)
+		}
 	}
 	for _, fee := range txn.MinerFees {
-		zero = zero || fee.IsZero()
-	}
-	if zero {
-		return errors.New("transaction creates a zero-valued output")
+		if fee.IsZero() {
+			return violation(ErrZeroValue, msg)
+		}
 	}
 	return nil
 }
@@ -177,17 +206,17 @@ func validateSiacoins(ms *MidState, txn types.Transaction, ts V1TransactionSuppl
 	var inputSum types.Currency
 	for i, sci := range txn.SiacoinInputs {
 		if sci.UnlockConditions.Timelock > ms.base.childHeight() {
-			return fmt.Errorf("siacoin input %v has timelocked parent", i)
+			return inputViolation(ErrTimelocked, i, sci.ParentID, "siacoin input %v has timelocked parent", i)
 		} else if txid, ok := ms.spent(types.Hash256(sci.ParentID)); ok {
-			return fmt.Errorf("siacoin input %v double-spends parent output (previously spent in %v)", i, txid)
+			return inputViolation(ErrConflict, i, sci.ParentID, "siacoin input %v double-spends parent output (previously spent in %v)", i, txid)
 		}
 		parent, ok := ms.siacoinElement(ts, sci.ParentID)
 		if !ok {
-			return fmt.Errorf("siacoin input %v spends nonexistent siacoin output %v", i, sci.ParentID)
+			return inputViolation(ErrMissingElement, i, sci.ParentID, "siacoin input %v spends nonexistent siacoin output %v", i, sci.ParentID)
 		} else if sci.UnlockConditions.UnlockHash() != parent.SiacoinOutput.Address {
-			return fmt.Errorf("siacoin input %v claims incorrect unlock conditions for siacoin output %v", i, sci.ParentID)
+			return inputViolation(ErrIncorrectUnlock, i, sci.ParentID, "siacoin input %v claims incorrect unlock conditions for siacoin output %v", i, sci.ParentID)
 		} else if parent.MaturityHeight > ms.base.childHeight() {
-			return fmt.Errorf("siacoin input %v has immature parent", i)
+			return inputViolation(ErrImmature, i, sci.ParentID, "siacoin input %v has immature parent", i)
 		}
 		inputSum = inputSum.Add(parent.SiacoinOutput.Value)
 	}
@@ -202,7 +231,7 @@ func validateSiacoins(ms *MidState, txn types.Transaction, ts V1TransactionSuppl
 		outputSum = outputSum.Add(fee)
 	}
 	if inputSum.Cmp(outputSum) != 0 {
-		return fmt.Errorf("siacoin inputs (%v) do not equal outputs (%v)", inputSum, outputSum)
+		return violation(ErrUnbalanced, "siacoin inputs (%v) do not equal outputs (%v)", inputSum, outputSum)
 	}
 	return nil
 }
@@ -211,19 +240,19 @@ func validateSiafunds(ms *MidState, txn types.Transaction, ts V1TransactionSuppl
 	var inputSum uint64
 	for i, sfi := range txn.SiafundInputs {
 		if sfi.UnlockConditions.Timelock > ms.base.childHeight() {
-			return fmt.Errorf("siafund input %v has timelocked parent", i)
+			return inputViolation(ErrTimelocked, i, sfi.ParentID, "siafund input %v has timelocked parent", i)
 		} else if txid, ok := ms.spent(types.Hash256(sfi.ParentID)); ok {
-			return fmt.Errorf("siafund input %v double-spends parent output (previously spent in %v)", i, txid)
+			return inputViolation(ErrConflict, i, sfi.ParentID, "siafund input %v double-spends parent output (previously spent in %v)", i, txid)
 		}
 		parent, ok := ms.siafundElement(ts, sfi.ParentID)
 		if !ok {
-			return fmt.Errorf("siafund input %v spends nonexistent siafund output %v", i, sfi.ParentID)
+			return inputViolation(ErrMissingElement, i, sfi.ParentID, "siafund input %v spends nonexistent siafund output %v", i, sfi.ParentID)
 		} else if sfi.UnlockConditions.UnlockHash() != parent.SiafundOutput.Address &&
 			// override old developer siafund address
 			!(ms.base.childHeight() >= ms.base.Network.HardforkDevAddr.Height &&
 				parent.SiafundOutput.Address == ms.base.Network.HardforkDevAddr.OldAddress &&
 				sfi.UnlockConditions.UnlockHash() == ms.base.Network.HardforkDevAddr.NewAddress) {
-			return fmt.Errorf("siafund input %v claims incorrect unlock conditions for siafund output %v", i, sfi.ParentID)
+			return inputViolation(ErrIncorrectUnlock, i, sfi.ParentID, "siafund input %v claims incorrect unlock conditions for siafund output %v", i, sfi.ParentID)
 		}
 		inputSum += parent.SiafundOutput.Value
 	}
@@ -232,7 +261,7 @@ func validateSiafunds(ms *MidState, txn types.Transaction, ts V1TransactionSuppl
 		outputSum += out.Value
 	}
 	if inputSum != outputSum {
-		return fmt.Errorf("siafund inputs (%v) do not equal outputs (%v)", inputSum, outputSum)
+		return violation(ErrUnbalanced, "siafund inputs (%v) do not equal outputs (%v)", inputSum, outputSum)
 	}
 	return nil
 }
@@ -240,9 +269,9 @@ func validateSiafunds(ms *MidState, txn types.Transaction, ts V1TransactionSuppl
 func validateFileContracts(ms *MidState, txn types.Transaction, ts V1TransactionSupplement) error {
 	for i, fc := range txn.FileContracts {
 		if fc.WindowStart < ms.base.childHeight() {
-			return fmt.Errorf("file contract %v has window that starts in the past", i)
+			return outputViolation(ErrInvalidContract, i, txn.FileContractID(i), "file contract %v has window that starts in the past", i)
 		} else if fc.WindowEnd <= fc.WindowStart {
-			return fmt.Errorf("file contract %v has window that ends before it begins", i)
+			return outputViolation(ErrInvalidContract, i, txn.FileContractID(i), "file contract %v has window that ends before it begins", i)
 		}
 		var validSum, missedSum types.Currency
 		for _, output := range fc.ValidProofOutputs {
@@ -252,31 +281,31 @@ func validateFileContracts(ms *MidState, txn types.Transaction, ts V1Transaction
 			missedSum = missedSum.Add(output.Value)
 		}
 		if !validSum.Equals(missedSum) {
-			return fmt.Errorf("file contract %v has valid payout that does not equal missed payout", i)
+			return outputViolation(ErrInvalidContract, i, txn.FileContractID(i), "file contract %v has valid payout that does not equal missed payout", i)
 		} else if !fc.Payout.Equals(validSum.Add(ms.base.FileContractTax(fc))) {
-			return fmt.Errorf("file contract %v has payout with incorrect tax", i)
+			return outputViolation(ErrUnbalanced, i, txn.FileContractID(i), "file contract %v has payout with incorrect tax", i)
 		}
 	}
 
 	for i, fcr := range txn.FileContractRevisions {
 		if fcr.UnlockConditions.Timelock > ms.base.childHeight() {
-			return fmt.Errorf("file contract revision %v has timelocked parent", i)
+			return inputViolation(ErrTimelocked, i, fcr.ParentID, "file contract revision %v has timelocked parent", i)
 		} else if fcr.FileContract.WindowStart < ms.base.childHeight() {
-			return fmt.Errorf("file contract revision %v has window that starts in the past", i)
+			return inputViolation(ErrInvalidRevision, i, fcr.ParentID, "file contract revision %v has window that starts in the past", i)
 		} else if fcr.FileContract.WindowEnd <= fcr.FileContract.WindowStart {
-			return fmt.Errorf("file contract revision %v has window that ends before it begins", i)
+			return inputViolation(ErrInvalidRevision, i, fcr.ParentID, "file contract revision %v has window that ends before it begins", i)
 		} else if txid, ok := ms.spent(types.Hash256(fcr.ParentID)); ok {
-			return fmt.Errorf("file contract revision %v conflicts with previous proof or revision (in %v)", i, txid)
+			return inputViolation(ErrConflict, i, fcr.ParentID, "file contract revision %v conflicts with previous proof or revision (in %v)", i, txid)
 		}
 		parent, ok := ms.fileContractElement(ts, fcr.ParentID)
 		if !ok {
-			return fmt.Errorf("file contract revision %v revises nonexistent file contract %v", i, fcr.ParentID)
+			return inputViolation(ErrMissingElement, i, fcr.ParentID, "file contract revision %v revises nonexistent file contract %v", i, fcr.ParentID)
 		} else if parent.FileContract.WindowStart < ms.base.childHeight() {
-			return fmt.Errorf("file contract revision %v revises contract after its proof window has opened", i)
+			return inputViolation(ErrInvalidRevision, i, fcr.ParentID, "file contract revision %v revises contract after its proof window has opened", i)
 		} else if fcr.FileContract.RevisionNumber <= parent.FileContract.RevisionNumber {
-			return fmt.Errorf("file contract revision %v does not have a higher revision number than its parent", i)
+			return inputViolation(ErrInvalidRevision, i, fcr.ParentID, "file contract revision %v does not have a higher revision number than its parent", i)
 		} else if fcr.UnlockConditions.UnlockHash() != parent.FileContract.UnlockHash {
-			return fmt.Errorf("file contract revision %v claims incorrect unlock conditions", i)
+			return inputViolation(ErrIncorrectUnlock, i, fcr.ParentID, "file contract revision %v claims incorrect unlock conditions", i)
 		}
 		outputSum := func(outputs []types.SiacoinOutput) (sum types.Currency) {
 			for _, output := range outputs {
@@ -285,9 +314,9 @@ func validateFileContracts(ms *MidState, txn types.Transaction, ts V1Transaction
 			return sum
 		}
 		if outputSum(fcr.FileContract.ValidProofOutputs) != outputSum(parent.FileContract.ValidProofOutputs) {
-			return fmt.Errorf("file contract revision %v changes valid payout sum", i)
+			return inputViolation(ErrInvalidRevision, i, fcr.ParentID, "file contract revision %v changes valid payout sum", i)
 		} else if outputSum(fcr.FileContract.MissedProofOutputs) != outputSum(parent.FileContract.MissedProofOutputs) {
-			return fmt.Errorf("file contract revision %v changes missed payout sum", i)
+			return inputViolation(ErrInvalidRevision, i, fcr.ParentID, "file contract revision %v changes missed payout sum", i)
 		}
 	}
 
@@ -298,13 +327,13 @@ func validateFileContracts(ms *MidState, txn types.Transaction, ts V1Transaction
 	if len(txn.StorageProofs) > 0 &&
 		(len(txn.SiacoinOutputs) > 0 || len(txn.SiafundOutputs) > 0 ||
 			len(txn.FileContracts) > 0 || len(txn.FileContractRevisions) > 0) {
-		return errors.New("transaction contains both a storage proof and other outputs")
+		return violation(ErrMalformed, "transaction contains both a storage proof and other outputs")
 	}
 	// A contract can only have a single storage proof.
 	for i := range txn.StorageProofs {
 		for j := i + 1; j < len(txn.StorageProofs); j++ {
 			if txn.StorageProofs[i].ParentID == txn.StorageProofs[j].ParentID {
-				return fmt.Errorf("storage proof %v resolves contract (%v) already resolved by storage proof %v", j, txn.StorageProofs[i].ParentID, i)
+				return inputViolation(ErrDuplicateInput, j, txn.StorageProofs[j].ParentID, "storage proof %v resolves contract (%v) already resolved by storage proof %v", j, txn.StorageProofs[i].ParentID, i)
 			}
 		}
 	}
@@ -352,23 +381,23 @@ func validateFileContracts(ms *MidState, txn types.Transaction, ts V1Transaction
 
 	for i, sp := range txn.StorageProofs {
 		if txid, ok := ms.spent(sp.ParentID); ok {
-			return fmt.Errorf("storage proof %v conflicts with previous proof (in %v)", i, txid)
+			return inputViolation(ErrConflict, i, sp.ParentID, "storage proof %v conflicts with previous proof (in %v)", i, txid)
 		}
 		fce, ok := ms.fileContractElement(ts, sp.ParentID)
 		if !ok {
-			return fmt.Errorf("storage proof %v references nonexistent file contract", i)
+			return inputViolation(ErrMissingElement, i, sp.ParentID, "storage proof %v references nonexistent file contract", i)
 		}
 		fc := fce.FileContract
 		windowID, ok := ms.storageProofWindowID(ts, sp.ParentID)
 		if !ok {
-			return fmt.Errorf("storage proof %v cannot be submitted until after window start (%v)", i, fc.WindowStart)
+			return inputViolation(ErrPrematureResolution, i, sp.ParentID, "storage proof %v cannot be submitted until after window start (%v)", i, fc.WindowStart)
 		}
 		leafIndex := ms.base.StorageProofLeafIndex(fc.Filesize, windowID, sp.ParentID)
 		leaf := storageProofLeaf(leafIndex, fc.Filesize, sp.Leaf)
 		if leaf == nil {
 			continue
 		} else if storageProofRoot(leafIndex, fc.Filesize, leaf, sp.Proof) != fc.FileMerkleRoot {
-			return fmt.Errorf("storage proof %v has root that does not match contract Merkle root", i)
+			return inputViolation(ErrInvalidStorageProof, i, sp.ParentID, "storage proof %v has root that does not match contract Merkle root", i)
 		}
 	}
 
@@ -384,9 +413,9 @@ func validateArbitraryData(ms *MidState, txn types.Transaction) error {
 			var update types.FoundationAddressUpdate
 			d := types.NewBufDecoder(arb[len(types.SpecifierFoundation):])
 			if update.DecodeFrom(d); d.Err() != nil {
-				return errors.New("transaction contains an improperly-encoded FoundationAddressUpdate")
+				return violation(ErrMalformed, "transaction contains an improperly-encoded FoundationAddressUpdate")
 			} else if update.NewPrimary == types.VoidAddress || update.NewFailsafe == types.VoidAddress {
-				return errors.New("transaction contains an uninitialized FoundationAddressUpdate")
+				return violation(ErrMalformed, "transaction contains an uninitialized FoundationAddressUpdate")
 			}
 			// check that the transaction is signed by a current key
 			var signed bool
@@ -402,13 +431,36 @@ func validateArbitraryData(ms *MidState, txn types.Transaction) error {
 				}
 			}
 			if !signed {
-				return errors.New("transaction contains an unsigned FoundationAddressUpdate")
+				return violation(ErrUnauthorized, "transaction contains an unsigned FoundationAddressUpdate")
 			}
 		}
 	}
 	return nil
 }
 
+// coveredFieldsInRange reports whether every field index in cf refers to a
+// field present in txn. Hashing fields that are not present would panic.
+func coveredFieldsInRange(txn types.Transaction, cf types.CoveredFields) bool {
+	inRange := func(indices []uint64, n int) bool {
+		for _, i := range indices {
+			if i >= uint64(n) {
+				return false
+			}
+		}
+		return true
+	}
+	return inRange(cf.SiacoinInputs, len(txn.SiacoinInputs)) &&
+		inRange(cf.SiacoinOutputs, len(txn.SiacoinOutputs)) &&
+		inRange(cf.FileContracts, len(txn.FileContracts)) &&
+		inRange(cf.FileContractRevisions, len(txn.FileContractRevisions)) &&
+		inRange(cf.StorageProofs, len(txn.StorageProofs)) &&
+		inRange(cf.SiafundInputs, len(txn.SiafundInputs)) &&
+		inRange(cf.SiafundOutputs, len(txn.SiafundOutputs)) &&
+		inRange(cf.MinerFees, len(txn.MinerFees)) &&
+		inRange(cf.ArbitraryData, len(txn.ArbitraryData)) &&
+		inRange(cf.Signatures, len(txn.Signatures))
+}
+
 func validateSignatures(ms *MidState, txn types.Transaction) error {
 	// build a map of all outstanding signatures
 	//
@@ -419,10 +471,12 @@ func validateSignatures(ms *MidState, txn types.Transaction) error {
 		used []bool
 	}
 	sigMap := make(map[types.Hash256]*sigMapEntry)
+	var parents []types.Hash256 // for deterministic error reporting
 	addEntry := func(id types.Hash256, uc types.UnlockConditions) bool {
 		if _, ok := sigMap[id]; ok {
 			return false
 		}
+		parents = append(parents, id)
 		sigMap[id] = &sigMapEntry{
 			need: uc.SignaturesRequired,
 			keys: uc.PublicKeys,
@@ -430,32 +484,34 @@ func validateSignatures(ms *MidState, txn types.Transaction) error {
 		}
 		return true
 	}
-	for _, sci := range txn.SiacoinInputs {
+	for i, sci := range txn.SiacoinInputs {
 		if !addEntry(types.Hash256(sci.ParentID), sci.UnlockConditions) {
-			return fmt.Errorf("transaction spends siacoin input %v more than once", sci.ParentID)
+			return inputViolation(ErrDuplicateInput, i, sci.ParentID, "transaction spends siacoin input %v more than once", sci.ParentID)
 		}
 	}
-	for _, sfi := range txn.SiafundInputs {
+	for i, sfi := range txn.SiafundInputs {
 		if !addEntry(types.Hash256(sfi.ParentID), sfi.UnlockConditions) {
-			return fmt.Errorf("transaction spends siafund input %v more than once", sfi.ParentID)
+			return inputViolation(ErrDuplicateInput, i, sfi.ParentID, "transaction spends siafund input %v more than once", sfi.ParentID)
 		}
 	}
-	for _, fcr := range txn.FileContractRevisions {
+	for i, fcr := range txn.FileContractRevisions {
 		if !addEntry(types.Hash256(fcr.ParentID), fcr.UnlockConditions) {
-			return fmt.Errorf("transaction revises file contract %v more than once", fcr.ParentID)
+			return inputViolation(ErrDuplicateInput, i, fcr.ParentID, "transaction revises file contract %v more than once", fcr.ParentID)
 		}
 	}
 
 	for i, sig := range txn.Signatures {
 		e, ok := sigMap[types.Hash256(sig.ParentID)]
 		if !ok {
-			return fmt.Errorf("signature %v references parent not present in transaction", i)
+			return inputViolation(ErrMalformed, -1, sig.ParentID, "signature %v references parent not present in transaction", i)
 		} else if sig.PublicKeyIndex >= uint64(len(e.keys)) {
-			return fmt.Errorf("signature %v points to a nonexistent public key", i)
+			return inputViolation(ErrMalformed, -1, sig.ParentID, "signature %v points to a nonexistent public key", i)
 		} else if e.need == 0 || e.used[sig.PublicKeyIndex] {
-			return fmt.Errorf("signature %v is redundant", i)
+			return inputViolation(ErrMalformed, -1, sig.ParentID, "signature %v is redundant", i)
 		} else if sig.Timelock > ms.base.childHeight() {
-			return fmt.Errorf("timelock of signature %v has not expired", i)
+			return inputViolation(ErrTimelocked, -1, sig.ParentID, "timelock of signature %v has not expired", i)
+		} else if !coveredFieldsInRange(txn, sig.CoveredFields) {
+			return inputViolation(ErrMalformed, -1, sig.ParentID, "signature %v covers nonexistent fields", i)
 		}
 		e.used[sig.PublicKeyIndex] = true
 		e.need--
@@ -473,19 +529,19 @@ func validateSignatures(ms *MidState, txn types.Transaction) error {
 				sigHash = ms.base.PartialSigHash(txn, sig.CoveredFields)
 			}
 			if !epk.VerifyHash(sigHash, esig) {
-				return fmt.Errorf("signature %v is invalid", i)
+				return inputViolation(ErrInvalidSignature, -1, sig.ParentID, "signature %v is invalid", i)
 			}
 		case types.SpecifierEntropy:
-			return fmt.Errorf("signature %v uses an entropy public key", i)
+			return inputViolation(ErrInvalidSignature, -1, sig.ParentID, "signature %v uses an entropy public key", i)
 		default:
 			// signatures for unrecognized algorithms are considered valid by
 			// default; this allows new algorithms to be soft-forked in
 		}
 	}
 
-	for id, sig := range sigMap {
-		if sig.need > 0 {
-			return fmt.Errorf("parent %v has missing signatures", id)
+	for _, id := range parents {
+		if sigMap[id].need > 0 {
+			return inputViolation(ErrInvalidSignature, -1, id, "parent %v has missing signatures", id)
 		}
 	}
 	return nil
@@ -493,24 +549,34 @@ func validateSignatures(ms *MidState, txn types.Transaction) error {
 
 // ValidateTransaction validates txn within the context of ms and store.
 func ValidateTransaction(ms *MidState, txn types.Transaction, ts V1TransactionSupplement) error {
+	return validateTransaction(ms, txn, ts, nil)
+}
+
+// validateTransaction validates txn. If sigErr is non-nil, it is used as the
+// result of validateSignatures.
+func validateTransaction(ms *MidState, txn types.Transaction, ts V1TransactionSupplement, sigErr *error) error {
 	if ms.base.childHeight() >= ms.base.Network.HardforkV2.RequireHeight {
-		return errors.New("v1 transactions are not allowed after v2 hardfork is complete")
+		return ruleError(RuleVersion, violation(ErrV1NotAllowed, "v1 transactions are not allowed after v2 hardfork is complete"))
 	} else if err := validateCurrencyOverflow(ms, txn); err != nil {
-		return err
+		return ruleError(RuleOverflow, err)
 	} else if weight := ms.base.TransactionWeight(txn); weight > ms.base.MaxBlockWeight() {
-		return fmt.Errorf("transaction exceeds maximum block weight (%v > %v)", weight, ms.base.MaxBlockWeight())
+		return ruleError(RuleWeight, violation(ErrWeight, "transaction exceeds maximum block weight (%v > %v)", weight, ms.base.MaxBlockWeight()))
 	} else if err := validateMinimumValues(ms, txn); err != nil {
-		return err
+		return ruleError(RuleMinimumValues, err)
 	} else if err := validateSiacoins(ms, txn, ts); err != nil {
-		return err
+		return ruleError(RuleSiacoins, err)
 	} else if err := validateSiafunds(ms, txn, ts); err != nil {
-		return err
+		return ruleError(RuleSiafunds, err)
 	} else if err := validateFileContracts(ms, txn, ts); err != nil {
-		return err
+		return ruleError(RuleFileContracts, err)
 	} else if err := validateArbitraryData(ms, txn); err != nil {
-		return err
-	} else if err := validateSignatures(ms, txn); err != nil {
-		return err
+		return ruleError(RuleArbitraryData, err)
+	} else if sigErr != nil && *sigErr != nil {
+		return ruleError(RuleSignatures, *sigErr)
+	} else if sigErr == nil {
+		if err := validateSignatures(ms, txn); err != nil {
+			return ruleError(RuleSignatures, err)
+		}
 	}
 	return nil
 }
@@ -556,42 +622,57 @@ func validateV2CurrencyOverflow(ms *MidState, txn types.V2Transaction) error {
 	}
 	add(txn.MinerFee)
 	if overflow {
-		return errors.New("transaction outputs exceed inputs") // technically true
+		return violation(ErrOverflow, "transaction outputs exceed inputs") // technically true
 	}
 	return nil
 }
 
-func validateV2Siacoins(ms *MidState, txn types.V2Transaction) error {
+func verifyPolicy(s State, medianTimestamp time.Time, sigHash types.Hash256, sp types.SatisfiedPolicy) error {
+	return sp.Policy.Verify(s.Index.Height, medianTimestamp, sigHash, sp.Signatures, sp.Preimages)
+}
+
+// policyErrorKind classifies a spend policy that failed verification. If the
+// policy would be satisfied at some later height and time, it is merely
+// timelocked; otherwise, e.g. because a signature is invalid, it can never be
+// satisfied.
+func policyErrorKind(sigHash types.Hash256, sp types.SatisfiedPolicy) ErrorKind {
+	if sp.Policy.Verify(math.MaxUint64, time.Unix(1<<62, 0), sigHash, sp.Signatures, sp.Preimages) == nil {
+		return ErrTimelocked
+	}
+	return ErrUnsatisfiedPolicy
+}
+
+func validateV2Siacoins(ms *MidState, txn types.V2Transaction, policyErrs []error) error {
 	sigHash := ms.base.InputSigHash(txn)
 	spent := make(map[types.SiacoinOutputID]int)
 	for i, sci := range txn.SiacoinInputs {
 		if txid, ok := ms.spent(sci.Parent.ID); ok {
-			return fmt.Errorf("siacoin input %v double-spends parent output (previously spent in %v)", i, txid)
+			return inputViolation(ErrConflict, i, sci.Parent.ID, "siacoin input %v double-spends parent output (previously spent in %v)", i, txid)
 		} else if j, ok := spent[sci.Parent.ID]; ok {
-			return fmt.Errorf("siacoin input %v double-spends parent output (previously spent by input %v)", i, j)
+			return inputViolation(ErrDuplicateInput, i, sci.Parent.ID, "siacoin input %v double-spends parent output (previously spent by input %v)", i, j)
 		} else if sci.Parent.MaturityHeight > ms.base.childHeight() {
-			return fmt.Errorf("siacoin input %v has immature parent", i)
+			return inputViolation(ErrImmature, i, sci.Parent.ID, "siacoin input %v has immature parent", i)
 		}
 		spent[sci.Parent.ID] = i
 
 		// check accumulator
 		if sci.Parent.StateElement.LeafIndex == types.UnassignedLeafIndex {
-			if i, ok := ms.elements[sci.Parent.ID]; !ok || !ms.sces[i].Created {
-				return fmt.Errorf("siacoin input %v spends nonexistent ephemeral output %v", i, sci.Parent.ID)
+			if j, ok := ms.elements[sci.Parent.ID]; !ok || !ms.sces[j].Created {
+				return inputViolation(ErrMissingElement, i, sci.Parent.ID, "siacoin input %v spends nonexistent ephemeral output %v", i, sci.Parent.ID)
 			}
 		} else if !ms.base.Elements.containsUnspentSiacoinElement(sci.Parent.Share()) {
 			if ms.base.Elements.containsSpentSiacoinElement(sci.Parent.Share()) {
-				return fmt.Errorf("siacoin input %v double-spends output %v", i, sci.Parent.ID)
+				return inputViolation(ErrConflict, i, sci.Parent.ID, "siacoin input %v double-spends output %v", i, sci.Parent.ID)
 			}
-			return fmt.Errorf("siacoin input %v spends output (%v) not present in the accumulator", i, sci.Parent.ID)
+			return inputViolation(ErrMissingElement, i, sci.Parent.ID, "siacoin input %v spends output (%v) not present in the accumulator", i, sci.Parent.ID)
 		}
 
 		// check spend policy
 		sp := sci.SatisfiedPolicy
 		if sp.Policy.Address() != sci.Parent.SiacoinOutput.Address {
-			return fmt.Errorf("siacoin input %v claims incorrect policy for parent address", i)
-		} else if err := sp.Policy.Verify(ms.base.Index.Height, ms.base.medianTimestamp(), sigHash, sp.Signatures, sp.Preimages); err != nil {
-			return fmt.Errorf("siacoin input %v failed to satisfy spend policy: %w", i, err)
+			return inputViolation(ErrIncorrectUnlock, i, sci.Parent.ID, "siacoin input %v claims incorrect policy for parent address", i)
+		} else if err := verifyInputPolicy(ms, sigHash, sp, policyErrs, i); err != nil {
+			return inputViolation(policyErrorKind(sigHash, sp), i, sci.Parent.ID, "siacoin input %v failed to satisfy spend policy: %w", i, err)
 		}
 	}
 
@@ -601,7 +682,7 @@ func validateV2Siacoins(ms *MidState, txn types.V2Transaction) error {
 	}
 	for i, out := range txn.SiacoinOutputs {
 		if out.Value.IsZero() {
-			return fmt.Errorf("siacoin output %v has zero value", i)
+			return outputViolation(ErrZeroValue, i, txn.SiacoinOutputID(txn.ID(), i), "siacoin output %v has zero value", i)
 		}
 		outputSum = outputSum.Add(out.Value)
 	}
@@ -621,41 +702,41 @@ func validateV2Siacoins(ms *MidState, txn types.V2Transaction) error {
 	}
 	outputSum = outputSum.Add(txn.MinerFee)
 	if inputSum != outputSum {
-		return fmt.Errorf("siacoin inputs (%v) do not equal outputs (%v)", inputSum, outputSum)
+		return violation(ErrUnbalanced, "siacoin inputs (%v) do not equal outputs (%v)", inputSum, outputSum)
 	}
 
 	return nil
 }
 
-func validateV2Siafunds(ms *MidState, txn types.V2Transaction) error {
+func validateV2Siafunds(ms *MidState, txn types.V2Transaction, policyErrs []error) error {
 	sigHash := ms.base.InputSigHash(txn)
 	spent := make(map[types.SiafundOutputID]int)
 	for i, sfi := range txn.SiafundInputs {
 		if txid, ok := ms.spent(sfi.Parent.ID); ok {
-			return fmt.Errorf("siafund input %v double-spends parent output (previously spent in %v)", i, txid)
+			return inputViolation(ErrConflict, i, sfi.Parent.ID, "siafund input %v double-spends parent output (previously spent in %v)", i, txid)
 		} else if j, ok := spent[sfi.Parent.ID]; ok {
-			return fmt.Errorf("siafund input %v double-spends parent output (previously spent by input %v)", i, j)
+			return inputViolation(ErrDuplicateInput, i, sfi.Parent.ID, "siafund input %v double-spends parent output (previously spent by input %v)", i, j)
 		}
 		spent[sfi.Parent.ID] = i
 
 		// check accumulator
 		if sfi.Parent.StateElement.LeafIndex == types.UnassignedLeafIndex {
-			if i, ok := ms.elements[sfi.Parent.ID]; !ok || !ms.sfes[i].Created {
-				return fmt.Errorf("siafund input %v spends nonexistent ephemeral output %v", i, sfi.Parent.ID)
+			if j, ok := ms.elements[sfi.Parent.ID]; !ok || !ms.sfes[j].Created {
+				return inputViolation(ErrMissingElement, i, sfi.Parent.ID, "siafund input %v spends nonexistent ephemeral output %v", i, sfi.Parent.ID)
 			}
 		} else if !ms.base.Elements.containsUnspentSiafundElement(sfi.Parent.Share()) {
 			if ms.base.Elements.containsSpentSiafundElement(sfi.Parent.Share()) {
-				return fmt.Errorf("siafund input %v double-spends output %v", i, sfi.Parent.ID)
+				return inputViolation(ErrConflict, i, sfi.Parent.ID, "siafund input %v double-spends output %v", i, sfi.Parent.ID)
 			}
-			return fmt.Errorf("siafund input %v spends output (%v) not present in the accumulator", i, sfi.Parent.ID)
+			return inputViolation(ErrMissingElement, i, sfi.Parent.ID, "siafund input %v spends output (%v) not present in the accumulator", i, sfi.Parent.ID)
 		}
 
 		// check spend policy
 		sp := sfi.SatisfiedPolicy
 		if sp.Policy.Address() != sfi.Parent.SiafundOutput.Address {
-			return fmt.Errorf("siafund input %v claims incorrect policy for parent address", i)
-		} else if err := sp.Policy.Verify(ms.base.Index.Height, ms.base.medianTimestamp(), sigHash, sp.Signatures, sp.Preimages); err != nil {
-			return fmt.Errorf("siafund input %v failed to satisfy spend policy: %w", i, err)
+			return inputViolation(ErrIncorrectUnlock, i, sfi.Parent.ID, "siafund input %v claims incorrect policy for parent address", i)
+		} else if err := verifyInputPolicy(ms, sigHash, sp, policyErrs, i); err != nil {
+			return inputViolation(policyErrorKind(sigHash, sp), i, sfi.Parent.ID, "siafund input %v failed to satisfy spend policy: %w", i, err)
 		}
 	}
 
@@ -665,12 +746,12 @@ func validateV2Siafunds(ms *MidState, txn types.V2Transaction) error {
 	}
 	for i, out := range txn.SiafundOutputs {
 		if out.Value == 0 {
-			return fmt.Errorf("siafund output %v has zero value", i)
+			return outputViolation(ErrZeroValue, i, txn.SiafundOutputID(txn.ID(), i), "siafund output %v has zero value", i)
 		}
 		outputSum += out.Value
 	}
 	if inputSum != outputSum {
-		return fmt.Errorf("siafund inputs (%d SF) do not equal outputs (%d SF)", inputSum, outputSum)
+		return violation(ErrUnbalanced, "siafund inputs (%d SF) do not equal outputs (%d SF)", inputSum, outputSum)
 	}
 	return nil
 }
@@ -678,51 +759,51 @@ func validateV2Siafunds(ms *MidState, txn types.V2Transaction) error {
 func validateV2FileContracts(ms *MidState, txn types.V2Transaction) error {
 	revised := make(map[types.FileContractID]int)
 	resolved := make(map[types.FileContractID]int)
-	validateParent := func(fce types.V2FileContractElement) error {
+	validateParent := func(fce types.V2FileContractElement) *ValidationError {
 		if txid, ok := ms.spent(fce.ID); ok {
-			return fmt.Errorf("has already been resolved in transaction %v", txid)
+			return violation(ErrConflict, "has already been resolved in transaction %v", txid)
 		} else if i, ok := revised[fce.ID]; ok {
-			return fmt.Errorf("has already been revised by contract revision %v", i)
+			return violation(ErrDuplicateInput, "has already been revised by contract revision %v", i)
 		} else if i, ok := resolved[fce.ID]; ok {
-			return fmt.Errorf("has already been resolved by contract resolution %v", i)
+			return violation(ErrDuplicateInput, "has already been resolved by contract resolution %v", i)
 		} else if !ms.base.Elements.containsUnresolvedV2FileContractElement(fce.Share()) {
 			if ms.base.Elements.containsResolvedV2FileContractElement(fce.Share()) {
-				return errors.New("has already been resolved in a previous block")
+				return violation(ErrConflict, "has already been resolved in a previous block")
 			}
-			return errors.New("is not present in the accumulator")
+			return violation(ErrMissingElement, "is not present in the accumulator")
 		}
 		return nil
 	}
 
-	validateSignatures := func(fc types.V2FileContract, renter, host types.PublicKey) error {
+	validateSignatures := func(fc types.V2FileContract, renter, host types.PublicKey) *ValidationError {
 		contractHash := ms.base.ContractSigHash(fc)
 		if !renter.VerifyHash(contractHash, fc.RenterSignature) {
-			return errors.New("has invalid renter signature")
+			return violation(ErrInvalidSignature, "has invalid renter signature")
 		} else if !host.VerifyHash(contractHash, fc.HostSignature) {
-			return errors.New("has invalid host signature")
+			return violation(ErrInvalidSignature, "has invalid host signature")
 		}
 		return nil
 	}
 
-	validateContract := func(fc types.V2FileContract) error {
+	validateContract := func(fc types.V2FileContract) *ValidationError {
 		switch {
 		case fc.Filesize > fc.Capacity:
-			return fmt.Errorf("has filesize (%v) exceeding capacity (%v)", fc.Filesize, fc.Capacity)
+			return violation(ErrInvalidContract, "has filesize (%v) exceeding capacity (%v)", fc.Filesize, fc.Capacity)
 		case fc.ProofHeight < ms.base.childHeight():
-			return fmt.Errorf("has proof height (%v) that has already passed", fc.ProofHeight)
+			return violation(ErrInvalidContract, "has proof height (%v) that has already passed", fc.ProofHeight)
 		case fc.ExpirationHeight <= fc.ProofHeight:
-			return fmt.Errorf("leaves no time between proof height (%v) and expiration height (%v)", fc.ProofHeight, fc.ExpirationHeight)
+			return violation(ErrInvalidContract, "leaves no time between proof height (%v) and expiration height (%v)", fc.ProofHeight, fc.ExpirationHeight)
 		case fc.RenterOutput.Value.IsZero() && fc.HostOutput.Value.IsZero():
-			return fmt.Errorf("has zero value")
+			return violation(ErrZeroValue, "has zero value")
 		case fc.MissedHostValue.Cmp(fc.HostOutput.Value) > 0:
-			return fmt.Errorf("has missed host value (%v) exceeding valid host value (%v)", fc.MissedHostValue, fc.HostOutput.Value)
+			return violation(ErrInvalidContract, "has missed host value (%v) exceeding valid host value (%v)", fc.MissedHostValue, fc.HostOutput.Value)
 		case fc.TotalCollateral.Cmp(fc.HostOutput.Value) > 0:
-			return fmt.Errorf("has total collateral (%v) exceeding valid host value (%v)", fc.TotalCollateral, fc.HostOutput.Value)
+			return violation(ErrInvalidContract, "has total collateral (%v) exceeding valid host value (%v)", fc.TotalCollateral, fc.HostOutput.Value)
 		}
 		return validateSignatures(fc, fc.RenterPublicKey, fc.HostPublicKey)
 	}
 
-	validateRevision := func(fce types.V2FileContractElement, rev types.V2FileContract) error {
+	validateRevision := func(fce types.V2FileContractElement, rev types.V2FileContract) *ValidationError {
 		cur := fce.V2FileContract
 		// check for prior revision within block
 		if i, ok := ms.elements[fce.ID]; ok && ms.v2fces[i].Revision != nil {
@@ -732,49 +813,53 @@ func validateV2FileContracts(ms *MidState, txn types.V2Transaction) error {
 		revOutputSum := rev.RenterOutput.Value.Add(rev.HostOutput.Value)
 		switch {
 		case rev.Capacity < cur.Capacity:
-			return fmt.Errorf("decreases capacity")
+			return violation(ErrInvalidRevision, "decreases capacity")
 		case rev.Filesize > rev.Capacity:
-			return fmt.Errorf("has filesize (%v) exceeding capacity (%v)", rev.Filesize, rev.Capacity)
+			return violation(ErrInvalidRevision, "has filesize (%v) exceeding capacity (%v)", rev.Filesize, rev.Capacity)
 		case cur.ProofHeight < ms.base.childHeight():
-			return fmt.Errorf("revises contract after its proof window has opened")
+			return violation(ErrInvalidRevision, "revises contract after its proof window has opened")
 		case rev.RevisionNumber <= cur.RevisionNumber:
-			return fmt.Errorf("does not increase revision number (%v -> %v)", cur.RevisionNumber, rev.RevisionNumber)
+			return violation(ErrInvalidRevision, "does not increase revision number (%v -> %v)", cur.RevisionNumber, rev.RevisionNumber)
 		case !revOutputSum.Equals(curOutputSum):
-			return fmt.Errorf("modifies output sum (%v -> %v)", curOutputSum, revOutputSum)
+			return violation(ErrInvalidRevision, "modifies output sum (%v -> %v)", curOutputSum, revOutputSum)
 		case rev.MissedHostValue.Cmp(cur.MissedHostValue) > 0:
-			return fmt.Errorf("has missed host value (%v) exceeding old value (%v)", rev.MissedHostValue, cur.MissedHostValue)
+			return violation(ErrInvalidRevision, "has missed host value (%v) exceeding old value (%v)", rev.MissedHostValue, cur.MissedHostValue)
 		case rev.TotalCollateral != cur.TotalCollateral:
-			return errors.New("modifies total collateral")
+			return violation(ErrInvalidRevision, "modifies total collateral")
 		case rev.ProofHeight < ms.base.childHeight():
-			return fmt.Errorf("has proof height (%v) that has already passed", rev.ProofHeight)
+			return violation(ErrInvalidRevision, "has proof height (%v) that has already passed", rev.ProofHeight)
 		case rev.ExpirationHeight <= rev.ProofHeight:
-			return fmt.Errorf("leaves no time between proof height (%v) and expiration height (%v)", rev.ProofHeight, rev.ExpirationHeight)
+			return violation(ErrInvalidRevision, "leaves no time between proof height (%v) and expiration height (%v)", rev.ProofHeight, rev.ExpirationHeight)
 		}
 		// NOTE: very important that we verify with the *current* keys!
 		return validateSignatures(rev, cur.RenterPublicKey, cur.HostPublicKey)
 	}
 
+	var txid types.TransactionID
+	if len(txn.FileContracts) > 0 {
+		txid = txn.ID()
+	}
 	for i, fc := range txn.FileContracts {
 		if err := validateContract(fc); err != nil {
-			return fmt.Errorf("file contract %v %s", i, err)
+			return outputViolation(err.Kind, i, txn.V2FileContractID(txid, i), "file contract %v %s", i, err.Err)
 		}
 	}
 
 	for i, fcr := range txn.FileContractRevisions {
 		cur, rev := fcr.Parent.V2FileContract, fcr.Revision
 		if err := validateParent(fcr.Parent.Share()); err != nil {
-			return fmt.Errorf("file contract revision %v parent (%v) %s", i, fcr.Parent.ID, err)
+			return inputViolation(err.Kind, i, fcr.Parent.ID, "file contract revision %v parent (%v) %s", i, fcr.Parent.ID, err.Err)
 		} else if cur.ProofHeight < ms.base.childHeight() {
-			return fmt.Errorf("file contract revision %v cannot be applied to contract after proof height (%v)", i, cur.ProofHeight)
+			return inputViolation(ErrInvalidRevision, i, fcr.Parent.ID, "file contract revision %v cannot be applied to contract after proof height (%v)", i, cur.ProofHeight)
 		} else if err := validateRevision(fcr.Parent.Share(), rev); err != nil {
-			return fmt.Errorf("file contract revision %v %s", i, err)
+			return inputViolation(err.Kind, i, fcr.Parent.ID, "file contract revision %v %s", i, err.Err)
 		}
 		revised[fcr.Parent.ID] = i
 	}
 
 	for i, fcr := range txn.FileContractResolutions {
 		if err := validateParent(fcr.Parent.Share()); err != nil {
-			return fmt.Errorf("file contract renewal %v parent (%v) %s", i, fcr.Parent.ID, err)
+			return inputViolation(err.Kind, i, fcr.Parent.ID, "file contract renewal %v parent (%v) %s", i, fcr.Parent.ID, err.Err)
 		}
 		fc := fcr.Parent.V2FileContract
 		switch r := fcr.Resolution.(type) {
@@ -782,9 +867,9 @@ func validateV2FileContracts(ms *MidState, txn types.V2Transaction) error {
 			renewal := *r
 
 			if fc.RenterPublicKey != renewal.NewContract.RenterPublicKey {
-				return fmt.Errorf("file contract renewal %v changes renter public key", i)
+				return inputViolation(ErrInvalidRenewal, i, fcr.Parent.ID, "file contract renewal %v changes renter public key", i)
 			} else if fc.HostPublicKey != renewal.NewContract.HostPublicKey {
-				return fmt.Errorf("file contract renewal %v changes host public key", i)
+				return inputViolation(ErrInvalidRenewal, i, fcr.Parent.ID, "file contract renewal %v changes host public key", i)
 			}
 
 			// validate that the renewal value is equal to existing contract's value.
@@ -794,38 +879,38 @@ func validateV2FileContracts(ms *MidState, txn types.V2Transaction) error {
 				Add(renewal.FinalHostOutput.Value).Add(renewal.HostRollover)
 			existingPayout := fc.RenterOutput.Value.Add(fc.HostOutput.Value)
 			if totalPayout != existingPayout {
-				return fmt.Errorf("file contract renewal %v renewal payout (%v) does not match existing contract payout %v", i, totalPayout, existingPayout)
+				return inputViolation(ErrInvalidRenewal, i, fcr.Parent.ID, "file contract renewal %v renewal payout (%v) does not match existing contract payout %v", i, totalPayout, existingPayout)
 			}
 
 			newContractCost := renewal.NewContract.RenterOutput.Value.Add(renewal.NewContract.HostOutput.Value).Add(ms.base.V2FileContractTax(renewal.NewContract))
 			if rollover := renewal.RenterRollover.Add(renewal.HostRollover); rollover.Cmp(newContractCost) > 0 {
-				return fmt.Errorf("file contract renewal %v has rollover (%v) exceeding new contract cost (%v)", i, rollover, newContractCost)
+				return inputViolation(ErrInvalidRenewal, i, fcr.Parent.ID, "file contract renewal %v has rollover (%v) exceeding new contract cost (%v)", i, rollover, newContractCost)
 			} else if err := validateContract(renewal.NewContract); err != nil {
-				return fmt.Errorf("file contract renewal %v initial revision %s", i, err)
+				return inputViolation(err.Kind, i, fcr.Parent.ID, "file contract renewal %v initial revision %s", i, err.Err)
 			}
 			renewalHash := ms.base.RenewalSigHash(renewal)
 			if !fc.RenterPublicKey.VerifyHash(renewalHash, renewal.RenterSignature) {
-				return fmt.Errorf("file contract renewal %v has invalid renter signature", i)
+				return inputViolation(ErrInvalidSignature, i, fcr.Parent.ID, "file contract renewal %v has invalid renter signature", i)
 			} else if !fc.HostPublicKey.VerifyHash(renewalHash, renewal.HostSignature) {
-				return fmt.Errorf("file contract renewal %v has invalid host signature", i)
+				return inputViolation(ErrInvalidSignature, i, fcr.Parent.ID, "file contract renewal %v has invalid host signature", i)
 			}
 		case *types.V2StorageProof:
 			sp := *r
 			if ms.base.childHeight() < fc.ProofHeight {
-				return fmt.Errorf("file contract storage proof %v cannot be submitted until after proof height (%v)", i, fc.ProofHeight)
+				return inputViolation(ErrPrematureResolution, i, fcr.Parent.ID, "file contract storage proof %v cannot be submitted until after proof height (%v)", i, fc.ProofHeight)
 			} else if sp.ProofIndex.ChainIndex.Height != fc.ProofHeight {
 				// see note on this field in types.StorageProof
-				return fmt.Errorf("file contract storage proof %v has ProofIndex height (%v) that does not match contract ProofHeight (%v)", i, sp.ProofIndex.ChainIndex.Height, fc.ProofHeight)
+				return inputViolation(ErrInvalidStorageProof, i, fcr.Parent.ID, "file contract storage proof %v has ProofIndex height (%v) that does not match contract ProofHeight (%v)", i, sp.ProofIndex.ChainIndex.Height, fc.ProofHeight)
 			} else if !ms.base.Elements.containsChainIndex(sp.ProofIndex.Share()) {
-				return fmt.Errorf("file contract storage proof %v has invalid history proof", i)
+				return inputViolation(ErrMissingElement, i, fcr.Parent.ID, "file contract storage proof %v has invalid history proof", i)
 			}
 			leafIndex := ms.base.StorageProofLeafIndex(fc.Filesize, sp.ProofIndex.ChainIndex.ID, types.FileContractID(fcr.Parent.ID))
 			if storageProofRoot(ms.base.StorageProofLeafHash(sp.Leaf[:]), leafIndex, fc.Filesize, sp.Proof) != fc.FileMerkleRoot {
-				return fmt.Errorf("file contract storage proof %v has root that does not match contract Merkle root", i)
+				return inputViolation(ErrInvalidStorageProof, i, fcr.Parent.ID, "file contract storage proof %v has root that does not match contract Merkle root", i)
 			}
 		case *types.V2FileContractExpiration:
 			if ms.base.childHeight() <= fc.ExpirationHeight {
-				return fmt.Errorf("file contract expiration %v cannot be submitted until after expiration height (%v) ", i, fc.ExpirationHeight)
+				return inputViolation(ErrPrematureResolution, i, fcr.Parent.ID, "file contract expiration %v cannot be submitted until after expiration height (%v) ", i, fc.ExpirationHeight)
 			}
 		}
 		resolved[fcr.Parent.ID] = i
@@ -838,9 +923,9 @@ func validateAttestations(ms *MidState, txn types.V2Transaction) error {
 	for i, a := range txn.Attestations {
 		switch {
 		case len(a.Key) == 0:
-			return fmt.Errorf("attestation %v has empty key", i)
+			return outputViolation(ErrMalformed, i, txn.AttestationID(txn.ID(), i), "attestation %v has empty key", i)
 		case !a.PublicKey.VerifyHash(ms.base.AttestationSigHash(a), a.Signature):
-			return fmt.Errorf("attestation %v has invalid signature", i)
+			return outputViolation(ErrInvalidSignature, i, txn.AttestationID(txn.ID(), i), "attestation %v has invalid signature", i)
 		}
 	}
 	return nil
@@ -855,93 +940,199 @@ func validateFoundationUpdate(ms *MidState, txn types.V2Transaction) error {
 			return nil
 		}
 	}
-	return errors.New("transaction changes Foundation address, but does not spend an input controlled by current address")
+	return violation(ErrUnauthorized, "transaction changes Foundation address, but does not spend an input controlled by current address")
 }
 
 // ValidateV2Transaction validates txn within the context of ms.
 func ValidateV2Transaction(ms *MidState, txn types.V2Transaction) error {
+	return validateV2Transaction(ms, txn, v2PolicyResults{})
+}
+
+// validateV2Transaction validates txn. If the slices of pr are non-nil, they
+// are used as the results of verifying each input's spend policy.
+func validateV2Transaction(ms *MidState, txn types.V2Transaction, pr v2PolicyResults) error {
 	if ms.base.childHeight() < ms.base.Network.HardforkV2.AllowHeight {
-		return errors.New("v2 transactions are not allowed until v2 hardfork begins")
+		return ruleError(RuleVersion, violation(ErrV2NotAllowed, "v2 transactions are not allowed until v2 hardfork begins"))
 	} else if err := validateV2CurrencyOverflow(ms, txn); err != nil {
-		return err
+		return ruleError(RuleOverflow, err)
 	} else if weight := ms.base.V2TransactionWeight(txn); weight == 0 {
-		return errors.New("transactions cannot be empty")
+		return ruleError(RuleWeight, violation(ErrWeight, "transactions cannot be empty"))
 	} else if weight > ms.base.MaxBlockWeight() {
-		return fmt.Errorf("transaction exceeds maximum block weight (%v > %v)", weight, ms.base.MaxBlockWeight())
-	} else if err := validateV2Siacoins(ms, txn); err != nil {
-		return err
-	} else if err := validateV2Siafunds(ms, txn); err != nil {
-		return err
+		return ruleError(RuleWeight, violation(ErrWeight, "transaction exceeds maximum block weight (%v > %v)", weight, ms.base.MaxBlockWeight()))
+	} else if err := validateV2Siacoins(ms, txn, pr.siacoins); err != nil {
+		return ruleError(RuleSiacoins, err)
+	} else if err := validateV2Siafunds(ms, txn, pr.siafunds); err != nil {
+		return ruleError(RuleSiafunds, err)
 	} else if err := validateV2FileContracts(ms, txn); err != nil {
-		return err
+		return ruleError(RuleFileContracts, err)
 	} else if err := validateAttestations(ms, txn); err != nil {
-		return err
+		return ruleError(RuleAttestations, err)
 	} else if err := validateFoundationUpdate(ms, txn); err != nil {
-		return err
+		return ruleError(RuleFoundationAddr, err)
 	}
 	return nil
 }
 
-func validateSupplement(s State, b types.Block, bs V1BlockSupplement) error {
+func validateSupplement(s State, b types.Block, bs V1BlockSupplement) *ValidationError {
 	if len(bs.Transactions) != len(b.Transactions) {
-		return errors.New("incorrect number of transactions")
+		return violation(ErrSupplement, "incorrect number of transactions")
 	}
 	for _, txn := range bs.Transactions {
 		for _, sce := range txn.SiacoinInputs {
 			if !s.Elements.containsUnspentSiacoinElement(sce.Share()) {
-				return fmt.Errorf("siacoin element %v is not present in the accumulator", sce.ID)
+				return violation(ErrSupplement, "siacoin element %v is not present in the accumulator", sce.ID)
 			}
 		}
 		for _, sfe := range txn.SiafundInputs {
 			if !s.Elements.containsUnspentSiafundElement(sfe.Share()) {
-				return fmt.Errorf("siafund element %v is not present in the accumulator", sfe.ID)
+				return violation(ErrSupplement, "siafund element %v is not present in the accumulator", sfe.ID)
 			}
 		}
 		for _, fce := range txn.RevisedFileContracts {
 			if !s.Elements.containsUnresolvedFileContractElement(fce.Share()) {
-				return fmt.Errorf("revised file contract %v is not present in the accumulator", fce.ID)
+				return violation(ErrSupplement, "revised file contract %v is not present in the accumulator", fce.ID)
 			}
 		}
 		for _, sps := range txn.StorageProofs {
 			if !s.Elements.containsUnresolvedFileContractElement(sps.FileContract.Share()) {
-				return fmt.Errorf("valid file contract %v is not present in the accumulator", sps.FileContract.ID)
+				return violation(ErrSupplement, "valid file contract %v is not present in the accumulator", sps.FileContract.ID)
 			}
 		}
 	}
 	for _, fce := range bs.ExpiringFileContracts {
 		if !s.Elements.containsUnresolvedFileContractElement(fce.Share()) {
-			return fmt.Errorf("expiring file contract %v is not present in the accumulator", fce.ID)
+			return violation(ErrSupplement, "expiring file contract %v is not present in the accumulator", fce.ID)
 		}
 	}
 	return nil
 }
 
+// verifyInputPolicy returns the result of verifying the spend policy of the
+// i'th input, using the precomputed result if available.
+func verifyInputPolicy(ms *MidState, sigHash types.Hash256, sp types.SatisfiedPolicy, policyErrs []error, i int) error {
+	if policyErrs != nil {
+		return policyErrs[i]
+	}
+	return verifyPolicy(ms.base, ms.base.medianTimestamp(), sigHash, sp)
+}
+
+// v2PolicyResults holds the results of verifying the spend policies of a v2
+// transaction's inputs.
+type v2PolicyResults struct {
+	siacoins []error
+	siafunds []error
+}
+
+// blockSignatures holds the results of verifying the signatures and spend
+// policies of each transaction in a block.
+type blockSignatures struct {
+	v1 []error
+	v2 []v2PolicyResults
+}
+
+// verifyBlockSignatures verifies the signatures and spend policies of each
+// transaction in b using the specified number of goroutines. This is possible
+// because signature verification depends only on the parent state and the
+// transaction itself, not on any prior transactions in the block.
+func verifyBlockSignatures(s State, b types.Block, workers int) blockSignatures {
+	v2txns := b.V2Transactions()
+	bsigs := blockSignatures{
+		v1: make([]error, len(b.Transactions)),
+		v2: make([]v2PolicyResults, len(v2txns)),
+	}
+	base := &MidState{base: s}
+	medianTimestamp := s.medianTimestamp()
+	var jobs []func()
+	for i, txn := range b.Transactions {
+		jobs = append(jobs, func() { bsigs.v1[i] = validateSignatures(base, txn) })
+	}
+	for i, txn := range v2txns {
+		sigHash := s.InputSigHash(txn)
+		pr := v2PolicyResults{
+			siacoins: make([]error, len(txn.SiacoinInputs)),
+			siafunds: make([]error, len(txn.SiafundInputs)),
+		}
+		for j, sci := range txn.SiacoinInputs {
+			jobs = append(jobs, func() { pr.siacoins[j] = verifyPolicy(s, medianTimestamp, sigHash, sci.SatisfiedPolicy) })
+		}
+		for j, sfi := range txn.SiafundInputs {
+			jobs = append(jobs, func() { pr.siafunds[j] = verifyPolicy(s, medianTimestamp, sigHash, sfi.SatisfiedPolicy) })
+		}
+		bsigs.v2[i] = pr
+	}
+
+	workers = min(workers, len(jobs))
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for range workers {
+		go func() {
+			defer wg.Done()
+			for j := next.Add(1) - 1; j < int64(len(jobs)); j = next.Add(1) - 1 {
+				jobs[j]()
+			}
+		}()
+	}
+	wg.Wait()
+	return bsigs
+}
+
 // ValidateBlock validates b in the context of s.
 //
 // This function does not check whether the header's timestamp is too far in the
 // future. That check should be performed at the time the block is received,
 // e.g. in p2p networking code; see MaxFutureTimestamp.
 func ValidateBlock(s State, b types.Block, bs V1BlockSupplement) error {
+	return validateBlock(s, b, bs, 0)
+}
+
+// ValidateBlockParallel validates b in the context of s, like ValidateBlock,
+// but verifies transaction signatures and spend policies concurrently using up
+// to workers goroutines. If workers is zero or negative, runtime.NumCPU() is
+// used. ValidateBlockParallel always returns the same error as ValidateBlock.
+func ValidateBlockParallel(s State, b types.Block, bs V1BlockSupplement, workers int) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return validateBlock(s, b, bs, workers)
+}
+
+// validateBlock validates b. If workers is non-zero, signatures are verified
+// concurrently before the block's transactions are validated.
+func validateBlock(s State, b types.Block, bs V1BlockSupplement, workers int) error {
 	if err := ValidateOrphan(s, b); err != nil {
 		return err
 	} else if err := validateSupplement(s, b, bs); err != nil {
-		return fmt.Errorf("block supplement is invalid: %w", err)
+		err.Err = fmt.Errorf("block supplement is invalid: %w", err.Err)
+		return ruleError(RuleSupplement, err)
 	}
 	if b.V2 != nil {
 		if b.V2.Commitment != s.Commitment(s.TransactionsCommitment(b.Transactions, b.V2Transactions()), b.MinerPayouts[0].Address) {
-			return errors.New("commitment hash mismatch")
+			return ruleError(RuleCommitment, violation(ErrCommitment, "commitment hash mismatch"))
 		}
 	}
+	var bsigs blockSignatures
+	if workers > 0 {
+		bsigs = verifyBlockSignatures(s, b, workers)
+	}
 	ms := NewMidState(s)
 	for i, txn := range b.Transactions {
-		if err := ValidateTransaction(ms, txn, bs.Transactions[i]); err != nil {
-			return fmt.Errorf("transaction %v is invalid: %w", i, err)
+		var sigErr *error
+		if bsigs.v1 != nil {
+			sigErr = &bsigs.v1[i]
+		}
+		if err := validateTransaction(ms, txn, bs.Transactions[i], sigErr); err != nil {
+			return transactionError(i, false, err)
 		}
 		ms.ApplyTransaction(txn, bs.Transactions[i])
 	}
 	for i, txn := range b.V2Transactions() {
-		if err := ValidateV2Transaction(ms, txn); err != nil {
-			return fmt.Errorf("v2 transaction %v is invalid: %w", i, err)
+		var pr v2PolicyResults
+		if bsigs.v2 != nil {
+			pr = bsigs.v2[i]
+		}
+		if err := validateV2Transaction(ms, txn, pr); err != nil {
+			return transactionError(i, true, err)
 		}
 		ms.ApplyV2Transaction(txn)
 	}
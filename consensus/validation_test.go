@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math"
 	"math/bits"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -387,6 +388,8 @@ func TestValidateBlock(t *testing.T) {
 	findBlockNonce(cs, &validBlock)
 	if err := ValidateBlock(cs, validBlock, db.supplementTipBlock(validBlock)); err != nil {
 		t.Fatal(err)
+	} else if err := ValidateBlockParallel(cs, validBlock, db.supplementTipBlock(validBlock), 4); err != nil {
+		t.Fatal(err)
 	}
 
 	// clear signatures to avoid false positives
@@ -772,8 +775,11 @@ func TestValidateBlock(t *testing.T) {
 			}
 			findBlockNonce(cs, &corruptBlock)
 
-			if err := ValidateBlock(cs, corruptBlock, db.supplementTipBlock(corruptBlock)); err == nil || !strings.Contains(err.Error(), test.errString) {
+			err := ValidateBlock(cs, corruptBlock, db.supplementTipBlock(corruptBlock))
+			if err == nil || !strings.Contains(err.Error(), test.errString) {
 				t.Fatalf("expected error containing %q, got %v", test.errString, err)
+			} else if perr := ValidateBlockParallel(cs, corruptBlock, db.supplementTipBlock(corruptBlock), 4); perr == nil || perr.Error() != err.Error() {
+				t.Fatalf("expected parallel validation to return %q, got %v", err, perr)
 			}
 		}
 	}
@@ -839,6 +845,32 @@ func TestValidateBlock(t *testing.T) {
 					txn.Signatures[0].CoveredFields.FileContractRevisions = []uint64{0}
 				},
 			},
+			{
+				"partial signature covering nonexistent fields",
+				func(b *types.Block) {
+					txn := &b.Transactions[0]
+					txn.Signatures[0].CoveredFields = types.CoveredFields{SiacoinInputs: []uint64{5}}
+				},
+			},
+			{
+				"whole transaction signature covering nonexistent signatures",
+				func(b *types.Block) {
+					txn := &b.Transactions[0]
+					txn.Signatures[0].CoveredFields.Signatures = []uint64{99}
+				},
+			},
+			{
+				"invalid transaction followed by signature covering nonexistent fields",
+				func(b *types.Block) {
+					// the second transaction is never reached by sequential
+					// validation, so it must not crash parallel validation
+					b.Transactions[0].SiacoinOutputs[0].Value = types.ZeroCurrency
+					txn := b.Transactions[0]
+					txn.Signatures = append([]types.TransactionSignature(nil), txn.Signatures...)
+					txn.Signatures[0].CoveredFields = types.CoveredFields{SiacoinInputs: []uint64{5}}
+					b.Transactions = append(b.Transactions, txn)
+				},
+			},
 		}
 		for _, test := range tests {
 			corruptBlock := deepCopyBlock(validBlock)
@@ -848,13 +880,55 @@ func TestValidateBlock(t *testing.T) {
 			test.corrupt(&corruptBlock)
 			findBlockNonce(cs, &corruptBlock)
 
-			if err := ValidateBlock(cs, corruptBlock, db.supplementTipBlock(corruptBlock)); err == nil {
+			err := ValidateBlock(cs, corruptBlock, db.supplementTipBlock(corruptBlock))
+			if err == nil {
 				t.Fatalf("accepted block with %v", test.desc)
+			} else if perr := ValidateBlockParallel(cs, corruptBlock, db.supplementTipBlock(corruptBlock), 4); perr == nil || perr.Error() != err.Error() {
+				t.Fatalf("expected parallel validation to return %q, got %v", err, perr)
 			}
 		}
 	}
 }
 
+func TestCoveredFieldsOutOfRange(t *testing.T) {
+	n, genesisBlock := testnet()
+	n.InitialTarget = types.BlockID{0xFF}
+
+	sk := types.GeneratePrivateKey()
+	uc := types.StandardUnlockConditions(sk.PublicKey())
+	genesisBlock.Transactions = []types.Transaction{{
+		SiacoinOutputs: []types.SiacoinOutput{{Address: uc.UnlockHash(), Value: types.Siacoins(1)}},
+	}}
+	db, cs := newConsensusDB(n, genesisBlock)
+	scoid := genesisBlock.Transactions[0].SiacoinOutputID(0)
+
+	txn := types.Transaction{
+		SiacoinInputs:  []types.SiacoinInput{{ParentID: scoid, UnlockConditions: uc}},
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: types.Siacoins(1)}},
+		Signatures: []types.TransactionSignature{{
+			ParentID:      types.Hash256(scoid),
+			CoveredFields: types.CoveredFields{SiacoinInputs: []uint64{5}},
+		}},
+	}
+	b := types.Block{
+		ParentID:     cs.Index.ID,
+		Timestamp:    types.CurrentTimestamp(),
+		MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+		Transactions: []types.Transaction{txn},
+	}
+	findBlockNonce(cs, &b)
+	bs := db.supplementTipBlock(b)
+
+	// previously, these panicked in PartialSigHash
+	if err := ValidateTransaction(NewMidState(cs), txn, bs.Transactions[0]); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("expected %v, got %v", ErrMalformed, err)
+	} else if err := ValidateBlock(cs, b, bs); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("expected %v, got %v", ErrMalformed, err)
+	} else if err := ValidateBlockParallel(cs, b, bs, 4); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("expected %v, got %v", ErrMalformed, err)
+	}
+}
+
 func updateProofs(au ApplyUpdate, sces []types.SiacoinElement, sfes []types.SiafundElement, fces []types.V2FileContractElement, cies []types.ChainIndexElement) {
 	for i := range sces {
 		au.UpdateElementProof(&sces[i].StateElement)
@@ -1017,6 +1091,8 @@ func TestValidateV2Block(t *testing.T) {
 	validBlock := deepCopyBlock(b)
 	if err := ValidateBlock(cs, validBlock, db.supplementTipBlock(validBlock)); err != nil {
 		t.Fatal(err)
+	} else if err := ValidateBlockParallel(cs, validBlock, db.supplementTipBlock(validBlock), 4); err != nil {
+		t.Fatal(err)
 	}
 
 	{
@@ -1349,8 +1425,11 @@ func TestValidateV2Block(t *testing.T) {
 			}
 			findBlockNonce(cs, &corruptBlock)
 
-			if err := ValidateBlock(cs, corruptBlock, db.supplementTipBlock(corruptBlock)); err == nil || !strings.Contains(err.Error(), test.errString) {
+			err := ValidateBlock(cs, corruptBlock, db.supplementTipBlock(corruptBlock))
+			if err == nil || !strings.Contains(err.Error(), test.errString) {
 				t.Fatalf("expected error containing %q, got %v", test.errString, err)
+			} else if perr := ValidateBlockParallel(cs, corruptBlock, db.supplementTipBlock(corruptBlock), 4); perr == nil || perr.Error() != err.Error() {
+				t.Fatalf("expected parallel validation to return %q, got %v", err, perr)
 			}
 		}
 	}
@@ -1443,6 +1522,8 @@ func TestValidateV2Block(t *testing.T) {
 	validBlock = deepCopyBlock(b)
 	if err := ValidateBlock(cs, validBlock, db.supplementTipBlock(validBlock)); err != nil {
 		t.Fatal(err)
+	} else if err := ValidateBlockParallel(cs, validBlock, db.supplementTipBlock(validBlock), 4); err != nil {
+		t.Fatal(err)
 	}
 
 	{
@@ -1555,8 +1636,11 @@ func TestValidateV2Block(t *testing.T) {
 			}
 			findBlockNonce(cs, &corruptBlock)
 
-			if err := ValidateBlock(cs, corruptBlock, db.supplementTipBlock(corruptBlock)); err == nil {
+			err := ValidateBlock(cs, corruptBlock, db.supplementTipBlock(corruptBlock))
+			if err == nil {
 				t.Fatalf("accepted block with %v", test.desc)
+			} else if perr := ValidateBlockParallel(cs, corruptBlock, db.supplementTipBlock(corruptBlock), 4); perr == nil || perr.Error() != err.Error() {
+				t.Fatalf("expected parallel validation to return %q, got %v", err, perr)
 			}
 		}
 	}
@@ -2180,3 +2264,102 @@ func TestV2RenewalResolution(t *testing.T) {
 		})
 	}
 }
+
+// manySpendsBlock returns a block containing n v2 transactions, each spending
+// a separate output controlled by a separate key.
+func manySpendsBlock(tb testing.TB, n int) (State, types.Block) {
+	network, genesisBlock := testnet()
+	network.HardforkV2.AllowHeight = 0
+	network.HardforkV2.RequireHeight = 0
+
+	keys := make([]types.PrivateKey, n)
+	genesisTxn := types.V2Transaction{SiacoinOutputs: make([]types.SiacoinOutput, n)}
+	for i := range keys {
+		keys[i] = types.GeneratePrivateKey()
+		genesisTxn.SiacoinOutputs[i] = types.SiacoinOutput{
+			Address: types.StandardAddress(keys[i].PublicKey()),
+			Value:   types.Siacoins(1),
+		}
+	}
+	genesisBlock.V2 = &types.V2BlockData{Transactions: []types.V2Transaction{genesisTxn}}
+	cs, au := ApplyBlock(network.GenesisState(), genesisBlock, V1BlockSupplement{}, time.Time{})
+
+	b := types.Block{
+		ParentID:     cs.Index.ID,
+		Timestamp:    types.CurrentTimestamp(),
+		MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+		V2:           &types.V2BlockData{Height: 1},
+	}
+	for _, sced := range au.SiacoinElementDiffs() {
+		sce := sced.SiacoinElement
+		i := slices.IndexFunc(keys, func(k types.PrivateKey) bool {
+			return types.StandardAddress(k.PublicKey()) == sce.SiacoinOutput.Address
+		})
+		if i < 0 {
+			continue
+		}
+		txn := types.V2Transaction{
+			SiacoinInputs: []types.V2SiacoinInput{{
+				Parent:          sce.Copy(),
+				SatisfiedPolicy: types.SatisfiedPolicy{Policy: types.PolicyPublicKey(keys[i].PublicKey())},
+			}},
+			SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: sce.SiacoinOutput.Value}},
+		}
+		txn.SiacoinInputs[0].SatisfiedPolicy.Signatures = []types.Signature{keys[i].SignHash(cs.InputSigHash(txn))}
+		b.V2.Transactions = append(b.V2.Transactions, txn)
+	}
+	b.V2.Commitment = cs.Commitment(cs.TransactionsCommitment(b.Transactions, b.V2Transactions()), b.MinerPayouts[0].Address)
+	findBlockNonce(cs, &b)
+	if err := ValidateBlock(cs, b, V1BlockSupplement{}); err != nil {
+		tb.Fatal(err)
+	}
+	return cs, b
+}
+
+func TestValidateBlockParallel(t *testing.T) {
+	cs, b := manySpendsBlock(t, 20)
+	for _, workers := range []int{0, 1, 3, 64} {
+		if err := ValidateBlockParallel(cs, b, V1BlockSupplement{}, workers); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// corrupt several transactions; the first error must always match
+	// sequential validation
+	corrupt := deepCopyBlock(b)
+	corrupt.V2.Transactions[7].SiacoinInputs[0].SatisfiedPolicy.Signatures[0][0] ^= 1
+	corrupt.V2.Transactions[12].SiacoinOutputs[0].Value = types.ZeroCurrency
+	corrupt.V2.Transactions[15].SiacoinInputs[0].SatisfiedPolicy.Signatures[0][0] ^= 1
+	corrupt.V2.Commitment = cs.Commitment(cs.TransactionsCommitment(corrupt.Transactions, corrupt.V2Transactions()), corrupt.MinerPayouts[0].Address)
+	findBlockNonce(cs, &corrupt)
+
+	err := ValidateBlock(cs, corrupt, V1BlockSupplement{})
+	if err == nil || !strings.HasPrefix(err.Error(), "v2 transaction 7 is invalid") {
+		t.Fatalf("expected error in transaction 7, got %v", err)
+	}
+	for _, workers := range []int{0, 1, 3, 64} {
+		for range 10 {
+			if perr := ValidateBlockParallel(cs, corrupt, V1BlockSupplement{}, workers); perr == nil || perr.Error() != err.Error() {
+				t.Fatalf("expected %q, got %v", err, perr)
+			}
+		}
+	}
+}
+
+func BenchmarkValidateBlock(b *testing.B) {
+	cs, block := manySpendsBlock(b, 1000)
+	b.Run("sequential", func(b *testing.B) {
+		for range b.N {
+			if err := ValidateBlock(cs, block, V1BlockSupplement{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		for range b.N {
+			if err := ValidateBlockParallel(cs, block, V1BlockSupplement{}, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,286 @@
+package contracts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+var (
+	// ErrRevisionRegression is returned by Journal.Append when a revision
+	// does not increase the contract's revision number.
+	ErrRevisionRegression = errors.New("revision number does not increase")
+	// ErrCorruptJournal is returned by OpenJournal when a record before the
+	// end of the journal is corrupt. Such a record cannot have been torn by
+	// a crash, so the journal is left untouched.
+	ErrCorruptJournal = errors.New("journal is corrupt")
+)
+
+const (
+	recordRevision = iota + 1
+	recordDelete
+)
+
+// journalHeaderSize is the size of the header preceding each journal record:
+// the length of the record and its CRC-32C checksum.
+const journalHeaderSize = 8
+
+// maxRecordSize is an upper bound on the size of a journal record, used to
+// reject corrupt lengths.
+const maxRecordSize = 1 << 16
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// A Journal is an append-only log of signed contract revisions, keyed by
+// contract ID. Each record is checksummed and synced to disk before Append
+// returns, so the latest revision of every contract survives a crash. If a
+// crash interrupts a write, OpenJournal discards the torn record at the end of
+// the journal.
+//
+// The journal grows with every revision; call Compact periodically to discard
+// superseded revisions.
+type Journal struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	size    int64 // offset of the end of the last valid record
+	records int
+	latest  map[types.FileContractID]types.V2FileContract
+}
+
+func encodeRecord(kind uint8, id types.FileContractID, rev types.V2FileContract) []byte {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, journalHeaderSize))
+	e := types.NewEncoder(&buf)
+	e.WriteUint8(kind)
+	id.EncodeTo(e)
+	if kind == recordRevision {
+		rev.EncodeTo(e)
+	}
+	e.Flush()
+	b := buf.Bytes()
+	payload := b[journalHeaderSize:]
+	binary.LittleEndian.PutUint32(b[0:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(b[4:], crc32.Checksum(payload, crcTable))
+	return b
+}
+
+// readRecord reads the next record from r, returning its size. It returns
+// io.EOF if r is exhausted, or another error if the record is torn or corrupt.
+// If the record's header was read, n is the size the header claims, even if
+// the record is invalid.
+func readRecord(r io.Reader) (n int64, kind uint8, id types.FileContractID, rev types.V2FileContract, err error) {
+	var header [journalHeaderSize]byte
+	if _, err = io.ReadFull(r, header[:]); err == io.EOF {
+		return 0, 0, id, rev, io.EOF
+	} else if err != nil {
+		return 0, 0, id, rev, fmt.Errorf("torn record header: %w", err)
+	}
+	length := binary.LittleEndian.Uint32(header[0:])
+	n = int64(len(header)) + int64(length)
+	if length > maxRecordSize {
+		return n, 0, id, rev, fmt.Errorf("record length %v exceeds maximum", length)
+	}
+	payload := make([]byte, length)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return n, 0, id, rev, fmt.Errorf("torn record: %w", err)
+	} else if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[4:]) {
+		return n, 0, id, rev, errors.New("record checksum mismatch")
+	}
+	d := types.NewBufDecoder(payload)
+	kind = d.ReadUint8()
+	id.DecodeFrom(d)
+	switch kind {
+	case recordRevision:
+		rev.DecodeFrom(d)
+	case recordDelete:
+	default:
+		d.SetErr(fmt.Errorf("unknown record type %d", kind))
+	}
+	if err := d.Err(); err != nil {
+		return n, 0, id, rev, fmt.Errorf("invalid record: %w", err)
+	}
+	return n, kind, id, rev, nil
+}
+
+func (j *Journal) apply(kind uint8, id types.FileContractID, rev types.V2FileContract) {
+	switch kind {
+	case recordRevision:
+		j.latest[id] = rev
+	case recordDelete:
+		delete(j.latest, id)
+	}
+	j.records++
+}
+
+func (j *Journal) write(kind uint8, id types.FileContractID, rev types.V2FileContract) error {
+	b := encodeRecord(kind, id, rev)
+	if _, err := j.f.WriteAt(b, j.size); err != nil {
+		return fmt.Errorf("failed to write record: %w", err)
+	} else if err := j.f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	j.size += int64(len(b))
+	j.apply(kind, id, rev)
+	return nil
+}
+
+// Append adds a revision of the contract with the given ID to the journal.
+// The revision must be signed by the renter and host keys of the previous
+// revision (or, for the first revision, its own keys), and must increase the
+// contract's revision number. Appending the latest revision again is a no-op.
+func (j *Journal) Append(cs consensus.State, id types.FileContractID, rev types.V2FileContract) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	keys := rev
+	if prev, ok := j.latest[id]; ok {
+		if prev == rev {
+			return nil
+		} else if rev.RevisionNumber <= prev.RevisionNumber {
+			return fmt.Errorf("%w: %v <= %v", ErrRevisionRegression, rev.RevisionNumber, prev.RevisionNumber)
+		}
+		keys = prev
+	}
+	sigHash := cs.ContractSigHash(rev)
+	if !keys.RenterPublicKey.VerifyHash(sigHash, rev.RenterSignature) {
+		return fmt.Errorf("%w: renter", ErrInvalidSignature)
+	} else if !keys.HostPublicKey.VerifyHash(sigHash, rev.HostSignature) {
+		return fmt.Errorf("%w: host", ErrInvalidSignature)
+	}
+	return j.write(recordRevision, id, rev)
+}
+
+// Delete removes a contract from the journal, e.g. once it has been resolved.
+// Its revisions are discarded by the next compaction.
+func (j *Journal) Delete(id types.FileContractID) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.latest[id]; !ok {
+		return ErrUnknownContract
+	}
+	return j.write(recordDelete, id, types.V2FileContract{})
+}
+
+// Latest returns the latest revision of the contract with the given ID.
+func (j *Journal) Latest(id types.FileContractID) (types.V2FileContract, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	rev, ok := j.latest[id]
+	return rev, ok
+}
+
+// Len returns the number of contracts in the journal and the number of records
+// in its file. Compact reduces the latter to the former.
+func (j *Journal) Len() (contracts, records int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.latest), j.records
+}
+
+// Compact rewrites the journal, keeping only the latest revision of each
+// contract. The new journal atomically replaces the old one.
+func (j *Journal) Compact() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	tmpPath := j.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create compacted journal: %w", err)
+	}
+	var size int64
+	for id, rev := range j.latest {
+		b := encodeRecord(recordRevision, id, rev)
+		if _, err := f.Write(b); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write compacted journal: %w", err)
+		}
+		size += int64(len(b))
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync compacted journal: %w", err)
+	} else if err := os.Rename(tmpPath, j.path); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to replace journal: %w", err)
+	}
+	j.f.Close()
+	j.f = f
+	j.size = size
+	j.records = len(j.latest)
+	return syncDir(filepath.Dir(j.path))
+}
+
+// Close closes the journal.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.f.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open journal directory: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal directory: %w", err)
+	}
+	return nil
+}
+
+// OpenJournal opens the journal at path, creating it if necessary. If the
+// journal ends with a torn or corrupt record, the record is truncated. If an
+// earlier record is corrupt, OpenJournal returns ErrCorruptJournal rather than
+// discard the valid records that follow it.
+func OpenJournal(path string) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	j := &Journal{
+		path:   path,
+		f:      f,
+		latest: make(map[types.FileContractID]types.V2FileContract),
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to stat journal: %w", err)
+	}
+	r := io.NewSectionReader(f, 0, 1<<62)
+	for {
+		n, kind, id, rev, err := readRecord(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			// only the last record can have been torn by a crash
+			if j.size+n < fi.Size() {
+				f.Close()
+				return nil, fmt.Errorf("%w: record at offset %v: %v", ErrCorruptJournal, j.size, err)
+			}
+			// discard the torn record
+			if err := f.Truncate(j.size); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("failed to truncate torn record: %w", err)
+			} else if err := f.Sync(); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("failed to sync journal: %w", err)
+			}
+			break
+		}
+		j.size += n
+		j.apply(kind, id, rev)
+	}
+	return j, nil
+}
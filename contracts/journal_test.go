@@ -0,0 +1,173 @@
+package contracts
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.sia.tech/core/consensus/chaintest"
+	"go.sia.tech/core/types"
+	"lukechampine.com/frand"
+)
+
+func TestJournal(t *testing.T) {
+	cs := chaintest.Network().GenesisState()
+	renterKey, hostKey := types.GeneratePrivateKey(), types.GeneratePrivateKey()
+	sign := func(fc types.V2FileContract) types.V2FileContract {
+		fc.RenterSignature = renterKey.SignHash(cs.ContractSigHash(fc))
+		fc.HostSignature = hostKey.SignHash(cs.ContractSigHash(fc))
+		return fc
+	}
+	revision := func(n uint64) types.V2FileContract {
+		return sign(types.V2FileContract{
+			RevisionNumber:  n,
+			Filesize:        n * 4096,
+			Capacity:        n * 4096,
+			RenterPublicKey: renterKey.PublicKey(),
+			HostPublicKey:   hostKey.PublicKey(),
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "journal")
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := frand.Entropy256()
+	for n := range uint64(3) {
+		if err := j.Append(cs, id, revision(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.Append(cs, id, revision(1)); !errors.Is(err, ErrRevisionRegression) {
+		t.Fatalf("expected regression, got %v", err)
+	} else if err := j.Append(cs, id, revision(2)); err != nil {
+		t.Fatal("expected duplicate revision to be ignored:", err)
+	}
+	forged := revision(3)
+	forged.HostSignature = types.GeneratePrivateKey().SignHash(cs.ContractSigHash(forged))
+	if err := j.Append(cs, id, forged); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected invalid signature, got %v", err)
+	}
+	if contracts, records := j.Len(); contracts != 1 || records != 3 {
+		t.Fatalf("expected 1 contract in 3 records, got %v in %v", contracts, records)
+	}
+	j.Close()
+
+	// reopening recovers the latest revision
+	reopen := func() {
+		t.Helper()
+		var err error
+		if j, err = OpenJournal(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkLatest := func(n uint64) {
+		t.Helper()
+		if rev, ok := j.Latest(id); !ok || rev != revision(n) {
+			t.Fatalf("expected revision %v, got %v", n, rev.RevisionNumber)
+		}
+	}
+	reopen()
+	checkLatest(2)
+	j.Close()
+
+	// simulate a torn write of the next revision
+	validSize := func() int64 {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(encodeRecord(recordRevision, id, revision(3))[:40])
+	f.Close()
+	reopen()
+	checkLatest(2)
+	if fi, _ := os.Stat(path); fi.Size() != validSize {
+		t.Fatalf("expected torn record to be truncated (%v != %v)", fi.Size(), validSize)
+	}
+	if err := j.Append(cs, id, revision(3)); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+	reopen()
+	checkLatest(3)
+	j.Close()
+
+	// a corrupt record is discarded
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)-1] ^= 1
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	reopen()
+	checkLatest(2)
+	j.Close()
+
+	// a corrupt record followed by valid records is not discarded
+	b, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append([]byte(nil), b...)
+	corrupt[journalHeaderSize] ^= 1
+	if err := os.WriteFile(path, corrupt, 0600); err != nil {
+		t.Fatal(err)
+	} else if _, err := OpenJournal(path); !errors.Is(err, ErrCorruptJournal) {
+		t.Fatalf("expected corrupt journal, got %v", err)
+	} else if after, err := os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else if len(after) != len(corrupt) {
+		t.Fatal("expected corrupt journal to be left untouched")
+	}
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	reopen()
+	checkLatest(2)
+
+	// compaction keeps only the latest revision of each contract
+	other := frand.Entropy256()
+	for n := range uint64(5) {
+		if err := j.Append(cs, other, revision(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deleted := frand.Entropy256()
+	if err := j.Append(cs, deleted, revision(0)); err != nil {
+		t.Fatal(err)
+	} else if err := j.Delete(deleted); err != nil {
+		t.Fatal(err)
+	} else if err := j.Delete(deleted); !errors.Is(err, ErrUnknownContract) {
+		t.Fatalf("expected unknown contract, got %v", err)
+	}
+	if err := j.Compact(); err != nil {
+		t.Fatal(err)
+	} else if contracts, records := j.Len(); contracts != 2 || records != 2 {
+		t.Fatalf("expected 2 contracts in 2 records, got %v in %v", contracts, records)
+	}
+	// the compacted journal remains writable
+	if err := j.Append(cs, id, revision(4)); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+	reopen()
+	defer j.Close()
+	checkLatest(4)
+	if rev, ok := j.Latest(other); !ok || rev != revision(4) {
+		t.Fatal("expected other contract to survive compaction")
+	} else if _, ok := j.Latest(deleted); ok {
+		t.Fatal("expected deleted contract to be discarded")
+	} else if contracts, records := j.Len(); contracts != 2 || records != 3 {
+		t.Fatalf("expected 2 contracts in 3 records, got %v in %v", contracts, records)
+	}
+}
@@ -0,0 +1,511 @@
+// Package contracts tracks the lifecycle of v2 file contracts.
+//
+// A Manager follows a set of contracts from formation to resolution. It is fed
+// chain updates and signed revisions, persists every change through a Store,
+// and reports the transitions that require action, such as a contract
+// approaching its proof height. The same Manager serves renters and hosts;
+// each reacts to the events relevant to its role.
+package contracts
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+// A Status is the lifecycle status of a contract.
+type Status uint8
+
+// Contract statuses.
+const (
+	// StatusPending indicates that the contract's formation transaction has
+	// not been confirmed.
+	StatusPending Status = iota
+	// StatusActive indicates that the contract is confirmed and its proof
+	// height has not been reached.
+	StatusActive
+	// StatusProofWindow indicates that the contract's proof height has been
+	// reached, but it has not been resolved.
+	StatusProofWindow
+	// StatusRenewed indicates that the contract was resolved by a renewal
+	// that extended its proof height.
+	StatusRenewed
+	// StatusRefreshed indicates that the contract was resolved by a renewal
+	// that kept its proof height, e.g. to add funds.
+	StatusRefreshed
+	// StatusResolved indicates that the contract was resolved by a storage
+	// proof.
+	StatusResolved
+	// StatusExpired indicates that the contract was resolved without a
+	// storage proof after its expiration height.
+	StatusExpired
+	// StatusAbandoned indicates that the contract was the successor of a
+	// renewal that has been reverted. It becomes active again if the renewal
+	// is confirmed again.
+	StatusAbandoned
+)
+
+var statusStrings = [...]string{
+	StatusPending:     "pending",
+	StatusActive:      "active",
+	StatusProofWindow: "proofWindow",
+	StatusRenewed:     "renewed",
+	StatusRefreshed:   "refreshed",
+	StatusResolved:    "resolved",
+	StatusExpired:     "expired",
+	StatusAbandoned:   "abandoned",
+}
+
+// String implements fmt.Stringer.
+func (s Status) String() string {
+	if int(s) < len(statusStrings) {
+		return statusStrings[s]
+	}
+	return fmt.Sprintf("Status(%d)", s)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Status) MarshalText() ([]byte, error) {
+	if int(s) >= len(statusStrings) {
+		return nil, fmt.Errorf("unknown status %d", s)
+	}
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *Status) UnmarshalText(b []byte) error {
+	if i := slices.Index(statusStrings[:], string(b)); i >= 0 {
+		*s = Status(i)
+		return nil
+	}
+	return fmt.Errorf("unknown status %q", b)
+}
+
+// Unresolved reports whether a contract with this status may still be
+// resolved.
+func (s Status) Unresolved() bool {
+	return s == StatusPending || s == StatusActive || s == StatusProofWindow
+}
+
+// A Contract is a tracked v2 file contract.
+type Contract struct {
+	ID     types.FileContractID `json:"id"`
+	Status Status               `json:"status"`
+	// Revision is the latest known revision of the contract, which may not
+	// have been broadcast.
+	Revision types.V2FileContract `json:"revision"`
+	// ConfirmedRevisionNumber is the revision number of the latest revision
+	// confirmed on chain.
+	ConfirmedRevisionNumber uint64 `json:"confirmedRevisionNumber"`
+
+	// FormationIndex and ResolutionIndex are the indices of the blocks that
+	// confirmed the contract's formation and resolution, respectively.
+	FormationIndex  types.ChainIndex `json:"formationIndex"`
+	ResolutionIndex types.ChainIndex `json:"resolutionIndex"`
+	// RenewedFrom and RenewedTo link the contract to its predecessor and
+	// successor, if it was formed by or resolved by a renewal.
+	RenewedFrom types.FileContractID `json:"renewedFrom"`
+	RenewedTo   types.FileContractID `json:"renewedTo"`
+}
+
+// An EventType identifies a kind of Event.
+type EventType string
+
+// Event types.
+const (
+	// EventStatusChanged is emitted whenever a contract's status changes.
+	EventStatusChanged EventType = "statusChanged"
+	// EventRenewNow is emitted when an active contract comes within the
+	// Manager's renew window of its proof height.
+	EventRenewNow EventType = "renewNow"
+	// EventProofDue is emitted when a contract enters its proof window. Hosts
+	// should submit a storage proof.
+	EventProofDue EventType = "proofDue"
+	// EventExpirable is emitted when an unresolved contract passes its
+	// expiration height, after which anyone may resolve it with a
+	// V2FileContractExpiration.
+	EventExpirable EventType = "expirable"
+)
+
+// An Event reports a change to a tracked contract.
+type Event struct {
+	Type       EventType            `json:"type"`
+	ContractID types.FileContractID `json:"contractID"`
+	Status     Status               `json:"status"`
+	Height     uint64               `json:"height"`
+}
+
+// A Store persists the state of a Manager.
+type Store interface {
+	// Contracts returns every stored contract, along with the index of the
+	// last chain update applied to them.
+	Contracts() ([]Contract, types.ChainIndex, error)
+	// UpdateContracts atomically stores the updated contracts and the index
+	// of the last applied chain update.
+	UpdateContracts(updated []Contract, tip types.ChainIndex) error
+	// DeleteContract deletes a contract.
+	DeleteContract(id types.FileContractID) error
+}
+
+var (
+	// ErrUnknownContract is returned when a contract is not tracked by the
+	// Manager.
+	ErrUnknownContract = errors.New("unknown contract")
+	// ErrStaleRevision is returned when a revision does not increase the
+	// contract's revision number.
+	ErrStaleRevision = errors.New("revision number must increase")
+	// ErrNotRevisable is returned when a contract can no longer be revised.
+	ErrNotRevisable = errors.New("contract can no longer be revised")
+	// ErrInvalidSignature is returned when a revision is not signed by the
+	// contract's renter and host.
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// A Manager tracks the lifecycle of a set of contracts. It is safe for
+// concurrent use.
+type Manager struct {
+	store       Store
+	renewWindow uint64
+
+	mu        sync.Mutex
+	tip       types.ChainIndex
+	contracts map[types.FileContractID]*Contract
+}
+
+// statusAt returns the status of an unresolved, confirmed contract when the
+// chain tip is at height.
+func statusAt(fc types.V2FileContract, height uint64) Status {
+	if height >= fc.ProofHeight {
+		return StatusProofWindow
+	}
+	return StatusActive
+}
+
+// conditions returns which of the conditions reported by events hold for c
+// when the chain tip is at height.
+func (m *Manager) conditions(c Contract, height uint64) (renew, proofDue, expirable bool) {
+	fc := c.Revision
+	renew = c.Status == StatusActive && height+m.renewWindow >= fc.ProofHeight
+	proofDue = c.Status == StatusProofWindow
+	expirable = c.Status == StatusProofWindow && height >= fc.ExpirationHeight
+	return
+}
+
+// events returns the events caused by a change from old to c, as the chain tip
+// moved from oldHeight to height.
+func (m *Manager) events(old, c Contract, oldHeight, height uint64) (events []Event) {
+	add := func(typ EventType) {
+		events = append(events, Event{Type: typ, ContractID: c.ID, Status: c.Status, Height: height})
+	}
+	if old.Status != c.Status {
+		add(EventStatusChanged)
+	}
+	oldRenew, oldProof, oldExpirable := m.conditions(old, oldHeight)
+	renew, proof, expirable := m.conditions(c, height)
+	if renew && !oldRenew {
+		add(EventRenewNow)
+	}
+	if proof && !oldProof {
+		add(EventProofDue)
+	}
+	if expirable && !oldExpirable {
+		add(EventExpirable)
+	}
+	return
+}
+
+// commit persists the updated contracts and tip, then applies them to m,
+// returning the resulting events.
+func (m *Manager) commit(updated map[types.FileContractID]*Contract, tip types.ChainIndex) ([]Event, error) {
+	var changed []Contract
+	var events []Event
+	for id, c := range updated {
+		var old Contract
+		if p, ok := m.contracts[id]; ok {
+			old = *p
+		} else {
+			// successors are first observed on chain; report them as new
+			old = Contract{ID: id, Status: StatusPending}
+		}
+		events = append(events, m.events(old, *c, m.tip.Height, tip.Height)...)
+		if m.contracts[id] == nil || *c != old {
+			changed = append(changed, *c)
+		}
+	}
+	slices.SortFunc(changed, func(a, b Contract) int { return bytes.Compare(a.ID[:], b.ID[:]) })
+	if err := m.store.UpdateContracts(changed, tip); err != nil {
+		return nil, fmt.Errorf("failed to update contracts: %w", err)
+	}
+	for id, c := range updated {
+		m.contracts[id] = c
+	}
+	m.tip = tip
+	slices.SortStableFunc(events, func(a, b Event) int { return bytes.Compare(a.ContractID[:], b.ContractID[:]) })
+	return events, nil
+}
+
+// addUnresolved adds copies of every unresolved, confirmed contract to
+// updated, so that their statuses can be recomputed.
+func (m *Manager) addUnresolved(updated map[types.FileContractID]*Contract) {
+	for id, c := range m.contracts {
+		if _, ok := updated[id]; !ok && (c.Status == StatusActive || c.Status == StatusProofWindow) {
+			cc := *c
+			updated[id] = &cc
+		}
+	}
+}
+
+// ApplyBlock ingests an update applying a block. cs is the state after
+// applying the block. It returns the resulting events.
+func (m *Manager) ApplyBlock(cs consensus.State, au consensus.ApplyUpdate) ([]Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	updated := make(map[types.FileContractID]*Contract)
+	get := func(id types.FileContractID) *Contract {
+		if c, ok := updated[id]; ok {
+			return c
+		} else if c, ok := m.contracts[id]; ok {
+			cc := *c
+			updated[id] = &cc
+			return &cc
+		}
+		return nil
+	}
+	m.addUnresolved(updated)
+
+	for _, fced := range au.V2FileContractElementDiffs() {
+		id := fced.V2FileContractElement.ID
+		c := get(id)
+		if c == nil {
+			continue
+		}
+		if fced.Created {
+			c.FormationIndex = cs.Index
+			if c.Status == StatusPending || c.Status == StatusAbandoned {
+				c.Status = StatusActive
+			}
+		}
+		confirmed := fced.V2FileContractElement.V2FileContract
+		if fced.Revision != nil {
+			confirmed = *fced.Revision
+		}
+		c.ConfirmedRevisionNumber = confirmed.RevisionNumber
+		// a confirmed revision with the same number as the local revision is
+		// the same revision; taking it also replaces the contract passed to
+		// AddContract
+		if confirmed.RevisionNumber >= c.Revision.RevisionNumber {
+			c.Revision = confirmed
+		}
+
+		switch r := fced.Resolution.(type) {
+		case nil:
+			continue
+		case *types.V2FileContractRenewal:
+			c.Status = StatusRenewed
+			if r.NewContract.ProofHeight == confirmed.ProofHeight {
+				c.Status = StatusRefreshed
+			}
+			c.RenewedTo = id.V2RenewalID()
+			if get(c.RenewedTo) == nil {
+				updated[c.RenewedTo] = &Contract{
+					ID:                      c.RenewedTo,
+					Status:                  StatusActive,
+					Revision:                r.NewContract,
+					ConfirmedRevisionNumber: r.NewContract.RevisionNumber,
+					FormationIndex:          cs.Index,
+					RenewedFrom:             id,
+				}
+			}
+		case *types.V2StorageProof:
+			c.Status = StatusResolved
+		case *types.V2FileContractExpiration:
+			c.Status = StatusExpired
+		}
+		c.ResolutionIndex = cs.Index
+	}
+
+	for _, c := range updated {
+		if c.Status == StatusActive || c.Status == StatusProofWindow {
+			c.Status = statusAt(c.Revision, cs.Index.Height)
+		}
+	}
+	return m.commit(updated, cs.Index)
+}
+
+// RevertBlock ingests an update reverting a block. cs is the state after
+// reverting the block. It returns the resulting events.
+func (m *Manager) RevertBlock(cs consensus.State, ru consensus.RevertUpdate) ([]Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	updated := make(map[types.FileContractID]*Contract)
+	get := func(id types.FileContractID) *Contract {
+		if c, ok := updated[id]; ok {
+			return c
+		} else if c, ok := m.contracts[id]; ok {
+			cc := *c
+			updated[id] = &cc
+			return &cc
+		}
+		return nil
+	}
+	m.addUnresolved(updated)
+	// successors of reverted renewals, keyed by their predecessors
+	abandoned := make(map[types.FileContractID]types.FileContractID)
+	for _, fced := range ru.V2FileContractElementDiffs() {
+		id := fced.V2FileContractElement.ID
+		c := get(id)
+		if c == nil {
+			continue
+		}
+		if fced.Created {
+			// the formation (or renewal) may be confirmed again
+			c.Status = StatusPending
+			c.FormationIndex = types.ChainIndex{}
+			c.ConfirmedRevisionNumber = 0
+			continue
+		}
+		c.ConfirmedRevisionNumber = fced.V2FileContractElement.V2FileContract.RevisionNumber
+		if fced.Resolution != nil {
+			if _, ok := fced.Resolution.(*types.V2FileContractRenewal); ok && c.RenewedTo != (types.FileContractID{}) {
+				abandoned[c.RenewedTo] = id
+			}
+			c.Status = StatusActive
+			c.ResolutionIndex = types.ChainIndex{}
+			c.RenewedTo = types.FileContractID{}
+		}
+	}
+	for id, from := range abandoned {
+		if c := get(id); c != nil && c.RenewedFrom == from {
+			c.Status = StatusAbandoned
+			c.FormationIndex = types.ChainIndex{}
+			c.ConfirmedRevisionNumber = 0
+		}
+	}
+	for _, c := range updated {
+		if c.Status == StatusActive || c.Status == StatusProofWindow {
+			c.Status = statusAt(c.Revision, cs.Index.Height)
+		}
+	}
+	return m.commit(updated, cs.Index)
+}
+
+// AddContract starts tracking a contract whose formation transaction has not
+// yet been confirmed. fc is replaced by the confirmed contract once the
+// formation is confirmed. The successors of renewed contracts are tracked
+// automatically; if a renewal is reverted, its successor is marked
+// StatusAbandoned.
+func (m *Manager) AddContract(id types.FileContractID, fc types.V2FileContract) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.contracts[id]; ok {
+		return fmt.Errorf("contract %v is already tracked", id)
+	}
+	c := &Contract{ID: id, Status: StatusPending, Revision: fc}
+	if err := m.store.UpdateContracts([]Contract{*c}, m.tip); err != nil {
+		return fmt.Errorf("failed to add contract: %w", err)
+	}
+	m.contracts[id] = c
+	return nil
+}
+
+// RemoveContract stops tracking a contract, e.g. because its formation
+// transaction was abandoned.
+func (m *Manager) RemoveContract(id types.FileContractID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.contracts[id]; !ok {
+		return ErrUnknownContract
+	} else if err := m.store.DeleteContract(id); err != nil {
+		return fmt.Errorf("failed to delete contract: %w", err)
+	}
+	delete(m.contracts, id)
+	return nil
+}
+
+// Revise records a signed revision of a contract. The revision must increase
+// the contract's revision number and be signed by the contract's current
+// renter and host keys.
+func (m *Manager) Revise(cs consensus.State, id types.FileContractID, rev types.V2FileContract) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.contracts[id]
+	if !ok {
+		return ErrUnknownContract
+	} else if c.Status != StatusPending && c.Status != StatusActive {
+		return fmt.Errorf("%w: contract is %v", ErrNotRevisable, c.Status)
+	} else if rev.RevisionNumber <= c.Revision.RevisionNumber {
+		return fmt.Errorf("%w: %v <= %v", ErrStaleRevision, rev.RevisionNumber, c.Revision.RevisionNumber)
+	}
+	sigHash := cs.ContractSigHash(rev)
+	if !c.Revision.RenterPublicKey.VerifyHash(sigHash, rev.RenterSignature) {
+		return fmt.Errorf("%w: renter", ErrInvalidSignature)
+	} else if !c.Revision.HostPublicKey.VerifyHash(sigHash, rev.HostSignature) {
+		return fmt.Errorf("%w: host", ErrInvalidSignature)
+	}
+	updated := *c
+	updated.Revision = rev
+	if err := m.store.UpdateContracts([]Contract{updated}, m.tip); err != nil {
+		return fmt.Errorf("failed to update contract: %w", err)
+	}
+	*c = updated
+	return nil
+}
+
+// Contract returns the tracked contract with the given ID.
+func (m *Manager) Contract(id types.FileContractID) (Contract, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.contracts[id]
+	if !ok {
+		return Contract{}, false
+	}
+	return *c, true
+}
+
+// Contracts returns every tracked contract with one of the given statuses, or
+// every tracked contract if no statuses are given.
+func (m *Manager) Contracts(statuses ...Status) []Contract {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var contracts []Contract
+	for _, c := range m.contracts {
+		if len(statuses) == 0 || slices.Contains(statuses, c.Status) {
+			contracts = append(contracts, *c)
+		}
+	}
+	slices.SortFunc(contracts, func(a, b Contract) int { return bytes.Compare(a.ID[:], b.ID[:]) })
+	return contracts
+}
+
+// Tip returns the index of the last chain update applied to the Manager.
+func (m *Manager) Tip() types.ChainIndex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tip
+}
+
+// NewManager returns a Manager that persists its state in store. EventRenewNow
+// is emitted renewWindow blocks before each contract's proof height.
+func NewManager(store Store, renewWindow uint64) (*Manager, error) {
+	contracts, tip, err := store.Contracts()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load contracts: %w", err)
+	}
+	m := &Manager{
+		store:       store,
+		renewWindow: renewWindow,
+		tip:         tip,
+		contracts:   make(map[types.FileContractID]*Contract, len(contracts)),
+	}
+	for i := range contracts {
+		m.contracts[contracts[i].ID] = &contracts[i]
+	}
+	return m, nil
+}
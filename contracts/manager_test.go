@@ -0,0 +1,220 @@
+package contracts
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/consensus/chaintest"
+	"go.sia.tech/core/types"
+)
+
+type memStore struct {
+	contracts map[types.FileContractID]Contract
+	tip       types.ChainIndex
+}
+
+func (ms *memStore) Contracts() ([]Contract, types.ChainIndex, error) {
+	return slices.Collect(maps.Values(ms.contracts)), ms.tip, nil
+}
+
+func (ms *memStore) UpdateContracts(updated []Contract, tip types.ChainIndex) error {
+	for _, c := range updated {
+		ms.contracts[c.ID] = c
+	}
+	ms.tip = tip
+	return nil
+}
+
+func (ms *memStore) DeleteContract(id types.FileContractID) error {
+	delete(ms.contracts, id)
+	return nil
+}
+
+func TestManager(t *testing.T) {
+	c := chaintest.New(t, nil)
+	n := c.Network()
+	genesis, renter, host := c.Wallet("genesis"), c.Wallet("renter"), c.Wallet("host")
+	for range 2 {
+		c.SendSiacoins(genesis, renter.Address(), types.Siacoins(1000)).
+			SendSiacoins(genesis, host.Address(), types.Siacoins(1000)).
+			Mine(1)
+	}
+	c.Mine(int(n.HardforkV2.AllowHeight - c.Tip().Index.Height))
+
+	store := &memStore{contracts: make(map[types.FileContractID]Contract)}
+	m, err := NewManager(store, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []Event
+	c.OnApply(func(cs consensus.State, _ types.Block, au consensus.ApplyUpdate) {
+		es, err := m.ApplyBlock(cs, au)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, es...)
+	})
+	c.OnRevert(func(cs consensus.State, _ types.Block, ru consensus.RevertUpdate) {
+		es, err := m.RevertBlock(cs, ru)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, es...)
+	})
+	// expect checks that exactly the given events (ignoring heights) were
+	// emitted since the last call
+	expect := func(want ...Event) {
+		t.Helper()
+		for i := range events {
+			events[i].Height = 0
+		}
+		byID := func(a, b Event) int { return bytes.Compare(a.ContractID[:], b.ContractID[:]) }
+		slices.SortStableFunc(want, byID)
+		if !slices.Equal(events, want) {
+			t.Fatalf("expected events %+v, got %+v", want, events)
+		}
+		events = nil
+	}
+	checkStatus := func(id types.FileContractID, status Status) {
+		t.Helper()
+		if c, ok := m.Contract(id); !ok {
+			t.Fatalf("contract %v is not tracked", id)
+		} else if c.Status != status {
+			t.Fatalf("expected contract %v to be %v, got %v", id, status, c.Status)
+		}
+	}
+
+	height := c.Tip().Index.Height
+	renewed := c.FormContract(renter, host, types.Siacoins(100), types.Siacoins(200), height+6, height+10)
+	refreshed := c.FormContract(renter, host, types.Siacoins(100), types.Siacoins(200), height+8, height+10)
+	for _, id := range []types.FileContractID{renewed, refreshed} {
+		if err := m.AddContract(id, types.V2FileContract{}); err != nil {
+			t.Fatal(err)
+		}
+		checkStatus(id, StatusPending)
+	}
+	c.Mine(1)
+	expect(
+		Event{EventStatusChanged, renewed, StatusActive, 0},
+		Event{EventStatusChanged, refreshed, StatusActive, 0},
+	)
+	if fc, _ := m.Contract(renewed); fc.Revision.ProofHeight != height+6 || fc.FormationIndex != c.Tip().Index {
+		t.Fatal("expected confirmed contract to replace placeholder")
+	}
+
+	// the first contract enters the renew window 3 blocks before its proof
+	// height
+	c.Mine(1)
+	expect()
+	c.Mine(1)
+	expect(Event{EventRenewNow, renewed, StatusActive, 0})
+
+	// renewing the first contract tracks its successor
+	successor := c.RenewContract(renter, host, renewed, height+20, height+30)
+	c.Mine(1)
+	expect(
+		Event{EventStatusChanged, renewed, StatusRenewed, 0},
+		Event{EventStatusChanged, successor, StatusActive, 0},
+	)
+	if s, _ := m.Contract(successor); s.RenewedFrom != renewed {
+		t.Fatal("expected successor to link to predecessor")
+	} else if p, _ := m.Contract(renewed); p.RenewedTo != successor {
+		t.Fatal("expected predecessor to link to successor")
+	}
+	// reverting the renewal abandons the successor, which can no longer be
+	// revised, and survives further blocks
+	c.Revert(1)
+	expect(
+		Event{EventStatusChanged, renewed, StatusActive, 0},
+		Event{EventRenewNow, renewed, StatusActive, 0},
+		Event{EventStatusChanged, successor, StatusAbandoned, 0},
+	)
+	if s, _ := m.Contract(successor); s.FormationIndex != (types.ChainIndex{}) || s.RenewedFrom != renewed {
+		t.Fatal("expected abandoned successor to be unconfirmed and linked to predecessor", s)
+	} else if p, _ := m.Contract(renewed); p.RenewedTo != (types.FileContractID{}) {
+		t.Fatal("expected predecessor to be unlinked from successor")
+	} else if err := m.Revise(c.Tip(), successor, s.Revision); !errors.Is(err, ErrNotRevisable) {
+		t.Fatalf("expected abandoned successor to be unrevisable, got %v", err)
+	}
+	c.Mine(1)
+	expect()
+	checkStatus(successor, StatusAbandoned)
+	if got := m.Contracts(StatusAbandoned); len(got) != 1 || got[0].ID != successor {
+		t.Fatal("expected abandoned successor to be listed", got)
+	}
+	c.Revert(1)
+	expect()
+
+	// confirming the renewal again revives the successor
+	successor = c.RenewContract(renter, host, renewed, height+20, height+30)
+	c.Mine(1)
+	expect(
+		Event{EventStatusChanged, renewed, StatusRenewed, 0},
+		Event{EventStatusChanged, successor, StatusActive, 0},
+	)
+
+	// refreshing keeps the proof height
+	fresh := c.RenewContract(renter, host, refreshed, height+8, height+10)
+	c.Mine(1)
+	checkStatus(refreshed, StatusRefreshed)
+	expect(
+		Event{EventStatusChanged, refreshed, StatusRefreshed, 0},
+		Event{EventStatusChanged, fresh, StatusActive, 0},
+		Event{EventRenewNow, fresh, StatusActive, 0},
+	)
+
+	// off-chain revisions must be signed and increase the revision number
+	cs := c.Tip()
+	rev, _ := m.Contract(successor)
+	fc := rev.Revision
+	fc.RevisionNumber++
+	fc.RenterSignature = renter.PrivateKey.SignHash(cs.ContractSigHash(fc))
+	if err := m.Revise(cs, successor, fc); err == nil {
+		t.Fatal("expected missing host signature to be rejected")
+	}
+	fc.HostSignature = host.PrivateKey.SignHash(cs.ContractSigHash(fc))
+	if err := m.Revise(cs, successor, fc); err != nil {
+		t.Fatal(err)
+	} else if err := m.Revise(cs, successor, fc); !errors.Is(err, ErrStaleRevision) {
+		t.Fatalf("expected stale revision, got %v", err)
+	} else if err := m.Revise(cs, renewed, fc); !errors.Is(err, ErrNotRevisable) {
+		t.Fatalf("expected renewed contract to be unrevisable, got %v", err)
+	}
+
+	// the refreshed contract enters its proof window and is proven
+	c.Mine(int(height + 8 - c.Tip().Index.Height))
+	expect(Event{EventStatusChanged, fresh, StatusProofWindow, 0}, Event{EventProofDue, fresh, StatusProofWindow, 0})
+	c.SubmitStorageProof(fresh).Mine(1)
+	expect(Event{EventStatusChanged, fresh, StatusResolved, 0})
+	c.Revert(1)
+	expect(Event{EventStatusChanged, fresh, StatusProofWindow, 0}, Event{EventProofDue, fresh, StatusProofWindow, 0})
+	c.Mine(1)
+	expect()
+
+	// unresolved contracts become expirable after their expiration height
+	c.Mine(int(height + 10 - c.Tip().Index.Height))
+	expect(Event{EventExpirable, fresh, StatusProofWindow, 0})
+	c.ExpireContract(fresh).Mine(1)
+	expect(Event{EventStatusChanged, fresh, StatusExpired, 0})
+
+	// the state survives a restart
+	m2, err := NewManager(store, 3)
+	if err != nil {
+		t.Fatal(err)
+	} else if m2.Tip() != c.Tip().Index {
+		t.Fatal("expected tip to be persisted")
+	} else if !slices.Equal(m2.Contracts(), m.Contracts()) {
+		t.Fatal("expected contracts to be persisted")
+	} else if got := m2.Contracts(StatusRenewed, StatusRefreshed); len(got) != 2 {
+		t.Fatalf("expected 2 renewed contracts, got %v", len(got))
+	}
+	if err := m2.RemoveContract(renewed); err != nil {
+		t.Fatal(err)
+	} else if _, ok := store.contracts[renewed]; ok {
+		t.Fatal("expected contract to be deleted")
+	}
+}
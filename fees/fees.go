@@ -0,0 +1,264 @@
+// Package fees recommends miner fees based on recent blocks and unconfirmed
+// transactions.
+//
+// An Estimator is fed each block as it is applied to (or reverted from) the
+// chain, and, optionally, the contents of a txpool. It tracks the minimum fee
+// rate, in Hastings per unit of weight, that was required for a transaction to
+// be included in each recent block, and estimates the rate required for a new
+// transaction to be confirmed within a given number of blocks.
+package fees
+
+import (
+	"slices"
+	"sync"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+// Confirmation targets, in blocks.
+const (
+	TargetNextBlock = 1
+	TargetHour      = 6
+	TargetDay       = 144
+)
+
+// maxHistory is the number of recent blocks tracked by an Estimator. It is
+// large enough to yield a distribution for the longest confirmation target.
+const maxHistory = 2 * TargetDay
+
+// fullThreshold is the fraction of the maximum block weight above which a
+// block is considered full. Transactions paying less than the lowest fee rate
+// in a full block may have been excluded for lack of space.
+const fullThreshold = 0.9
+
+// Floor returns the lowest fee rate that an Estimator will recommend for a
+// child of s: the rate at which the fees of a full block would total one
+// thousandth of the block reward.
+func Floor(s consensus.State) types.Currency {
+	return s.BlockReward().Div64(s.MaxBlockWeight()).Div64(1000)
+}
+
+// TransactionFeeRate returns the fee rate of a v1 transaction.
+func TransactionFeeRate(s consensus.State, txn types.Transaction) types.Currency {
+	var fees types.Currency
+	for _, fee := range txn.MinerFees {
+		fees = fees.Add(fee)
+	}
+	return fees.Div64(max(s.TransactionWeight(txn), 1))
+}
+
+// V2TransactionFeeRate returns the fee rate of a v2 transaction.
+func V2TransactionFeeRate(s consensus.State, txn types.V2Transaction) types.Currency {
+	return txn.MinerFee.Div64(max(s.V2TransactionWeight(txn), 1))
+}
+
+// Percentiles are fee rates, in Hastings per unit of weight, at several
+// percentiles of a distribution.
+type Percentiles struct {
+	P10 types.Currency `json:"p10"`
+	P25 types.Currency `json:"p25"`
+	P50 types.Currency `json:"p50"`
+	P75 types.Currency `json:"p75"`
+	P90 types.Currency `json:"p90"`
+}
+
+// An Estimate is a fee recommendation for a confirmation target.
+type Estimate struct {
+	// Target is the number of blocks within which the transaction should be
+	// confirmed.
+	Target uint64 `json:"target"`
+	// Percentiles is the distribution, over recent blocks, of the minimum fee
+	// rate that would have confirmed a transaction within Target blocks. It is
+	// zero if no blocks have been observed, in which case Recommended is the
+	// Floor.
+	Percentiles Percentiles `json:"percentiles"`
+	// Pool is the fee rate needed to outbid enough unconfirmed transactions
+	// to fit within Target blocks, or zero if the txpool is not congested.
+	Pool types.Currency `json:"pool"`
+	// Recommended is the greater of the median of Percentiles (the 75th
+	// percentile for TargetNextBlock), Pool, and the Floor.
+	Recommended types.Currency `json:"recommended"`
+}
+
+// A TxpoolFeed receives the unconfirmed transactions of a txpool.
+type TxpoolFeed interface {
+	// AddPoolTransactions adds unconfirmed transactions that are valid in
+	// the context of s.
+	AddPoolTransactions(s consensus.State, txns []types.Transaction, v2txns []types.V2Transaction)
+	// RemovePoolTransactions removes unconfirmed transactions, e.g. because
+	// they were evicted from the pool. Transactions that are confirmed in a
+	// block are removed automatically.
+	RemovePoolTransactions(ids []types.TransactionID)
+}
+
+type blockStats struct {
+	id types.BlockID
+	// inclusionRate is the minimum fee rate a transaction needed to be
+	// included in the block: the lowest rate in the block if it was full, or
+	// zero otherwise.
+	inclusionRate types.Currency
+}
+
+type poolTxn struct {
+	rate   types.Currency
+	weight uint64
+}
+
+// An Estimator estimates miner fees. It is safe for concurrent use.
+type Estimator struct {
+	mu        sync.Mutex
+	blocks    []blockStats // oldest first
+	pool      map[types.TransactionID]poolTxn
+	floor     types.Currency
+	maxWeight uint64
+}
+
+var _ TxpoolFeed = (*Estimator)(nil)
+
+// ApplyBlock ingests a block. s is the state after applying b.
+func (e *Estimator) ApplyBlock(s consensus.State, b types.Block) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var weight uint64
+	var rates []types.Currency
+	for _, txn := range b.Transactions {
+		weight += s.TransactionWeight(txn)
+		rates = append(rates, TransactionFeeRate(s, txn))
+		delete(e.pool, txn.ID())
+	}
+	for _, txn := range b.V2Transactions() {
+		weight += s.V2TransactionWeight(txn)
+		rates = append(rates, V2TransactionFeeRate(s, txn))
+		delete(e.pool, txn.ID())
+	}
+	bs := blockStats{id: b.ID()}
+	if float64(weight) >= fullThreshold*float64(s.MaxBlockWeight()) && len(rates) > 0 {
+		bs.inclusionRate = slices.MinFunc(rates, types.Currency.Cmp)
+	}
+	e.blocks = append(e.blocks, bs)
+	if len(e.blocks) > maxHistory {
+		e.blocks = slices.Delete(e.blocks, 0, len(e.blocks)-maxHistory)
+	}
+	e.floor = Floor(s)
+	e.maxWeight = s.MaxBlockWeight()
+}
+
+// RevertBlock removes a block that was previously ingested. s is the state
+// after reverting b. The transactions in b are not added back to the pool;
+// the txpool should re-add them if they remain valid.
+func (e *Estimator) RevertBlock(s consensus.State, b types.Block) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if n := len(e.blocks); n > 0 && e.blocks[n-1].id == b.ID() {
+		e.blocks = e.blocks[:n-1]
+	}
+	e.floor = Floor(s)
+	e.maxWeight = s.MaxBlockWeight()
+}
+
+// AddPoolTransactions implements TxpoolFeed.
+func (e *Estimator) AddPoolTransactions(s consensus.State, txns []types.Transaction, v2txns []types.V2Transaction) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, txn := range txns {
+		e.pool[txn.ID()] = poolTxn{TransactionFeeRate(s, txn), s.TransactionWeight(txn)}
+	}
+	for _, txn := range v2txns {
+		e.pool[txn.ID()] = poolTxn{V2TransactionFeeRate(s, txn), s.V2TransactionWeight(txn)}
+	}
+}
+
+// RemovePoolTransactions implements TxpoolFeed.
+func (e *Estimator) RemovePoolTransactions(ids []types.TransactionID) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, id := range ids {
+		delete(e.pool, id)
+	}
+}
+
+// percentile returns the p'th percentile of sorted.
+func percentile(sorted []types.Currency, p int) types.Currency {
+	if len(sorted) == 0 {
+		return types.ZeroCurrency
+	}
+	return sorted[(len(sorted)-1)*p/100]
+}
+
+// Estimate returns a fee estimate for the specified confirmation target,
+// which must be at least 1.
+func (e *Estimator) Estimate(target uint64) Estimate {
+	target = max(target, 1)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// for each run of target consecutive blocks, a transaction would have
+	// been confirmed within the run if it paid the lowest inclusion rate
+	// among the run's blocks
+	var rates []types.Currency
+	if n := uint64(len(e.blocks)); n > 0 {
+		window := min(target, n)
+		for i := uint64(0); i+window <= n; i++ {
+			run := e.blocks[i : i+window]
+			minRate := run[0].inclusionRate
+			for _, bs := range run[1:] {
+				if bs.inclusionRate.Cmp(minRate) < 0 {
+					minRate = bs.inclusionRate
+				}
+			}
+			rates = append(rates, minRate)
+		}
+		slices.SortFunc(rates, types.Currency.Cmp)
+	}
+	est := Estimate{
+		Target: target,
+		Percentiles: Percentiles{
+			P10: percentile(rates, 10),
+			P25: percentile(rates, 25),
+			P50: percentile(rates, 50),
+			P75: percentile(rates, 75),
+			P90: percentile(rates, 90),
+		},
+	}
+
+	// if the pool contains more weight than fits in target blocks, a
+	// transaction must outbid the excess
+	if e.maxWeight > 0 && len(e.pool) > 0 {
+		pool := make([]poolTxn, 0, len(e.pool))
+		for _, txn := range e.pool {
+			pool = append(pool, txn)
+		}
+		slices.SortFunc(pool, func(a, b poolTxn) int { return b.rate.Cmp(a.rate) })
+		capacity := e.maxWeight * target
+		var weight uint64
+		for _, txn := range pool {
+			if weight += txn.weight; weight > capacity {
+				est.Pool = txn.rate.Add(types.NewCurrency64(1))
+				break
+			}
+		}
+	}
+
+	est.Recommended = est.Percentiles.P50
+	if target == TargetNextBlock {
+		est.Recommended = est.Percentiles.P75
+	}
+	for _, c := range []types.Currency{est.Pool, e.floor} {
+		if c.Cmp(est.Recommended) > 0 {
+			est.Recommended = c
+		}
+	}
+	return est
+}
+
+// NewEstimator returns an Estimator for the chain whose tip is s. Until it
+// ingests any blocks, it recommends the Floor of s.
+func NewEstimator(s consensus.State) *Estimator {
+	return &Estimator{
+		pool:      make(map[types.TransactionID]poolTxn),
+		floor:     Floor(s),
+		maxWeight: s.MaxBlockWeight(),
+	}
+}
@@ -0,0 +1,93 @@
+package fees
+
+import (
+	"testing"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+// txnWithRate returns a transaction of roughly the given weight, paying the
+// given fee per unit of weight.
+func txnWithRate(s consensus.State, weight uint64, rate types.Currency, nonce byte) types.Transaction {
+	txn := types.Transaction{
+		ArbitraryData: [][]byte{make([]byte, weight)},
+		MinerFees:     []types.Currency{types.ZeroCurrency},
+	}
+	txn.ArbitraryData[0][0] = nonce
+	// the weight depends on the encoded size of the fee
+	for range 2 {
+		txn.MinerFees[0] = rate.Mul64(s.TransactionWeight(txn))
+	}
+	return txn
+}
+
+func TestEstimator(t *testing.T) {
+	n, _ := consensus.Devnet()
+	s := n.GenesisState()
+	floor := Floor(s)
+	if floor.IsZero() {
+		t.Fatal("expected nonzero floor")
+	}
+
+	e := NewEstimator(s)
+	if est := e.Estimate(TargetNextBlock); est.Recommended != floor || est.Percentiles != (Percentiles{}) {
+		t.Fatalf("expected empty estimate recommending the floor, got %+v", est)
+	}
+
+	// alternate between full and empty blocks
+	high, low := floor.Mul64(100), floor.Mul64(10)
+	var height byte
+	block := func(txns ...types.Transaction) types.Block {
+		height++
+		return types.Block{Nonce: uint64(height), Transactions: txns}
+	}
+	for i := 0; i < 10; i++ {
+		e.ApplyBlock(s, block(
+			txnWithRate(s, s.MaxBlockWeight()*95/100, high, height),
+			txnWithRate(s, 1000, low, height),
+		))
+		e.ApplyBlock(s, block())
+	}
+
+	// half of all blocks required the low rate
+	next := e.Estimate(TargetNextBlock)
+	if next.Percentiles.P50.Cmp(low) > 0 || next.Percentiles.P75 != low || next.Percentiles.P90 != low {
+		t.Fatalf("unexpected percentiles: %+v", next.Percentiles)
+	} else if next.Recommended != low {
+		t.Fatalf("expected recommendation of %v, got %v", low, next.Recommended)
+	}
+	// every run of 6 blocks contained an empty block
+	if hour := e.Estimate(TargetHour); hour.Percentiles.P90 != types.ZeroCurrency || hour.Recommended != floor {
+		t.Fatalf("expected floor, got %+v", hour)
+	} else if day := e.Estimate(TargetDay); day.Recommended != floor {
+		t.Fatalf("expected floor, got %+v", day)
+	}
+
+	// reverting the last (empty) block leaves a full block at the tip
+	last := block()
+	e.ApplyBlock(s, last)
+	e.RevertBlock(s, last)
+	if len(e.blocks) != 20 {
+		t.Fatalf("expected 20 blocks, got %v", len(e.blocks))
+	}
+
+	// a congested pool raises the estimate for the next block, but not for
+	// later blocks
+	var pool []types.Transaction
+	for i := range 3 {
+		pool = append(pool, txnWithRate(s, s.MaxBlockWeight()/2, high, byte(100+i)))
+	}
+	e.AddPoolTransactions(s, pool, nil)
+	if next := e.Estimate(TargetNextBlock); next.Pool.Cmp(high) <= 0 || next.Recommended != next.Pool {
+		t.Fatalf("expected pool to raise estimate, got %+v", next)
+	} else if hour := e.Estimate(TargetHour); !hour.Pool.IsZero() {
+		t.Fatalf("expected uncongested pool for 6 blocks, got %+v", hour)
+	}
+	// confirmed and evicted transactions leave the pool
+	e.ApplyBlock(s, block(pool[0]))
+	e.RemovePoolTransactions([]types.TransactionID{pool[1].ID()})
+	if next := e.Estimate(TargetNextBlock); !next.Pool.IsZero() {
+		t.Fatalf("expected uncongested pool, got %+v", next)
+	}
+}
@@ -0,0 +1,298 @@
+// Package forest implements a persistent store for the nodes of the consensus
+// ElementAccumulator, allowing up-to-date Merkle proofs to be produced for any
+// leaf at the current tip.
+//
+// The accumulator is a forest of perfect binary trees. Each node is identified
+// by its row (0 for leaves) and column (its index within the row). A Store
+// consumes the nodes emitted by ApplyUpdate.ForEachTreeNode and
+// RevertUpdate.ForEachTreeNode, so it contains every node of the current forest
+// as long as it is fed every block since genesis.
+package forest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sync"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+// ErrNotFound is returned by a Backend when a key does not exist.
+var ErrNotFound = errors.New("not found")
+
+// A Backend is a key-value store used to persist accumulator nodes.
+type Backend interface {
+	// Get returns the value associated with key, or ErrNotFound.
+	Get(key []byte) ([]byte, error)
+	Put(key, value []byte) error
+	// Delete removes key. It is not an error to delete a nonexistent key.
+	Delete(key []byte) error
+}
+
+// A MemBackend is an in-memory Backend.
+type MemBackend struct {
+	mu sync.Mutex
+	m  map[string][]byte
+}
+
+// Get implements Backend.
+func (mb *MemBackend) Get(key []byte) ([]byte, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	v, ok := mb.m[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), v...), nil
+}
+
+// Put implements Backend.
+func (mb *MemBackend) Put(key, value []byte) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.m[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+// Delete implements Backend.
+func (mb *MemBackend) Delete(key []byte) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	delete(mb.m, string(key))
+	return nil
+}
+
+// Len returns the number of keys in the backend.
+func (mb *MemBackend) Len() int {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return len(mb.m)
+}
+
+// NewMemBackend returns an empty MemBackend.
+func NewMemBackend() *MemBackend {
+	return &MemBackend{m: make(map[string][]byte)}
+}
+
+var metaKey = []byte("meta")
+
+func nodeKey(row, col uint64) []byte {
+	key := make([]byte, 1+1+8)
+	key[0] = 'n'
+	key[1] = byte(row)
+	binary.BigEndian.PutUint64(key[2:], col)
+	return key
+}
+
+// A Store tracks the nodes of the ElementAccumulator.
+//
+// A block's nodes are staged and checked against the accumulator before any
+// are written, so a rejected update never modifies the store. Writes are
+// idempotent, and the store's metadata is written only after all of a block's
+// nodes have been written. If the process crashes partway through ApplyBlock
+// or RevertBlock, calling the same method again with the same arguments
+// restores consistency.
+type Store struct {
+	mu        sync.Mutex
+	b         Backend
+	tip       types.ChainIndex
+	numLeaves uint64
+}
+
+// Tip returns the index of the most recently applied block.
+func (s *Store) Tip() types.ChainIndex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tip
+}
+
+// NumLeaves returns the number of leaves in the accumulator as of the tip.
+func (s *Store) NumLeaves() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.numLeaves
+}
+
+func (s *Store) putNode(row, col uint64, h types.Hash256) error {
+	return s.b.Put(nodeKey(row, col), h[:])
+}
+
+func (s *Store) getNode(row, col uint64) (types.Hash256, error) {
+	v, err := s.b.Get(nodeKey(row, col))
+	if err != nil {
+		return types.Hash256{}, fmt.Errorf("failed to get node (%v, %v): %w", row, col, err)
+	} else if len(v) != len(types.Hash256{}) {
+		return types.Hash256{}, fmt.Errorf("node (%v, %v) has invalid length %v", row, col, len(v))
+	}
+	return types.Hash256(v), nil
+}
+
+func (s *Store) writeMeta(tip types.ChainIndex, numLeaves uint64) error {
+	buf := make([]byte, 8+32+8)
+	binary.LittleEndian.PutUint64(buf[0:], tip.Height)
+	copy(buf[8:], tip.ID[:])
+	binary.LittleEndian.PutUint64(buf[40:], numLeaves)
+	if err := s.b.Put(metaKey, buf); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	s.tip, s.numLeaves = tip, numLeaves
+	return nil
+}
+
+type nodePos struct{ row, col uint64 }
+
+// stageNodes collects the nodes emitted by forEach, so that they can be
+// checked before any of them are written.
+func stageNodes(forEach func(func(row, col uint64, h types.Hash256))) map[nodePos]types.Hash256 {
+	staged := make(map[nodePos]types.Hash256)
+	forEach(func(row, col uint64, h types.Hash256) {
+		staged[nodePos{row, col}] = h
+	})
+	return staged
+}
+
+// checkRoots checks that the stored roots, updated with the staged nodes,
+// match the accumulator.
+func (s *Store) checkRoots(acc consensus.ElementAccumulator, staged map[nodePos]types.Hash256) error {
+	start := uint64(0)
+	for height := 63; height >= 0; height-- {
+		if acc.NumLeaves&(1<<height) == 0 {
+			continue
+		}
+		pos := nodePos{uint64(height), start >> height}
+		root, ok := staged[pos]
+		if !ok {
+			var err error
+			if root, err = s.getNode(pos.row, pos.col); err != nil {
+				return err
+			}
+		}
+		if root != acc.Trees[height] {
+			return fmt.Errorf("stored root of tree %v does not match accumulator", height)
+		}
+		start += 1 << height
+	}
+	return nil
+}
+
+// putNodes writes the staged nodes.
+func (s *Store) putNodes(staged map[nodePos]types.Hash256) error {
+	for pos, h := range staged {
+		if err := s.putNode(pos.row, pos.col, h); err != nil {
+			return fmt.Errorf("failed to store node: %w", err)
+		}
+	}
+	return nil
+}
+
+// ApplyBlock updates the store with the nodes affected by au. cs must be the
+// state after applying b, and b must be a child of the store's tip. The update
+// is checked against cs before anything is written, so an invalid update
+// leaves the store unchanged.
+func (s *Store) ApplyBlock(cs consensus.State, b types.Block, au consensus.ApplyUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b.ID() != cs.Index.ID {
+		return fmt.Errorf("block %v does not match state %v", b.ID(), cs.Index)
+	} else if s.tip != (types.ChainIndex{}) && (cs.Index.Height != s.tip.Height+1 || b.ParentID != s.tip.ID) {
+		return fmt.Errorf("cannot apply block %v (parent %v) to store at %v", cs.Index, b.ParentID, s.tip)
+	} else if cs.Elements.NumLeaves < s.numLeaves {
+		return fmt.Errorf("cannot apply block with %v leaves to store with %v leaves", cs.Elements.NumLeaves, s.numLeaves)
+	}
+	staged := stageNodes(au.ForEachTreeNode)
+	if err := s.checkRoots(cs.Elements, staged); err != nil {
+		return fmt.Errorf("store is inconsistent with block %v: %w", cs.Index, err)
+	} else if err := s.putNodes(staged); err != nil {
+		return err
+	}
+	return s.writeMeta(cs.Index, cs.Elements.NumLeaves)
+}
+
+// RevertBlock updates the store with the nodes affected by ru. b must be the
+// store's tip, and cs must be the state after reverting it, i.e. the parent
+// state. Nodes that are no longer part of the accumulator are deleted. As with
+// ApplyBlock, the update is checked before anything is written.
+func (s *Store) RevertBlock(cs consensus.State, b types.Block, ru consensus.RevertUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	numLeaves := cs.Elements.NumLeaves
+	if b.ID() != s.tip.ID || b.ParentID != cs.Index.ID || cs.Index.Height+1 != s.tip.Height {
+		return fmt.Errorf("cannot revert block %v (parent %v) from store at %v", b.ID(), cs.Index, s.tip)
+	} else if numLeaves > s.numLeaves {
+		return fmt.Errorf("cannot revert block with %v leaves from store with %v leaves", numLeaves, s.numLeaves)
+	}
+	staged := stageNodes(ru.ForEachTreeNode)
+	if err := s.checkRoots(cs.Elements, staged); err != nil {
+		return fmt.Errorf("store is inconsistent with block %v: %w", cs.Index, err)
+	} else if err := s.putNodes(staged); err != nil {
+		return err
+	}
+	// delete any node whose span extends past the new number of leaves; such
+	// nodes are not part of any tree in the current forest
+	if s.numLeaves > 0 {
+		for row := uint64(0); row < 64; row++ {
+			for col := numLeaves >> row; col <= (s.numLeaves-1)>>row; col++ {
+				if (col+1)<<row > numLeaves || (col+1)<<row == 0 {
+					if err := s.b.Delete(nodeKey(row, col)); err != nil {
+						return fmt.Errorf("failed to delete node: %w", err)
+					}
+				}
+			}
+		}
+	}
+	return s.writeMeta(cs.Index, numLeaves)
+}
+
+// MerkleProof returns the current Merkle proof for the leaf at the specified
+// index.
+func (s *Store) MerkleProof(leafIndex uint64) ([]types.Hash256, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if leafIndex >= s.numLeaves {
+		return nil, fmt.Errorf("leaf index %v out of range (store has %v leaves)", leafIndex, s.numLeaves)
+	}
+	// the height of the tree containing the leaf is determined by the highest
+	// bit at which the leaf index and the number of leaves differ
+	height := bits.Len64(leafIndex^s.numLeaves) - 1
+	proof := make([]types.Hash256, height)
+	for row := range proof {
+		h, err := s.getNode(uint64(row), (leafIndex>>row)^1)
+		if err != nil {
+			return nil, err
+		}
+		proof[row] = h
+	}
+	return proof, nil
+}
+
+// UpdateElementProof replaces the Merkle proof of e with the current proof.
+func (s *Store) UpdateElementProof(e *types.StateElement) error {
+	proof, err := s.MerkleProof(e.LeafIndex)
+	if err != nil {
+		return err
+	}
+	e.MerkleProof = proof
+	return nil
+}
+
+// New returns a Store backed by b. If b already contains a store, its tip is
+// loaded; otherwise, the store is empty, and the genesis block should be applied
+// first.
+func New(b Backend) (*Store, error) {
+	s := &Store{b: b}
+	buf, err := b.Get(metaKey)
+	if errors.Is(err, ErrNotFound) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	} else if len(buf) != 8+32+8 {
+		return nil, fmt.Errorf("metadata has invalid length %v", len(buf))
+	}
+	s.tip.Height = binary.LittleEndian.Uint64(buf[0:])
+	copy(s.tip.ID[:], buf[8:])
+	s.numLeaves = binary.LittleEndian.Uint64(buf[40:])
+	return s, nil
+}
@@ -0,0 +1,264 @@
+package forest
+
+import (
+	"maps"
+	"math/bits"
+	"slices"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+	"lukechampine.com/frand"
+)
+
+func testNetwork() (*consensus.Network, types.Block) {
+	n := &consensus.Network{
+		Name:            "forest",
+		InitialCoinbase: types.Siacoins(300000),
+		MinimumCoinbase: types.Siacoins(300000),
+		InitialTarget:   types.BlockID{0xFF},
+		BlockInterval:   10 * time.Millisecond,
+		MaturityDelay:   5,
+	}
+	n.HardforkFoundation.PrimaryAddress = types.VoidAddress
+	n.HardforkFoundation.FailsafeAddress = types.VoidAddress
+	n.HardforkOak.GenesisTimestamp = time.Unix(1618033988, 0)
+	n.HardforkV2.AllowHeight = 0
+	n.HardforkV2.RequireHeight = 0
+
+	genesisTxn := types.V2Transaction{}
+	for range 50 {
+		genesisTxn.SiacoinOutputs = append(genesisTxn.SiacoinOutputs, types.SiacoinOutput{
+			Address: types.AnyoneCanSpend().Address(),
+			Value:   types.Siacoins(1000),
+		})
+	}
+	return n, types.Block{
+		Timestamp: n.HardforkOak.GenesisTimestamp,
+		V2:        &types.V2BlockData{Transactions: []types.V2Transaction{genesisTxn}},
+	}
+}
+
+func mineBlock(cs consensus.State, txns []types.V2Transaction) types.Block {
+	b := types.Block{
+		ParentID:     cs.Index.ID,
+		Timestamp:    types.CurrentTimestamp(),
+		V2:           &types.V2BlockData{Height: cs.Index.Height + 1, Transactions: txns},
+		MinerPayouts: []types.SiacoinOutput{{Address: types.VoidAddress, Value: cs.BlockReward()}},
+	}
+	b.V2.Commitment = cs.Commitment(cs.TransactionsCommitment(b.Transactions, b.V2Transactions()), b.MinerPayouts[0].Address)
+	for b.ID().CmpWork(cs.ChildTarget) < 0 {
+		b.Nonce += cs.NonceFactor()
+	}
+	return b
+}
+
+// spendRandom returns a transaction that spends up to four random elements of
+// sces, each into two new outputs.
+func spendRandom(sces []types.SiacoinElement) types.V2Transaction {
+	var txn types.V2Transaction
+	for _, i := range frand.Perm(len(sces))[:min(len(sces), 4)] {
+		sce := sces[i]
+		txn.SiacoinInputs = append(txn.SiacoinInputs, types.V2SiacoinInput{
+			Parent:          sce.Copy(),
+			SatisfiedPolicy: types.SatisfiedPolicy{Policy: types.AnyoneCanSpend()},
+		})
+		half := sce.SiacoinOutput.Value.Div64(2)
+		txn.SiacoinOutputs = append(txn.SiacoinOutputs,
+			types.SiacoinOutput{Address: sce.SiacoinOutput.Address, Value: half},
+			types.SiacoinOutput{Address: sce.SiacoinOutput.Address, Value: sce.SiacoinOutput.Value.Sub(half)},
+		)
+	}
+	return txn
+}
+
+// A testChain tracks a set of elements and their proofs using UpdateElementProof.
+type testChain struct {
+	t      *testing.T
+	n      *consensus.Network
+	states []consensus.State
+	blocks []types.Block
+	sces   []types.SiacoinElement
+	cies   []types.ChainIndexElement
+	store  *Store
+}
+
+func (tc *testChain) tip() consensus.State { return tc.states[len(tc.states)-1] }
+
+func (tc *testChain) applyBlock(b types.Block) {
+	tc.t.Helper()
+	parent := tc.n.GenesisState()
+	if len(tc.states) > 0 {
+		parent = tc.tip()
+		if err := consensus.ValidateBlock(parent, b, consensus.V1BlockSupplement{}); err != nil {
+			tc.t.Fatal(err)
+		}
+	}
+	cs, au := consensus.ApplyBlock(parent, b, consensus.V1BlockSupplement{}, time.Time{})
+	for i := range tc.sces {
+		au.UpdateElementProof(&tc.sces[i].StateElement)
+	}
+	for i := range tc.cies {
+		au.UpdateElementProof(&tc.cies[i].StateElement)
+	}
+	for _, sced := range au.SiacoinElementDiffs() {
+		if sced.Spent {
+			tc.sces = slices.DeleteFunc(tc.sces, func(sce types.SiacoinElement) bool { return sce.ID == sced.SiacoinElement.ID })
+		} else if sced.SiacoinElement.SiacoinOutput.Address == types.AnyoneCanSpend().Address() {
+			tc.sces = append(tc.sces, sced.SiacoinElement.Copy())
+		}
+	}
+	tc.cies = append(tc.cies, au.ChainIndexElement())
+	if err := tc.store.ApplyBlock(cs, b, au); err != nil {
+		tc.t.Fatal(err)
+	}
+	tc.states = append(tc.states, cs)
+	tc.blocks = append(tc.blocks, b)
+}
+
+func (tc *testChain) revertBlock() {
+	tc.t.Helper()
+	b := tc.blocks[len(tc.blocks)-1]
+	parent := tc.states[len(tc.states)-2]
+	ru := consensus.RevertBlock(parent, b, consensus.V1BlockSupplement{})
+	tc.sces = slices.DeleteFunc(tc.sces, func(sce types.SiacoinElement) bool {
+		return sce.StateElement.LeafIndex >= parent.Elements.NumLeaves
+	})
+	for i := range tc.sces {
+		ru.UpdateElementProof(&tc.sces[i].StateElement)
+	}
+	for _, sced := range ru.SiacoinElementDiffs() {
+		if sced.Spent && !sced.Created {
+			tc.sces = append(tc.sces, sced.SiacoinElement.Copy())
+		}
+	}
+	tc.cies = tc.cies[:len(tc.cies)-1]
+	for i := range tc.cies {
+		ru.UpdateElementProof(&tc.cies[i].StateElement)
+	}
+	if err := tc.store.RevertBlock(parent, b, ru); err != nil {
+		tc.t.Fatal(err)
+	}
+	tc.states = tc.states[:len(tc.states)-1]
+	tc.blocks = tc.blocks[:len(tc.blocks)-1]
+}
+
+func (tc *testChain) mine() {
+	tc.t.Helper()
+	tc.applyBlock(mineBlock(tc.tip(), []types.V2Transaction{spendRandom(tc.sces)}))
+}
+
+func (tc *testChain) checkProofs(store *Store) {
+	tc.t.Helper()
+	cs := tc.tip()
+	if store.Tip() != cs.Index {
+		tc.t.Fatalf("store tip %v does not match chain tip %v", store.Tip(), cs.Index)
+	}
+	for _, sce := range tc.sces {
+		if err := cs.Elements.VerifySiacoinElement(sce.Share(), false); err != nil {
+			tc.t.Fatal(err)
+		}
+		proof, err := store.MerkleProof(sce.StateElement.LeafIndex)
+		if err != nil {
+			tc.t.Fatal(err)
+		} else if !slices.Equal(proof, sce.StateElement.MerkleProof) {
+			tc.t.Fatalf("proof for leaf %v does not match UpdateElementProof", sce.StateElement.LeafIndex)
+		}
+	}
+	for _, cie := range tc.cies {
+		proof, err := store.MerkleProof(cie.StateElement.LeafIndex)
+		if err != nil {
+			tc.t.Fatal(err)
+		} else if !slices.Equal(proof, cie.StateElement.MerkleProof) {
+			tc.t.Fatalf("proof for chain index %v does not match UpdateElementProof", cie.ChainIndex)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	n, genesisBlock := testNetwork()
+	backend := NewMemBackend()
+	store, err := New(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := &testChain{t: t, n: n, store: store}
+	tc.applyBlock(genesisBlock)
+	tc.checkProofs(store)
+
+	for range 20 {
+		tc.mine()
+		tc.checkProofs(store)
+	}
+
+	// reorg: revert a few blocks, then mine a different chain
+	for range 5 {
+		tc.revertBlock()
+		tc.checkProofs(store)
+	}
+	if _, err := store.MerkleProof(tc.tip().Elements.NumLeaves); err == nil {
+		t.Fatal("expected error for leaf index beyond tip")
+	}
+	for range 10 {
+		tc.mine()
+		tc.checkProofs(store)
+	}
+
+	// reopen the store
+	reopened, err := New(backend)
+	if err != nil {
+		t.Fatal(err)
+	} else if reopened.NumLeaves() != tc.tip().Elements.NumLeaves {
+		t.Fatalf("reopened store has %v leaves, expected %v", reopened.NumLeaves(), tc.tip().Elements.NumLeaves)
+	}
+	tc.checkProofs(reopened)
+
+	// applying a block out of order should be rejected
+	cs, au := consensus.ApplyBlock(tc.tip(), mineBlock(tc.tip(), nil), consensus.V1BlockSupplement{}, time.Time{})
+	next := mineBlock(cs, nil)
+	if cs2, au2 := consensus.ApplyBlock(cs, next, consensus.V1BlockSupplement{}, time.Time{}); store.ApplyBlock(cs2, next, au2) == nil {
+		t.Fatal("expected out-of-order block to be rejected")
+	}
+
+	// as should a block on a sibling fork, even at the correct height
+	sibling := mineBlock(tc.states[len(tc.states)-2], nil)
+	siblingState, _ := consensus.ApplyBlock(tc.states[len(tc.states)-2], sibling, consensus.V1BlockSupplement{}, time.Time{})
+	child := mineBlock(siblingState, nil)
+	if childState, childAU := consensus.ApplyBlock(siblingState, child, consensus.V1BlockSupplement{}, time.Time{}); store.ApplyBlock(childState, child, childAU) == nil {
+		t.Fatal("expected block on sibling fork to be rejected")
+	} else if store.RevertBlock(tc.states[len(tc.states)-2], sibling, consensus.RevertBlock(tc.states[len(tc.states)-2], sibling, consensus.V1BlockSupplement{})) == nil {
+		t.Fatal("expected revert of sibling block to be rejected")
+	}
+
+	// an update that does not match the block should be rejected without
+	// modifying the store
+	snapshot := func() map[string]string {
+		m := make(map[string]string)
+		for k, v := range backend.m {
+			m[k] = string(v)
+		}
+		return m
+	}
+	before := snapshot()
+	next = mineBlock(tc.tip(), []types.V2Transaction{spendRandom(tc.sces)})
+	nextState, _ := consensus.ApplyBlock(tc.tip(), next, consensus.V1BlockSupplement{}, time.Time{})
+	if err := store.ApplyBlock(nextState, next, au); err == nil {
+		t.Fatal("expected mismatched update to be rejected")
+	} else if !maps.Equal(before, snapshot()) {
+		t.Fatal("rejected update modified the store")
+	}
+	tc.applyBlock(next)
+	tc.checkProofs(store)
+
+	// corrupting a stored root should be detected
+	acc := tc.tip().Elements
+	height := 63 - bits.LeadingZeros64(acc.NumLeaves)
+	next = mineBlock(tc.tip(), nil)
+	nextState, au = consensus.ApplyBlock(tc.tip(), next, consensus.V1BlockSupplement{}, time.Time{})
+	if err := backend.Put(nodeKey(uint64(height), 0), make([]byte, 32)); err != nil {
+		t.Fatal(err)
+	} else if err := store.ApplyBlock(nextState, next, au); err == nil {
+		t.Fatal("expected inconsistent store to be detected")
+	}
+}
@@ -281,6 +281,42 @@ func (r *RPCSendV2Blocks) decodeResponse(d *types.Decoder) {
 }
 func (r *RPCSendV2Blocks) maxResponseLen() int { return int(r.Max) * 5e6 }
 
+// RPCSendStatelessBlocks requests a set of blocks, along with the witnesses
+// required to validate and apply them without a UTXO database. Witnesses for v2
+// blocks are empty, since v2 transactions already carry their own proofs.
+type RPCSendStatelessBlocks struct {
+	History   []types.BlockID
+	Max       uint64
+	Blocks    []types.Block
+	Witnesses []consensus.V1BlockWitness
+	Remaining uint64
+}
+
+func (r *RPCSendStatelessBlocks) encodeRequest(e *types.Encoder) {
+	types.EncodeSlice(e, r.History)
+	e.WriteUint64(r.Max)
+}
+func (r *RPCSendStatelessBlocks) decodeRequest(d *types.Decoder) {
+	types.DecodeSlice(d, &r.History)
+	r.Max = d.ReadUint64()
+}
+func (r *RPCSendStatelessBlocks) maxRequestLen() int { return 8 + 32*32 + 8 }
+
+func (r *RPCSendStatelessBlocks) encodeResponse(e *types.Encoder) {
+	types.EncodeSliceCast[types.V2Block](e, r.Blocks)
+	types.EncodeSlice(e, r.Witnesses)
+	e.WriteUint64(r.Remaining)
+}
+func (r *RPCSendStatelessBlocks) decodeResponse(d *types.Decoder) {
+	types.DecodeSliceCast[types.V2Block](d, &r.Blocks)
+	types.DecodeSlice(d, &r.Witnesses)
+	if len(r.Witnesses) != len(r.Blocks) {
+		d.SetErr(fmt.Errorf("response contains %v blocks but %v witnesses", len(r.Blocks), len(r.Witnesses)))
+	}
+	r.Remaining = d.ReadUint64()
+}
+func (r *RPCSendStatelessBlocks) maxResponseLen() int { return int(r.Max) * 10e6 }
+
 // RPCSendTransactions requests a subset of a block's transactions.
 type RPCSendTransactions struct {
 	Index  types.ChainIndex
@@ -408,6 +444,7 @@ var (
 	idRelayV2Header         = types.NewSpecifier("RelayV2Header")
 	idRelayV2BlockOutline   = types.NewSpecifier("RelayV2Outline")
 	idRelayV2TransactionSet = types.NewSpecifier("RelayV2Txns")
+	idSendStatelessBlocks   = types.NewSpecifier("StatelessBlocks")
 )
 
 func idForObject(o Object) types.Specifier {
@@ -436,6 +473,8 @@ func idForObject(o Object) types.Specifier {
 		return idRelayV2BlockOutline
 	case *RPCRelayV2TransactionSet:
 		return idRelayV2TransactionSet
+	case *RPCSendStatelessBlocks:
+		return idSendStatelessBlocks
 	default:
 		panic(fmt.Sprintf("unhandled object type %T", o))
 	}
@@ -468,6 +507,8 @@ func ObjectForID(id types.Specifier) Object {
 		return new(RPCRelayV2BlockOutline)
 	case idRelayV2TransactionSet:
 		return new(RPCRelayV2TransactionSet)
+	case idSendStatelessBlocks:
+		return new(RPCSendStatelessBlocks)
 	default:
 		return nil
 	}
@@ -0,0 +1,318 @@
+// Package hostscore helps renters choose hosts by scoring their prices and
+// settings.
+//
+// Hosts advertise their prices differently depending on the protocol they
+// speak: RHP2 hosts publish HostSettings, RHP3 hosts additionally publish a
+// HostPriceTable, and RHP4 hosts publish HostSettings containing signed
+// HostPrices. FromV2, FromV3 and FromV4 normalize all three into Settings,
+// expressing prices as RHP4 HostPrices, so that the cost of a Workload can be
+// computed with the RHP4 cost functions regardless of protocol.
+//
+// Evaluate rejects hosts that exceed a renter's GougingLimits, and scores the
+// rest on their expected cost, collateral, maximum contract duration,
+// remaining storage and protocol version.
+package hostscore
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+
+	rhp2 "go.sia.tech/core/rhp/v2"
+	rhp3 "go.sia.tech/core/rhp/v3"
+	rhp4 "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/core/types"
+)
+
+const (
+	// BlocksPerMonth is the expected number of blocks in 30 days.
+	BlocksPerMonth = 144 * 30
+
+	// TB is one terabyte, in bytes.
+	TB = 1e12
+)
+
+// Settings are the settings of a host, normalized across protocol versions.
+type Settings struct {
+	// Protocol is the major version of the renter-host protocol spoken by the
+	// host: 2, 3 or 4.
+	Protocol uint8 `json:"protocol"`
+	// Version is the version reported by the host.
+	Version [3]uint8 `json:"version"`
+
+	AcceptingContracts  bool           `json:"acceptingContracts"`
+	MaxCollateral       types.Currency `json:"maxCollateral"`
+	MaxContractDuration uint64         `json:"maxContractDuration"`
+	// RemainingStorage and TotalStorage are in bytes.
+	RemainingStorage uint64 `json:"remainingStorage"`
+	TotalStorage     uint64 `json:"totalStorage"`
+
+	// Prices are the host's prices, converted to RHP4 prices. Older protocols
+	// charge a base price for each RPC; since RHP4 has no equivalent, these
+	// are amortized over a full sector and added to the ingress and egress
+	// prices. The prices are not signed.
+	Prices rhp4.HostPrices `json:"prices"`
+}
+
+// parseVersion parses a version string of the form "1.2.3". Components that
+// are missing or invalid are zero.
+func parseVersion(s string) (v [3]uint8) {
+	for i, p := range strings.SplitN(strings.TrimPrefix(s, "v"), ".", 3) {
+		n, _ := strconv.ParseUint(p, 10, 8)
+		v[i] = uint8(n)
+	}
+	return
+}
+
+// perByte amortizes a per-sector price over the bytes of a sector.
+func perByte(c types.Currency) types.Currency {
+	return c.Div64(rhp4.SectorSize)
+}
+
+// FromV2 normalizes the settings of an RHP2 host.
+func FromV2(hs rhp2.HostSettings) Settings {
+	return Settings{
+		Protocol:            2,
+		Version:             parseVersion(hs.Version),
+		AcceptingContracts:  hs.AcceptingContracts,
+		MaxCollateral:       hs.MaxCollateral,
+		MaxContractDuration: hs.MaxDuration,
+		RemainingStorage:    hs.RemainingStorage,
+		TotalStorage:        hs.TotalStorage,
+		Prices: rhp4.HostPrices{
+			ContractPrice:   hs.ContractPrice,
+			Collateral:      hs.Collateral,
+			StoragePrice:    hs.StoragePrice,
+			IngressPrice:    hs.UploadBandwidthPrice.Add(perByte(hs.BaseRPCPrice)),
+			EgressPrice:     hs.DownloadBandwidthPrice.Add(perByte(hs.BaseRPCPrice.Add(hs.SectorAccessPrice))),
+			FreeSectorPrice: hs.BaseRPCPrice,
+		},
+	}
+}
+
+// FromV3 normalizes the settings of an RHP3 host. RHP3 hosts also publish
+// RHP2 settings; the prices in the price table take precedence.
+func FromV3(hs rhp2.HostSettings, pt rhp3.HostPriceTable) Settings {
+	s := FromV2(hs)
+	s.Protocol = 3
+	s.MaxCollateral = pt.MaxCollateral
+	s.MaxContractDuration = pt.MaxDuration
+	s.Prices = rhp4.HostPrices{
+		ContractPrice:   pt.ContractPrice,
+		Collateral:      pt.CollateralCost,
+		StoragePrice:    pt.WriteStoreCost,
+		IngressPrice:    pt.UploadBandwidthCost.Add(pt.WriteLengthCost).Add(perByte(pt.InitBaseCost.Add(pt.WriteBaseCost))),
+		EgressPrice:     pt.DownloadBandwidthCost.Add(pt.ReadLengthCost).Add(perByte(pt.InitBaseCost.Add(pt.ReadBaseCost))),
+		FreeSectorPrice: pt.DropSectorsUnitCost,
+		TipHeight:       pt.HostBlockHeight,
+	}
+	return s
+}
+
+// FromV4 normalizes the settings of an RHP4 host.
+func FromV4(hs rhp4.HostSettings) Settings {
+	return Settings{
+		Protocol:            4,
+		Version:             hs.ProtocolVersion,
+		AcceptingContracts:  hs.AcceptingContracts,
+		MaxCollateral:       hs.MaxCollateral,
+		MaxContractDuration: hs.MaxContractDuration,
+		RemainingStorage:    hs.RemainingStorage * rhp4.SectorSize,
+		TotalStorage:        hs.TotalStorage * rhp4.SectorSize,
+		Prices:              hs.Prices,
+	}
+}
+
+// A Workload describes how a renter expects to use a host over the course of
+// a contract.
+type Workload struct {
+	Storage  uint64 `json:"storage"`  // bytes stored for the full duration
+	Upload   uint64 `json:"upload"`   // bytes uploaded
+	Download uint64 `json:"download"` // bytes downloaded
+	Duration uint64 `json:"duration"` // blocks
+}
+
+// MonthlyTB is a Workload that uploads and stores 1 TB for a month, and
+// downloads it once.
+var MonthlyTB = Workload{
+	Storage:  TB,
+	Upload:   TB,
+	Download: TB,
+	Duration: BlocksPerMonth,
+}
+
+func sectors(n uint64) uint64 {
+	return (n + rhp4.SectorSize - 1) / rhp4.SectorSize
+}
+
+// Cost returns the expected cost of w on a single contract with the host. The
+// contract price is included in the RPC cost.
+func (s Settings) Cost(w Workload) rhp4.Usage {
+	p := s.Prices
+	u := rhp4.Usage{RPC: p.ContractPrice}
+	u = u.Add(p.RPCWriteSectorCost(rhp4.SectorSize).Mul(sectors(w.Upload)))
+	u = u.Add(p.RPCAppendSectorsCost(sectors(w.Storage), w.Duration))
+	u = u.Add(p.RPCReadSectorCost(rhp4.SectorSize).Mul(sectors(w.Download)))
+	return u
+}
+
+// MonthlyCostPerTB returns the expected cost to a renter of the MonthlyTB
+// workload.
+func (s Settings) MonthlyCostPerTB() types.Currency {
+	return s.Cost(MonthlyTB).RenterCost()
+}
+
+// GougingLimits are the highest prices a renter is willing to pay, and the
+// least a host must offer. A zero value disables the corresponding check.
+type GougingLimits struct {
+	MaxContractPrice   types.Currency `json:"maxContractPrice"`
+	MaxStoragePrice    types.Currency `json:"maxStoragePrice"` // per byte per block
+	MaxIngressPrice    types.Currency `json:"maxIngressPrice"` // per byte
+	MaxEgressPrice     types.Currency `json:"maxEgressPrice"`  // per byte
+	MaxFreeSectorPrice types.Currency `json:"maxFreeSectorPrice"`
+	// MaxCost is the most a renter is willing to pay for the Workload of a
+	// Config.
+	MaxCost types.Currency `json:"maxCost"`
+
+	MinCollateral       types.Currency `json:"minCollateral"` // per byte per block
+	MinMaxCollateral    types.Currency `json:"minMaxCollateral"`
+	MinContractDuration uint64         `json:"minContractDuration"`
+}
+
+// A GougingError is returned by Evaluate when a host's settings exceed a
+// renter's GougingLimits.
+type GougingError struct {
+	Reasons []string
+}
+
+// Error implements error.
+func (e *GougingError) Error() string {
+	return "host is gouging: " + strings.Join(e.Reasons, "; ")
+}
+
+// CheckGouging returns a *GougingError if s exceeds the limits of cfg.
+func CheckGouging(s Settings, cfg Config) error {
+	l, p := cfg.Limits, s.Prices
+	var reasons []string
+	exceeds := func(name string, v, limit types.Currency) {
+		if !limit.IsZero() && v.Cmp(limit) > 0 {
+			reasons = append(reasons, fmt.Sprintf("%v %v exceeds limit of %v", name, v, limit))
+		}
+	}
+	below := func(name string, v, limit types.Currency) {
+		if !limit.IsZero() && v.Cmp(limit) < 0 {
+			reasons = append(reasons, fmt.Sprintf("%v %v is below minimum of %v", name, v, limit))
+		}
+	}
+	exceeds("contract price", p.ContractPrice, l.MaxContractPrice)
+	exceeds("storage price", p.StoragePrice, l.MaxStoragePrice)
+	exceeds("ingress price", p.IngressPrice, l.MaxIngressPrice)
+	exceeds("egress price", p.EgressPrice, l.MaxEgressPrice)
+	exceeds("free sector price", p.FreeSectorPrice, l.MaxFreeSectorPrice)
+	exceeds("expected cost", s.Cost(cfg.Workload).RenterCost(), l.MaxCost)
+	below("collateral", p.Collateral, l.MinCollateral)
+	below("max collateral", s.MaxCollateral, l.MinMaxCollateral)
+	if s.MaxContractDuration < l.MinContractDuration {
+		reasons = append(reasons, fmt.Sprintf("max contract duration %v is below minimum of %v", s.MaxContractDuration, l.MinContractDuration))
+	}
+	if len(reasons) > 0 {
+		return &GougingError{Reasons: reasons}
+	}
+	return nil
+}
+
+// A Config determines how hosts are scored.
+type Config struct {
+	// Workload is the renter's expected usage of each host.
+	Workload Workload `json:"workload"`
+	// Budget is the amount the renter expects to pay for Workload. A host
+	// whose expected cost equals the budget has a price score of 0.5. If
+	// Budget is zero, prices are not scored.
+	Budget types.Currency `json:"budget"`
+	// CollateralRatio is the ratio of risked collateral to storage cost at
+	// which a host has a collateral score of 1.
+	CollateralRatio float64 `json:"collateralRatio"`
+	// StorageHeadroom is the multiple of Workload.Storage a host must have
+	// remaining to have a storage score of 1.
+	StorageHeadroom float64 `json:"storageHeadroom"`
+	// MinVersions maps each protocol to the oldest Version that is not
+	// penalized, e.g. the latest release. Versions are compared only within a
+	// protocol, since RHP2 and RHP3 hosts report their software version,
+	// while RHP4 hosts report their protocol version.
+	MinVersions map[uint8][3]uint8 `json:"minVersions"`
+
+	Limits GougingLimits `json:"limits"`
+}
+
+// DefaultConfig returns a Config that stores 1 TB per host per month.
+func DefaultConfig() Config {
+	return Config{
+		Workload:        MonthlyTB,
+		Budget:          types.Siacoins(1000),
+		CollateralRatio: 2,
+		StorageHeadroom: 10,
+	}
+}
+
+// A Score is the breakdown of a host's score. Each component is between 0
+// and 1, with higher being better.
+type Score struct {
+	Prices     float64 `json:"prices"`
+	Collateral float64 `json:"collateral"`
+	Duration   float64 `json:"duration"`
+	Storage    float64 `json:"storage"`
+	Version    float64 `json:"version"`
+}
+
+// Total returns the product of the score's components.
+func (s Score) Total() float64 {
+	return s.Prices * s.Collateral * s.Duration * s.Storage * s.Version
+}
+
+// ratio returns a/b as a float64.
+func ratio(a, b types.Currency) float64 {
+	f, _ := new(big.Rat).SetFrac(a.Big(), b.Big()).Float64()
+	return f
+}
+
+// Evaluate scores a host. If the host exceeds the limits of cfg, it returns a
+// *GougingError.
+func Evaluate(s Settings, cfg Config) (Score, error) {
+	if err := CheckGouging(s, cfg); err != nil {
+		return Score{}, err
+	}
+	w := cfg.Workload
+
+	score := Score{Prices: 1, Collateral: 1, Duration: 1, Storage: 1}
+	if !cfg.Budget.IsZero() {
+		score.Prices = ratio(cfg.Budget, cfg.Budget.Add(s.Cost(w).RenterCost()))
+	}
+	// compare the collateral to the cost of long-term storage, bearing in mind
+	// that the host will not risk more than its max collateral
+	stored := s.Prices.RPCAppendSectorsCost(sectors(w.Storage), w.Duration)
+	collateral := stored.RiskedCollateral
+	if collateral.Cmp(s.MaxCollateral) > 0 {
+		collateral = s.MaxCollateral
+	}
+	if !stored.Storage.IsZero() && cfg.CollateralRatio > 0 {
+		score.Collateral = min(1, ratio(collateral, stored.Storage)/cfg.CollateralRatio)
+	}
+	if w.Duration > 0 {
+		score.Duration = min(1, float64(s.MaxContractDuration)/float64(w.Duration))
+	}
+	if !s.AcceptingContracts {
+		score.Storage = 0
+	} else if want := float64(w.Storage) * cfg.StorageHeadroom; want > 0 {
+		score.Storage = min(1, float64(s.RemainingStorage)/want)
+	}
+	// each protocol version behind RHP4 halves the score, as does an
+	// outdated version of the host's protocol
+	score.Version = math.Pow(0.5, float64(4-min(s.Protocol, 4)))
+	if minVersion, ok := cfg.MinVersions[s.Protocol]; ok && slices.Compare(s.Version[:], minVersion[:]) < 0 {
+		score.Version /= 2
+	}
+	return score, nil
+}
@@ -0,0 +1,185 @@
+package hostscore
+
+import (
+	"errors"
+	"testing"
+
+	rhp2 "go.sia.tech/core/rhp/v2"
+	rhp3 "go.sia.tech/core/rhp/v3"
+	rhp4 "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/core/types"
+)
+
+func TestNormalize(t *testing.T) {
+	h := types.NewCurrency64
+	v4 := rhp4.HostSettings{
+		ProtocolVersion:     [3]uint8{4, 0, 1},
+		AcceptingContracts:  true,
+		MaxCollateral:       types.Siacoins(1000),
+		MaxContractDuration: 1000,
+		RemainingStorage:    100,
+		TotalStorage:        200,
+		Prices: rhp4.HostPrices{
+			ContractPrice:   types.Siacoins(1),
+			Collateral:      h(20),
+			StoragePrice:    h(10),
+			IngressPrice:    h(3),
+			EgressPrice:     h(5),
+			FreeSectorPrice: h(7),
+		},
+	}
+	v2 := rhp2.HostSettings{
+		AcceptingContracts:     true,
+		MaxCollateral:          types.Siacoins(1000),
+		MaxDuration:            1000,
+		RemainingStorage:       100 * rhp4.SectorSize,
+		TotalStorage:           200 * rhp4.SectorSize,
+		Collateral:             h(20),
+		ContractPrice:          types.Siacoins(1),
+		StoragePrice:           h(10),
+		UploadBandwidthPrice:   h(3),
+		DownloadBandwidthPrice: h(5),
+		BaseRPCPrice:           h(7),
+		Version:                "1.6.0",
+	}
+	pt := rhp3.HostPriceTable{
+		MaxCollateral:         types.Siacoins(1000),
+		MaxDuration:           1000,
+		ContractPrice:         types.Siacoins(1),
+		CollateralCost:        h(20),
+		WriteStoreCost:        h(10),
+		UploadBandwidthCost:   h(3),
+		DownloadBandwidthCost: h(5),
+		DropSectorsUnitCost:   h(7),
+	}
+
+	s2, s3, s4 := FromV2(v2), FromV3(v2, pt), FromV4(v4)
+	if s2.Protocol != 2 || s3.Protocol != 3 || s4.Protocol != 4 {
+		t.Fatal("wrong protocol")
+	} else if s2.Version != [3]uint8{1, 6, 0} || s4.Version != v4.ProtocolVersion {
+		t.Fatal("wrong version", s2.Version, s4.Version)
+	} else if s2.RemainingStorage != s4.RemainingStorage || s2.TotalStorage != s4.TotalStorage {
+		t.Fatal("storage should be normalized to bytes")
+	}
+	// the v2 base RPC price is amortized over a sector
+	if s2.Prices.IngressPrice != h(3) || s2.Prices.EgressPrice != h(5) {
+		t.Fatal("amortized base price should be negligible", s2.Prices)
+	} else if s3.Prices != s4.Prices {
+		t.Fatalf("expected v3 prices %v to match v4 prices %v", s3.Prices, s4.Prices)
+	}
+	v2.SectorAccessPrice = h(rhp4.SectorSize * 2)
+	if p := FromV2(v2).Prices.EgressPrice; p != h(7) {
+		t.Fatalf("expected sector access price to be amortized, got %v", p)
+	}
+
+	// 1 TB is 238419 sectors
+	const n = 238419
+	want := types.Siacoins(1). // contract
+					Add(h(10 * rhp4.SectorSize * rhp4.TempSectorDuration * n)). // temp storage
+					Add(h(3 * rhp4.SectorSize * n)).                            // upload
+					Add(h(10 * rhp4.SectorSize * n * BlocksPerMonth)).          // storage
+					Add(h(3 * ((32*n + 4095) &^ 4095))).                        // append
+					Add(h(5 * rhp4.SectorSize * n))                             // download
+	if got := s4.MonthlyCostPerTB(); got != want {
+		t.Fatalf("expected monthly cost %v, got %v", want, got)
+	} else if got := s3.MonthlyCostPerTB(); got != want {
+		t.Fatalf("expected monthly cost %v, got %v", want, got)
+	}
+	if u := s4.Cost(MonthlyTB); u.RiskedCollateral != h(20*rhp4.SectorSize*n*BlocksPerMonth) {
+		t.Fatal("wrong collateral", u.RiskedCollateral)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	h := types.NewCurrency64
+	good := Settings{
+		Protocol:            4,
+		AcceptingContracts:  true,
+		MaxCollateral:       types.Siacoins(100000),
+		MaxContractDuration: 2 * BlocksPerMonth,
+		RemainingStorage:    20 * TB,
+		Prices: rhp4.HostPrices{
+			ContractPrice: types.Siacoins(1).Div64(10),
+			Collateral:    h(4e10),
+			StoragePrice:  h(2e10), // ~86 SC/TB/month
+			IngressPrice:  h(1e14), // 100 SC/TB
+			EgressPrice:   h(1e14),
+		},
+	}
+	cfg := DefaultConfig()
+	score, err := Evaluate(good, cfg)
+	if err != nil {
+		t.Fatal(err)
+	} else if score.Collateral != 1 || score.Duration != 1 || score.Storage != 1 || score.Version != 1 {
+		t.Fatalf("unexpected score %+v", score)
+	} else if score.Prices <= 0.5 || score.Prices >= 1 {
+		t.Fatalf("expected host within budget to score above 0.5, got %v", score.Prices)
+	}
+
+	worse := func(name string, fn func(*Settings)) {
+		t.Helper()
+		s := good
+		fn(&s)
+		other, err := Evaluate(s, cfg)
+		if err != nil {
+			t.Fatal(err)
+		} else if other.Total() >= score.Total() {
+			t.Fatalf("expected %v host to score lower (%v >= %v)", name, other.Total(), score.Total())
+		}
+	}
+	worse("expensive", func(s *Settings) { s.Prices.StoragePrice = s.Prices.StoragePrice.Mul64(10) })
+	worse("low collateral", func(s *Settings) { s.Prices.Collateral = s.Prices.StoragePrice })
+	worse("low max collateral", func(s *Settings) { s.MaxCollateral = types.Siacoins(1) })
+	worse("short duration", func(s *Settings) { s.MaxContractDuration = BlocksPerMonth / 2 })
+	worse("full", func(s *Settings) { s.RemainingStorage = TB })
+	worse("RHP2", func(s *Settings) { s.Protocol = 2 })
+
+	// outdated versions are penalized only within their protocol
+	cfg.MinVersions = map[uint8][3]uint8{2: {1, 6, 0}, 4: {4, 0, 1}}
+	good.Version = [3]uint8{4, 0, 1}
+	if score, err := Evaluate(good, cfg); err != nil {
+		t.Fatal(err)
+	} else if score.Version != 1 {
+		t.Fatalf("expected current host to have version score 1, got %v", score.Version)
+	}
+	worse("outdated", func(s *Settings) { s.Version = [3]uint8{4, 0, 0} })
+	rhp2 := good
+	rhp2.Protocol, rhp2.Version = 2, [3]uint8{1, 6, 0}
+	if score, _ := Evaluate(rhp2, cfg); score.Version != 0.25 {
+		t.Fatalf("expected current RHP2 host to have version score 0.25, got %v", score.Version)
+	}
+	rhp2.Version = [3]uint8{1, 5, 9}
+	if score, _ := Evaluate(rhp2, cfg); score.Version != 0.125 {
+		t.Fatalf("expected outdated RHP2 host to have version score 0.125, got %v", score.Version)
+	}
+	cfg.MinVersions = nil
+	s := good
+	s.AcceptingContracts = false
+	if score, _ := Evaluate(s, cfg); score.Total() != 0 {
+		t.Fatal("expected host not accepting contracts to score 0")
+	}
+
+	// gouging hosts are rejected
+	cfg.Limits = GougingLimits{
+		MaxEgressPrice:      h(1e14),
+		MaxCost:             types.Siacoins(1000),
+		MinContractDuration: BlocksPerMonth,
+	}
+	if _, err := Evaluate(good, cfg); err != nil {
+		t.Fatal(err)
+	}
+	s = good
+	s.Prices.EgressPrice = h(1e14 + 1)
+	s.MaxContractDuration = 10
+	var ge *GougingError
+	if _, err := Evaluate(s, cfg); !errors.As(err, &ge) {
+		t.Fatalf("expected gouging error, got %v", err)
+	} else if len(ge.Reasons) != 2 {
+		t.Fatalf("expected 2 reasons, got %v", ge.Reasons)
+	}
+	s = good
+	s.Prices.StoragePrice = h(1e12)
+	if err := CheckGouging(s, cfg); !errors.As(err, &ge) || len(ge.Reasons) != 1 {
+		t.Fatalf("expected expected cost to exceed limit, got %v", err)
+	}
+}
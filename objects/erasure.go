@@ -0,0 +1,188 @@
+package objects
+
+import (
+	"errors"
+	"fmt"
+)
+
+// arithmetic in GF(2^8), using the polynomial x^8 + x^4 + x^3 + x^2 + 1
+var (
+	gfExp [510]byte
+	gfLog [256]byte
+	gfMul [256][256]byte
+)
+
+func init() {
+	x := 1
+	for i := range 255 {
+		gfExp[i] = byte(x)
+		gfExp[i+255] = byte(x)
+		gfLog[x] = byte(i)
+		if x <<= 1; x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			gfMul[a][b] = gfExp[int(gfLog[a])+int(gfLog[b])]
+		}
+	}
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// invertMatrix inverts a square matrix in place.
+func invertMatrix(m [][]byte) error {
+	n := len(m)
+	inv := make([][]byte, n)
+	for i := range inv {
+		inv[i] = make([]byte, n)
+		inv[i][i] = 1
+	}
+	for col := range n {
+		pivot := col
+		for pivot < n && m[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return errors.New("matrix is singular")
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		inv[col], inv[pivot] = inv[pivot], inv[col]
+		if c := gfInv(m[col][col]); c != 1 {
+			for j := range n {
+				m[col][j] = gfMul[c][m[col][j]]
+				inv[col][j] = gfMul[c][inv[col][j]]
+			}
+		}
+		for row := range n {
+			if c := m[row][col]; row != col && c != 0 {
+				for j := range n {
+					m[row][j] ^= gfMul[c][m[col][j]]
+					inv[row][j] ^= gfMul[c][inv[col][j]]
+				}
+			}
+		}
+	}
+	copy(m, inv)
+	return nil
+}
+
+// mulAdd sets dst ^= c * src.
+func mulAdd(dst, src []byte, c byte) {
+	switch c {
+	case 0:
+	case 1:
+		for i := range dst {
+			dst[i] ^= src[i]
+		}
+	default:
+		t := &gfMul[c]
+		for i := range dst {
+			dst[i] ^= t[src[i]]
+		}
+	}
+}
+
+// An rsCode is a systematic Reed-Solomon code that encodes n data shards into
+// m total shards, any n of which suffice to recover the data.
+type rsCode struct {
+	n, m int
+	// the encoding matrix; its first n rows are the identity, and every set
+	// of n rows is invertible
+	matrix [][]byte
+}
+
+// encode computes the parity shards of shards[:n], storing them in
+// shards[n:].
+func (rs *rsCode) encode(shards [][]byte) {
+	for i := rs.n; i < rs.m; i++ {
+		clear(shards[i])
+		for j := range rs.n {
+			mulAdd(shards[i], shards[j], rs.matrix[i][j])
+		}
+	}
+}
+
+// reconstruct recovers the data shards, shards[:n], from any n non-nil
+// shards. Missing data shards are allocated as needed.
+func (rs *rsCode) reconstruct(shards [][]byte) error {
+	var rows []int
+	size := 0
+	for i, s := range shards {
+		if s != nil && len(rows) < rs.n {
+			rows = append(rows, i)
+			size = len(s)
+		}
+	}
+	if len(rows) < rs.n {
+		return fmt.Errorf("not enough shards to reconstruct (have %v, need %v)", len(rows), rs.n)
+	} else if rows[rs.n-1] == rs.n-1 {
+		return nil // all data shards are present
+	}
+
+	// invert the rows of the encoding matrix corresponding to the shards we
+	// have, yielding a matrix that maps them back to the data shards
+	dec := make([][]byte, rs.n)
+	for i, r := range rows {
+		dec[i] = append([]byte(nil), rs.matrix[r]...)
+	}
+	if err := invertMatrix(dec); err != nil {
+		panic(err) // should never happen
+	}
+	have := make([][]byte, rs.n)
+	for i, r := range rows {
+		have[i] = shards[r]
+	}
+	for i := range rs.n {
+		if shards[i] != nil {
+			continue
+		}
+		shards[i] = make([]byte, size)
+		for j := range rs.n {
+			mulAdd(shards[i], have[j], dec[i][j])
+		}
+	}
+	return nil
+}
+
+// newRSCode returns a Reed-Solomon code that encodes n data shards into m
+// total shards.
+func newRSCode(n, m int) (*rsCode, error) {
+	if n < 1 || m < n || m > 256 {
+		return nil, fmt.Errorf("invalid erasure coding parameters %v-of-%v", n, m)
+	}
+	// start with a Vandermonde matrix, any n rows of which are invertible,
+	// then multiply it by the inverse of its top square so that the code is
+	// systematic; this preserves the invertibility of every n rows
+	vm := make([][]byte, m)
+	for r := range vm {
+		vm[r] = make([]byte, n)
+		x := byte(1)
+		for c := range vm[r] {
+			vm[r][c] = x
+			x = gfMul[x][byte(r)]
+		}
+	}
+	top := make([][]byte, n)
+	for i := range top {
+		top[i] = append([]byte(nil), vm[i]...)
+	}
+	if err := invertMatrix(top); err != nil {
+		return nil, err
+	}
+	matrix := make([][]byte, m)
+	for r := range matrix {
+		matrix[r] = make([]byte, n)
+		for c := range n {
+			var v byte
+			for k := range n {
+				v ^= gfMul[vm[r][k]][top[k][c]]
+			}
+			matrix[r][c] = v
+		}
+	}
+	return &rsCode{n: n, m: m, matrix: matrix}, nil
+}
@@ -0,0 +1,367 @@
+// Package objects stores arbitrary data on RHP4 hosts.
+//
+// RHP4 hosts store fixed-size sectors. To store an object, Upload splits it
+// into slabs of MinShards sectors, erasure-codes each slab into TotalShards
+// shards, any MinShards of which suffice to recover it, and encrypts each
+// shard with XChaCha20 before uploading it to a different host. The resulting
+// Object is a manifest of the key and sector roots of each slab; Download uses
+// it to fetch and reconstruct the object, tolerating the loss of up to
+// TotalShards-MinShards shards of each slab.
+package objects
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	rhp "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/core/types"
+	"golang.org/x/crypto/chacha20"
+	"lukechampine.com/frand"
+)
+
+// An EncryptionKey is the key used to encrypt the shards of a slab.
+type EncryptionKey [32]byte
+
+// A Sector is a shard of a slab stored on a host.
+type Sector struct {
+	HostKey types.PublicKey
+	Root    types.Hash256
+}
+
+// A Slab is an erasure-coded, encrypted piece of an object.
+type Slab struct {
+	Key       EncryptionKey
+	MinShards uint8
+	Length    uint64 // number of object bytes stored in the slab
+	Shards    []Sector
+}
+
+// An Object is a manifest describing where the data of an object is stored.
+type Object struct {
+	Slabs []Slab
+}
+
+// Size returns the size of the object, in bytes.
+func (o Object) Size() (n uint64) {
+	for _, s := range o.Slabs {
+		n += s.Length
+	}
+	return
+}
+
+// EncodeTo implements types.EncoderTo.
+func (s Sector) EncodeTo(e *types.Encoder) {
+	s.HostKey.EncodeTo(e)
+	s.Root.EncodeTo(e)
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (s *Sector) DecodeFrom(d *types.Decoder) {
+	s.HostKey.DecodeFrom(d)
+	s.Root.DecodeFrom(d)
+}
+
+// EncodeTo implements types.EncoderTo.
+func (s Slab) EncodeTo(e *types.Encoder) {
+	e.Write(s.Key[:])
+	e.WriteUint8(s.MinShards)
+	e.WriteUint64(s.Length)
+	types.EncodeSlice(e, s.Shards)
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (s *Slab) DecodeFrom(d *types.Decoder) {
+	d.Read(s.Key[:])
+	s.MinShards = d.ReadUint8()
+	s.Length = d.ReadUint64()
+	types.DecodeSlice(d, &s.Shards)
+}
+
+// EncodeTo implements types.EncoderTo.
+func (o Object) EncodeTo(e *types.Encoder) {
+	types.EncodeSlice(e, o.Slabs)
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (o *Object) DecodeFrom(d *types.Decoder) {
+	types.DecodeSlice(d, &o.Slabs)
+}
+
+// A SectorStore stores sectors on a host.
+type SectorStore interface {
+	HostKey() types.PublicKey
+	WriteSector(sector *[rhp.SectorSize]byte) (types.Hash256, error)
+	ReadSector(root types.Hash256) (*[rhp.SectorSize]byte, error)
+}
+
+// A HostStore is a SectorStore that stores sectors on an RHP4 host, paying
+// for them from an ephemeral account.
+type HostStore struct {
+	c     *rhp.Client
+	token rhp.AccountToken
+
+	mu     sync.Mutex
+	prices rhp.HostPrices
+}
+
+// currentPrices returns the host's prices, fetching new ones if the cached
+// prices are about to expire.
+func (hs *HostStore) currentPrices() (rhp.HostPrices, error) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if time.Until(hs.prices.ValidUntil) < time.Minute {
+		settings, err := hs.c.Settings()
+		if err != nil {
+			return rhp.HostPrices{}, fmt.Errorf("failed to fetch prices: %w", err)
+		}
+		hs.prices = settings.Prices
+	}
+	return hs.prices, nil
+}
+
+// HostKey implements SectorStore.
+func (hs *HostStore) HostKey() types.PublicKey { return hs.c.HostKey() }
+
+// WriteSector implements SectorStore.
+func (hs *HostStore) WriteSector(sector *[rhp.SectorSize]byte) (types.Hash256, error) {
+	prices, err := hs.currentPrices()
+	if err != nil {
+		return types.Hash256{}, err
+	}
+	root, _, err := hs.c.WriteSector(prices, hs.token, &byteReader{b: sector[:]}, rhp.SectorSize)
+	return root, err
+}
+
+// ReadSector implements SectorStore.
+func (hs *HostStore) ReadSector(root types.Hash256) (*[rhp.SectorSize]byte, error) {
+	prices, err := hs.currentPrices()
+	if err != nil {
+		return nil, err
+	}
+	sector := new([rhp.SectorSize]byte)
+	if _, err := hs.c.ReadSector(prices, hs.token, &byteWriter{b: sector[:0]}, root, 0, rhp.SectorSize); err != nil {
+		return nil, err
+	}
+	return sector, nil
+}
+
+// NewHostStore returns a HostStore that uses c, paying with token.
+func NewHostStore(c *rhp.Client, token rhp.AccountToken) *HostStore {
+	return &HostStore{c: c, token: token}
+}
+
+// byteReader and byteWriter avoid copying sectors through bytes.Buffer.
+type byteReader struct{ b []byte }
+
+func (r *byteReader) Read(p []byte) (int, error) {
+	if len(r.b) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.b)
+	r.b = r.b[n:]
+	return n, nil
+}
+
+type byteWriter struct{ b []byte }
+
+func (w *byteWriter) Write(p []byte) (int, error) {
+	if len(p) > cap(w.b)-len(w.b) {
+		return 0, io.ErrShortWrite
+	}
+	w.b = append(w.b, p...)
+	return len(p), nil
+}
+
+// cryptShard encrypts or decrypts a shard in place.
+func cryptShard(key EncryptionKey, index int, shard []byte) {
+	nonce := make([]byte, chacha20.NonceSizeX)
+	nonce[0] = byte(index)
+	c, err := chacha20.NewUnauthenticatedCipher(key[:], nonce)
+	if err != nil {
+		panic(err) // should never happen
+	}
+	c.XORKeyStream(shard, shard)
+}
+
+// uploadShards uploads each shard to a different host. If an upload fails,
+// the shard is retried on a host that has not been used yet.
+func uploadShards(shards [][]byte, hosts []SectorStore) ([]Sector, error) {
+	var mu sync.Mutex
+	next := 0
+	nextHost := func() SectorStore {
+		mu.Lock()
+		defer mu.Unlock()
+		if next == len(hosts) {
+			return nil
+		}
+		next++
+		return hosts[next-1]
+	}
+
+	sectors := make([]Sector, len(shards))
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var hostErrs []error
+			for h := nextHost(); h != nil; h = nextHost() {
+				root, err := h.WriteSector((*[rhp.SectorSize]byte)(shard))
+				if err == nil {
+					sectors[i] = Sector{HostKey: h.HostKey(), Root: root}
+					return
+				}
+				hostErrs = append(hostErrs, fmt.Errorf("host %v: %w", h.HostKey(), err))
+			}
+			errs[i] = fmt.Errorf("failed to upload shard %v: %w", i, errors.Join(append(hostErrs, errors.New("no hosts remaining"))...))
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return sectors, nil
+}
+
+// Upload reads r until EOF and uploads it, erasure-coding each slab of
+// minShards sectors into totalShards shards. Each shard of a slab is uploaded
+// to a different host; hosts beyond the first totalShards are used if an
+// upload fails.
+func Upload(r io.Reader, minShards, totalShards int, hosts []SectorStore) (Object, error) {
+	rs, err := newRSCode(minShards, totalShards)
+	if err != nil {
+		return Object{}, err
+	} else if minShards > math.MaxUint8 {
+		return Object{}, fmt.Errorf("too many data shards (%v)", minShards)
+	} else if len(hosts) < totalShards {
+		return Object{}, fmt.Errorf("not enough hosts to upload %v shards (have %v)", totalShards, len(hosts))
+	}
+
+	buf := make([]byte, minShards*rhp.SectorSize)
+	shards := make([][]byte, totalShards)
+	for i := range shards {
+		if i < minShards {
+			shards[i] = buf[i*rhp.SectorSize:][:rhp.SectorSize]
+		} else {
+			shards[i] = make([]byte, rhp.SectorSize)
+		}
+	}
+
+	var o Object
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			break
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return Object{}, fmt.Errorf("failed to read object data: %w", err)
+		}
+		clear(buf[n:])
+		rs.encode(shards)
+		key := EncryptionKey(frand.Entropy256())
+		for i, shard := range shards {
+			cryptShard(key, i, shard)
+		}
+		sectors, uerr := uploadShards(shards, hosts)
+		if uerr != nil {
+			return Object{}, fmt.Errorf("failed to upload slab %v: %w", len(o.Slabs), uerr)
+		}
+		o.Slabs = append(o.Slabs, Slab{
+			Key:       key,
+			MinShards: uint8(minShards),
+			Length:    uint64(n),
+			Shards:    sectors,
+		})
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+	return o, nil
+}
+
+// downloadSlab downloads and decrypts the data shards of a slab, fetching as
+// few shards as possible and reconstructing any that are missing.
+func downloadSlab(s Slab, hosts map[types.PublicKey]SectorStore) ([][]byte, error) {
+	n := int(s.MinShards)
+	rs, err := newRSCode(n, len(s.Shards))
+	if err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		index  int
+		sector *[rhp.SectorSize]byte
+		err    error
+	}
+	results := make(chan result, len(s.Shards))
+	var errs []error
+	next, inflight := 0, 0
+	// launch starts downloading the next shard whose host is available
+	launch := func() {
+		for ; next < len(s.Shards); next++ {
+			i, sector := next, s.Shards[next]
+			if h, ok := hosts[sector.HostKey]; ok {
+				go func() {
+					buf, err := h.ReadSector(sector.Root)
+					results <- result{i, buf, err}
+				}()
+				next++
+				inflight++
+				return
+			}
+			errs = append(errs, fmt.Errorf("shard %v: unknown host %v", i, sector.HostKey))
+		}
+	}
+	for range n {
+		launch()
+	}
+
+	shards := make([][]byte, len(s.Shards))
+	var have int
+	for ; have < n && inflight > 0; inflight-- {
+		res := <-results
+		if res.err != nil {
+			errs = append(errs, fmt.Errorf("shard %v: host %v: %w", res.index, s.Shards[res.index].HostKey, res.err))
+			launch()
+			continue
+		}
+		cryptShard(s.Key, res.index, res.sector[:])
+		shards[res.index] = res.sector[:]
+		have++
+	}
+	if have < n {
+		return nil, fmt.Errorf("only %v of %v required shards available: %w", have, n, errors.Join(errs...))
+	} else if err := rs.reconstruct(shards); err != nil {
+		return nil, err
+	}
+	return shards[:n], nil
+}
+
+// Download writes the data of o to w, downloading each slab from any
+// MinShards of its hosts.
+func Download(w io.Writer, o Object, hosts map[types.PublicKey]SectorStore) error {
+	for i, s := range o.Slabs {
+		if uint64(s.MinShards)*rhp.SectorSize < s.Length {
+			return fmt.Errorf("slab %v is invalid: length %v exceeds capacity", i, s.Length)
+		}
+		shards, err := downloadSlab(s, hosts)
+		if err != nil {
+			return fmt.Errorf("failed to download slab %v: %w", i, err)
+		}
+		rem := s.Length
+		for _, shard := range shards {
+			if rem < uint64(len(shard)) {
+				shard = shard[:rem]
+			}
+			if _, err := w.Write(shard); err != nil {
+				return err
+			}
+			rem -= uint64(len(shard))
+		}
+	}
+	return nil
+}
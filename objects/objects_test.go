@@ -0,0 +1,167 @@
+package objects
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	rhp "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/core/rhp/v4/rhptest"
+	"go.sia.tech/core/types"
+	"lukechampine.com/frand"
+)
+
+func TestReedSolomon(t *testing.T) {
+	const n, m = 3, 6
+	rs, err := newRSCode(n, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([][]byte, m)
+	for i := range data {
+		data[i] = make([]byte, 64)
+		if i < n {
+			frand.Read(data[i])
+		}
+	}
+	rs.encode(data)
+
+	// every subset of n shards recovers the data
+	for mask := range 1 << m {
+		shards := make([][]byte, m)
+		var have int
+		for i := range shards {
+			if mask&(1<<i) != 0 {
+				shards[i] = append([]byte(nil), data[i]...)
+				have++
+			}
+		}
+		err := rs.reconstruct(shards)
+		if have < n {
+			if err == nil {
+				t.Fatalf("expected reconstruction from %v shards to fail", have)
+			}
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		for i := range n {
+			if !bytes.Equal(shards[i], data[i]) {
+				t.Fatalf("shard %v mismatch with mask %b", i, mask)
+			}
+		}
+	}
+
+	if _, err := newRSCode(4, 3); err == nil {
+		t.Fatal("expected invalid parameters to be rejected")
+	}
+}
+
+type failingStore struct {
+	SectorStore
+}
+
+func (failingStore) WriteSector(*[rhp.SectorSize]byte) (types.Hash256, error) {
+	return types.Hash256{}, errors.New("out of storage")
+}
+
+func TestUploadDownload(t *testing.T) {
+	const minShards, totalShards = 2, 4
+	hosts := make([]*rhptest.Host, totalShards+2)
+	stores := make([]SectorStore, len(hosts))
+	storeMap := make(map[types.PublicKey]SectorStore)
+	for i := range hosts {
+		h := rhptest.NewHost()
+		renterKey, account := rhp.GenerateAccount()
+		h.Credit(account, types.Siacoins(100))
+		c := rhp.NewClient(h, h.PublicKey(), rhp.GougingSettings{})
+		hosts[i] = h
+		stores[i] = NewHostStore(c, account.Token(renterKey, h.PublicKey()))
+		storeMap[h.PublicKey()] = stores[i]
+	}
+	// the first host refuses uploads, so a spare host is used instead
+	stores[0] = failingStore{stores[0]}
+
+	data := frand.Bytes(minShards*rhp.SectorSize + rhp.SectorSize/2 + 17)
+	o, err := Upload(bytes.NewReader(data), minShards, totalShards, stores)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(o.Slabs) != 2 {
+		t.Fatalf("expected 2 slabs, got %v", len(o.Slabs))
+	} else if o.Size() != uint64(len(data)) {
+		t.Fatalf("expected size %v, got %v", len(data), o.Size())
+	}
+	for _, s := range o.Slabs {
+		used := make(map[types.PublicKey]bool)
+		for _, sector := range s.Shards {
+			if sector.HostKey == hosts[0].PublicKey() {
+				t.Fatal("shard uploaded to failing host")
+			} else if used[sector.HostKey] {
+				t.Fatal("multiple shards uploaded to the same host")
+			}
+			used[sector.HostKey] = true
+		}
+	}
+
+	// shards are encrypted
+	for _, h := range hosts {
+		for _, sector := range o.Slabs[0].Shards {
+			if buf, ok := h.Sector(sector.Root); ok && bytes.Contains(data, buf[:64]) {
+				t.Fatal("shard stored unencrypted")
+			}
+		}
+	}
+
+	// the manifest round-trips
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	o.EncodeTo(e)
+	e.Flush()
+	var decoded Object
+	d := types.NewBufDecoder(buf.Bytes())
+	decoded.DecodeFrom(d)
+	if err := d.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	download := func() ([]byte, error) {
+		var out bytes.Buffer
+		err := Download(&out, decoded, storeMap)
+		return out.Bytes(), err
+	}
+	if out, err := download(); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(out, data) {
+		t.Fatal("data mismatch")
+	}
+
+	// the object can be recovered from any minShards shards of each slab,
+	// including when a host returns corrupt data
+	hostByKey := make(map[types.PublicKey]*rhptest.Host)
+	for _, h := range hosts {
+		hostByKey[h.PublicKey()] = h
+	}
+	for _, s := range o.Slabs {
+		hostByKey[s.Shards[0].HostKey].DeleteSector(s.Shards[0].Root)
+		sector, _ := hostByKey[s.Shards[2].HostKey].Sector(s.Shards[2].Root)
+		sector[0] ^= 1
+	}
+	if out, err := download(); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(out, data) {
+		t.Fatal("data mismatch")
+	}
+
+	// losing one more shard makes the first slab unrecoverable
+	s := o.Slabs[0]
+	hostByKey[s.Shards[3].HostKey].DeleteSector(s.Shards[3].Root)
+	if _, err := download(); err == nil {
+		t.Fatal("expected download to fail")
+	}
+
+	if _, err := Upload(bytes.NewReader(data), minShards, totalShards, stores[:totalShards-1]); err == nil {
+		t.Fatal("expected upload with too few hosts to fail")
+	} else if o, err := Upload(bytes.NewReader(nil), minShards, totalShards, stores); err != nil || len(o.Slabs) != 0 {
+		t.Fatal("expected empty object", err)
+	}
+}
@@ -0,0 +1,424 @@
+package rhp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+// ErrInvalidProof is returned by a Client when a host's response does not
+// match its Merkle proof.
+var ErrInvalidProof = errors.New("host returned an invalid proof")
+
+// A Transport opens streams to a host. Each RPC is executed on its own
+// stream.
+type Transport interface {
+	DialStream() (io.ReadWriteCloser, error)
+}
+
+// A pricedRequest is a request that embeds the host's prices.
+type pricedRequest interface {
+	Object
+	prices() HostPrices
+}
+
+func (r *RPCFormContractRequest) prices() HostPrices    { return r.Prices }
+func (r *RPCRenewContractRequest) prices() HostPrices   { return r.Prices }
+func (r *RPCRefreshContractRequest) prices() HostPrices { return r.Prices }
+func (r *RPCFreeSectorsRequest) prices() HostPrices     { return r.Prices }
+func (r *RPCAppendSectorsRequest) prices() HostPrices   { return r.Prices }
+func (r *RPCSectorRootsRequest) prices() HostPrices     { return r.Prices }
+func (r *RPCReadSectorRequest) prices() HostPrices      { return r.Prices }
+func (r *RPCWriteSectorRequest) prices() HostPrices     { return r.Prices }
+func (r *RPCVerifySectorRequest) prices() HostPrices    { return r.Prices }
+
+// A Client executes RPCs on a host.
+//
+// Before sending any request that embeds HostPrices, the Client checks that
+// the prices were signed by the host and have not expired, and that they pass
+// its GougingChecker. Prices that fail the check are never sent, so the
+// renter cannot be charged them.
+type Client struct {
+	t       Transport
+	hostKey types.PublicKey
+	gouging *GougingChecker
+}
+
+// HostKey returns the public key of the client's host.
+func (c *Client) HostKey() types.PublicKey { return c.hostKey }
+
+// Gouging returns the client's GougingChecker.
+func (c *Client) Gouging() *GougingChecker { return c.gouging }
+
+// OpenRPC checks the prices embedded in req, if any, opens a stream, and
+// writes the request to it. It is used by the RPC methods of the Client, and
+// may be used directly to execute RPCs that the Client does not implement,
+// such as RPCFormContract, which require funding from a wallet.
+func (c *Client) OpenRPC(id types.Specifier, req Object) (io.ReadWriteCloser, error) {
+	if pr, ok := req.(pricedRequest); ok {
+		p := pr.prices()
+		if err := p.Validate(c.hostKey); err != nil {
+			return nil, fmt.Errorf("invalid prices: %w", err)
+		} else if err := c.gouging.Check(p); err != nil {
+			return nil, err
+		}
+	}
+	s, err := c.t.DialStream()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stream: %w", err)
+	} else if err := WriteRequest(s, id, req); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to write request: %w", err)
+	}
+	return s, nil
+}
+
+// Settings returns the host's current settings. The prices are checked for a
+// valid signature, but not against the client's GougingChecker.
+func (c *Client) Settings() (HostSettings, error) {
+	s, err := c.OpenRPC(RPCSettingsID, &RPCSettingsRequest{})
+	if err != nil {
+		return HostSettings{}, err
+	}
+	defer s.Close()
+	var resp RPCSettingsResponse
+	if err := ReadResponse(s, &resp); err != nil {
+		return HostSettings{}, err
+	} else if !c.hostKey.VerifyHash(resp.Settings.Prices.SigHash(), resp.Settings.Prices.Signature) {
+		return HostSettings{}, fmt.Errorf("invalid prices: %w", ErrInvalidSignature)
+	}
+	return resp.Settings, nil
+}
+
+// ReadSector reads length bytes at offset from the sector with the given
+// root, writing them to w once they have been verified. The offset and length
+// must be multiples of LeafSize.
+func (c *Client) ReadSector(prices HostPrices, token AccountToken, w io.Writer, root types.Hash256, offset, length uint64) (Usage, error) {
+	if length == 0 || offset%LeafSize != 0 || length%LeafSize != 0 || length > SectorSize || offset > SectorSize-length {
+		return Usage{}, fmt.Errorf("invalid range (offset %v, length %v)", offset, length)
+	}
+	s, err := c.OpenRPC(RPCReadSectorID, &RPCReadSectorRequest{
+		Prices: prices,
+		Token:  token,
+		Root:   root,
+		Offset: offset,
+		Length: length,
+	})
+	if err != nil {
+		return Usage{}, err
+	}
+	defer s.Close()
+
+	var resp RPCReadSectorResponse
+	if err := ReadResponse(s, &resp); err != nil {
+		return Usage{}, err
+	} else if resp.DataLength != length {
+		return Usage{}, fmt.Errorf("host returned %v bytes, expected %v", resp.DataLength, length)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(s, buf); err != nil {
+		return Usage{}, fmt.Errorf("failed to read data: %w", err)
+	}
+	rpv := NewRangeProofVerifier(offset/LeafSize, (offset+length)/LeafSize)
+	if _, err := rpv.ReadFrom(bytes.NewReader(buf)); err != nil {
+		return Usage{}, err
+	} else if !rpv.Verify(resp.Proof, root) {
+		return Usage{}, ErrInvalidProof
+	} else if _, err := w.Write(buf); err != nil {
+		return Usage{}, err
+	}
+	return prices.RPCReadSectorCost(length), nil
+}
+
+// A rangeWriter trims a leaf-aligned range to the requested range and
+// withholds the final LeafSize bytes, so that they can be released only once
+// the range has been verified.
+type rangeWriter struct {
+	w    io.Writer
+	skip uint64 // leading bytes to discard
+	rem  uint64 // requested bytes not yet received
+	held []byte
+}
+
+func (rw *rangeWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	k := min(rw.skip, uint64(len(p)))
+	p, rw.skip = p[k:], rw.skip-k
+	p = p[:min(uint64(len(p)), rw.rem)]
+	rw.rem -= uint64(len(p))
+	rw.held = append(rw.held, p...)
+	if excess := len(rw.held) - LeafSize; excess > 0 {
+		if _, err := rw.w.Write(rw.held[:excess]); err != nil {
+			return 0, err
+		}
+		rw.held = append(rw.held[:0], rw.held[excess:]...)
+	}
+	return n, nil
+}
+
+// flush releases the withheld bytes.
+func (rw *rangeWriter) flush() error {
+	_, err := rw.w.Write(rw.held)
+	rw.held = nil
+	return err
+}
+
+// A sectorReader streams a range of a sector as it is verified.
+type sectorReader struct {
+	pr    *io.PipeReader
+	close func() error
+}
+
+// Read implements io.Reader.
+func (sr *sectorReader) Read(p []byte) (int, error) { return sr.pr.Read(p) }
+
+// Close implements io.Closer.
+func (sr *sectorReader) Close() error {
+	sr.pr.Close()
+	return sr.close()
+}
+
+// ReadSectorStream reads length bytes at offset from the sector with the
+// given root, returning a reader that streams them as they arrive. The data
+// is verified against root as it is read; the final bytes are withheld until
+// the proof has been checked, so a reader that returns io.EOF has returned
+// exactly the requested, verified range. If verification fails, Read returns
+// ErrInvalidProof instead.
+//
+// The offset and length need not be multiples of LeafSize; the host is asked
+// for the smallest leaf-aligned range containing them, and the usage reflects
+// that range. The caller must close the reader.
+func (c *Client) ReadSectorStream(prices HostPrices, token AccountToken, root types.Hash256, offset, length uint64) (io.ReadCloser, Usage, error) {
+	if length == 0 || length > SectorSize || offset > SectorSize-length {
+		return nil, Usage{}, fmt.Errorf("invalid range (offset %v, length %v)", offset, length)
+	}
+	start := offset / LeafSize
+	end := (offset + length + LeafSize - 1) / LeafSize
+	alignedLength := (end - start) * LeafSize
+	s, err := c.OpenRPC(RPCReadSectorID, &RPCReadSectorRequest{
+		Prices: prices,
+		Token:  token,
+		Root:   root,
+		Offset: start * LeafSize,
+		Length: alignedLength,
+	})
+	if err != nil {
+		return nil, Usage{}, err
+	}
+	var closeOnce sync.Once
+	var closeErr error
+	closeStream := func() error {
+		closeOnce.Do(func() { closeErr = s.Close() })
+		return closeErr
+	}
+
+	var resp RPCReadSectorResponse
+	if err := ReadResponse(s, &resp); err != nil {
+		closeStream()
+		return nil, Usage{}, err
+	} else if resp.DataLength != alignedLength {
+		closeStream()
+		return nil, Usage{}, fmt.Errorf("host returned %v bytes, expected %v", resp.DataLength, alignedLength)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer closeStream()
+		rw := &rangeWriter{w: pw, skip: offset - start*LeafSize, rem: length}
+		rpv := NewRangeProofVerifier(start, end)
+		_, err := rpv.ReadFrom(io.TeeReader(s, rw))
+		if err != nil {
+			err = fmt.Errorf("failed to read data: %w", err)
+		} else if !rpv.Verify(resp.Proof, root) {
+			err = ErrInvalidProof
+		} else {
+			err = rw.flush()
+		}
+		pw.CloseWithError(err)
+	}()
+	return &sectorReader{pr: pr, close: closeStream}, prices.RPCReadSectorCost(alignedLength), nil
+}
+
+// WriteSector writes length bytes from r to a new sector, which the host
+// stores for TempSectorDuration blocks unless it is appended to a contract.
+// Sectors shorter than SectorSize are padded with zeros. It returns the
+// sector's root.
+func (c *Client) WriteSector(prices HostPrices, token AccountToken, r io.Reader, length uint64) (types.Hash256, Usage, error) {
+	if length == 0 || length > SectorSize {
+		return types.Hash256{}, Usage{}, fmt.Errorf("invalid sector length %v", length)
+	}
+	var sector [SectorSize]byte
+	if _, err := io.ReadFull(r, sector[:length]); err != nil {
+		return types.Hash256{}, Usage{}, fmt.Errorf("failed to read sector: %w", err)
+	}
+	root := SectorRoot(&sector)
+
+	s, err := c.OpenRPC(RPCWriteSectorID, &RPCWriteSectorRequest{
+		Prices:     prices,
+		Token:      token,
+		DataLength: length,
+	})
+	if err != nil {
+		return types.Hash256{}, Usage{}, err
+	}
+	defer s.Close()
+	if _, err := s.Write(sector[:length]); err != nil {
+		return types.Hash256{}, Usage{}, fmt.Errorf("failed to write sector: %w", err)
+	}
+	var resp RPCWriteSectorResponse
+	if err := ReadResponse(s, &resp); err != nil {
+		return types.Hash256{}, Usage{}, err
+	} else if resp.Root != root {
+		return types.Hash256{}, Usage{}, fmt.Errorf("host returned root %v, expected %v", resp.Root, root)
+	}
+	return root, prices.RPCWriteSectorCost(length), nil
+}
+
+// VerifySector asks the host to prove that it is storing the leaf at
+// leafIndex of the sector with the given root. It returns ErrInvalidProof if
+// the host's proof is invalid.
+func (c *Client) VerifySector(prices HostPrices, token AccountToken, root types.Hash256, leafIndex uint64) (RPCVerifySectorResponse, Usage, error) {
+	if leafIndex >= LeavesPerSector {
+		return RPCVerifySectorResponse{}, Usage{}, fmt.Errorf("leaf index must be less than %d", LeavesPerSector)
+	}
+	s, err := c.OpenRPC(RPCVerifySectorID, &RPCVerifySectorRequest{
+		Prices:    prices,
+		Token:     token,
+		Root:      root,
+		LeafIndex: leafIndex,
+	})
+	if err != nil {
+		return RPCVerifySectorResponse{}, Usage{}, err
+	}
+	defer s.Close()
+	var resp RPCVerifySectorResponse
+	if err := ReadResponse(s, &resp); err != nil {
+		return RPCVerifySectorResponse{}, Usage{}, err
+	}
+	usage := prices.RPCVerifySectorCost()
+	if !VerifyLeafProof(resp.Proof, resp.Leaf, leafIndex, root) {
+		return resp, usage, ErrInvalidProof
+	}
+	return resp, usage, nil
+}
+
+// AccountBalance returns the balance of an account.
+func (c *Client) AccountBalance(account Account) (types.Currency, error) {
+	s, err := c.OpenRPC(RPCAccountBalanceID, &RPCAccountBalanceRequest{Account: account})
+	if err != nil {
+		return types.Currency{}, err
+	}
+	defer s.Close()
+	var resp RPCAccountBalanceResponse
+	if err := ReadResponse(s, &resp); err != nil {
+		return types.Currency{}, err
+	}
+	return resp.Balance, nil
+}
+
+// FundAccounts deposits funds from a contract into accounts. It returns the
+// signed revision, which the renter must store, and the new balances of the
+// accounts.
+func (c *Client) FundAccounts(cs consensus.State, renterKey types.PrivateKey, contractID types.FileContractID, fc types.V2FileContract, deposits []AccountDeposit) (types.V2FileContract, []types.Currency, error) {
+	var total types.Currency
+	for _, d := range deposits {
+		total = total.Add(d.Amount)
+	}
+	rev, _, err := ReviseForFundAccounts(fc, total)
+	if err != nil {
+		return types.V2FileContract{}, nil, err
+	}
+	sigHash := cs.ContractSigHash(rev)
+	rev.RenterSignature = renterKey.SignHash(sigHash)
+
+	s, err := c.OpenRPC(RPCFundAccountsID, &RPCFundAccountsRequest{
+		ContractID:      contractID,
+		Deposits:        deposits,
+		RenterSignature: rev.RenterSignature,
+	})
+	if err != nil {
+		return types.V2FileContract{}, nil, err
+	}
+	defer s.Close()
+	var resp RPCFundAccountsResponse
+	if err := ReadResponse(s, &resp); err != nil {
+		return types.V2FileContract{}, nil, err
+	} else if !c.hostKey.VerifyHash(sigHash, resp.HostSignature) {
+		return types.V2FileContract{}, nil, fmt.Errorf("invalid host signature: %w", ErrInvalidSignature)
+	} else if len(resp.Balances) != len(deposits) {
+		return types.V2FileContract{}, nil, fmt.Errorf("host returned %v balances, expected %v", len(resp.Balances), len(deposits))
+	}
+	rev.HostSignature = resp.HostSignature
+	return rev, resp.Balances, nil
+}
+
+// ReplenishAccounts tops up the balance of each account to target, paying
+// from a contract. It returns the signed revision, which the renter must
+// store, and the amount deposited into each account; accounts already at or
+// above target receive nothing.
+func (c *Client) ReplenishAccounts(cs consensus.State, renterKey types.PrivateKey, contractID types.FileContractID, fc types.V2FileContract, accounts []Account, target types.Currency) (types.V2FileContract, []AccountDeposit, error) {
+	req := RPCReplenishAccountsRequest{
+		Accounts:   accounts,
+		Target:     target,
+		ContractID: contractID,
+	}
+	req.ChallengeSignature = renterKey.SignHash(req.ChallengeSigHash(fc.RevisionNumber))
+	s, err := c.OpenRPC(RPCReplenishAccountsID, &req)
+	if err != nil {
+		return types.V2FileContract{}, nil, err
+	}
+	defer s.Close()
+
+	var resp RPCReplenishAccountsResponse
+	if err := ReadResponse(s, &resp); err != nil {
+		return types.V2FileContract{}, nil, err
+	}
+	// check the deposits before signing the revision that pays for them
+	if len(resp.Deposits) > len(accounts) {
+		return types.V2FileContract{}, nil, fmt.Errorf("host returned %v deposits for %v accounts", len(resp.Deposits), len(accounts))
+	}
+	deposited := make(map[Account]bool, len(resp.Deposits))
+	for _, d := range resp.Deposits {
+		if !slices.Contains(accounts, d.Account) {
+			return types.V2FileContract{}, nil, fmt.Errorf("host deposited into unrequested account %v", d.Account)
+		} else if deposited[d.Account] {
+			return types.V2FileContract{}, nil, fmt.Errorf("host deposited into account %v more than once", d.Account)
+		} else if d.Amount.Cmp(target) > 0 {
+			return types.V2FileContract{}, nil, fmt.Errorf("host deposit of %v exceeds target %v", d.Amount, target)
+		}
+		deposited[d.Account] = true
+	}
+	rev, _, err := ReviseForReplenish(fc, resp.TotalCost())
+	if err != nil {
+		return types.V2FileContract{}, nil, err
+	}
+	sigHash := cs.ContractSigHash(rev)
+	rev.RenterSignature = renterKey.SignHash(sigHash)
+	if err := WriteResponse(s, &RPCReplenishAccountsSecondResponse{RenterSignature: rev.RenterSignature}); err != nil {
+		return types.V2FileContract{}, nil, err
+	}
+	var third RPCReplenishAccountsThirdResponse
+	if err := ReadResponse(s, &third); err != nil {
+		return types.V2FileContract{}, nil, err
+	} else if !c.hostKey.VerifyHash(sigHash, third.HostSignature) {
+		return types.V2FileContract{}, nil, fmt.Errorf("invalid host signature: %w", ErrInvalidSignature)
+	}
+	rev.HostSignature = third.HostSignature
+	return rev, resp.Deposits, nil
+}
+
+// NewClient returns a Client that executes RPCs on the host with the given
+// public key, rejecting prices that fail the given gouging settings.
+func NewClient(t Transport, hostKey types.PublicKey, gs GougingSettings) *Client {
+	return &Client{
+		t:       t,
+		hostKey: hostKey,
+		gouging: NewGougingChecker(gs),
+	}
+}
@@ -0,0 +1,328 @@
+package rhp_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	rhp "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/core/rhp/v4/rhptest"
+	"go.sia.tech/core/types"
+	"lukechampine.com/frand"
+)
+
+func TestClient(t *testing.T) {
+	h := rhptest.NewHost()
+	c := rhp.NewClient(h, h.PublicKey(), rhp.GougingSettings{})
+	renterKey, account := rhp.GenerateAccount()
+	token := account.Token(renterKey, h.PublicKey())
+	h.Credit(account, types.Siacoins(1))
+
+	hs, err := c.Settings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prices := hs.Prices
+
+	data := frand.Bytes(rhp.SectorSize / 2)
+	root, usage, err := c.WriteSector(prices, token, bytes.NewReader(data), uint64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spent := usage.RenterCost()
+
+	var buf bytes.Buffer
+	usage, err = c.ReadSector(prices, token, &buf, root, rhp.LeafSize*10, rhp.LeafSize*20)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf.Bytes(), data[rhp.LeafSize*10:][:rhp.LeafSize*20]) {
+		t.Fatal("data mismatch")
+	}
+	spent = spent.Add(usage.RenterCost())
+	if _, err := c.ReadSector(prices, token, &buf, root, 1, rhp.LeafSize); err == nil {
+		t.Fatal("expected unaligned read to be rejected")
+	}
+
+	resp, usage, err := c.VerifySector(prices, token, root, 3)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(resp.Leaf[:], data[3*rhp.LeafSize:][:rhp.LeafSize]) {
+		t.Fatal("leaf mismatch")
+	}
+	spent = spent.Add(usage.RenterCost())
+
+	if balance, err := c.AccountBalance(account); err != nil {
+		t.Fatal(err)
+	} else if balance != types.Siacoins(1).Sub(spent) {
+		t.Fatalf("expected balance %v, got %v", types.Siacoins(1).Sub(spent), balance)
+	}
+
+	// corrupt data is detected
+	sector, _ := h.Sector(root)
+	sector[3*rhp.LeafSize] ^= 1
+	if _, _, err := c.VerifySector(prices, token, root, 3); !errors.Is(err, rhp.ErrInvalidProof) {
+		t.Fatalf("expected invalid proof, got %v", err)
+	} else if _, err := c.ReadSector(prices, token, &buf, root, 0, rhp.SectorSize); !errors.Is(err, rhp.ErrInvalidProof) {
+		t.Fatalf("expected invalid proof, got %v", err)
+	}
+
+	// ranges that overflow are rejected before reaching the host
+	reqs := h.Requests(rhp.RPCReadSectorID)
+	if _, err := c.ReadSector(prices, token, &buf, root, math.MaxUint64-rhp.LeafSize+1, 2*rhp.LeafSize); err == nil {
+		t.Fatal("expected overflowing range to be rejected")
+	} else if h.Requests(rhp.RPCReadSectorID) != reqs {
+		t.Fatal("request was sent despite invalid range")
+	}
+
+	// host errors are returned
+	h.DeleteSector(root)
+	if _, err := c.ReadSector(prices, token, &buf, root, 0, rhp.LeafSize); rhp.ErrorCode(err) != rhp.ErrorCodeHostError {
+		t.Fatalf("expected host error, got %v", err)
+	}
+	empty := account.Token(types.GeneratePrivateKey(), h.PublicKey())
+	empty.Account = rhp.Account(types.GeneratePrivateKey().PublicKey())
+	if _, _, err := c.WriteSector(prices, empty, bytes.NewReader(data), uint64(len(data))); err == nil {
+		t.Fatal("expected invalid token to be rejected")
+	}
+}
+
+func TestClientReplenishAccounts(t *testing.T) {
+	h := rhptest.NewHost()
+	c := rhp.NewClient(h, h.PublicKey(), rhp.GougingSettings{})
+	renterKey := types.GeneratePrivateKey()
+	contractID := types.FileContractID(frand.Entropy256())
+	fc := types.V2FileContract{
+		RenterPublicKey: renterKey.PublicKey(),
+		HostPublicKey:   h.PublicKey(),
+		RenterOutput:    types.SiacoinOutput{Value: types.Siacoins(10)},
+	}
+	h.AddContract(contractID, fc)
+	_, a1 := rhp.GenerateAccount()
+	_, a2 := rhp.GenerateAccount()
+	accounts := []rhp.Account{a1, a2}
+	target := types.Siacoins(1)
+
+	// a host that deposits into an account more than once, or returns more
+	// deposits than accounts, is rejected before the revision is signed
+	tests := []struct {
+		desc   string
+		tamper func(*rhp.RPCReplenishAccountsResponse)
+	}{
+		{"duplicate deposit", func(resp *rhp.RPCReplenishAccountsResponse) {
+			resp.Deposits[1] = resp.Deposits[0]
+		}},
+		{"extra deposit", func(resp *rhp.RPCReplenishAccountsResponse) {
+			resp.Deposits = append(resp.Deposits, resp.Deposits[0])
+		}},
+	}
+	for _, test := range tests {
+		h.TamperReplenish(test.tamper)
+		if _, _, err := c.ReplenishAccounts(h.State(), renterKey, contractID, fc, accounts, target); err == nil {
+			t.Fatalf("%v: expected deposits to be rejected", test.desc)
+		} else if rev, _ := h.Contract(contractID); rev.RevisionNumber != fc.RevisionNumber {
+			t.Fatalf("%v: expected contract to be unrevised", test.desc)
+		} else if !h.Balance(a1).IsZero() || !h.Balance(a2).IsZero() {
+			t.Fatalf("%v: expected no deposits", test.desc)
+		}
+	}
+
+	h.TamperReplenish(nil)
+	rev, deposits, err := c.ReplenishAccounts(h.State(), renterKey, contractID, fc, accounts, target)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(deposits) != 2 || rev.RevisionNumber != fc.RevisionNumber+1 {
+		t.Fatalf("expected 2 deposits and a new revision, got %v deposits and revision %v", len(deposits), rev.RevisionNumber)
+	} else if h.Balance(a1) != target || h.Balance(a2) != target {
+		t.Fatal("expected accounts to be replenished")
+	}
+}
+
+func TestClientGouging(t *testing.T) {
+	h := rhptest.NewHost()
+	hs := h.Settings()
+	gs := rhp.GougingSettings{
+		MaxEgressPrice: hs.Prices.EgressPrice.Mul64(100),
+		MaxValidity:    time.Hour,
+		MaxDeviation:   2,
+	}
+	c := rhp.NewClient(h, h.PublicKey(), gs)
+	renterKey, account := rhp.GenerateAccount()
+	token := account.Token(renterKey, h.PublicKey())
+	h.Credit(account, types.Siacoins(1))
+
+	root, _, err := c.WriteSector(hs.Prices, token, bytes.NewReader(make([]byte, rhp.LeafSize)), rhp.LeafSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func(prices rhp.HostPrices) error {
+		_, err := c.ReadSector(prices, token, new(bytes.Buffer), root, 0, rhp.LeafSize)
+		return err
+	}
+	// rejected prices must never reach the host
+	checkRejected := func(prices rhp.HostPrices, field string) {
+		t.Helper()
+		reqs := h.Requests(rhp.RPCReadSectorID)
+		var ge *rhp.GougingError
+		if err := read(prices); !errors.As(err, &ge) {
+			t.Fatalf("expected gouging error, got %v", err)
+		} else if ge.Field != field {
+			t.Fatalf("expected %v to be rejected, got %v", field, ge)
+		} else if h.Requests(rhp.RPCReadSectorID) != reqs {
+			t.Fatal("request was sent despite gouging")
+		}
+	}
+
+	// a modest price increase is accepted
+	h.UpdateSettings(func(hs *rhp.HostSettings) { hs.Prices.EgressPrice = hs.Prices.EgressPrice.Mul64(3).Div64(2) })
+	if err := read(h.Settings().Prices); err != nil {
+		t.Fatal(err)
+	}
+
+	// a sudden increase is rejected, even though it is within the limit
+	spike := hs.Prices
+	spike.EgressPrice = spike.EgressPrice.Mul64(10)
+	checkRejected(h.SignPrices(spike), "egress price")
+	// as are prices exceeding the limit
+	c.Gouging().SetSettings(rhp.GougingSettings{MaxEgressPrice: hs.Prices.EgressPrice})
+	checkRejected(h.Settings().Prices, "egress price")
+	c.Gouging().SetSettings(gs)
+
+	// a drop in collateral is rejected
+	low := h.Settings().Prices
+	low.Collateral = low.Collateral.Div64(10)
+	checkRejected(h.SignPrices(low), "collateral")
+
+	// prices that remain valid for too long are rejected
+	long := h.Settings().Prices
+	long.ValidUntil = time.Now().Add(365 * 24 * time.Hour)
+	checkRejected(h.SignPrices(long), "validity")
+
+	// prices not signed by the host are rejected
+	forged := h.Settings().Prices
+	forged.Signature = types.GeneratePrivateKey().SignHash(forged.SigHash())
+	reqs := h.Requests(rhp.RPCReadSectorID)
+	if err := read(forged); !errors.Is(err, rhp.ErrInvalidSignature) {
+		t.Fatalf("expected invalid signature, got %v", err)
+	} else if h.Requests(rhp.RPCReadSectorID) != reqs {
+		t.Fatal("request was sent despite invalid prices")
+	}
+
+	// once the increase becomes the norm, it is accepted
+	h.UpdateSettings(func(hs *rhp.HostSettings) { hs.Prices.EgressPrice = hs.Prices.EgressPrice.Mul64(3).Div64(2) })
+	for i := range 10 {
+		// only distinct price tables count towards the norm
+		prices := h.Settings().Prices
+		prices.ValidUntil = prices.ValidUntil.Add(-time.Duration(i) * time.Second)
+		if err := read(h.SignPrices(prices)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.UpdateSettings(func(hs *rhp.HostSettings) { hs.Prices.EgressPrice = hs.Prices.EgressPrice.Mul64(3).Div64(2) })
+	if err := read(h.Settings().Prices); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGougingCheckerRepeatedPrices(t *testing.T) {
+	gc := rhp.NewGougingChecker(rhp.GougingSettings{MaxDeviation: 2})
+	base := rhp.HostPrices{EgressPrice: types.Siacoins(1), ValidUntil: time.Now().Add(time.Hour)}
+	resigned := base
+	resigned.ValidUntil = base.ValidUntil.Add(time.Minute)
+	raised := base
+	raised.EgressPrice = types.Siacoins(3).Div64(2)
+	for _, p := range []rhp.HostPrices{base, resigned, raised} {
+		if err := gc.Check(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// checking the same prices repeatedly must not move the median
+	for range 20 {
+		if err := gc.Check(raised); err != nil {
+			t.Fatal(err)
+		}
+	}
+	spike := base
+	spike.EgressPrice = types.Siacoins(5).Div64(2)
+	var ge *rhp.GougingError
+	if err := gc.Check(spike); !errors.As(err, &ge) || ge.Field != "egress price" {
+		t.Fatalf("expected egress price to be rejected, got %v", err)
+	}
+}
+
+func TestReadSectorStream(t *testing.T) {
+	h := rhptest.NewHost()
+	c := rhp.NewClient(h, h.PublicKey(), rhp.GougingSettings{})
+	renterKey, account := rhp.GenerateAccount()
+	token := account.Token(renterKey, h.PublicKey())
+	h.Credit(account, types.Siacoins(1))
+	prices := h.Settings().Prices
+
+	data := frand.Bytes(rhp.SectorSize)
+	root, _, err := c.WriteSector(prices, token, bytes.NewReader(data), rhp.SectorSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(offset, length uint64) ([]byte, rhp.Usage, error) {
+		r, usage, err := c.ReadSectorStream(prices, token, root, offset, length)
+		if err != nil {
+			return nil, rhp.Usage{}, err
+		}
+		defer r.Close()
+		buf, err := io.ReadAll(r)
+		return buf, usage, err
+	}
+	tests := []struct {
+		offset, length, aligned uint64
+	}{
+		{0, rhp.SectorSize, rhp.SectorSize},
+		{rhp.LeafSize * 10, rhp.LeafSize * 20, rhp.LeafSize * 20},
+		{1, 1, rhp.LeafSize},
+		{rhp.LeafSize - 1, 2, 2 * rhp.LeafSize},
+		{1000, 12345, 12416},
+		{rhp.SectorSize - 100, 100, rhp.LeafSize},
+	}
+	for _, test := range tests {
+		buf, usage, err := read(test.offset, test.length)
+		if err != nil {
+			t.Fatalf("[%v, %v): %v", test.offset, test.offset+test.length, err)
+		} else if !bytes.Equal(buf, data[test.offset:][:test.length]) {
+			t.Fatalf("[%v, %v): data mismatch", test.offset, test.offset+test.length)
+		} else if usage != prices.RPCReadSectorCost(test.aligned) {
+			t.Fatalf("[%v, %v): expected usage for %v bytes", test.offset, test.offset+test.length, test.aligned)
+		}
+	}
+	if _, _, err := read(rhp.SectorSize-1, 2); err == nil {
+		t.Fatal("expected out-of-bounds read to be rejected")
+	} else if _, _, err := read(math.MaxUint64, 2); err == nil {
+		t.Fatal("expected overflowing read to be rejected")
+	}
+
+	// a reader can be closed before it is drained
+	r, _, err := c.ReadSectorStream(prices, token, root, 0, rhp.SectorSize)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := io.ReadFull(r, make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	} else if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// corrupt data is detected before the final bytes are released
+	sector, _ := h.Sector(root)
+	sector[rhp.SectorSize-1] ^= 1
+	for _, test := range tests {
+		if test.offset+test.length < rhp.SectorSize-rhp.LeafSize {
+			continue
+		}
+		buf, _, err := read(test.offset, test.length)
+		if !errors.Is(err, rhp.ErrInvalidProof) {
+			t.Fatalf("[%v, %v): expected invalid proof, got %v", test.offset, test.offset+test.length, err)
+		} else if uint64(len(buf)) >= test.length {
+			t.Fatalf("[%v, %v): final bytes released before verification", test.offset, test.offset+test.length)
+		}
+	}
+}
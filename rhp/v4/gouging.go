@@ -0,0 +1,170 @@
+package rhp
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"sync"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+// priceHistorySize is the number of distinct recently-accepted prices a
+// GougingChecker compares new prices against.
+const priceHistorySize = 10
+
+// GougingSettings are the limits a renter places on a host's prices. A zero
+// value disables the corresponding check.
+type GougingSettings struct {
+	MaxContractPrice   types.Currency `json:"maxContractPrice"`
+	MaxStoragePrice    types.Currency `json:"maxStoragePrice"` // per byte per block
+	MaxIngressPrice    types.Currency `json:"maxIngressPrice"` // per byte
+	MaxEgressPrice     types.Currency `json:"maxEgressPrice"`  // per byte
+	MaxFreeSectorPrice types.Currency `json:"maxFreeSectorPrice"`
+	MinCollateral      types.Currency `json:"minCollateral"` // per byte per block
+
+	// MaxValidity is the furthest in the future that prices may expire. Hosts
+	// that sign long-lived prices can continue to charge them long after
+	// they have changed.
+	MaxValidity time.Duration `json:"maxValidity"`
+	// MaxDeviation is the largest factor by which a price may exceed the
+	// median of the host's recent prices (or, for collateral, fall short of
+	// it).
+	MaxDeviation float64 `json:"maxDeviation"`
+}
+
+// A GougingError is returned when a host's prices exceed a renter's
+// GougingSettings.
+type GougingError struct {
+	Field  string
+	Reason string
+}
+
+// Error implements error.
+func (e *GougingError) Error() string {
+	return fmt.Sprintf("host is gouging: %v %v", e.Field, e.Reason)
+}
+
+// CheckPrices returns a *GougingError if p exceeds the limits of gs.
+func (gs GougingSettings) CheckPrices(p HostPrices) error {
+	for _, c := range []struct {
+		field string
+		price types.Currency
+		limit types.Currency
+	}{
+		{"contract price", p.ContractPrice, gs.MaxContractPrice},
+		{"storage price", p.StoragePrice, gs.MaxStoragePrice},
+		{"ingress price", p.IngressPrice, gs.MaxIngressPrice},
+		{"egress price", p.EgressPrice, gs.MaxEgressPrice},
+		{"free sector price", p.FreeSectorPrice, gs.MaxFreeSectorPrice},
+	} {
+		if !c.limit.IsZero() && c.price.Cmp(c.limit) > 0 {
+			return &GougingError{c.field, fmt.Sprintf("%v exceeds limit of %v", c.price, c.limit)}
+		}
+	}
+	if !gs.MinCollateral.IsZero() && p.Collateral.Cmp(gs.MinCollateral) < 0 {
+		return &GougingError{"collateral", fmt.Sprintf("%v is below minimum of %v", p.Collateral, gs.MinCollateral)}
+	}
+	if gs.MaxValidity > 0 {
+		if validity := time.Until(p.ValidUntil); validity > gs.MaxValidity {
+			return &GougingError{"validity", fmt.Sprintf("%v exceeds limit of %v", validity.Round(time.Second), gs.MaxValidity)}
+		}
+	}
+	return nil
+}
+
+// A GougingChecker checks a host's prices against a renter's GougingSettings
+// and the host's recent prices.
+type GougingChecker struct {
+	mu       sync.Mutex
+	settings GougingSettings
+	history  []HostPrices
+}
+
+func median(prices []HostPrices, field func(HostPrices) types.Currency) types.Currency {
+	vals := make([]types.Currency, len(prices))
+	for i := range prices {
+		vals[i] = field(prices[i])
+	}
+	slices.SortFunc(vals, types.Currency.Cmp)
+	return vals[len(vals)/2]
+}
+
+func ratio(a, b types.Currency) float64 {
+	f, _ := new(big.Rat).SetFrac(a.Big(), b.Big()).Float64()
+	return f
+}
+
+// Check returns a *GougingError if p exceeds the checker's settings, or
+// deviates too far from the host's recent prices. Otherwise, p is added to
+// the host's recent prices, unless it is already among them.
+func (gc *GougingChecker) Check(p HostPrices) error {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if err := gc.settings.CheckPrices(p); err != nil {
+		return err
+	}
+	if len(gc.history) > 0 && gc.settings.MaxDeviation > 0 {
+		for _, c := range []struct {
+			field   string
+			price   func(HostPrices) types.Currency
+			inverse bool
+		}{
+			{"contract price", func(p HostPrices) types.Currency { return p.ContractPrice }, false},
+			{"storage price", func(p HostPrices) types.Currency { return p.StoragePrice }, false},
+			{"ingress price", func(p HostPrices) types.Currency { return p.IngressPrice }, false},
+			{"egress price", func(p HostPrices) types.Currency { return p.EgressPrice }, false},
+			{"free sector price", func(p HostPrices) types.Currency { return p.FreeSectorPrice }, false},
+			{"collateral", func(p HostPrices) types.Currency { return p.Collateral }, true},
+		} {
+			m, v := median(gc.history, c.price), c.price(p)
+			var deviation float64
+			switch {
+			case m.IsZero():
+				continue // only the absolute limits apply to previously-free prices
+			case !c.inverse:
+				deviation = ratio(v, m)
+			case v.IsZero():
+				deviation = math.Inf(1)
+			default:
+				deviation = ratio(m, v)
+			}
+			if deviation > gc.settings.MaxDeviation {
+				return &GougingError{c.field, fmt.Sprintf("%v deviates too far from recent median of %v", v, m)}
+			}
+		}
+	}
+	// only record distinct prices, so that repeatedly checking the same
+	// prices does not crowd out the rest of the history
+	h := p.SigHash()
+	if !slices.ContainsFunc(gc.history, func(hp HostPrices) bool { return hp.SigHash() == h }) {
+		gc.history = append(gc.history, p)
+		if len(gc.history) > priceHistorySize {
+			gc.history = gc.history[1:]
+		}
+	}
+	return nil
+}
+
+// Settings returns the checker's settings.
+func (gc *GougingChecker) Settings() GougingSettings {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	return gc.settings
+}
+
+// SetSettings updates the checker's settings. The host's recent prices are
+// retained.
+func (gc *GougingChecker) SetSettings(gs GougingSettings) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.settings = gs
+}
+
+// NewGougingChecker returns a GougingChecker with the given settings and no
+// price history.
+func NewGougingChecker(gs GougingSettings) *GougingChecker {
+	return &GougingChecker{settings: gs}
+}
@@ -0,0 +1,156 @@
+package rhp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+// A Migration moves the data of a v1 file contract to an equivalent v2 file
+// contract.
+//
+// The funds locked in a v1 contract cannot be released until its proof window
+// ends, so, like a v1 renewal, a migration forms the v2 contract with new funds
+// and clears the v1 contract: its final revision removes the data and pays the
+// valid outputs regardless of whether a storage proof is submitted.
+type Migration struct {
+	// Clearing is a v1 transaction containing the final revision of the v1
+	// contract. It is nil if the v2 require height has been reached, since v1
+	// contracts can no longer be revised; in that case the v1 contract will
+	// expire with its missed outputs.
+	Clearing *types.Transaction `json:"clearing,omitempty"`
+	// Formation is a v2 transaction forming the v2 contract. It must be funded
+	// by the renter and host before it is signed.
+	Formation types.V2Transaction `json:"formation"`
+
+	// ClearingSupplement is the supplement used to validate Clearing.
+	ClearingSupplement consensus.V1TransactionSupplement `json:"clearingSupplement"`
+}
+
+// clearingFields are the fields of the clearing transaction covered by the
+// renter's and host's signatures.
+var clearingFields = types.CoveredFields{FileContractRevisions: []uint64{0}}
+
+// MigrateContract returns a Migration for fce, whose latest revision is rev. The
+// v2 contract stores the same data, with the same proof window, and pays the
+// same outputs as rev. Its total collateral is the host's missed payout, i.e.
+// the host funds that are not yet at risk.
+//
+// The renter and host public keys are taken from the unlock conditions of rev.
+func MigrateContract(cs consensus.State, fce types.FileContractElement, rev types.FileContractRevision) (Migration, error) {
+	fc := rev.FileContract
+	switch {
+	case rev.ParentID != fce.ID:
+		return Migration{}, fmt.Errorf("revision parent %v does not match contract %v", rev.ParentID, fce.ID)
+	case rev.UnlockConditions.UnlockHash() != fce.FileContract.UnlockHash:
+		return Migration{}, errors.New("revision has incorrect unlock conditions")
+	case fc.RevisionNumber < fce.FileContract.RevisionNumber:
+		return Migration{}, fmt.Errorf("revision %v is older than confirmed revision %v", fc.RevisionNumber, fce.FileContract.RevisionNumber)
+	case fc.RevisionNumber == math.MaxUint64:
+		return Migration{}, errors.New("contract has already been cleared")
+	case fc.WindowStart < cs.Index.Height+1:
+		return Migration{}, fmt.Errorf("contract proof window has already opened (%v)", fc.WindowStart)
+	case len(fc.ValidProofOutputs) < 2 || len(fc.MissedProofOutputs) < 2:
+		return Migration{}, errors.New("contract must have renter and host outputs")
+	case fc.MissedProofOutputs[0] != fc.ValidProofOutputs[0]:
+		return Migration{}, errors.New("contract has different valid and missed renter outputs")
+	case fc.MissedProofOutputs[1].Value.Cmp(fc.ValidProofOutputs[1].Value) > 0:
+		return Migration{}, errors.New("contract has missed host payout exceeding valid host payout")
+	}
+	uc := rev.UnlockConditions
+	if len(uc.PublicKeys) != 2 || uc.SignaturesRequired != 2 {
+		return Migration{}, errors.New("contract must be signed by exactly two keys")
+	}
+	var keys [2]types.PublicKey
+	for i, uk := range uc.PublicKeys {
+		if uk.Algorithm != types.SpecifierEd25519 || len(uk.Key) != len(keys[i]) {
+			return Migration{}, fmt.Errorf("contract public key %v is not an ed25519 key", i)
+		}
+		copy(keys[i][:], uk.Key)
+	}
+
+	var m Migration
+	if cs.Index.Height+1 < cs.Network.HardforkV2.RequireHeight {
+		cleared := fc
+		cleared.Filesize = 0
+		cleared.FileMerkleRoot = types.Hash256{}
+		cleared.RevisionNumber = math.MaxUint64
+		cleared.MissedProofOutputs = slices.Clone(fc.ValidProofOutputs)
+		m.Clearing = &types.Transaction{
+			FileContractRevisions: []types.FileContractRevision{{
+				ParentID:         fce.ID,
+				UnlockConditions: uc,
+				FileContract:     cleared,
+			}},
+			Signatures: []types.TransactionSignature{
+				{ParentID: types.Hash256(fce.ID), PublicKeyIndex: 0, CoveredFields: clearingFields},
+				{ParentID: types.Hash256(fce.ID), PublicKeyIndex: 1, CoveredFields: clearingFields},
+			},
+		}
+		m.ClearingSupplement.RevisedFileContracts = []types.FileContractElement{fce.Copy()}
+	}
+	m.Formation.FileContracts = []types.V2FileContract{{
+		Capacity:         fc.Filesize,
+		Filesize:         fc.Filesize,
+		FileMerkleRoot:   fc.FileMerkleRoot,
+		ProofHeight:      fc.WindowStart,
+		ExpirationHeight: fc.WindowEnd,
+		RenterOutput:     fc.ValidProofOutputs[0],
+		HostOutput:       fc.ValidProofOutputs[1],
+		MissedHostValue:  fc.MissedProofOutputs[1].Value,
+		TotalCollateral:  fc.MissedProofOutputs[1].Value,
+		RenterPublicKey:  keys[0],
+		HostPublicKey:    keys[1],
+	}}
+	return m, nil
+}
+
+// Contract returns the v2 contract formed by the migration.
+func (m *Migration) Contract() *types.V2FileContract {
+	return &m.Formation.FileContracts[0]
+}
+
+// ContractID returns the ID of the v2 contract formed by the migration. It
+// changes if the formation transaction is modified, e.g. by funding it.
+func (m *Migration) ContractID() types.FileContractID {
+	return m.Formation.V2FileContractID(m.Formation.ID(), 0)
+}
+
+// ClearingSigHash returns the hash that the renter and host must sign to
+// authorize the clearing revision. The renter's signature is placed in
+// Clearing.Signatures[0], and the host's in Clearing.Signatures[1].
+func (m *Migration) ClearingSigHash(cs consensus.State) types.Hash256 {
+	return cs.PartialSigHash(*m.Clearing, clearingFields)
+}
+
+// ContractSigHash returns the hash that the renter and host must sign to
+// authorize the v2 contract.
+func (m *Migration) ContractSigHash(cs consensus.State) types.Hash256 {
+	return cs.ContractSigHash(*m.Contract())
+}
+
+// Required returns the amounts that the renter and host must add to the
+// formation transaction, excluding the miner fee.
+func (m *Migration) Required(cs consensus.State) (renter, host types.Currency) {
+	fc := m.Contract()
+	return fc.RenterOutput.Value.Add(cs.V2FileContractTax(*fc)), fc.HostOutput.Value
+}
+
+// Validate validates the signed and funded migration within the context of cs.
+func (m *Migration) Validate(cs consensus.State) error {
+	ms := consensus.NewMidState(cs)
+	if m.Clearing != nil {
+		if err := consensus.ValidateTransaction(ms, *m.Clearing, m.ClearingSupplement); err != nil {
+			return fmt.Errorf("invalid clearing transaction: %w", err)
+		}
+		ms.ApplyTransaction(*m.Clearing, m.ClearingSupplement)
+	}
+	if err := consensus.ValidateV2Transaction(ms, m.Formation); err != nil {
+		return fmt.Errorf("invalid formation transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package rhp
+
+import (
+	"math"
+	"testing"
+
+	"go.sia.tech/core/consensus/chaintest"
+	"go.sia.tech/core/types"
+)
+
+// fundV2 adds an input from w worth at least amount to txn, along with a
+// change output.
+func fundV2(t *testing.T, txn *types.V2Transaction, w *chaintest.Wallet, amount types.Currency) {
+	t.Helper()
+	for _, sce := range w.SiacoinElements() {
+		if sce.SiacoinOutput.Value.Cmp(amount) >= 0 {
+			txn.SiacoinInputs = append(txn.SiacoinInputs, types.V2SiacoinInput{
+				Parent:          sce.Copy(),
+				SatisfiedPolicy: types.SatisfiedPolicy{Policy: w.SpendPolicy()},
+			})
+			txn.SiacoinOutputs = append(txn.SiacoinOutputs, types.SiacoinOutput{
+				Address: w.Address(),
+				Value:   sce.SiacoinOutput.Value.Sub(amount),
+			})
+			return
+		}
+	}
+	t.Fatalf("wallet %q has insufficient funds", w.Name)
+}
+
+func TestMigrateContract(t *testing.T) {
+	c := chaintest.New(t, nil)
+	n := c.Network()
+	genesis, renter, host := c.Wallet("genesis"), c.Wallet("renter"), c.Wallet("host")
+	c.SendSiacoins(genesis, renter.Address(), types.Siacoins(1000)).
+		SendSiacoins(genesis, host.Address(), types.Siacoins(1000)).
+		Mine(1)
+
+	// form a v1 contract whose proof window opens after the require height
+	windowStart := n.HardforkV2.RequireHeight + 10
+	id := c.FormContract(renter, host, types.Siacoins(100), types.Siacoins(200), windowStart, windowStart+10)
+	c.Mine(int(n.HardforkV2.AllowHeight - c.Tip().Index.Height))
+	fce, ok := c.FileContractElement(id)
+	if !ok {
+		t.Fatal("missing contract")
+	}
+	rev := types.FileContractRevision{
+		ParentID: id,
+		UnlockConditions: types.UnlockConditions{
+			PublicKeys:         []types.UnlockKey{renter.PublicKey().UnlockKey(), host.PublicKey().UnlockKey()},
+			SignaturesRequired: 2,
+		},
+		FileContract: fce.FileContract,
+	}
+
+	cs := c.Tip()
+	m, err := MigrateContract(cs, fce, rev)
+	if err != nil {
+		t.Fatal(err)
+	} else if m.Clearing == nil {
+		t.Fatal("expected clearing transaction before the require height")
+	}
+	fc := m.Contract()
+	if fc.FileMerkleRoot != fce.FileContract.FileMerkleRoot || fc.Filesize != fce.FileContract.Filesize {
+		t.Fatal("v2 contract does not store the same data")
+	} else if fc.RenterOutput != fce.FileContract.ValidProofOutputs[0] || fc.HostOutput != fce.FileContract.ValidProofOutputs[1] {
+		t.Fatal("v2 contract does not pay the same outputs")
+	} else if fc.ProofHeight != windowStart || fc.ExpirationHeight != windowStart+10 {
+		t.Fatal("v2 contract does not have the same proof window")
+	}
+
+	// an unsigned, unfunded migration is invalid
+	if err := m.Validate(cs); err == nil {
+		t.Fatal("expected unsigned migration to be invalid")
+	}
+
+	// sign and fund
+	sigHash := m.ClearingSigHash(cs)
+	renterSig, hostSig := renter.PrivateKey.SignHash(sigHash), host.PrivateKey.SignHash(sigHash)
+	m.Clearing.Signatures[0].Signature = renterSig[:]
+	m.Clearing.Signatures[1].Signature = hostSig[:]
+	contractHash := m.ContractSigHash(cs)
+	fc.RenterSignature = renter.PrivateKey.SignHash(contractHash)
+	fc.HostSignature = host.PrivateKey.SignHash(contractHash)
+	renterCost, hostCost := m.Required(cs)
+	fundV2(t, &m.Formation, renter, renterCost)
+	fundV2(t, &m.Formation, host, hostCost)
+	inputHash := cs.InputSigHash(m.Formation)
+	for i, w := range []*chaintest.Wallet{renter, host} {
+		m.Formation.SiacoinInputs[i].SatisfiedPolicy.Signatures = []types.Signature{w.PrivateKey.SignHash(inputHash)}
+	}
+	if err := m.Validate(cs); err != nil {
+		t.Fatal(err)
+	}
+
+	v2id := m.ContractID()
+	c.AddTransaction(*m.Clearing).AddV2Transaction(m.Formation).Mine(1)
+	if fce, ok := c.FileContractElement(id); !ok || fce.FileContract.RevisionNumber != math.MaxUint64 {
+		t.Fatal("expected v1 contract to be cleared")
+	} else if _, ok := c.V2FileContractElement(v2id); !ok {
+		t.Fatal("missing v2 contract")
+	}
+
+	// the v2 contract can be proven with the same data, and the cleared v1
+	// contract pays its valid outputs despite missing its proof
+	c.Mine(int(windowStart - c.Tip().Index.Height)).SubmitStorageProof(v2id).Mine(1)
+	if _, ok := c.V2FileContractElement(v2id); ok {
+		t.Fatal("expected v2 contract to be resolved")
+	}
+	c.Mine(int(windowStart + 10 - c.Tip().Index.Height))
+	if sce, ok := c.SiacoinElement(id.MissedOutputID(1)); !ok || sce.SiacoinOutput != fce.FileContract.ValidProofOutputs[1] {
+		t.Fatal("expected v1 contract to pay valid host output")
+	}
+}
+
+func TestMigrateContractRequireHeight(t *testing.T) {
+	c := chaintest.New(t, nil)
+	n := c.Network()
+	genesis, renter, host := c.Wallet("genesis"), c.Wallet("renter"), c.Wallet("host")
+	c.SendSiacoins(genesis, renter.Address(), types.Siacoins(1000)).
+		SendSiacoins(genesis, host.Address(), types.Siacoins(1000)).
+		Mine(1)
+	windowStart := n.HardforkV2.RequireHeight + 5
+	id := c.FormContract(renter, host, types.Siacoins(100), types.Siacoins(200), windowStart, windowStart+10)
+	c.Mine(1)
+	fce, _ := c.FileContractElement(id)
+	rev := types.FileContractRevision{
+		ParentID: id,
+		UnlockConditions: types.UnlockConditions{
+			PublicKeys:         []types.UnlockKey{renter.PublicKey().UnlockKey(), host.PublicKey().UnlockKey()},
+			SignaturesRequired: 2,
+		},
+		FileContract: fce.FileContract,
+	}
+
+	// a stale revision is rejected
+	stale := rev
+	stale.FileContract.RevisionNumber = 0
+	fce.FileContract.RevisionNumber = 1
+	if _, err := MigrateContract(c.Tip(), fce, stale); err == nil {
+		t.Fatal("expected stale revision to be rejected")
+	}
+	fce.FileContract.RevisionNumber = 0
+
+	// after the require height, the v1 contract cannot be cleared
+	c.Mine(int(n.HardforkV2.RequireHeight - c.Tip().Index.Height))
+	fce, _ = c.FileContractElement(id)
+	if m, err := MigrateContract(c.Tip(), fce, rev); err != nil {
+		t.Fatal(err)
+	} else if m.Clearing != nil {
+		t.Fatal("expected no clearing transaction after the require height")
+	}
+
+	// once the proof window opens, the contract cannot be migrated
+	c.Mine(int(windowStart - c.Tip().Index.Height))
+	fce, _ = c.FileContractElement(id)
+	if _, err := MigrateContract(c.Tip(), fce, rev); err == nil {
+		t.Fatal("expected error after proof window opened")
+	}
+}
@@ -0,0 +1,399 @@
+// Package rhptest provides an in-memory RHP4 host for testing renter code.
+//
+// A Host stores sectors, contracts and account balances in memory and serves RPCs over
+// in-process pipes. It implements rhp.Transport, so it can be passed directly
+// to rhp.NewClient:
+//
+//	h := rhptest.NewHost()
+//	c := rhp.NewClient(h, h.PublicKey(), rhp.GougingSettings{})
+//	renterKey, account := rhp.GenerateAccount()
+//	h.Credit(account, types.Siacoins(1))
+//	hs, _ := c.Settings()
+//	root, _, err := c.WriteSector(hs.Prices, account.Token(renterKey, h.PublicKey()), r, rhp.SectorSize)
+package rhptest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/consensus/chaintest"
+	rhp "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/core/types"
+)
+
+// PriceValidity is the duration for which a Host's prices are valid.
+const PriceValidity = 10 * time.Minute
+
+// A Host is an in-memory RHP4 host.
+type Host struct {
+	key types.PrivateKey
+
+	mu        sync.Mutex
+	settings  rhp.HostSettings
+	cs        consensus.State
+	sectors   map[types.Hash256]*[rhp.SectorSize]byte
+	accounts  map[rhp.Account]types.Currency
+	contracts map[types.FileContractID]types.V2FileContract
+	requests  map[types.Specifier]int
+	// tamperReplenish, if set, modifies the deposits of RPCReplenishAccounts
+	tamperReplenish func(*rhp.RPCReplenishAccountsResponse)
+}
+
+// PublicKey returns the host's public key.
+func (h *Host) PublicKey() types.PublicKey { return h.key.PublicKey() }
+
+// Settings returns the host's settings, with freshly-signed prices.
+func (h *Host) Settings() rhp.HostSettings {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.signedSettings()
+}
+
+func (h *Host) signedSettings() rhp.HostSettings {
+	hs := h.settings
+	hs.RemainingStorage = hs.TotalStorage - uint64(len(h.sectors))
+	hs.Prices.ValidUntil = time.Now().Add(PriceValidity)
+	hs.Prices.Signature = h.key.SignHash(hs.Prices.SigHash())
+	return hs
+}
+
+// UpdateSettings modifies the host's settings.
+func (h *Host) UpdateSettings(fn func(*rhp.HostSettings)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fn(&h.settings)
+}
+
+// SignPrices signs p with the host's key. It can be used to forge prices
+// that the host never advertised.
+func (h *Host) SignPrices(p rhp.HostPrices) rhp.HostPrices {
+	p.Signature = h.key.SignHash(p.SigHash())
+	return p
+}
+
+// State returns the consensus state the host uses to sign contract revisions.
+// By default, it is the genesis state of chaintest.Network.
+func (h *Host) State() consensus.State {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.cs
+}
+
+// SetState sets the consensus state the host uses to sign contract revisions.
+func (h *Host) SetState(cs consensus.State) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cs = cs
+}
+
+// AddContract adds a contract that the renter can pay with. The contract is
+// not validated.
+func (h *Host) AddContract(id types.FileContractID, fc types.V2FileContract) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.contracts[id] = fc
+}
+
+// Contract returns the latest revision of a contract.
+func (h *Host) Contract(id types.FileContractID) (types.V2FileContract, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fc, ok := h.contracts[id]
+	return fc, ok
+}
+
+// Credit adds amount to the balance of an account.
+func (h *Host) Credit(account rhp.Account, amount types.Currency) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.accounts[account] = h.accounts[account].Add(amount)
+}
+
+// Balance returns the balance of an account.
+func (h *Host) Balance(account rhp.Account) types.Currency {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.accounts[account]
+}
+
+// Sector returns the sector with the given root, if the host is storing it.
+// The returned sector may be modified to simulate data corruption.
+func (h *Host) Sector(root types.Hash256) (*[rhp.SectorSize]byte, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sector, ok := h.sectors[root]
+	return sector, ok
+}
+
+// DeleteSector removes a sector from the host.
+func (h *Host) DeleteSector(root types.Hash256) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.sectors, root)
+}
+
+// TamperReplenish sets a function that modifies the host's response to
+// RPCReplenishAccounts, simulating a dishonest host. The host charges for, and
+// credits, the modified deposits. Passing nil restores honest behavior.
+func (h *Host) TamperReplenish(fn func(*rhp.RPCReplenishAccountsResponse)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tamperReplenish = fn
+}
+
+// Requests returns the number of requests the host has received for the
+// given RPC.
+func (h *Host) Requests(id types.Specifier) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.requests[id]
+}
+
+// DialStream implements rhp.Transport.
+func (h *Host) DialStream() (io.ReadWriteCloser, error) {
+	renter, host := net.Pipe()
+	go func() {
+		defer host.Close()
+		if err := h.handle(host); err != nil {
+			rhp.WriteResponse(host, asRPCError(err))
+		}
+	}()
+	return renter, nil
+}
+
+// debit deducts the renter cost of usage from the token's account.
+func (h *Host) debit(token rhp.AccountToken, usage rhp.Usage) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	balance, underflow := h.accounts[token.Account].SubWithUnderflow(usage.RenterCost())
+	if underflow {
+		return rhp.ErrNotEnoughFunds
+	}
+	h.accounts[token.Account] = balance
+	return nil
+}
+
+func asRPCError(err error) *rhp.RPCError {
+	if re := new(rhp.RPCError); errors.As(err, &re) {
+		return re
+	}
+	return &rhp.RPCError{Code: rhp.ErrorCodeBadRequest, Description: err.Error()}
+}
+
+// handle serves a single RPC. Errors returned by handle are sent to the
+// renter; transport errors are ignored.
+func (h *Host) handle(s net.Conn) error {
+	id, err := rhp.ReadID(s)
+	if err != nil {
+		return nil
+	}
+	h.mu.Lock()
+	h.requests[id]++
+	h.mu.Unlock()
+
+	switch id {
+	case rhp.RPCSettingsID:
+		rhp.WriteResponse(s, &rhp.RPCSettingsResponse{Settings: h.Settings()})
+		return nil
+
+	case rhp.RPCAccountBalanceID:
+		var req rhp.RPCAccountBalanceRequest
+		if err := rhp.ReadRequest(s, &req); err != nil {
+			return err
+		}
+		rhp.WriteResponse(s, &rhp.RPCAccountBalanceResponse{Balance: h.Balance(req.Account)})
+		return nil
+
+	case rhp.RPCReadSectorID:
+		var req rhp.RPCReadSectorRequest
+		if err := rhp.ReadRequest(s, &req); err != nil {
+			return err
+		} else if err := req.Validate(h.PublicKey()); err != nil {
+			return err
+		}
+		sector, ok := h.Sector(req.Root)
+		if !ok {
+			return rhp.ErrSectorNotFound
+		} else if err := h.debit(req.Token, req.Prices.RPCReadSectorCost(req.Length)); err != nil {
+			return err
+		}
+		start := req.Offset / rhp.LeafSize
+		end := (req.Offset + req.Length + rhp.LeafSize - 1) / rhp.LeafSize
+		h.mu.Lock()
+		proof := rhp.BuildSectorProof(sector, start, end)
+		data := append([]byte(nil), sector[req.Offset:][:req.Length]...)
+		h.mu.Unlock()
+		if err := rhp.WriteResponse(s, &rhp.RPCReadSectorResponse{Proof: proof, DataLength: req.Length}); err == nil {
+			s.Write(data)
+		}
+		return nil
+
+	case rhp.RPCWriteSectorID:
+		var req rhp.RPCWriteSectorRequest
+		if err := rhp.ReadRequest(s, &req); err != nil {
+			return err
+		} else if req.DataLength > rhp.SectorSize {
+			return errors.New("sector is too large")
+		}
+		// read the sector before validating the request, so that the renter
+		// is not blocked writing it when the error is sent
+		sector := new([rhp.SectorSize]byte)
+		if _, err := io.ReadFull(s, sector[:req.DataLength]); err != nil {
+			return nil
+		} else if err := req.Validate(h.PublicKey()); err != nil {
+			return err
+		} else if err := h.debit(req.Token, req.Prices.RPCWriteSectorCost(req.DataLength)); err != nil {
+			return err
+		}
+		root := rhp.SectorRoot(sector)
+		h.mu.Lock()
+		h.sectors[root] = sector
+		h.mu.Unlock()
+		rhp.WriteResponse(s, &rhp.RPCWriteSectorResponse{Root: root})
+		return nil
+
+	case rhp.RPCVerifySectorID:
+		var req rhp.RPCVerifySectorRequest
+		if err := rhp.ReadRequest(s, &req); err != nil {
+			return err
+		} else if err := req.Validate(h.PublicKey()); err != nil {
+			return err
+		}
+		sector, ok := h.Sector(req.Root)
+		if !ok {
+			return rhp.ErrSectorNotFound
+		} else if err := h.debit(req.Token, req.Prices.RPCVerifySectorCost()); err != nil {
+			return err
+		}
+		var resp rhp.RPCVerifySectorResponse
+		h.mu.Lock()
+		resp.Proof = rhp.BuildSectorProof(sector, req.LeafIndex, req.LeafIndex+1)
+		copy(resp.Leaf[:], sector[req.LeafIndex*rhp.LeafSize:])
+		h.mu.Unlock()
+		rhp.WriteResponse(s, &resp)
+		return nil
+
+	case rhp.RPCFundAccountsID:
+		var req rhp.RPCFundAccountsRequest
+		if err := rhp.ReadRequest(s, &req); err != nil {
+			return err
+		} else if err := req.Validate(); err != nil {
+			return err
+		}
+		var total types.Currency
+		for _, d := range req.Deposits {
+			total = total.Add(d.Amount)
+		}
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		fc, ok := h.contracts[req.ContractID]
+		if !ok {
+			return errors.New("unknown contract")
+		}
+		rev, _, err := rhp.ReviseForFundAccounts(fc, total)
+		if err != nil {
+			return err
+		}
+		sigHash := h.cs.ContractSigHash(rev)
+		if !fc.RenterPublicKey.VerifyHash(sigHash, req.RenterSignature) {
+			return rhp.ErrInvalidSignature
+		}
+		rev.RenterSignature = req.RenterSignature
+		rev.HostSignature = h.key.SignHash(sigHash)
+		h.contracts[req.ContractID] = rev
+		resp := rhp.RPCFundAccountsResponse{HostSignature: rev.HostSignature}
+		for _, d := range req.Deposits {
+			h.accounts[d.Account] = h.accounts[d.Account].Add(d.Amount)
+			resp.Balances = append(resp.Balances, h.accounts[d.Account])
+		}
+		rhp.WriteResponse(s, &resp)
+		return nil
+
+	case rhp.RPCReplenishAccountsID:
+		var req rhp.RPCReplenishAccountsRequest
+		if err := rhp.ReadRequest(s, &req); err != nil {
+			return err
+		} else if err := req.Validate(); err != nil {
+			return err
+		}
+		// hold the lock for the duration of the RPC, so that the deposits
+		// remain accurate
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		fc, ok := h.contracts[req.ContractID]
+		if !ok {
+			return errors.New("unknown contract")
+		} else if !req.ValidChallengeSignature(fc) {
+			return rhp.ErrInvalidSignature
+		}
+		var resp rhp.RPCReplenishAccountsResponse
+		for _, a := range req.Accounts {
+			if balance := h.accounts[a]; balance.Cmp(req.Target) < 0 {
+				resp.Deposits = append(resp.Deposits, rhp.AccountDeposit{Account: a, Amount: req.Target.Sub(balance)})
+			}
+		}
+		if h.tamperReplenish != nil {
+			h.tamperReplenish(&resp)
+		}
+		rev, _, err := rhp.ReviseForReplenish(fc, resp.TotalCost())
+		if err != nil {
+			return err
+		} else if err := rhp.WriteResponse(s, &resp); err != nil {
+			return nil
+		}
+		var second rhp.RPCReplenishAccountsSecondResponse
+		if err := rhp.ReadResponse(s, &second); err != nil {
+			return nil
+		}
+		sigHash := h.cs.ContractSigHash(rev)
+		if !fc.RenterPublicKey.VerifyHash(sigHash, second.RenterSignature) {
+			return rhp.ErrInvalidSignature
+		}
+		rev.RenterSignature = second.RenterSignature
+		rev.HostSignature = h.key.SignHash(sigHash)
+		h.contracts[req.ContractID] = rev
+		for _, d := range resp.Deposits {
+			h.accounts[d.Account] = h.accounts[d.Account].Add(d.Amount)
+		}
+		rhp.WriteResponse(s, &rhp.RPCReplenishAccountsThirdResponse{HostSignature: rev.HostSignature})
+		return nil
+
+	default:
+		return rhp.NewRPCError(rhp.ErrorCodeBadRequest, "unknown RPC")
+	}
+}
+
+// NewHost returns a Host with a random key, 1000 sectors of storage, and
+// reasonable prices.
+func NewHost() *Host {
+	key := types.GeneratePrivateKey()
+	return &Host{
+		key: key,
+		settings: rhp.HostSettings{
+			ProtocolVersion:     [3]uint8{4, 0, 0},
+			Release:             "rhptest",
+			WalletAddress:       types.StandardUnlockHash(key.PublicKey()),
+			AcceptingContracts:  true,
+			MaxCollateral:       types.Siacoins(1000),
+			MaxContractDuration: 144 * 90,
+			TotalStorage:        1000,
+			Prices: rhp.HostPrices{
+				ContractPrice:   types.Siacoins(1).Div64(5),
+				Collateral:      types.Siacoins(200).Div64(1e12).Div64(4320), // 200 SC/TB/month
+				StoragePrice:    types.Siacoins(100).Div64(1e12).Div64(4320), // 100 SC/TB/month
+				IngressPrice:    types.Siacoins(50).Div64(1e12),              // 50 SC/TB
+				EgressPrice:     types.Siacoins(100).Div64(1e12),             // 100 SC/TB
+				FreeSectorPrice: types.Siacoins(1).Div64(1e6),
+			},
+		},
+		cs:        chaintest.Network().GenesisState(),
+		sectors:   make(map[types.Hash256]*[rhp.SectorSize]byte),
+		accounts:  make(map[rhp.Account]types.Currency),
+		contracts: make(map[types.FileContractID]types.V2FileContract),
+		requests:  make(map[types.Specifier]int),
+	}
+}
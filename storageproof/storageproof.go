@@ -0,0 +1,342 @@
+// Package storageproof builds storage proofs for v2 file contracts and submits
+// them automatically.
+//
+// A host must resolve each of its v2 contracts with a V2StorageProof after the
+// contract's ProofHeight; otherwise, once the ExpirationHeight has passed,
+// anyone may resolve it with a V2FileContractExpiration, forfeiting the host's
+// collateral. The proof selects a leaf of the contract data using the ID of the
+// block at the ProofHeight, so it cannot be built in advance.
+//
+// Build constructs a proof from the contract's sector roots and the sector
+// containing the selected leaf, and ResolutionTransaction wraps it in a
+// transaction. A Watchtower does both automatically: it follows the chain via
+// ApplyUpdates and RevertUpdates, keeps the Merkle proofs of its contracts up
+// to date, and submits a proof for each contract as soon as one is valid.
+package storageproof
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.sia.tech/core/consensus"
+	rhp2 "go.sia.tech/core/rhp/v2"
+	rhp4 "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/core/types"
+)
+
+// RetryInterval is the number of blocks a Watchtower waits for a submitted
+// proof to be confirmed before submitting it again.
+const RetryInterval = 6
+
+// ErrRootMismatch is returned by Build when the sector roots or sector data do
+// not match the contract.
+var ErrRootMismatch = errors.New("data does not match contract Merkle root")
+
+// A SectorReader reads sector data.
+type SectorReader interface {
+	ReadSector(root types.Hash256) (*[rhp4.SectorSize]byte, error)
+}
+
+// A SectorStore provides the sector roots and data of a host's contracts.
+type SectorStore interface {
+	SectorReader
+	// SectorRoots returns the sector roots of the latest revision of the
+	// contract, in order.
+	SectorRoots(id types.FileContractID) ([]types.Hash256, error)
+}
+
+// A ChainStore provides the chain index elements of past blocks, allowing a
+// Watchtower to prove contracts that it starts watching after their proof
+// height.
+type ChainStore interface {
+	// ChainIndexElement returns the chain index element of the block at the
+	// specified height on the best chain. Its Merkle proof must be valid for
+	// the most recent update fed to the Watchtower.
+	ChainIndexElement(height uint64) (types.ChainIndexElement, bool)
+}
+
+// Build returns a storage proof for fce, which must store roots, using the
+// chain index element of the block at the contract's ProofHeight. The sector
+// containing the selected leaf is read from r.
+func Build(cs consensus.State, fce types.V2FileContractElement, cie types.ChainIndexElement, roots []types.Hash256, r SectorReader) (types.V2StorageProof, error) {
+	fc := fce.V2FileContract
+	if cie.ChainIndex.Height != fc.ProofHeight {
+		return types.V2StorageProof{}, fmt.Errorf("chain index height (%v) does not match contract proof height (%v)", cie.ChainIndex.Height, fc.ProofHeight)
+	} else if uint64(len(roots))*rhp4.SectorSize != fc.Filesize {
+		return types.V2StorageProof{}, fmt.Errorf("%v sector roots do not match contract filesize (%v)", len(roots), fc.Filesize)
+	} else if rhp4.MetaRoot(roots) != fc.FileMerkleRoot {
+		return types.V2StorageProof{}, ErrRootMismatch
+	}
+	sp := types.V2StorageProof{ProofIndex: cie}
+	if fc.Filesize == 0 {
+		// an empty contract is proven with an empty proof
+		return sp, nil
+	}
+
+	leafIndex := cs.StorageProofLeafIndex(fc.Filesize, cie.ChainIndex.ID, fce.ID)
+	sectorIndex, segmentIndex := leafIndex/rhp4.LeavesPerSector, leafIndex%rhp4.LeavesPerSector
+	sector, err := r.ReadSector(roots[sectorIndex])
+	if err != nil {
+		return types.V2StorageProof{}, fmt.Errorf("failed to read sector %v: %w", roots[sectorIndex], err)
+	} else if rhp4.SectorRoot(sector) != roots[sectorIndex] {
+		return types.V2StorageProof{}, fmt.Errorf("sector %v: %w", roots[sectorIndex], ErrRootMismatch)
+	}
+	copy(sp.Leaf[:], sector[segmentIndex*rhp4.LeafSize:][:rhp4.LeafSize])
+
+	// consensus expects the proof to be ordered from leaf to root
+	segmentProof := rhp2.ConvertProofOrdering(rhp4.BuildSectorProof(sector, segmentIndex, segmentIndex+1), segmentIndex)
+	sectorProof := rhp2.ConvertProofOrdering(rhp4.BuildSectorRootsProof(roots, sectorIndex, sectorIndex+1), sectorIndex)
+	sp.Proof = append(segmentProof, sectorProof...)
+	return sp, nil
+}
+
+// ResolutionTransaction returns a transaction that resolves fce with sp. The
+// transaction pays no miner fee; callers may add inputs and a fee before
+// broadcasting it.
+func ResolutionTransaction(fce types.V2FileContractElement, sp types.V2StorageProof) types.V2Transaction {
+	return types.V2Transaction{
+		FileContractResolutions: []types.V2FileContractResolution{{
+			Parent:     fce.Copy(),
+			Resolution: &sp,
+		}},
+	}
+}
+
+// A Submitter receives the resolution transactions built by a Watchtower.
+type Submitter interface {
+	// SubmitProof broadcasts txn, which is valid for a child of cs.
+	SubmitProof(cs consensus.State, id types.FileContractID, txn types.V2Transaction) error
+	// ProofFailed is called when a proof could not be built or submitted.
+	// The Watchtower retries after RetryInterval blocks.
+	ProofFailed(id types.FileContractID, err error)
+}
+
+type watchedContract struct {
+	// fce is nil until the contract is confirmed
+	fce      *types.V2FileContractElement
+	resolved bool
+	// submitted indicates whether a proof is outstanding; submittedHeight is
+	// the height of the tip when it was submitted
+	submitted       bool
+	submittedHeight uint64
+}
+
+type proofJob struct {
+	fce types.V2FileContractElement
+	cie types.ChainIndexElement
+	err error // set if the proof cannot be built
+}
+
+// A Watchtower submits storage proofs for v2 contracts. It must be fed every
+// update to the chain after its contracts are watched. The chain index element
+// of the block at a contract's proof height is captured when the block is
+// applied, or looked up in the ChainStore if the contract was watched later.
+//
+// A proof remains valid after the contract's expiration height until the
+// contract is resolved, so the Watchtower keeps submitting proofs until a
+// resolution, either a proof or an expiration, is confirmed. Resolved
+// contracts remain watched, so that monitoring resumes if the resolution is
+// reverted; call Unwatch to stop watching a contract.
+type Watchtower struct {
+	store SectorStore
+	chain ChainStore
+	sub   Submitter
+
+	mu        sync.Mutex
+	contracts map[types.FileContractID]*watchedContract
+	// cies holds the chain index elements of the blocks at the proof heights
+	// of watched contracts, keyed by height
+	cies map[uint64]types.ChainIndexElement
+}
+
+// Watch starts watching the contract with the given ID. The contract is
+// picked up once it is confirmed.
+func (w *Watchtower) Watch(id types.FileContractID) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.contracts[id]; !ok {
+		w.contracts[id] = new(watchedContract)
+	}
+}
+
+// WatchElement starts watching a confirmed contract. The element's proof must
+// be valid for the most recent update fed to the Watchtower.
+func (w *Watchtower) WatchElement(fce types.V2FileContractElement) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fce = fce.Copy()
+	w.contracts[fce.ID] = &watchedContract{fce: &fce}
+}
+
+// Unwatch stops watching the contract with the given ID.
+func (w *Watchtower) Unwatch(id types.FileContractID) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	c, ok := w.contracts[id]
+	if !ok {
+		return
+	}
+	delete(w.contracts, id)
+	w.releaseIndex(c)
+}
+
+// releaseIndex deletes the chain index element used by c if no other watched
+// contract needs it. c must no longer be watched.
+func (w *Watchtower) releaseIndex(c *watchedContract) {
+	if c.fce != nil && !w.needsIndex(c.fce.V2FileContract.ProofHeight) {
+		delete(w.cies, c.fce.V2FileContract.ProofHeight)
+	}
+}
+
+// Resolved reports whether the contract with the given ID is watched and has
+// been resolved.
+func (w *Watchtower) Resolved(id types.FileContractID) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	c, ok := w.contracts[id]
+	return ok && c.resolved
+}
+
+// needsIndex reports whether a watched contract has a proof height of height.
+func (w *Watchtower) needsIndex(height uint64) bool {
+	for _, c := range w.contracts {
+		if c.fce != nil && c.fce.V2FileContract.ProofHeight == height {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyBlock ingests an update applying a block. cs is the state after
+// applying the block. Proofs are built and submitted before ApplyBlock
+// returns.
+func (w *Watchtower) ApplyBlock(cs consensus.State, au consensus.ApplyUpdate) {
+	w.mu.Lock()
+	for _, c := range w.contracts {
+		if c.fce != nil {
+			au.UpdateElementProof(&c.fce.StateElement)
+		}
+	}
+	for height, cie := range w.cies {
+		au.UpdateElementProof(&cie.StateElement)
+		w.cies[height] = cie.Move()
+	}
+	for _, fced := range au.V2FileContractElementDiffs() {
+		c, ok := w.contracts[fced.V2FileContractElement.ID]
+		if !ok {
+			continue
+		}
+		fce := fced.V2FileContractElement.Copy()
+		if fced.Revision != nil {
+			fce.V2FileContract = *fced.Revision
+		}
+		c.fce = &fce
+		c.resolved = fced.Resolution != nil
+	}
+	if w.needsIndex(cs.Index.Height) {
+		w.cies[cs.Index.Height] = au.ChainIndexElement()
+	}
+
+	var jobs []proofJob
+	for _, c := range w.contracts {
+		if c.fce == nil || c.resolved || cs.Index.Height < c.fce.V2FileContract.ProofHeight {
+			continue
+		} else if c.submitted && cs.Index.Height < c.submittedHeight+RetryInterval {
+			continue
+		}
+		c.submitted, c.submittedHeight = true, cs.Index.Height
+		cie, err := w.proofIndex(cs, c.fce.V2FileContract.ProofHeight)
+		jobs = append(jobs, proofJob{c.fce.Copy(), cie.Copy(), err})
+	}
+	w.mu.Unlock()
+
+	for _, job := range jobs {
+		id := job.fce.ID
+		if job.err != nil {
+			w.sub.ProofFailed(id, job.err)
+		} else if err := w.submit(cs, job); err != nil {
+			w.sub.ProofFailed(id, err)
+		}
+	}
+}
+
+// proofIndex returns the chain index element of the block at height, looking
+// it up in the ChainStore if it was not captured when the block was applied.
+func (w *Watchtower) proofIndex(cs consensus.State, height uint64) (types.ChainIndexElement, error) {
+	if cie, ok := w.cies[height]; ok {
+		return cie, nil
+	}
+	cie, ok := w.chain.ChainIndexElement(height)
+	if !ok {
+		return types.ChainIndexElement{}, fmt.Errorf("chain index element at height %v not found", height)
+	} else if cie.ChainIndex.Height != height {
+		return types.ChainIndexElement{}, fmt.Errorf("chain store returned chain index element for height %v, not %v", cie.ChainIndex.Height, height)
+	} else if err := cs.Elements.VerifyChainIndexElement(cie.Share()); err != nil {
+		return types.ChainIndexElement{}, fmt.Errorf("chain store returned invalid chain index element: %w", err)
+	}
+	cie = cie.Copy()
+	w.cies[height] = cie
+	return cie, nil
+}
+
+// RevertBlock ingests an update reverting a block. cs is the state after
+// reverting the block.
+func (w *Watchtower) RevertBlock(cs consensus.State, ru consensus.RevertUpdate) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for height := range w.cies {
+		if height > cs.Index.Height {
+			delete(w.cies, height)
+		}
+	}
+	for _, fced := range ru.V2FileContractElementDiffs() {
+		c, ok := w.contracts[fced.V2FileContractElement.ID]
+		if !ok {
+			continue
+		} else if fced.Created {
+			c.fce = nil
+		} else {
+			fce := fced.V2FileContractElement.Copy()
+			c.fce = &fce
+		}
+		c.resolved, c.submitted = false, false
+	}
+	for _, c := range w.contracts {
+		if c.fce != nil {
+			ru.UpdateElementProof(&c.fce.StateElement)
+		}
+		// a proof submitted on the reverted chain may no longer be valid
+		if c.submitted && c.submittedHeight > cs.Index.Height {
+			c.submitted = false
+		}
+	}
+	for height, cie := range w.cies {
+		ru.UpdateElementProof(&cie.StateElement)
+		w.cies[height] = cie.Move()
+	}
+}
+
+func (w *Watchtower) submit(cs consensus.State, job proofJob) error {
+	roots, err := w.store.SectorRoots(job.fce.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get sector roots: %w", err)
+	}
+	sp, err := Build(cs, job.fce.Share(), job.cie.Share(), roots, w.store)
+	if err != nil {
+		return fmt.Errorf("failed to build storage proof: %w", err)
+	}
+	return w.sub.SubmitProof(cs, job.fce.ID, ResolutionTransaction(job.fce.Share(), sp))
+}
+
+// NewWatchtower returns a Watchtower that reads contract data from store, looks
+// up past chain index elements in chain, and submits proofs to sub.
+func NewWatchtower(store SectorStore, chain ChainStore, sub Submitter) *Watchtower {
+	return &Watchtower{
+		store:     store,
+		chain:     chain,
+		sub:       sub,
+		contracts: make(map[types.FileContractID]*watchedContract),
+		cies:      make(map[uint64]types.ChainIndexElement),
+	}
+}
@@ -0,0 +1,336 @@
+package storageproof
+
+import (
+	"errors"
+	"math/rand/v2"
+	"testing"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/consensus/chaintest"
+	rhp4 "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/core/types"
+)
+
+type memStore struct {
+	roots   map[types.FileContractID][]types.Hash256
+	sectors map[types.Hash256]*[rhp4.SectorSize]byte
+}
+
+func (ms *memStore) SectorRoots(id types.FileContractID) ([]types.Hash256, error) {
+	roots, ok := ms.roots[id]
+	if !ok {
+		return nil, errors.New("unknown contract")
+	}
+	return roots, nil
+}
+
+func (ms *memStore) ReadSector(root types.Hash256) (*[rhp4.SectorSize]byte, error) {
+	sector, ok := ms.sectors[root]
+	if !ok {
+		return nil, errors.New("sector not found")
+	}
+	return sector, nil
+}
+
+// addSectors adds n sectors of random data to the store, returning their
+// roots.
+func (ms *memStore) addSectors(rng *rand.Rand, n int) []types.Hash256 {
+	roots := make([]types.Hash256, n)
+	for i := range roots {
+		sector := new([rhp4.SectorSize]byte)
+		for j := 0; j < len(sector); j += 8 {
+			v := rng.Uint64()
+			for k := range 8 {
+				sector[j+k] = byte(v >> (8 * k))
+			}
+		}
+		roots[i] = rhp4.SectorRoot(sector)
+		ms.sectors[roots[i]] = sector
+	}
+	return roots
+}
+
+// chainStore implements ChainStore for a chaintest.Chain.
+type chainStore struct {
+	c *chaintest.Chain
+}
+
+func (cs chainStore) ChainIndexElement(height uint64) (types.ChainIndexElement, bool) {
+	if height > cs.c.Tip().Index.Height {
+		return types.ChainIndexElement{}, false
+	}
+	return cs.c.ChainIndexElement(height), true
+}
+
+type recorder struct {
+	txns   map[types.FileContractID]types.V2Transaction
+	failed map[types.FileContractID]error
+}
+
+func (r *recorder) SubmitProof(_ consensus.State, id types.FileContractID, txn types.V2Transaction) error {
+	r.txns[id] = txn
+	return nil
+}
+
+func (r *recorder) ProofFailed(id types.FileContractID, err error) {
+	r.failed[id] = err
+}
+
+// setup returns a chain at the v2 allow height, along with a renter and host
+// that can each fund several transactions per block.
+func setup(t *testing.T) (*chaintest.Chain, *chaintest.Wallet, *chaintest.Wallet) {
+	c := chaintest.New(t, nil)
+	genesis, renter, host := c.Wallet("genesis"), c.Wallet("renter"), c.Wallet("host")
+	for range 4 {
+		c.SendSiacoins(genesis, renter.Address(), types.Siacoins(1000)).
+			SendSiacoins(genesis, host.Address(), types.Siacoins(1000)).
+			Mine(1)
+	}
+	c.Mine(int(c.Network().HardforkV2.AllowHeight - c.Tip().Index.Height))
+	return c, renter, host
+}
+
+// formContract forms a v2 contract storing roots.
+func formContract(c *chaintest.Chain, renter, host *chaintest.Wallet, roots []types.Hash256, proofHeight uint64) types.FileContractID {
+	return c.FormV2Contract(renter, host, types.V2FileContract{
+		Capacity:         uint64(len(roots)) * rhp4.SectorSize,
+		Filesize:         uint64(len(roots)) * rhp4.SectorSize,
+		FileMerkleRoot:   rhp4.MetaRoot(roots),
+		ProofHeight:      proofHeight,
+		ExpirationHeight: proofHeight + 10,
+		RenterOutput:     types.SiacoinOutput{Address: renter.Address(), Value: types.Siacoins(10)},
+		HostOutput:       types.SiacoinOutput{Address: host.Address(), Value: types.Siacoins(20)},
+		MissedHostValue:  types.Siacoins(20),
+		TotalCollateral:  types.Siacoins(20),
+	})
+}
+
+func TestBuild(t *testing.T) {
+	c, renter, host := setup(t)
+	rng := rand.New(rand.NewPCG(1, 2))
+	store := &memStore{sectors: make(map[types.Hash256]*[rhp4.SectorSize]byte)}
+	roots := store.addSectors(rng, 5)
+
+	// contracts of varying sizes, including an empty contract
+	proofHeight := c.Tip().Index.Height + 2
+	sizes := []int{0, 1, 3, 5}
+	ids := make([]types.FileContractID, len(sizes))
+	for i, n := range sizes {
+		ids[i] = formContract(c, renter, host, roots[:n], proofHeight)
+	}
+	c.Mine(1)
+
+	// proofs cannot be built until the block at the proof height exists
+	c.Mine(int(proofHeight - c.Tip().Index.Height))
+	cie := c.ChainIndexElement(proofHeight)
+	for i, id := range ids {
+		fce, ok := c.V2FileContractElement(id)
+		if !ok {
+			t.Fatal("missing contract")
+		}
+		sp, err := Build(c.Tip(), fce, cie, roots[:sizes[i]], store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.AddV2Transaction(ResolutionTransaction(fce, sp))
+	}
+	c.Mine(1)
+	for _, id := range ids {
+		if _, ok := c.V2FileContractElement(id); ok {
+			t.Fatal("expected contract to be resolved")
+		}
+	}
+
+	// mismatched data is rejected
+	id := formContract(c, renter, host, roots[:2], c.Tip().Index.Height+1)
+	c.Mine(1)
+	fce, _ := c.V2FileContractElement(id)
+	cie = c.ChainIndexElement(fce.V2FileContract.ProofHeight)
+	if _, err := Build(c.Tip(), fce, cie, roots[1:3], store); !errors.Is(err, ErrRootMismatch) {
+		t.Fatalf("expected root mismatch, got %v", err)
+	} else if _, err := Build(c.Tip(), fce, cie, roots[:1], store); err == nil {
+		t.Fatal("expected filesize mismatch")
+	} else if _, err := Build(c.Tip(), fce, c.ChainIndexElement(1), roots[:2], store); err == nil {
+		t.Fatal("expected proof height mismatch")
+	}
+	corrupt := &memStore{sectors: make(map[types.Hash256]*[rhp4.SectorSize]byte)}
+	for _, root := range roots[:2] {
+		sector := *store.sectors[root]
+		sector[0] ^= 1
+		corrupt.sectors[root] = &sector
+	}
+	if _, err := Build(c.Tip(), fce, cie, roots[:2], corrupt); !errors.Is(err, ErrRootMismatch) {
+		t.Fatalf("expected root mismatch, got %v", err)
+	}
+}
+
+func TestWatchtower(t *testing.T) {
+	c, renter, host := setup(t)
+	rng := rand.New(rand.NewPCG(3, 4))
+	store := &memStore{
+		roots:   make(map[types.FileContractID][]types.Hash256),
+		sectors: make(map[types.Hash256]*[rhp4.SectorSize]byte),
+	}
+	sub := &recorder{
+		txns:   make(map[types.FileContractID]types.V2Transaction),
+		failed: make(map[types.FileContractID]error),
+	}
+	wt := NewWatchtower(store, chainStore{c}, sub)
+	c.OnApply(func(cs consensus.State, _ types.Block, au consensus.ApplyUpdate) { wt.ApplyBlock(cs, au) })
+	c.OnRevert(func(cs consensus.State, _ types.Block, ru consensus.RevertUpdate) { wt.RevertBlock(cs, ru) })
+
+	// watch two contracts before they are confirmed; the data of the second
+	// is missing
+	proofHeight := c.Tip().Index.Height + 3
+	roots := store.addSectors(rng, 2)
+	id := formContract(c, renter, host, roots, proofHeight)
+	missing := formContract(c, renter, host, roots[:1], proofHeight)
+	store.roots[id] = roots
+	wt.Watch(id)
+	wt.Watch(missing)
+	c.Mine(int(proofHeight - c.Tip().Index.Height - 1))
+	if len(sub.txns) != 0 || len(sub.failed) != 0 {
+		t.Fatal("expected no proofs before the proof height")
+	}
+	c.Mine(1)
+	txn, ok := sub.txns[id]
+	if !ok {
+		t.Fatal("expected proof at the proof height")
+	} else if _, ok := sub.failed[missing]; !ok {
+		t.Fatal("expected missing contract to fail")
+	}
+	wt.Unwatch(missing)
+
+	// after a reorg, the proof is rebuilt using the new block at the proof
+	// height
+	c.Revert(1)
+	delete(sub.txns, id)
+	c.Mine(1)
+	if next, ok := sub.txns[id]; !ok {
+		t.Fatal("expected proof after reorg")
+	} else if next.FileContractResolutions[0].Resolution.(*types.V2StorageProof).ProofIndex.ID == txn.FileContractResolutions[0].Resolution.(*types.V2StorageProof).ProofIndex.ID {
+		t.Fatal("expected proof to use new chain index")
+	}
+
+	// unconfirmed proofs are resubmitted
+	delete(sub.txns, id)
+	c.Mine(RetryInterval - 1)
+	if _, ok := sub.txns[id]; ok {
+		t.Fatal("expected no resubmission before retry interval")
+	}
+	c.Mine(1)
+	txn, ok = sub.txns[id]
+	if !ok {
+		t.Fatal("expected resubmission after retry interval")
+	}
+
+	// the submitted transaction is valid and resolves the contract
+	c.AddV2Transaction(txn).Mine(1)
+	if _, ok := c.V2FileContractElement(id); ok {
+		t.Fatal("expected contract to be resolved")
+	} else if !wt.Resolved(id) {
+		t.Fatal("expected watchtower to see resolution")
+	}
+
+	// reverting the resolution triggers a new proof
+	c.Revert(1)
+	if wt.Resolved(id) {
+		t.Fatal("expected resolution to be reverted")
+	}
+	delete(sub.txns, id)
+	c.Mine(1)
+	txn, ok = sub.txns[id]
+	if !ok {
+		t.Fatal("expected proof after resolution was reverted")
+	}
+	c.AddV2Transaction(txn).Mine(1)
+	if !wt.Resolved(id) {
+		t.Fatal("expected contract to be resolved")
+	}
+}
+
+func TestWatchtowerLateAndExpired(t *testing.T) {
+	c, renter, host := setup(t)
+	rng := rand.New(rand.NewPCG(5, 6))
+	store := &memStore{
+		roots:   make(map[types.FileContractID][]types.Hash256),
+		sectors: make(map[types.Hash256]*[rhp4.SectorSize]byte),
+	}
+	sub := &recorder{
+		txns:   make(map[types.FileContractID]types.V2Transaction),
+		failed: make(map[types.FileContractID]error),
+	}
+	wt := NewWatchtower(store, chainStore{c}, sub)
+	c.OnApply(func(cs consensus.State, _ types.Block, au consensus.ApplyUpdate) { wt.ApplyBlock(cs, au) })
+	c.OnRevert(func(cs consensus.State, _ types.Block, ru consensus.RevertUpdate) { wt.RevertBlock(cs, ru) })
+
+	// a contract watched after its proof height is proven using the chain
+	// index element from the chain store
+	proofHeight := c.Tip().Index.Height + 2
+	roots := store.addSectors(rng, 2)
+	late := formContract(c, renter, host, roots, proofHeight)
+	missing := formContract(c, renter, host, roots[:1], proofHeight)
+	abandoned := formContract(c, renter, host, roots[1:], proofHeight)
+	store.roots[late] = roots
+	c.Mine(int(proofHeight - c.Tip().Index.Height + 2))
+	for _, id := range []types.FileContractID{late, missing, abandoned} {
+		fce, ok := c.V2FileContractElement(id)
+		if !ok {
+			t.Fatal("missing contract")
+		}
+		wt.WatchElement(fce)
+	}
+	c.Mine(1)
+	txn, ok := sub.txns[late]
+	if !ok {
+		t.Fatal("expected proof for contract watched after its proof height", sub.failed[late])
+	}
+	c.AddV2Transaction(txn).Mine(1)
+	if !wt.Resolved(late) {
+		t.Fatal("expected contract to be resolved")
+	}
+
+	// a proof remains valid until the contract is resolved, so a contract
+	// that cannot be proven is retried past its expiration height
+	fce, _ := c.V2FileContractElement(missing)
+	c.Mine(int(fce.V2FileContract.ExpirationHeight - c.Tip().Index.Height + 1))
+	for _, id := range []types.FileContractID{missing, abandoned} {
+		if sub.failed[id] == nil {
+			t.Fatal("expected proof failure")
+		}
+		delete(sub.failed, id)
+	}
+	c.Mine(RetryInterval)
+	for _, id := range []types.FileContractID{missing, abandoned} {
+		if sub.failed[id] == nil || wt.Resolved(id) {
+			t.Fatal("expected expired contract to still be watched")
+		}
+	}
+
+	// once its data is available, an expired contract is still proven
+	store.roots[missing] = roots[:1]
+	// the proof must be confirmed before its element proof goes stale
+	ok = false
+	for i := 0; i < RetryInterval && !ok; i++ {
+		c.Mine(1)
+		txn, ok = sub.txns[missing]
+	}
+	if !ok {
+		t.Fatal("expected proof for expired contract", sub.failed[missing])
+	}
+	c.AddV2Transaction(txn).Mine(1)
+	if !wt.Resolved(missing) {
+		t.Fatal("expected expired contract to be resolved by its proof")
+	}
+
+	// a confirmed expiration also resolves the contract, ending the retries
+	c.ExpireContract(abandoned).Mine(1)
+	if !wt.Resolved(abandoned) {
+		t.Fatal("expected contract to be resolved by its expiration")
+	}
+	delete(sub.failed, abandoned)
+	c.Mine(RetryInterval)
+	if err, ok := sub.failed[abandoned]; ok {
+		t.Fatal("expected no more proofs for expired contract", err)
+	}
+}
@@ -37,6 +37,10 @@ func siafundLeaf(e *SiafundElement) elementLeaf {
 	return elementLeaf{&e.StateElement, hashAll("leaf/siafund", e.ID, V2SiafundOutput(e.SiafundOutput), V2Currency(e.ClaimStart))}
 }
 
+func fileContractLeaf(e *FileContractElement) elementLeaf {
+	return elementLeaf{&e.StateElement, hashAll("leaf/filecontract", e.ID, e.FileContract)}
+}
+
 func v2FileContractLeaf(e *V2FileContractElement) elementLeaf {
 	return elementLeaf{&e.StateElement, hashAll("leaf/v2filecontract", e.ID, e.V2FileContract)}
 }
@@ -71,13 +75,20 @@ func forEachElementLeaf(txns []V2Transaction, fn func(l elementLeaf)) {
 	}
 }
 
-func forEachTree(txns []V2Transaction, fn func(i, j uint64, leaves []elementLeaf)) {
+func transactionLeaves(txns []V2Transaction) (leaves []elementLeaf) {
+	forEachElementLeaf(txns, func(l elementLeaf) {
+		leaves = append(leaves, l)
+	})
+	return
+}
+
+func forEachTree(leaves []elementLeaf, fn func(i, j uint64, leaves []elementLeaf)) {
 	clearBits := func(x uint64, n int) uint64 { return x &^ (1<<n - 1) }
 
 	var trees [64][]elementLeaf
-	forEachElementLeaf(txns, func(l elementLeaf) {
+	for _, l := range leaves {
 		trees[len(l.MerkleProof)] = append(trees[len(l.MerkleProof)], l)
-	})
+	}
 	for height, leaves := range &trees {
 		if len(leaves) == 0 {
 			continue
@@ -91,8 +102,8 @@ func forEachTree(txns []V2Transaction, fn func(i, j uint64, leaves []elementLeaf
 	}
 }
 
-// multiproofSize computes the size of a multiproof for the given transactions.
-func multiproofSize(txns []V2Transaction) int {
+// multiproofSize computes the size of a multiproof for the given leaves.
+func multiproofSize(leaves []elementLeaf) int {
 	var proofSize func(i, j uint64, leaves []elementLeaf) int
 	proofSize = func(i, j uint64, leaves []elementLeaf) int {
 		height := bits.TrailingZeros64(j - i)
@@ -107,14 +118,14 @@ func multiproofSize(txns []V2Transaction) int {
 	}
 
 	size := 0
-	forEachTree(txns, func(i, j uint64, leaves []elementLeaf) {
+	forEachTree(leaves, func(i, j uint64, leaves []elementLeaf) {
 		size += proofSize(i, j, leaves)
 	})
 	return size
 }
 
-// computeMultiproof computes a single Merkle proof for all inputs in txns.
-func computeMultiproof(txns []V2Transaction) (proof []Hash256) {
+// computeMultiproof computes a single Merkle proof for all of the leaves.
+func computeMultiproof(leaves []elementLeaf) (proof []Hash256) {
 	var visit func(i, j uint64, leaves []elementLeaf)
 	visit = func(i, j uint64, leaves []elementLeaf) {
 		height := bits.TrailingZeros64(j - i)
@@ -135,13 +146,13 @@ func computeMultiproof(txns []V2Transaction) (proof []Hash256) {
 		}
 	}
 
-	forEachTree(txns, visit)
+	forEachTree(leaves, visit)
 	return
 }
 
-// expandMultiproof restores all of the proofs with txns using the supplied
-// multiproof, the length of which must equal multiproofSize(txns).
-func expandMultiproof(txns []V2Transaction, proof []Hash256) {
+// expandMultiproof restores the proofs of all of the leaves using the supplied
+// multiproof, the length of which must equal multiproofSize(leaves).
+func expandMultiproof(leaves []elementLeaf, proof []Hash256) {
 	var visit func(i, j uint64, leaves []elementLeaf) Hash256
 	visit = func(i, j uint64, leaves []elementLeaf) Hash256 {
 		height := bits.TrailingZeros64(j - i)
@@ -166,7 +177,7 @@ func expandMultiproof(txns []V2Transaction, proof []Hash256) {
 		return blake2b.SumPair(leftRoot, rightRoot)
 	}
 
-	forEachTree(txns, func(i, j uint64, leaves []elementLeaf) {
+	forEachTree(leaves, func(i, j uint64, leaves []elementLeaf) {
 		_ = visit(i, j, leaves)
 	})
 }
@@ -187,43 +198,149 @@ func (txns V2TransactionsMultiproof) EncodeTo(e *Encoder) {
 	for i := range prooflessTxns {
 		prooflessTxns[i] = txns[i].DeepCopy()
 	}
-	var numLeaves uint64
 	forEachElementLeaf(prooflessTxns, func(l elementLeaf) {
+		l.MerkleProof = nil
+	})
+	EncodeSlice(e, prooflessTxns)
+	encodeMultiproof(e, transactionLeaves(txns))
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (txns *V2TransactionsMultiproof) DecodeFrom(d *Decoder) {
+	DecodeSlice(d, (*[]V2Transaction)(txns))
+	if d.Err() == nil {
+		decodeMultiproof(d, transactionLeaves(*txns))
+	}
+}
+
+// encodeMultiproof writes the number of leaves and the multiproof for the
+// given leaves. The leaves themselves must be encoded separately, without their
+// proofs.
+func encodeMultiproof(e *Encoder, leaves []elementLeaf) {
+	var numLeaves uint64
+	for _, l := range leaves {
 		// infer numLeaves from the supplied leaves; this might not always
 		// produce the actual number of leaves in the accumulator, but it will
 		// be correct enough for the decoder to recover the proof lengths, which
 		// is all we care about
 		n := uint64(1) << len(l.MerkleProof)
 		numLeaves |= l.LeafIndex&^(n-1) | n
-		l.MerkleProof = nil
-	})
-	EncodeSlice(e, prooflessTxns)
+	}
 	e.WriteUint64(numLeaves)
-	multiproof := computeMultiproof(txns)
-	for _, p := range multiproof {
+	for _, p := range computeMultiproof(leaves) {
 		p.EncodeTo(e)
 	}
 }
 
-// DecodeFrom implements types.DecoderFrom.
-func (txns *V2TransactionsMultiproof) DecodeFrom(d *Decoder) {
-	DecodeSlice(d, (*[]V2Transaction)(txns))
+// decodeMultiproof reads a multiproof written by encodeMultiproof and uses it
+// to restore the proofs of the supplied leaves.
+func decodeMultiproof(d *Decoder, leaves []elementLeaf) {
 	numLeaves := d.ReadUint64()
-	forEachElementLeaf(*txns, func(l elementLeaf) {
+	for _, l := range leaves {
 		if l.LeafIndex >= numLeaves {
 			d.SetErr(errors.New("invalid leaf index"))
 			return
 		}
 		l.MerkleProof = make([]Hash256, bits.Len64(l.LeafIndex^numLeaves)-1)
-	})
-	// multiproofSize and/or expandMultiproof will panic if the the transactions
-	// are invalid, so bail out early if we've encountered an error
+	}
+	// multiproofSize and/or expandMultiproof will panic if the leaves are
+	// invalid, so bail out early if we've encountered an error
 	if d.Err() != nil {
 		return
 	}
-	multiproof := make([]Hash256, multiproofSize(*txns))
+	multiproof := make([]Hash256, multiproofSize(leaves))
 	for i := range multiproof {
 		multiproof[i].DecodeFrom(d)
 	}
-	expandMultiproof(*txns, multiproof)
+	if d.Err() == nil {
+		expandMultiproof(leaves, multiproof)
+	}
+}
+
+// An ElementMultiproof is a set of elements whose Merkle proofs are encoded as
+// a single multiproof. Like V2TransactionsMultiproof, it may only be used for
+// elements whose Merkle proofs are all valid for the same consensus state, and
+// every element must have been added to the accumulator.
+type ElementMultiproof struct {
+	SiacoinElements        []SiacoinElement
+	SiafundElements        []SiafundElement
+	FileContractElements   []FileContractElement
+	V2FileContractElements []V2FileContractElement
+	ChainIndexElements     []ChainIndexElement
+}
+
+func (mp *ElementMultiproof) leaves() []elementLeaf {
+	leaves := make([]elementLeaf, 0, len(mp.SiacoinElements)+len(mp.SiafundElements)+len(mp.FileContractElements)+len(mp.V2FileContractElements)+len(mp.ChainIndexElements))
+	for i := range mp.SiacoinElements {
+		leaves = append(leaves, siacoinLeaf(&mp.SiacoinElements[i]))
+	}
+	for i := range mp.SiafundElements {
+		leaves = append(leaves, siafundLeaf(&mp.SiafundElements[i]))
+	}
+	for i := range mp.FileContractElements {
+		leaves = append(leaves, fileContractLeaf(&mp.FileContractElements[i]))
+	}
+	for i := range mp.V2FileContractElements {
+		leaves = append(leaves, v2FileContractLeaf(&mp.V2FileContractElements[i]))
+	}
+	for i := range mp.ChainIndexElements {
+		leaves = append(leaves, chainIndexLeaf(&mp.ChainIndexElements[i]))
+	}
+	return leaves
+}
+
+// EncodeProofs writes the multiproof of the elements in mp, without the
+// elements themselves. It allows types that embed elements in their own
+// encoding to share a single multiproof.
+func (mp ElementMultiproof) EncodeProofs(e *Encoder) {
+	encodeMultiproof(e, mp.leaves())
+}
+
+// DecodeProofs reads a multiproof written by EncodeProofs, restoring the Merkle
+// proofs of the elements in mp, which must be the same elements, in the same
+// order, as those passed to EncodeProofs.
+func (mp *ElementMultiproof) DecodeProofs(d *Decoder) {
+	decodeMultiproof(d, mp.leaves())
+}
+
+// EncodeTo implements types.EncoderTo.
+func (mp ElementMultiproof) EncodeTo(e *Encoder) {
+	e.WriteUint64(uint64(len(mp.SiacoinElements)))
+	for _, sce := range mp.SiacoinElements {
+		sce.StateElement.MerkleProof = nil
+		sce.EncodeTo(e)
+	}
+	e.WriteUint64(uint64(len(mp.SiafundElements)))
+	for _, sfe := range mp.SiafundElements {
+		sfe.StateElement.MerkleProof = nil
+		sfe.EncodeTo(e)
+	}
+	e.WriteUint64(uint64(len(mp.FileContractElements)))
+	for _, fce := range mp.FileContractElements {
+		fce.StateElement.MerkleProof = nil
+		fce.EncodeTo(e)
+	}
+	e.WriteUint64(uint64(len(mp.V2FileContractElements)))
+	for _, fce := range mp.V2FileContractElements {
+		fce.StateElement.MerkleProof = nil
+		fce.EncodeTo(e)
+	}
+	e.WriteUint64(uint64(len(mp.ChainIndexElements)))
+	for _, cie := range mp.ChainIndexElements {
+		cie.StateElement.MerkleProof = nil
+		cie.EncodeTo(e)
+	}
+	mp.EncodeProofs(e)
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (mp *ElementMultiproof) DecodeFrom(d *Decoder) {
+	DecodeSlice(d, &mp.SiacoinElements)
+	DecodeSlice(d, &mp.SiafundElements)
+	DecodeSlice(d, &mp.FileContractElements)
+	DecodeSlice(d, &mp.V2FileContractElements)
+	DecodeSlice(d, &mp.ChainIndexElements)
+	if d.Err() == nil {
+		mp.DecodeProofs(d)
+	}
 }
@@ -169,3 +169,114 @@ func TestBlockCompression(t *testing.T) {
 		}
 	}
 }
+
+func TestElementMultiproof(t *testing.T) {
+	cs := (&consensus.Network{InitialTarget: types.BlockID{0: 1}, BlockInterval: time.Second}).GenesisState()
+	b := types.Block{
+		Transactions: []types.Transaction{{
+			FileContracts: make([]types.FileContract, 100),
+		}},
+		V2: &types.V2BlockData{
+			Transactions: []types.V2Transaction{{
+				SiacoinOutputs: make([]types.SiacoinOutput, 100),
+				SiafundOutputs: make([]types.SiafundOutput, 100),
+				FileContracts:  make([]types.V2FileContract, 100),
+			}},
+		},
+	}
+	cs, cau := consensus.ApplyBlock(cs, b, consensus.V1BlockSupplement{Transactions: make([]consensus.V1TransactionSupplement, 1)}, time.Time{})
+
+	// select a random subset of the created elements
+	var mp types.ElementMultiproof
+	for _, sced := range cau.SiacoinElementDiffs() {
+		if frand.Intn(4) == 0 {
+			mp.SiacoinElements = append(mp.SiacoinElements, sced.SiacoinElement.Copy())
+		}
+	}
+	for _, sfed := range cau.SiafundElementDiffs() {
+		if frand.Intn(4) == 0 {
+			mp.SiafundElements = append(mp.SiafundElements, sfed.SiafundElement.Copy())
+		}
+	}
+	for _, fced := range cau.FileContractElementDiffs() {
+		if frand.Intn(4) == 0 {
+			mp.FileContractElements = append(mp.FileContractElements, fced.FileContractElement.Copy())
+		}
+	}
+	for _, fced := range cau.V2FileContractElementDiffs() {
+		if frand.Intn(4) == 0 {
+			mp.V2FileContractElements = append(mp.V2FileContractElements, fced.V2FileContractElement.Copy())
+		}
+	}
+	mp.ChainIndexElements = []types.ChainIndexElement{cau.ChainIndexElement()}
+	if err := cs.Elements.VerifyElementMultiproof(mp); err != nil {
+		t.Fatal(err)
+	}
+
+	encode := func(v types.EncoderTo) []byte {
+		var buf bytes.Buffer
+		e := types.NewEncoder(&buf)
+		v.EncodeTo(e)
+		e.Flush()
+		return buf.Bytes()
+	}
+	decode := func(buf []byte) (mp types.ElementMultiproof) {
+		d := types.NewBufDecoder(buf)
+		if mp.DecodeFrom(d); d.Err() != nil {
+			t.Fatal(d.Err())
+		}
+		return
+	}
+
+	// round-trip
+	enc := encode(mp)
+	mp2 := decode(enc)
+	if err := cs.Elements.VerifyElementMultiproof(mp2); err != nil {
+		t.Fatal(err)
+	}
+	for i := range mp.SiacoinElements {
+		if mp.SiacoinElements[i].ID != mp2.SiacoinElements[i].ID || !reflect.DeepEqual(mp.SiacoinElements[i].StateElement.MerkleProof, mp2.SiacoinElements[i].StateElement.MerkleProof) {
+			t.Fatal("siacoin element did not survive roundtrip")
+		}
+	}
+	if len(mp2.SiafundElements) != len(mp.SiafundElements) || len(mp2.FileContractElements) != len(mp.FileContractElements) || len(mp2.V2FileContractElements) != len(mp.V2FileContractElements) || len(mp2.ChainIndexElements) != 1 {
+		t.Fatal("elements did not survive roundtrip")
+	}
+
+	// the multiproof should be much smaller than the individual proofs
+	uncompressed := encode(types.EncoderFunc(func(e *types.Encoder) {
+		types.EncodeSlice(e, mp.SiacoinElements)
+		types.EncodeSlice(e, mp.SiafundElements)
+		types.EncodeSlice(e, mp.FileContractElements)
+		types.EncodeSlice(e, mp.V2FileContractElements)
+		types.EncodeSlice(e, mp.ChainIndexElements)
+	}))
+	if r := float64(len(enc)) / float64(len(uncompressed)); r >= 0.75 {
+		t.Errorf("expected compression ratio <0.75, got %g", r)
+	}
+
+	// tampering with an element should invalidate the multiproof
+	mp2 = decode(enc)
+	mp2.SiafundElements[0].SiafundOutput.Value++
+	if err := cs.Elements.VerifyElementMultiproof(mp2); err == nil {
+		t.Fatal("expected tampered element to be rejected")
+	}
+	mp2 = decode(enc)
+	if len(mp2.FileContractElements) > 0 {
+		mp2.FileContractElements[0].FileContract.Filesize++
+		if err := cs.Elements.VerifyElementMultiproof(mp2); err == nil {
+			t.Fatal("expected tampered file contract to be rejected")
+		}
+	}
+	mp2 = decode(enc)
+	mp2.ChainIndexElements[0].ChainIndex.Height++
+	if err := cs.Elements.VerifyElementMultiproof(mp2); err == nil {
+		t.Fatal("expected tampered chain index to be rejected")
+	}
+	// corrupting the multiproof should invalidate the decoded proofs
+	corrupt := append([]byte(nil), enc...)
+	corrupt[len(corrupt)-1] ^= 1
+	if err := cs.Elements.VerifyElementMultiproof(decode(corrupt)); err == nil {
+		t.Fatal("expected corrupted multiproof to be rejected")
+	}
+}